@@ -0,0 +1,391 @@
+package taskmaster
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultRegressionWindow is the number of runs whose durations are compared
+	// to the runs before them to detect duration regressions.
+	DefaultRegressionWindow = 10
+	// DefaultRegressionFactor is how many times the p95 duration of a window of
+	// runs must exceed the p95 duration of the previous window to be a regression.
+	DefaultRegressionFactor = 2.0
+	// DefaultMinFailureStreak is the minimum number of consecutive failed runs
+	// reported as a failure streak.
+	DefaultMinFailureStreak = 3
+	// DefaultSkippedRunTolerance is how long after an expected occurrence a run
+	// may start and still be counted as that occurrence.
+	DefaultSkippedRunTolerance = 5 * time.Minute
+)
+
+// HistoryOptions changes how AnalyzeHistoryWithOptions analyzes run records.
+// Zero values are replaced with defaults.
+type HistoryOptions struct {
+	RegressionWindow    int
+	RegressionFactor    float64
+	MinFailureStreak    int
+	SkippedRunTolerance time.Duration
+
+	// Triggers are the triggers of the task. If set, the runs caused by time
+	// triggers are compared with the expected occurrences of the triggers
+	// between From and To to find skipped runs. From defaults to the time of
+	// the first run minus SkippedRunTolerance and To defaults to now.
+	Triggers []Trigger
+	From     time.Time
+	To       time.Time
+}
+
+// HistoryStats summarizes the run history of a task.
+type HistoryStats struct {
+	Runs        int     // the number of runs that finished
+	Succeeded   int     // the number of runs that succeeded
+	Failed      int     // the number of runs that failed, were terminated or couldn't start
+	SuccessRate float64 // the fraction of finished runs that succeeded
+	FailureRate float64 // the fraction of finished runs that didn't succeed
+
+	DurationP50 time.Duration
+	DurationP95 time.Duration
+	DurationMax time.Duration
+
+	Regressions          []DurationRegression
+	FailureStreaks       []FailureStreak
+	CurrentFailureStreak int // the number of consecutive failed runs at the end of the history
+
+	ExpectedRuns int         // the number of expected occurrences of time triggers
+	SkippedRuns  []time.Time // the expected occurrences of time triggers without a run
+}
+
+// DurationRegression is a window of runs whose p95 duration grew compared to
+// the window of runs before it.
+type DurationRegression struct {
+	Start       time.Time // the start of the first run of the window
+	End         time.Time // the start of the last run of the window
+	BaselineP95 time.Duration
+	P95         time.Duration
+	Factor      float64 // P95 divided by BaselineP95
+}
+
+// FailureStreak is a sequence of consecutive failed runs.
+type FailureStreak struct {
+	Start time.Time // the start of the first failed run
+	End   time.Time // the start of the last failed run
+	Runs  int
+}
+
+// AnalyzeHistory returns statistics of task runs using default options.
+func AnalyzeHistory(records []RunRecord) HistoryStats {
+	return AnalyzeHistoryWithOptions(records, HistoryOptions{})
+}
+
+// AnalyzeHistoryWithOptions returns statistics of task runs, such as success
+// rates, duration percentiles, duration regressions, failure streaks and,
+// if triggers are given, skipped runs.
+func AnalyzeHistoryWithOptions(records []RunRecord, opts HistoryOptions) HistoryStats {
+	if opts.RegressionWindow <= 0 {
+		opts.RegressionWindow = DefaultRegressionWindow
+	}
+	if opts.RegressionFactor <= 0 {
+		opts.RegressionFactor = DefaultRegressionFactor
+	}
+	if opts.MinFailureStreak <= 0 {
+		opts.MinFailureStreak = DefaultMinFailureStreak
+	}
+	if opts.SkippedRunTolerance <= 0 {
+		opts.SkippedRunTolerance = DefaultSkippedRunTolerance
+	}
+
+	sorted := make([]RunRecord, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return runTime(sorted[i]).Before(runTime(sorted[j]))
+	})
+
+	var stats HistoryStats
+	var durations []time.Duration
+	var durationTimes []time.Time
+	var streak FailureStreak
+	endStreak := func() {
+		if streak.Runs >= opts.MinFailureStreak {
+			stats.FailureStreaks = append(stats.FailureStreaks, streak)
+		}
+		streak = FailureStreak{}
+	}
+
+	for _, record := range sorted {
+		switch record.Status {
+		case RunSucceeded:
+			stats.Succeeded++
+			endStreak()
+		case RunFailed, RunTerminated, RunStartFailed:
+			stats.Failed++
+			if streak.Runs == 0 {
+				streak.Start = runTime(record)
+			}
+			streak.End = runTime(record)
+			streak.Runs++
+		default:
+			continue
+		}
+
+		if d := record.Duration(); d > 0 {
+			durations = append(durations, d)
+			durationTimes = append(durationTimes, runTime(record))
+		}
+	}
+	stats.CurrentFailureStreak = streak.Runs
+	endStreak()
+
+	stats.Runs = stats.Succeeded + stats.Failed
+	if stats.Runs > 0 {
+		stats.SuccessRate = float64(stats.Succeeded) / float64(stats.Runs)
+		stats.FailureRate = float64(stats.Failed) / float64(stats.Runs)
+	}
+
+	stats.DurationP50 = percentile(durations, 0.5)
+	stats.DurationP95 = percentile(durations, 0.95)
+	stats.DurationMax = percentile(durations, 1)
+	stats.Regressions = findRegressions(durations, durationTimes, opts.RegressionWindow, opts.RegressionFactor)
+
+	if len(opts.Triggers) > 0 {
+		from, to := opts.From, opts.To
+		if from.IsZero() && len(sorted) > 0 {
+			from = runTime(sorted[0]).Add(-opts.SkippedRunTolerance)
+		}
+		if to.IsZero() {
+			to = time.Now()
+		}
+
+		expected := ExpectedRuns(opts.Triggers, from, to)
+		stats.ExpectedRuns = len(expected)
+		stats.SkippedRuns = findSkippedRuns(expected, sorted, opts.SkippedRunTolerance)
+	}
+
+	return stats
+}
+
+// runTime returns when a run was launched.
+func runTime(r RunRecord) time.Time {
+	switch {
+	case !r.TriggerTime.IsZero():
+		return r.TriggerTime
+	case !r.StartTime.IsZero():
+		return r.StartTime
+	default:
+		return r.EndTime
+	}
+}
+
+// percentile returns the nearest rank percentile of durations.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return sorted[rank]
+}
+
+// findRegressions compares the p95 duration of consecutive windows of runs.
+// Windows are aligned to the most recent run so the latest window is always full.
+func findRegressions(durations []time.Duration, times []time.Time, window int, factor float64) []DurationRegression {
+	var regressions []DurationRegression
+
+	for start := len(durations) % window; start+2*window <= len(durations); start += window {
+		baseline := percentile(durations[start:start+window], 0.95)
+		current := percentile(durations[start+window:start+2*window], 0.95)
+		if baseline <= 0 {
+			continue
+		}
+
+		if f := float64(current) / float64(baseline); f >= factor {
+			regressions = append(regressions, DurationRegression{
+				Start:       times[start+window],
+				End:         times[start+2*window-1],
+				BaselineP95: baseline,
+				P95:         current,
+				Factor:      f,
+			})
+		}
+	}
+
+	return regressions
+}
+
+// findSkippedRuns returns the expected occurrences that have no run launched
+// by a time trigger within tolerance after them.
+func findSkippedRuns(expected []time.Time, records []RunRecord, tolerance time.Duration) []time.Time {
+	var launched []time.Time
+	for _, record := range records {
+		if record.Cause == TimeTriggered || record.Cause == UnknownCause {
+			launched = append(launched, runTime(record))
+		}
+	}
+
+	var skipped []time.Time
+	i := 0
+	for _, occurrence := range expected {
+		for i < len(launched) && launched[i].Before(occurrence) {
+			i++
+		}
+		if i < len(launched) && !launched[i].After(occurrence.Add(tolerance)) {
+			i++
+			continue
+		}
+		skipped = append(skipped, occurrence)
+	}
+
+	return skipped
+}
+
+// ExpectedRuns returns when the enabled time based triggers are scheduled to
+// launch a task between from and to, in order. Random delays aren't applied.
+// Triggers that aren't time based, such as boot or logon triggers, are ignored.
+func ExpectedRuns(triggers []Trigger, from, to time.Time) []time.Time {
+	seen := make(map[int64]bool)
+	var occurrences []time.Time
+
+	for _, trigger := range triggers {
+		var taskTrigger TaskTrigger
+		var matches func(day time.Time, dayNum int) bool
+
+		switch t := trigger.(type) {
+		case TimeTrigger:
+			taskTrigger = t.TaskTrigger
+			matches = func(day time.Time, dayNum int) bool {
+				return dayNum == 0
+			}
+		case DailyTrigger:
+			taskTrigger = t.TaskTrigger
+			interval := int(t.DayInterval)
+			if interval == 0 {
+				interval = 1
+			}
+			matches = func(day time.Time, dayNum int) bool {
+				return dayNum%interval == 0
+			}
+		case WeeklyTrigger:
+			taskTrigger = t.TaskTrigger
+			interval := int(t.WeekInterval)
+			if interval == 0 {
+				interval = 1
+			}
+			startWeekday := int(t.StartBoundary.Weekday())
+			matches = func(day time.Time, dayNum int) bool {
+				week := (dayNum + startWeekday) / 7
+				return week%interval == 0 && t.DaysOfWeek&(1<<uint(day.Weekday())) != 0
+			}
+		case MonthlyTrigger:
+			taskTrigger = t.TaskTrigger
+			matches = func(day time.Time, dayNum int) bool {
+				if t.MonthsOfYear&(1<<uint(day.Month()-1)) == 0 {
+					return false
+				}
+				if isLastDayOfMonth(day) && (t.DaysOfMonth&LastDayOfMonth != 0 || t.RunOnLastWeekOfMonth) {
+					return true
+				}
+				return t.DaysOfMonth&(1<<uint(day.Day()-1)) != 0
+			}
+		case MonthlyDOWTrigger:
+			taskTrigger = t.TaskTrigger
+			matches = func(day time.Time, dayNum int) bool {
+				if t.MonthsOfYear&(1<<uint(day.Month()-1)) == 0 || t.DaysOfWeek&(1<<uint(day.Weekday())) == 0 {
+					return false
+				}
+				if isLastWeekOfMonth(day) && (t.WeeksOfMonth&LastWeek != 0 || t.RunOnLastWeekOfMonth) {
+					return true
+				}
+				week := (day.Day() - 1) / 7
+				return week < 4 && t.WeeksOfMonth&(1<<uint(week)) != 0
+			}
+		default:
+			continue
+		}
+		if !taskTrigger.Enabled || taskTrigger.StartBoundary.IsZero() {
+			continue
+		}
+
+		end := to
+		if !taskTrigger.EndBoundary.IsZero() && taskTrigger.EndBoundary.Before(end) {
+			end = taskTrigger.EndBoundary
+		}
+		interval := taskTrigger.RepetitionInterval.DurationApprox()
+		duration := taskTrigger.RepetitionDuration.DurationApprox()
+
+		add := func(t time.Time) {
+			if t.Before(from) || t.After(end) || seen[t.UnixNano()] {
+				return
+			}
+			seen[t.UnixNano()] = true
+			occurrences = append(occurrences, t)
+		}
+
+		start := taskTrigger.StartBoundary
+		// skip the days whose occurrences all happened before from
+		firstDay := 0
+		if interval <= 0 || duration > 0 {
+			firstDay = int(from.Sub(start)/(24*time.Hour)) - 1 - int(duration/(24*time.Hour))
+			if firstDay < 0 {
+				firstDay = 0
+			}
+		}
+		for dayNum := firstDay; ; dayNum++ {
+			day := time.Date(start.Year(), start.Month(), start.Day()+dayNum, start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+			if day.After(end) {
+				break
+			}
+			if !matches(day, dayNum) {
+				continue
+			}
+
+			if interval <= 0 {
+				add(day)
+				continue
+			}
+			// without a duration the task is repeated indefinitely
+			repeatEnd := end
+			if duration > 0 && day.Add(duration).Before(repeatEnd) {
+				repeatEnd = day.Add(duration)
+			}
+			t := day
+			if t.Before(from) {
+				t = t.Add(from.Sub(t) / interval * interval)
+			}
+			for ; !t.After(repeatEnd); t = t.Add(interval) {
+				if duration > 0 && !t.Before(day.Add(duration)) {
+					break
+				}
+				add(t)
+			}
+			if duration <= 0 {
+				break
+			}
+		}
+	}
+
+	sort.Slice(occurrences, func(i, j int) bool {
+		return occurrences[i].Before(occurrences[j])
+	})
+
+	return occurrences
+}
+
+func isLastDayOfMonth(day time.Time) bool {
+	return day.AddDate(0, 0, 1).Month() != day.Month()
+}
+
+func isLastWeekOfMonth(day time.Time) bool {
+	return day.AddDate(0, 0, 7).Month() != day.Month()
+}
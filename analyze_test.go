@@ -0,0 +1,208 @@
+package taskmaster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rickb777/date/period"
+)
+
+func newTestRun(start time.Time, duration time.Duration, status RunStatus) RunRecord {
+	return RunRecord{
+		TaskPath:    `\Taskmaster\Backup`,
+		Cause:       TimeTriggered,
+		TriggerTime: start,
+		StartTime:   start,
+		EndTime:     start.Add(duration),
+		Status:      status,
+	}
+}
+
+func TestAnalyzeHistory(t *testing.T) {
+	start := time.Date(2020, 1, 1, 3, 0, 0, 0, time.UTC)
+
+	var records []RunRecord
+	// 20 fast runs, then 10 slow runs
+	for i := 0; i < 30; i++ {
+		duration := 4 * time.Minute
+		if i >= 20 {
+			duration = 40 * time.Minute
+		}
+		records = append(records, newTestRun(start.AddDate(0, 0, i), duration, RunSucceeded))
+	}
+	// a failure streak in the middle and at the end
+	for _, i := range []int{5, 6, 7, 28, 29} {
+		records[i].Status = RunFailed
+	}
+	records = append(records, RunRecord{Status: RunInProgress, StartTime: start.AddDate(0, 0, 30)})
+
+	stats := AnalyzeHistory(records)
+	if stats.Runs != 30 || stats.Succeeded != 25 || stats.Failed != 5 {
+		t.Errorf("unexpected run counts: %d runs, %d succeeded, %d failed", stats.Runs, stats.Succeeded, stats.Failed)
+	}
+	if stats.SuccessRate != 25.0/30 || stats.FailureRate != 5.0/30 {
+		t.Errorf("unexpected rates: %v, %v", stats.SuccessRate, stats.FailureRate)
+	}
+	if stats.DurationP50 != 4*time.Minute || stats.DurationP95 != 40*time.Minute || stats.DurationMax != 40*time.Minute {
+		t.Errorf("unexpected durations: p50 %v, p95 %v, max %v", stats.DurationP50, stats.DurationP95, stats.DurationMax)
+	}
+
+	if len(stats.Regressions) != 1 {
+		t.Fatalf("expected 1 regression, got %v", stats.Regressions)
+	}
+	regression := stats.Regressions[0]
+	if regression.BaselineP95 != 4*time.Minute || regression.P95 != 40*time.Minute || regression.Factor != 10 || !regression.Start.Equal(start.AddDate(0, 0, 20)) {
+		t.Errorf("unexpected regression %+v", regression)
+	}
+
+	if len(stats.FailureStreaks) != 1 || stats.FailureStreaks[0].Runs != 3 || !stats.FailureStreaks[0].Start.Equal(start.AddDate(0, 0, 5)) {
+		t.Errorf("unexpected failure streaks %+v", stats.FailureStreaks)
+	}
+	if stats.CurrentFailureStreak != 2 {
+		t.Errorf("expected a current failure streak of 2, got %d", stats.CurrentFailureStreak)
+	}
+
+	if stats := AnalyzeHistory(nil); stats.Runs != 0 || stats.DurationMax != 0 || stats.SuccessRate != 0 {
+		t.Errorf("unexpected stats for no runs %+v", stats)
+	}
+}
+
+func TestAnalyzeHistorySkippedRuns(t *testing.T) {
+	start := time.Date(2020, 1, 1, 3, 0, 0, 0, time.UTC)
+	trigger := DailyTrigger{
+		TaskTrigger: TaskTrigger{
+			Enabled:       true,
+			StartBoundary: start,
+		},
+		DayInterval: EveryDay,
+	}
+
+	var records []RunRecord
+	for i := 0; i < 10; i++ {
+		if i == 3 || i == 7 {
+			continue
+		}
+		// runs may start a little late
+		records = append(records, newTestRun(start.AddDate(0, 0, i).Add(time.Minute), time.Minute, RunSucceeded))
+	}
+	// runs started by users don't count
+	userRun := newTestRun(start.AddDate(0, 0, 3).Add(time.Minute), time.Minute, RunSucceeded)
+	userRun.Cause = UserTriggered
+	records = append(records, userRun)
+
+	stats := AnalyzeHistoryWithOptions(records, HistoryOptions{
+		Triggers: []Trigger{trigger},
+		To:       start.AddDate(0, 0, 9).Add(time.Hour),
+	})
+	if stats.ExpectedRuns != 10 {
+		t.Errorf("expected 10 expected runs, got %d", stats.ExpectedRuns)
+	}
+	if len(stats.SkippedRuns) != 2 || !stats.SkippedRuns[0].Equal(start.AddDate(0, 0, 3)) || !stats.SkippedRuns[1].Equal(start.AddDate(0, 0, 7)) {
+		t.Errorf("unexpected skipped runs %v", stats.SkippedRuns)
+	}
+}
+
+func TestExpectedRuns(t *testing.T) {
+	// Wednesday
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	enabled := TaskTrigger{Enabled: true, StartBoundary: start}
+
+	tests := []struct {
+		name     string
+		trigger  Trigger
+		from, to time.Time
+		expected []time.Time
+	}{
+		{
+			name:     "time",
+			trigger:  TimeTrigger{TaskTrigger: enabled},
+			to:       start.AddDate(1, 0, 0),
+			expected: []time.Time{start},
+		},
+		{
+			name:     "every other day",
+			trigger:  DailyTrigger{TaskTrigger: enabled, DayInterval: EveryOtherDay},
+			from:     start.AddDate(0, 0, 1),
+			to:       start.AddDate(0, 0, 6),
+			expected: []time.Time{start.AddDate(0, 0, 2), start.AddDate(0, 0, 4), start.AddDate(0, 0, 6)},
+		},
+		{
+			name:     "every other week",
+			trigger:  WeeklyTrigger{TaskTrigger: enabled, DaysOfWeek: Monday | Friday, WeekInterval: EveryOtherWeek},
+			to:       start.AddDate(0, 0, 20),
+			expected: []time.Time{start.AddDate(0, 0, 2), start.AddDate(0, 0, 12), start.AddDate(0, 0, 16)},
+		},
+		{
+			name:     "monthly",
+			trigger:  MonthlyTrigger{TaskTrigger: enabled, DaysOfMonth: Fifteen | LastDayOfMonth, MonthsOfYear: January | February},
+			to:       start.AddDate(0, 3, 0),
+			expected: []time.Time{start.AddDate(0, 0, 14), start.AddDate(0, 0, 30), start.AddDate(0, 1, 14), start.AddDate(0, 1, 28)},
+		},
+		{
+			name:     "monthly day of week",
+			trigger:  MonthlyDOWTrigger{TaskTrigger: enabled, DaysOfWeek: Monday, WeeksOfMonth: Second | LastWeek, MonthsOfYear: January},
+			to:       start.AddDate(1, 0, 0),
+			expected: []time.Time{start.AddDate(0, 0, 12), start.AddDate(0, 0, 26)},
+		},
+		{
+			name: "repetition",
+			trigger: TimeTrigger{TaskTrigger: TaskTrigger{
+				Enabled:       true,
+				StartBoundary: start,
+				RepetitionPattern: RepetitionPattern{
+					RepetitionDuration: period.NewHMS(1, 0, 0),
+					RepetitionInterval: period.NewHMS(0, 20, 0),
+				},
+			}},
+			to:       start.AddDate(0, 0, 1),
+			expected: []time.Time{start, start.Add(20 * time.Minute), start.Add(40 * time.Minute)},
+		},
+		{
+			name: "indefinite repetition",
+			trigger: TimeTrigger{TaskTrigger: TaskTrigger{
+				Enabled:       true,
+				StartBoundary: start,
+				RepetitionPattern: RepetitionPattern{
+					RepetitionInterval: period.NewHMS(6, 0, 0),
+				},
+			}},
+			from:     start.AddDate(0, 0, 100).Add(time.Hour),
+			to:       start.AddDate(0, 0, 101),
+			expected: []time.Time{start.AddDate(0, 0, 100).Add(6 * time.Hour), start.AddDate(0, 0, 100).Add(12 * time.Hour), start.AddDate(0, 0, 100).Add(18 * time.Hour), start.AddDate(0, 0, 101)},
+		},
+		{
+			name:     "end boundary",
+			trigger:  DailyTrigger{TaskTrigger: TaskTrigger{Enabled: true, StartBoundary: start, EndBoundary: start.AddDate(0, 0, 1)}, DayInterval: EveryDay},
+			to:       start.AddDate(0, 0, 5),
+			expected: []time.Time{start, start.AddDate(0, 0, 1)},
+		},
+		{
+			name:    "disabled",
+			trigger: DailyTrigger{TaskTrigger: TaskTrigger{StartBoundary: start}, DayInterval: EveryDay},
+			to:      start.AddDate(0, 0, 5),
+		},
+		{
+			name:    "boot",
+			trigger: BootTrigger{TaskTrigger: enabled},
+			to:      start.AddDate(0, 0, 5),
+		},
+	}
+
+	for _, test := range tests {
+		from := test.from
+		if from.IsZero() {
+			from = start
+		}
+		runs := ExpectedRuns([]Trigger{test.trigger}, from, test.to)
+		if len(runs) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, runs)
+			continue
+		}
+		for i := range runs {
+			if !runs[i].Equal(test.expected[i]) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.expected, runs)
+				break
+			}
+		}
+	}
+}
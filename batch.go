@@ -0,0 +1,342 @@
+package taskmaster
+
+import (
+	"context"
+	"fmt"
+)
+
+// BatchOp is the kind of a change of a Batch.
+type BatchOp uint
+
+const (
+	BatchCreate BatchOp = iota
+	BatchUpdate
+	BatchDelete
+	BatchEnable
+	BatchDisable
+)
+
+func (o BatchOp) String() string {
+	switch o {
+	case BatchCreate:
+		return "Create"
+	case BatchUpdate:
+		return "Update"
+	case BatchDelete:
+		return "Delete"
+	case BatchEnable:
+		return "Enable"
+	case BatchDisable:
+		return "Disable"
+	default:
+		return ""
+	}
+}
+
+// BatchChange is a change to a registered task made by a Batch.
+type BatchChange struct {
+	Op         BatchOp
+	Path       string
+	Definition Definition // the new definition of the task, for creates and updates
+	Overwrite  bool       // whether an existing task is replaced, for creates
+}
+
+func (c BatchChange) String() string {
+	return fmt.Sprintf("%s %s", c.Op, c.Path)
+}
+
+// BatchResult is a change of a Batch and the error that occurred applying or
+// rolling it back.
+type BatchResult struct {
+	Change BatchChange
+	Err    error
+}
+
+// BatchReport describes what committing a Batch did.
+type BatchReport struct {
+	Applied        []BatchChange // the changes that were applied, in order
+	Failed         *BatchResult  // the change that couldn't be applied, if any
+	RolledBack     []BatchChange // the changes that were undone after Failed, in the order they were undone
+	RollbackFailed []BatchResult // the changes that couldn't be undone after Failed
+}
+
+// Batch collects changes to registered tasks that are applied together. If a
+// change can't be applied, the changes applied before it are undone, so that
+// tasks that depend on each other aren't left half updated. The zero value is
+// an empty batch.
+type Batch struct {
+	// Passwords are the passwords of the users that tasks with the
+	// TASK_LOGON_PASSWORD or TASK_LOGON_INTERACTIVE_TOKEN_OR_PASSWORD logon
+	// types run as, keyed by user ID. They are needed to register the new
+	// definitions of tasks, and to restore the previous definitions of tasks
+	// when rolling back. User IDs are compared case insensitively.
+	Passwords map[string]string
+
+	changes []BatchChange
+}
+
+// Create adds a change that registers a new task at path. If a task already
+// exists at path, it is replaced if overwrite is true and the change fails
+// otherwise.
+func (b *Batch) Create(path string, def Definition, overwrite bool) {
+	b.changes = append(b.changes, BatchChange{Op: BatchCreate, Path: path, Definition: def, Overwrite: overwrite})
+}
+
+// Update adds a change that updates the definition of the task at path.
+func (b *Batch) Update(path string, def Definition) {
+	b.changes = append(b.changes, BatchChange{Op: BatchUpdate, Path: path, Definition: def})
+}
+
+// Delete adds a change that deletes the task at path.
+func (b *Batch) Delete(path string) {
+	b.changes = append(b.changes, BatchChange{Op: BatchDelete, Path: path})
+}
+
+// Enable adds a change that enables the task at path.
+func (b *Batch) Enable(path string) {
+	b.changes = append(b.changes, BatchChange{Op: BatchEnable, Path: path})
+}
+
+// Disable adds a change that disables the task at path.
+func (b *Batch) Disable(path string) {
+	b.changes = append(b.changes, BatchChange{Op: BatchDisable, Path: path})
+}
+
+// Changes returns the changes of the batch, in the order they are applied.
+func (b *Batch) Changes() []BatchChange {
+	return b.changes
+}
+
+// Commit applies the changes of the batch in order. Before a change is
+// applied, the definition and enabled state of the task it changes are saved.
+// If a change fails, the saved tasks are restored in reverse order and an
+// error is returned. The report describes which changes were applied, rolled
+// back and couldn't be rolled back.
+//
+// The security descriptors of deleted and replaced tasks are restored if svc
+// is a *TaskService.
+func (b *Batch) Commit(svc Scheduler) (BatchReport, error) {
+	return b.CommitContext(context.Background(), svc)
+}
+
+// CommitContext is like Commit, but ctx is passed to every call to svc while
+// the changes are applied. If ctx is canceled, the changes already applied
+// are rolled back.
+func (b *Batch) CommitContext(ctx context.Context, svc Scheduler) (BatchReport, error) {
+	var (
+		report    BatchReport
+		snapshots []taskSnapshot
+	)
+	for _, change := range b.changes {
+		snapshot, err := b.apply(ctx, svc, change)
+		if err == nil {
+			report.Applied = append(report.Applied, change)
+			snapshots = append(snapshots, snapshot)
+			continue
+		}
+
+		report.Failed = &BatchResult{Change: change, Err: err}
+		err = fmt.Errorf("error applying %v: %v", change, err)
+
+		// roll back even if ctx is canceled
+		rollbackCtx := context.Background()
+		// a failed replace may have deleted the existing task
+		if change.Op == BatchCreate && snapshot.exists {
+			if rollbackErr := b.recreate(rollbackCtx, svc, change.Path, snapshot); rollbackErr != nil {
+				err = fmt.Errorf("%v; error restoring task %s: %v", err, change.Path, rollbackErr)
+			}
+		}
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			applied := report.Applied[i]
+			if rollbackErr := b.restore(rollbackCtx, svc, applied, snapshots[i]); rollbackErr != nil {
+				report.RollbackFailed = append(report.RollbackFailed, BatchResult{Change: applied, Err: rollbackErr})
+				continue
+			}
+			report.RolledBack = append(report.RolledBack, applied)
+		}
+
+		if len(report.RollbackFailed) != 0 {
+			first := report.RollbackFailed[0]
+			return report, fmt.Errorf("%v; error rolling back %d changes: %v: %v", err, len(report.RollbackFailed), first.Change, first.Err)
+		}
+		return report, fmt.Errorf("%v; changes were rolled back", err)
+	}
+
+	return report, nil
+}
+
+// taskSnapshot is the state of a task before a change was applied.
+type taskSnapshot struct {
+	exists bool
+	def    Definition // the definition, with the enabled state of the task
+	sddl   string     // the security descriptor, if the scheduler can get it
+}
+
+// snapshotTask saves the state of task, with its security descriptor if
+// withSD is set and svc can get it.
+func snapshotTask(ctx context.Context, svc Scheduler, task RegisteredTask, withSD bool) (taskSnapshot, error) {
+	snapshot := taskSnapshot{exists: true, def: task.Definition}
+	snapshot.def.Settings.Enabled = task.Enabled
+
+	if mover, ok := svc.(taskMover); ok && withSD {
+		sddl, err := mover.getSecurityDescriptor(ctx, task.Path)
+		if err != nil {
+			return taskSnapshot{}, fmt.Errorf("error getting security descriptor of task %s: %v", task.Path, err)
+		}
+		snapshot.sddl = sddl
+	}
+
+	return snapshot, nil
+}
+
+// apply applies change and returns the state of the task before it.
+func (b *Batch) apply(ctx context.Context, svc Scheduler, change BatchChange) (taskSnapshot, error) {
+	if change.Op == BatchCreate {
+		return b.applyCreate(ctx, svc, change)
+	}
+
+	task, err := svc.GetRegisteredTaskContext(ctx, change.Path)
+	if err != nil {
+		return taskSnapshot{}, err
+	}
+	defer task.Release()
+
+	snapshot, err := snapshotTask(ctx, svc, task, change.Op == BatchDelete)
+	if err != nil {
+		return taskSnapshot{}, err
+	}
+
+	switch change.Op {
+	case BatchDelete:
+		err = svc.DeleteTaskContext(ctx, change.Path)
+	case BatchUpdate:
+		err = b.update(ctx, svc, change.Path, change.Definition)
+	case BatchEnable, BatchDisable:
+		def := snapshot.def
+		def.Settings.Enabled = change.Op == BatchEnable
+		err = b.update(ctx, svc, change.Path, def)
+	default:
+		err = fmt.Errorf("unknown batch operation %d", change.Op)
+	}
+	if err != nil {
+		return taskSnapshot{}, err
+	}
+
+	return snapshot, nil
+}
+
+// applyCreate registers the task of change. Registering without overwriting
+// returns the existing task if there is one, which is saved before it is
+// replaced.
+func (b *Batch) applyCreate(ctx context.Context, svc Scheduler, change BatchChange) (taskSnapshot, error) {
+	username, password, err := b.credentials(change.Definition)
+	if err != nil {
+		return taskSnapshot{}, err
+	}
+
+	task, created, err := svc.CreateTaskExContext(ctx, change.Path, change.Definition, username, password, change.Definition.Principal.LogonType, false)
+	if err != nil {
+		return taskSnapshot{}, err
+	}
+	defer task.Release()
+	if created {
+		return taskSnapshot{}, nil
+	}
+	if !change.Overwrite {
+		return taskSnapshot{}, fmt.Errorf("task already exists")
+	}
+
+	snapshot, err := snapshotTask(ctx, svc, task, true)
+	if err != nil {
+		return taskSnapshot{}, err
+	}
+	newTask, _, err := svc.CreateTaskExContext(ctx, change.Path, change.Definition, username, password, change.Definition.Principal.LogonType, true)
+	if err != nil {
+		return snapshot, err
+	}
+	newTask.Release()
+
+	return snapshot, nil
+}
+
+// restore undoes change, which was applied to the task saved in snapshot.
+func (b *Batch) restore(ctx context.Context, svc Scheduler, change BatchChange, snapshot taskSnapshot) error {
+	switch {
+	case !snapshot.exists:
+		return svc.DeleteTaskContext(ctx, change.Path)
+	case change.Op == BatchDelete:
+		return b.recreate(ctx, svc, change.Path, snapshot)
+	case change.Op == BatchCreate:
+		if err := svc.DeleteTaskContext(ctx, change.Path); err != nil {
+			return err
+		}
+		return b.recreate(ctx, svc, change.Path, snapshot)
+	default:
+		return b.update(ctx, svc, change.Path, snapshot.def)
+	}
+}
+
+// recreate registers the saved task at path if it doesn't exist.
+func (b *Batch) recreate(ctx context.Context, svc Scheduler, path string, snapshot taskSnapshot) error {
+	username, password, err := b.credentials(snapshot.def)
+	if err != nil {
+		return err
+	}
+
+	return recreateTask(ctx, svc, path, snapshot, username, password)
+}
+
+// recreateTask registers the saved task at path if it doesn't exist, with its
+// security descriptor if possible.
+func recreateTask(ctx context.Context, svc Scheduler, path string, snapshot taskSnapshot, username, password string) error {
+	if mover, ok := svc.(taskMover); ok && snapshot.sddl != "" {
+		if task, err := svc.GetRegisteredTaskContext(ctx, path); err == nil {
+			// the task wasn't deleted
+			task.Release()
+			return nil
+		}
+		task, err := mover.registerTask(ctx, path, snapshot.def, username, password, snapshot.def.Principal.LogonType, snapshot.sddl)
+		if err != nil {
+			return err
+		}
+		task.Release()
+		return nil
+	}
+
+	task, _, err := svc.CreateTaskExContext(ctx, path, snapshot.def, username, password, snapshot.def.Principal.LogonType, false)
+	if err != nil {
+		return err
+	}
+	task.Release()
+
+	return nil
+}
+
+func (b *Batch) update(ctx context.Context, svc Scheduler, path string, def Definition) error {
+	username, password, err := b.credentials(def)
+	if err != nil {
+		return err
+	}
+
+	task, err := svc.UpdateTaskExContext(ctx, path, def, username, password, def.Principal.LogonType)
+	if err != nil {
+		return err
+	}
+	task.Release()
+
+	return nil
+}
+
+// credentials returns the user name and password def is registered with.
+func (b *Batch) credentials(def Definition) (string, string, error) {
+	if !logonTypeNeedsPassword(def.Principal.LogonType) {
+		return "", "", nil
+	}
+
+	password, ok := lookupPassword(b.Passwords, def.Principal.UserID)
+	if !ok {
+		return "", "", fmt.Errorf("password of user %s is needed", def.Principal.UserID)
+	}
+
+	return def.Principal.UserID, password, nil
+}
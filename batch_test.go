@@ -0,0 +1,156 @@
+package taskmaster
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBatchCommit(t *testing.T) {
+	svc := newFakeScheduler(newFakeTask(`\Job\Extract`), newFakeTask(`\Job\Load`), newFakeTask(`\Job\Old`))
+
+	newDef := newFakeTask(`\Job\Transform`).Definition
+	newDef.RegistrationInfo.Description = "transform"
+	updated := newFakeTask(`\Job\Extract`).Definition
+	updated.RegistrationInfo.Description = "extract v2"
+
+	var b Batch
+	b.Create(`\Job\Transform`, newDef, false)
+	b.Update(`\Job\Extract`, updated)
+	b.Disable(`\Job\Load`)
+	b.Delete(`\Job\Old`)
+	if len(b.Changes()) != 4 {
+		t.Fatalf("expected 4 changes, got %d", len(b.Changes()))
+	}
+
+	report, err := b.Commit(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Applied, b.Changes()) || report.Failed != nil || len(report.RolledBack) != 0 || len(report.RollbackFailed) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if paths := svc.paths(); !reflect.DeepEqual(paths, []string{`\Job\Extract`, `\Job\Load`, `\Job\Transform`}) {
+		t.Errorf("unexpected tasks %v", paths)
+	}
+	if desc := svc.tasks[`\Job\Extract`].Definition.RegistrationInfo.Description; desc != "extract v2" {
+		t.Errorf("expected task to be updated, got description %q", desc)
+	}
+	if svc.tasks[`\Job\Load`].Enabled {
+		t.Error("expected task to be disabled")
+	}
+}
+
+func TestBatchRollback(t *testing.T) {
+	deleted := newFakeTask(`\Job\Old`)
+	deleted.Enabled = false
+	svc := newFakeScheduler(newFakeTask(`\Job\Extract`), newFakeTask(`\Job\Load`), deleted, newFakeTask(`\Job\Replaced`))
+	svc.sds[`\Job\Old`] = "D:(A;;FA;;;BA)"
+	svc.sds[`\Job\Replaced`] = "D:(A;;FR;;;AU)"
+	before := make(map[string]RegisteredTask)
+	for path, task := range svc.tasks {
+		before[path] = task
+	}
+
+	updated := newFakeTask(`\Job\Extract`).Definition
+	updated.RegistrationInfo.Description = "extract v2"
+	replacement := newFakeTask(`\Job\Replaced`).Definition
+	replacement.RegistrationInfo.Description = "replacement"
+
+	var b Batch
+	b.Update(`\Job\Extract`, updated)
+	b.Disable(`\Job\Load`)
+	b.Delete(`\Job\Old`)
+	b.Create(`\Job\New`, newFakeTask(`\Job\New`).Definition, false)
+	b.Create(`\Job\Replaced`, replacement, true)
+	b.Update(`\Job\Missing`, updated)
+
+	report, err := b.Commit(svc)
+	if err == nil {
+		t.Fatal("expected error updating a missing task")
+	}
+	if len(report.Applied) != 5 || report.Failed == nil || report.Failed.Change.Path != `\Job\Missing` {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(report.RollbackFailed) != 0 {
+		t.Fatalf("unexpected rollback failures %v", report.RollbackFailed)
+	}
+	var rolledBack []string
+	for _, change := range report.RolledBack {
+		rolledBack = append(rolledBack, change.Path)
+	}
+	if expected := []string{`\Job\Replaced`, `\Job\New`, `\Job\Old`, `\Job\Load`, `\Job\Extract`}; !reflect.DeepEqual(rolledBack, expected) {
+		t.Errorf("expected changes to be rolled back in reverse, got %v", rolledBack)
+	}
+
+	if paths := svc.paths(); !reflect.DeepEqual(paths, []string{`\Job\Extract`, `\Job\Load`, `\Job\Old`, `\Job\Replaced`}) {
+		t.Fatalf("unexpected tasks %v", paths)
+	}
+	for path, task := range before {
+		got := svc.tasks[path]
+		if got.Enabled != task.Enabled || got.Definition.RegistrationInfo.Description != task.Definition.RegistrationInfo.Description {
+			t.Errorf("task %s wasn't restored: %+v", path, got)
+		}
+	}
+	if sd := svc.sds[`\Job\Old`]; sd != "D:(A;;FA;;;BA)" {
+		t.Errorf("expected security descriptor of deleted task to be restored, got %q", sd)
+	}
+	if sd := svc.sds[`\Job\Replaced`]; sd != "D:(A;;FR;;;AU)" {
+		t.Errorf("expected security descriptor of replaced task to be restored, got %q", sd)
+	}
+}
+
+func TestBatchRollbackFailure(t *testing.T) {
+	svc := newFakeScheduler(newFakeTask(`\Existing`))
+	failing := &failingDeleteScheduler{fakeScheduler: svc, failDelete: map[string]bool{`\New`: true}}
+	svc.failPaths[`\Bad`] = errors.New("access denied")
+
+	var b Batch
+	b.Create(`\New`, newFakeTask(`\New`).Definition, false)
+	b.Disable(`\Existing`)
+	b.Create(`\Bad`, newFakeTask(`\Bad`).Definition, false)
+
+	report, err := b.Commit(failing)
+	if err == nil {
+		t.Fatal("expected error creating task")
+	}
+	if len(report.RolledBack) != 1 || report.RolledBack[0].Path != `\Existing` {
+		t.Errorf("unexpected rolled back changes %v", report.RolledBack)
+	}
+	if len(report.RollbackFailed) != 1 || report.RollbackFailed[0].Change.Path != `\New` || report.RollbackFailed[0].Err == nil {
+		t.Errorf("unexpected rollback failures %v", report.RollbackFailed)
+	}
+	if !svc.tasks[`\Existing`].Enabled {
+		t.Error("expected task to be enabled again")
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+	withPassword := newFakeTask(`\Password`)
+	withPassword.Definition.Principal = Principal{UserID: `CORP\svc`, LogonType: TASK_LOGON_PASSWORD}
+	svc := newFakeScheduler(newFakeTask(`\Existing`))
+
+	var b Batch
+	b.Create(`\Existing`, newFakeTask(`\Existing`).Definition, false)
+	if _, err := b.Commit(svc); err == nil {
+		t.Error("expected error creating an existing task without overwriting it")
+	}
+
+	b = Batch{}
+	b.Create(`\Password`, withPassword.Definition, false)
+	if _, err := b.Commit(svc); err == nil {
+		t.Error("expected error creating a password task without its password")
+	}
+	b.Passwords = map[string]string{`corp\SVC`: "hunter2"}
+	if _, err := b.Commit(svc); err != nil {
+		t.Fatal(err)
+	}
+	if password := svc.passwords[`\Password`]; password != "hunter2" {
+		t.Errorf("expected task to be registered with password, got %q", password)
+	}
+
+	var empty Batch
+	if report, err := empty.Commit(svc); err != nil || len(report.Applied) != 0 {
+		t.Errorf("expected empty batch to do nothing, got %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,157 @@
+package taskmaster
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// FieldChange is a difference between two values of a field of a task definition.
+type FieldChange struct {
+	Field string      // the path of the field, such as "Settings.Enabled" or "Actions[0].Path"
+	Old   interface{} // the old value of the field, or nil if it didn't exist
+	New   interface{} // the new value of the field, or nil if it doesn't exist anymore
+}
+
+func (c FieldChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Field, c.Old, c.New)
+}
+
+// DiffDefinitions returns the fields that differ between two task definitions.
+// Actions and triggers are compared by position. If the type of an action or
+// trigger changed, the whole action or trigger is reported as changed.
+// XMLText isn't compared, as it holds the whole definition, whose changes are
+// already reported field by field.
+func DiffDefinitions(oldDef, newDef Definition) []FieldChange {
+	oldDef.XMLText, newDef.XMLText = "", ""
+
+	var changes []FieldChange
+	diffValues("", reflect.ValueOf(oldDef), reflect.ValueOf(newDef), &changes)
+
+	return changes
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func diffValues(field string, oldVal, newVal reflect.Value, changes *[]FieldChange) {
+	if !oldVal.IsValid() || !newVal.IsValid() {
+		if oldVal.IsValid() || newVal.IsValid() {
+			*changes = append(*changes, FieldChange{Field: field, Old: valueOf(oldVal), New: valueOf(newVal)})
+		}
+		return
+	}
+	if oldVal.Type() != newVal.Type() {
+		*changes = append(*changes, FieldChange{Field: field, Old: valueOf(oldVal), New: valueOf(newVal)})
+		return
+	}
+
+	switch oldVal.Kind() {
+	case reflect.Interface:
+		if oldVal.IsNil() || newVal.IsNil() {
+			if oldVal.IsNil() != newVal.IsNil() {
+				*changes = append(*changes, FieldChange{Field: field, Old: valueOf(oldVal), New: valueOf(newVal)})
+			}
+			return
+		}
+		diffValues(field, oldVal.Elem(), newVal.Elem(), changes)
+	case reflect.Ptr:
+		// unset values are reported as nil, and set values as the values
+		// they point to
+		if oldVal.IsNil() || newVal.IsNil() {
+			if oldVal.IsNil() != newVal.IsNil() {
+				*changes = append(*changes, FieldChange{Field: field, Old: valueOf(oldVal.Elem()), New: valueOf(newVal.Elem())})
+			}
+			return
+		}
+		diffValues(field, oldVal.Elem(), newVal.Elem(), changes)
+	case reflect.Struct:
+		if oldVal.Type() == timeType {
+			if !oldVal.Interface().(time.Time).Equal(newVal.Interface().(time.Time)) {
+				*changes = append(*changes, FieldChange{Field: field, Old: oldVal.Interface(), New: newVal.Interface()})
+			}
+			return
+		}
+		if !hasOnlyExportedFields(oldVal.Type()) {
+			// compare structs from other packages, such as period.Period, as a whole
+			if !reflect.DeepEqual(oldVal.Interface(), newVal.Interface()) {
+				*changes = append(*changes, FieldChange{Field: field, Old: oldVal.Interface(), New: newVal.Interface()})
+			}
+			return
+		}
+		for i := 0; i < oldVal.NumField(); i++ {
+			structField := oldVal.Type().Field(i)
+			name := structField.Name
+			// fields of embedded structs are reported as fields of the parent struct
+			if !structField.Anonymous {
+				name = joinField(field, name)
+			} else {
+				name = field
+			}
+			diffValues(name, oldVal.Field(i), newVal.Field(i), changes)
+		}
+	case reflect.Slice:
+		n := oldVal.Len()
+		if newVal.Len() > n {
+			n = newVal.Len()
+		}
+		for i := 0; i < n; i++ {
+			var oldElem, newElem reflect.Value
+			if i < oldVal.Len() {
+				oldElem = oldVal.Index(i)
+			}
+			if i < newVal.Len() {
+				newElem = newVal.Index(i)
+			}
+			diffValues(fmt.Sprintf("%s[%d]", field, i), oldElem, newElem, changes)
+		}
+	case reflect.Map:
+		keys := make(map[string]reflect.Value)
+		for _, key := range oldVal.MapKeys() {
+			keys[fmt.Sprint(key.Interface())] = key
+		}
+		for _, key := range newVal.MapKeys() {
+			keys[fmt.Sprint(key.Interface())] = key
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			key := keys[name]
+			diffValues(fmt.Sprintf("%s[%s]", field, name), oldVal.MapIndex(key), newVal.MapIndex(key), changes)
+		}
+	default:
+		if !reflect.DeepEqual(oldVal.Interface(), newVal.Interface()) {
+			*changes = append(*changes, FieldChange{Field: field, Old: oldVal.Interface(), New: newVal.Interface()})
+		}
+	}
+}
+
+func hasOnlyExportedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+
+	return parent + "." + name
+}
+
+func valueOf(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	return v.Interface()
+}
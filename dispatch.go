@@ -0,0 +1,143 @@
+package taskmaster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// invoker prepares the goroutine of a dispatcher before any calls are made
+// on it, and cleans up after the last call was made. On Windows the invoker
+// locks the goroutine to its OS thread and initializes COM on that thread.
+type invoker interface {
+	setup() error
+	teardown()
+}
+
+// dispatcher serializes calls onto a single goroutine. Every COM object of a
+// TaskService is created and used on the goroutine of its dispatcher, so COM
+// calls are always made from the thread that initialized COM, regardless of
+// which goroutine the TaskService is used from.
+type dispatcher struct {
+	calls    chan dispatchedCall
+	closing  chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type dispatchedCall struct {
+	ctx    context.Context
+	fn     func() error
+	result chan error
+}
+
+// newDispatcher starts the goroutine of a new dispatcher and waits for inv
+// to set it up.
+func newDispatcher(inv invoker) (*dispatcher, error) {
+	d := &dispatcher{
+		calls:   make(chan dispatchedCall),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	setupErr := make(chan error, 1)
+	go d.loop(inv, setupErr)
+	if err := <-setupErr; err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *dispatcher) loop(inv invoker, setupErr chan<- error) {
+	defer close(d.done)
+
+	if err := inv.setup(); err != nil {
+		setupErr <- err
+		return
+	}
+	defer inv.teardown()
+	setupErr <- nil
+
+	for {
+		select {
+		case call := <-d.calls:
+			call.result <- call.invoke()
+		case <-d.closing:
+			return
+		}
+	}
+}
+
+func (c dispatchedCall) invoke() (err error) {
+	// don't start calls whose caller already gave up waiting
+	if err = c.ctx.Err(); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic during Task Scheduler call: %v", r)
+		}
+	}()
+
+	return c.fn()
+}
+
+// start queues fn to be called on the goroutine of the dispatcher, and returns
+// a channel that receives the error fn returns. If ctx is done before fn is
+// started, fn is not called and the error of ctx is sent instead. Panics in fn
+// are recovered and sent as errors.
+func (d *dispatcher) start(ctx context.Context, fn func() error) <-chan error {
+	result := make(chan error, 1)
+	if d == nil {
+		result <- ErrNotConnected
+		return result
+	}
+
+	select {
+	case d.calls <- dispatchedCall{ctx: ctx, fn: fn, result: result}:
+	case <-d.closing:
+		result <- ErrNotConnected
+	case <-ctx.Done():
+		result <- ctx.Err()
+	}
+
+	return result
+}
+
+// run is like start, but waits for fn to return. Once fn is started, run waits
+// for it to return even if ctx is done, so fn should check ctx itself if it
+// may run for a long time. ctx can only cancel fn between COM calls, as a COM
+// call can't be interrupted, and callers rely on fn having returned to use or
+// release the objects it created.
+func (d *dispatcher) run(ctx context.Context, fn func() error) error {
+	return <-d.start(ctx, fn)
+}
+
+// stop waits for the current call to return and tears down the goroutine of
+// the dispatcher. Calls made after stop fail with ErrNotConnected. stop must
+// not be called from a dispatched call.
+func (d *dispatcher) stop() {
+	if d == nil {
+		return
+	}
+
+	d.stopOnce.Do(func() {
+		close(d.closing)
+	})
+	<-d.done
+}
+
+func (d *dispatcher) stopped() bool {
+	if d == nil {
+		return true
+	}
+
+	select {
+	case <-d.closing:
+		return true
+	default:
+		return false
+	}
+}
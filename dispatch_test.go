@@ -0,0 +1,209 @@
+package taskmaster
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeInvoker struct {
+	setupErr  error
+	setups    int32
+	teardowns int32
+}
+
+func (f *fakeInvoker) setup() error {
+	atomic.AddInt32(&f.setups, 1)
+	return f.setupErr
+}
+
+func (f *fakeInvoker) teardown() {
+	atomic.AddInt32(&f.teardowns, 1)
+}
+
+func TestDispatcherSetupError(t *testing.T) {
+	inv := &fakeInvoker{setupErr: errors.New("setup failed")}
+
+	d, err := newDispatcher(inv)
+	if err != inv.setupErr {
+		t.Fatalf("expected setup error, got %v", err)
+	}
+	if d != nil {
+		t.Error("dispatcher should be nil")
+	}
+	if inv.teardowns != 0 {
+		t.Error("teardown shouldn't be called if setup failed")
+	}
+}
+
+func TestDispatcherRunsCallsInOrder(t *testing.T) {
+	inv := new(fakeInvoker)
+	d, err := newDispatcher(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.stop()
+
+	var order []int
+	for i := 0; i < 10; i++ {
+		i := i
+		err = d.run(context.Background(), func() error {
+			order = append(order, i)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, n := range order {
+		if i != n {
+			t.Fatalf("calls were executed out of order: %v", order)
+		}
+	}
+}
+
+func TestDispatcherSerializesConcurrentCalls(t *testing.T) {
+	d, err := newDispatcher(new(fakeInvoker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.stop()
+
+	var (
+		inFlight int32
+		calls    int
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := d.run(context.Background(), func() error {
+				if atomic.AddInt32(&inFlight, 1) != 1 {
+					return errors.New("calls were executed concurrently")
+				}
+				calls++
+				atomic.AddInt32(&inFlight, -1)
+
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 50 {
+		t.Fatalf("expected 50 calls, got %d", calls)
+	}
+}
+
+func TestDispatcherRecoversPanics(t *testing.T) {
+	d, err := newDispatcher(new(fakeInvoker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.stop()
+
+	err = d.run(context.Background(), func() error {
+		panic("COM call failed")
+	})
+	if err == nil || !strings.Contains(err.Error(), "COM call failed") {
+		t.Fatalf("expected error from recovered panic, got %v", err)
+	}
+
+	// the dispatcher should still accept calls after a panic
+	err = d.run(context.Background(), func() error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDispatcherCanceledWhileQueued(t *testing.T) {
+	d, err := newDispatcher(new(fakeInvoker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.stop()
+
+	// block the dispatcher so the next call stays queued
+	release := make(chan struct{})
+	blocking := d.start(context.Background(), func() error {
+		<-release
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var called bool
+	err = d.run(ctx, func() error {
+		called = true
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	close(release)
+	if err := <-blocking; err != nil {
+		t.Fatal(err)
+	}
+	if called {
+		t.Error("queued call shouldn't have been executed")
+	}
+}
+
+func TestDispatcherStop(t *testing.T) {
+	inv := new(fakeInvoker)
+	d, err := newDispatcher(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.stopped() {
+		t.Fatal("dispatcher shouldn't be stopped yet")
+	}
+	d.stop()
+	d.stop()
+
+	if !d.stopped() {
+		t.Error("dispatcher should be stopped")
+	}
+	if inv.setups != 1 || inv.teardowns != 1 {
+		t.Errorf("expected 1 setup and teardown, got %d and %d", inv.setups, inv.teardowns)
+	}
+
+	err = d.run(context.Background(), func() error {
+		t.Error("call shouldn't have been executed")
+		return nil
+	})
+	if err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+}
+
+func TestNilDispatcher(t *testing.T) {
+	var d *dispatcher
+
+	err := d.run(context.Background(), func() error {
+		t.Error("call shouldn't have been executed")
+		return nil
+	})
+	if err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+	if !d.stopped() {
+		t.Error("nil dispatcher should be stopped")
+	}
+	d.stop()
+}
@@ -0,0 +1,138 @@
+package taskmaster
+
+import (
+	"context"
+	"strings"
+)
+
+// EnumerateOptions changes which folders and tasks EnumerateTasks visits and
+// how tasks are parsed. The zero value visits every folder and task, including
+// hidden tasks, and parses task definitions.
+type EnumerateOptions struct {
+	// IncludeFolders are glob patterns of the paths of the folders whose
+	// tasks are visited, matched like the pattern of PathMatches. Folders
+	// that can't contain a matching folder aren't descended into. If empty,
+	// the tasks of every folder are visited.
+	IncludeFolders []string
+	// ExcludeFolders are glob patterns of the paths of folders that are
+	// skipped along with their subfolders.
+	ExcludeFolders []string
+	// MaxDepth is the number of levels of folders visited, counting the
+	// folder enumeration starts at. If zero, there is no limit.
+	MaxDepth int
+	// ExcludeHidden skips hidden tasks.
+	ExcludeHidden bool
+	// SummaryOnly skips parsing the definitions of tasks, which is much
+	// faster when only the names, paths, states and run times of tasks are
+	// needed.
+	SummaryOnly bool
+}
+
+// TaskVisitor is called by EnumerateTasks for every visited task. Returning
+// ErrSkipFolder skips the remaining tasks and the subfolders of the folder of
+// the task, and returning ErrStopEnumeration stops the enumeration. Any other
+// error stops the enumeration and is returned by EnumerateTasks.
+type TaskVisitor func(task RegisteredTask) error
+
+// folderLister returns the tasks and the paths of the subfolders of the folder
+// at path.
+type folderLister func(ctx context.Context, path string, opts EnumerateOptions) (RegisteredTaskCollection, []string, error)
+
+// enumerateTasks visits the tasks of the folder at path and its subfolders
+// depth first, listing one folder at a time. Tasks listed but not visited are
+// released.
+func enumerateTasks(ctx context.Context, path string, opts EnumerateOptions, list folderLister, fn TaskVisitor) error {
+	err := enumerateFolder(ctx, path, 1, opts, list, fn)
+	if err == ErrStopEnumeration || err == ErrSkipFolder {
+		return nil
+	}
+
+	return err
+}
+
+func enumerateFolder(ctx context.Context, path string, depth int, opts EnumerateOptions, list folderLister, fn TaskVisitor) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tasks, subFolders, err := list(ctx, path, opts)
+	if err != nil {
+		return err
+	}
+
+	if folderIncluded(path, opts.IncludeFolders) {
+		for i, task := range tasks {
+			if err := ctx.Err(); err != nil {
+				tasks[i:].Release()
+				return err
+			}
+
+			err := fn(task)
+			if err == ErrSkipFolder {
+				tasks[i+1:].Release()
+				return nil
+			} else if err != nil {
+				tasks[i+1:].Release()
+				return err
+			}
+		}
+	} else {
+		tasks.Release()
+	}
+
+	if opts.MaxDepth > 0 && depth >= opts.MaxDepth {
+		return nil
+	}
+	for _, subFolder := range subFolders {
+		if !folderMayBeIncluded(subFolder, opts.IncludeFolders) || matchesAnyGlob(subFolder, opts.ExcludeFolders) {
+			continue
+		}
+		if err := enumerateFolder(ctx, subFolder, depth+1, opts, list, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// folderIncluded reports whether the tasks of the folder at path are visited.
+func folderIncluded(path string, patterns []string) bool {
+	return len(patterns) == 0 || matchesAnyGlob(path, patterns)
+}
+
+// folderMayBeIncluded reports whether the folder at path or any of its
+// subfolders may match any of patterns.
+func folderMayBeIncluded(path string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	prefix := strings.ToLower(strings.TrimSuffix(path, `\`)) + `\`
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		wildcard := strings.IndexAny(pattern, "*?")
+		if wildcard == -1 {
+			if pattern+`\` == prefix || strings.HasPrefix(pattern, prefix) {
+				return true
+			}
+			continue
+		}
+
+		literal := pattern[:wildcard]
+		if strings.HasPrefix(literal, prefix) || strings.HasPrefix(prefix, literal) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAnyGlob(s string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if globMatch(pattern, s) {
+			return true
+		}
+	}
+
+	return false
+}
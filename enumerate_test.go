@@ -0,0 +1,200 @@
+package taskmaster
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fakeFolderLister lists the folders of a task folder tree and records the
+// paths of the folders it lists.
+type fakeFolderLister struct {
+	folders map[string]*TaskFolder
+	listed  []string
+	opts    EnumerateOptions
+}
+
+func newFakeFolderLister(root *TaskFolder) *fakeFolderLister {
+	l := &fakeFolderLister{folders: make(map[string]*TaskFolder)}
+	var add func(*TaskFolder)
+	add = func(folder *TaskFolder) {
+		l.folders[folder.Path] = folder
+		for _, subFolder := range folder.SubFolders {
+			add(subFolder)
+		}
+	}
+	add(root)
+
+	return l
+}
+
+func (l *fakeFolderLister) list(ctx context.Context, path string, opts EnumerateOptions) (RegisteredTaskCollection, []string, error) {
+	l.listed = append(l.listed, path)
+	l.opts = opts
+
+	folder, ok := l.folders[path]
+	if !ok {
+		return nil, nil, errors.New("folder doesn't exist")
+	}
+	var subFolders []string
+	for _, subFolder := range folder.SubFolders {
+		subFolders = append(subFolders, subFolder.Path)
+	}
+
+	return folder.RegisteredTasks, subFolders, nil
+}
+
+func newEnumerateTestFolder() *TaskFolder {
+	return &TaskFolder{
+		Path:            `\`,
+		RegisteredTasks: RegisteredTaskCollection{{Path: `\Root`}},
+		SubFolders: []*TaskFolder{
+			{
+				Path:            `\Microsoft`,
+				RegisteredTasks: RegisteredTaskCollection{{Path: `\Microsoft\Task`}},
+				SubFolders: []*TaskFolder{
+					{
+						Path:            `\Microsoft\Windows`,
+						RegisteredTasks: RegisteredTaskCollection{{Path: `\Microsoft\Windows\Defrag`}, {Path: `\Microsoft\Windows\Cleanup`}},
+						SubFolders: []*TaskFolder{{
+							Path:            `\Microsoft\Windows\Update`,
+							RegisteredTasks: RegisteredTaskCollection{{Path: `\Microsoft\Windows\Update\Scan`}},
+						}},
+					},
+					{
+						Path:            `\Microsoft\Office`,
+						RegisteredTasks: RegisteredTaskCollection{{Path: `\Microsoft\Office\Telemetry`}},
+					},
+				},
+			},
+			{
+				Path:            `\Backup`,
+				RegisteredTasks: RegisteredTaskCollection{{Path: `\Backup\Nightly`}},
+			},
+		},
+	}
+}
+
+func TestEnumerateTasks(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		opts     EnumerateOptions
+		skip     string
+		stop     string
+		tasks    []string
+		listed   []string
+		expected error
+	}{
+		{
+			name:   "all",
+			path:   `\`,
+			tasks:  []string{`\Root`, `\Microsoft\Task`, `\Microsoft\Windows\Defrag`, `\Microsoft\Windows\Cleanup`, `\Microsoft\Windows\Update\Scan`, `\Microsoft\Office\Telemetry`, `\Backup\Nightly`},
+			listed: []string{`\`, `\Microsoft`, `\Microsoft\Windows`, `\Microsoft\Windows\Update`, `\Microsoft\Office`, `\Backup`},
+		},
+		{
+			name:   "include",
+			path:   `\`,
+			opts:   EnumerateOptions{IncludeFolders: []string{`\Microsoft\Windows*`}},
+			tasks:  []string{`\Microsoft\Windows\Defrag`, `\Microsoft\Windows\Cleanup`, `\Microsoft\Windows\Update\Scan`},
+			listed: []string{`\`, `\Microsoft`, `\Microsoft\Windows`, `\Microsoft\Windows\Update`},
+		},
+		{
+			name:   "include literal",
+			path:   `\`,
+			opts:   EnumerateOptions{IncludeFolders: []string{`\backup`}},
+			tasks:  []string{`\Backup\Nightly`},
+			listed: []string{`\`, `\Backup`},
+		},
+		{
+			name:   "exclude",
+			path:   `\`,
+			opts:   EnumerateOptions{ExcludeFolders: []string{`\Microsoft\*`}},
+			tasks:  []string{`\Root`, `\Microsoft\Task`, `\Backup\Nightly`},
+			listed: []string{`\`, `\Microsoft`, `\Backup`},
+		},
+		{
+			name:   "max depth",
+			path:   `\Microsoft`,
+			opts:   EnumerateOptions{MaxDepth: 2},
+			tasks:  []string{`\Microsoft\Task`, `\Microsoft\Windows\Defrag`, `\Microsoft\Windows\Cleanup`, `\Microsoft\Office\Telemetry`},
+			listed: []string{`\Microsoft`, `\Microsoft\Windows`, `\Microsoft\Office`},
+		},
+		{
+			name:   "skip folder",
+			path:   `\Microsoft`,
+			skip:   `\Microsoft\Windows\Defrag`,
+			tasks:  []string{`\Microsoft\Task`, `\Microsoft\Windows\Defrag`, `\Microsoft\Office\Telemetry`},
+			listed: []string{`\Microsoft`, `\Microsoft\Windows`, `\Microsoft\Office`},
+		},
+		{
+			name:   "stop",
+			path:   `\`,
+			stop:   `\Microsoft\Windows\Defrag`,
+			tasks:  []string{`\Root`, `\Microsoft\Task`, `\Microsoft\Windows\Defrag`},
+			listed: []string{`\`, `\Microsoft`, `\Microsoft\Windows`},
+		},
+		{
+			name:     "missing folder",
+			path:     `\Missing`,
+			listed:   []string{`\Missing`},
+			expected: errors.New("folder doesn't exist"),
+		},
+	}
+
+	for _, test := range tests {
+		lister := newFakeFolderLister(newEnumerateTestFolder())
+
+		var tasks []string
+		err := enumerateTasks(context.Background(), test.path, test.opts, lister.list, func(task RegisteredTask) error {
+			tasks = append(tasks, task.Path)
+			switch task.Path {
+			case test.skip:
+				return ErrSkipFolder
+			case test.stop:
+				return ErrStopEnumeration
+			}
+			return nil
+		})
+		if !reflect.DeepEqual(err, test.expected) {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.expected, err)
+		}
+		if !reflect.DeepEqual(tasks, test.tasks) {
+			t.Errorf("%s: expected tasks %v, got %v", test.name, test.tasks, tasks)
+		}
+		if !reflect.DeepEqual(lister.listed, test.listed) {
+			t.Errorf("%s: expected listed folders %v, got %v", test.name, test.listed, lister.listed)
+		}
+	}
+}
+
+func TestEnumerateTasksErrors(t *testing.T) {
+	lister := newFakeFolderLister(newEnumerateTestFolder())
+	visitErr := errors.New("visit failed")
+
+	var visited int
+	err := enumerateTasks(context.Background(), `\`, EnumerateOptions{SummaryOnly: true}, lister.list, func(task RegisteredTask) error {
+		visited++
+		return visitErr
+	})
+	if err != visitErr {
+		t.Errorf("expected error %v, got %v", visitErr, err)
+	}
+	if visited != 1 {
+		t.Errorf("expected 1 visited task, got %d", visited)
+	}
+	if !lister.opts.SummaryOnly {
+		t.Error("expected options to be passed to the lister")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = enumerateTasks(ctx, `\`, EnumerateOptions{}, lister.list, func(task RegisteredTask) error {
+		t.Error("unexpected visit after cancellation")
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("expected error %v, got %v", context.Canceled, err)
+	}
+}
@@ -1,5 +1,3 @@
-// +build windows
-
 package taskmaster
 
 import (
@@ -16,6 +14,10 @@ var (
 	ErrNoActions            = errors.New("definition must have at least one action")
 	ErrInvalidPrinciple     = errors.New("both UserId and GroupId are defined for the principal; they are mutually exclusive")
 	ErrRunningTaskCompleted = errors.New("the running task completed while it was getting parsed")
+	ErrNotConnected         = errors.New("not connected to the Task Scheduler service")
+	ErrUnsupportedPlatform  = errors.New("the Task Scheduler service can only be managed on Windows")
+	ErrSkipFolder           = errors.New("skip this folder")
+	ErrStopEnumeration      = errors.New("stop enumeration")
 )
 
 func getTaskSchedulerError(err error) error {
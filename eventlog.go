@@ -0,0 +1,172 @@
+// +build windows
+
+package taskmaster
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	wevtapi = syscall.NewLazyDLL("wevtapi.dll")
+
+	procEvtOpenSession = wevtapi.NewProc("EvtOpenSession")
+	procEvtQuery       = wevtapi.NewProc("EvtQuery")
+	procEvtNext        = wevtapi.NewProc("EvtNext")
+	procEvtRender      = wevtapi.NewProc("EvtRender")
+	procEvtClose       = wevtapi.NewProc("EvtClose")
+)
+
+const (
+	evtRPCLogin              = 1
+	evtRPCLoginAuthNegotiate = 2
+	evtQueryChannelPath      = 0x1
+	evtQueryForwardDirection = 0x100
+	evtRenderEventXML        = 1
+	evtInfinite              = 0xFFFFFFFF
+
+	errorInsufficientBuffer = 122
+	errorNoMoreItems        = 259
+
+	// evtBatchSize is the number of events read from the event log at once.
+	evtBatchSize = 64
+)
+
+// evtRPCLoginInfo is the EVT_RPC_LOGIN structure.
+type evtRPCLoginInfo struct {
+	Server   *uint16
+	User     *uint16
+	Domain   *uint16
+	Password *uint16
+	Flags    uint32
+}
+
+// queryHistoryEvents returns the events of the Task Scheduler operational
+// event log of server that match the XPath query. The local event log is read
+// if server is empty. The event log of server is read with the credentials of
+// username, or of the current user if it's empty. ctx is checked between
+// batches of events.
+func queryHistoryEvents(ctx context.Context, server, domain, username, password, query string) ([]HistoryEvent, error) {
+	var session uintptr
+	if server != "" {
+		var err error
+		session, err = openEventLogSession(server, domain, username, password)
+		if err != nil {
+			return nil, err
+		}
+		defer procEvtClose.Call(session)
+	}
+
+	channelPtr, err := syscall.UTF16PtrFromString(TaskSchedulerOperationalLog)
+	if err != nil {
+		return nil, err
+	}
+	queryPtr, err := syscall.UTF16PtrFromString(query)
+	if err != nil {
+		return nil, err
+	}
+	resultSet, _, err := procEvtQuery.Call(session, uintptr(unsafe.Pointer(channelPtr)), uintptr(unsafe.Pointer(queryPtr)), evtQueryChannelPath|evtQueryForwardDirection)
+	if resultSet == 0 {
+		return nil, fmt.Errorf("error querying event log: %v", err)
+	}
+	defer procEvtClose.Call(resultSet)
+
+	var (
+		events  []HistoryEvent
+		handles [evtBatchSize]uintptr
+		buf     []uint16
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return events, err
+		}
+
+		var returned uint32
+		ok, _, err := procEvtNext.Call(resultSet, evtBatchSize, uintptr(unsafe.Pointer(&handles[0])), evtInfinite, 0, uintptr(unsafe.Pointer(&returned)))
+		if ok == 0 {
+			if err == syscall.Errno(errorNoMoreItems) {
+				return events, nil
+			}
+			return events, fmt.Errorf("error reading event log: %v", err)
+		}
+
+		for i := 0; i < int(returned); i++ {
+			var xmlText string
+			xmlText, buf, err = renderEventXML(handles[i], buf)
+			procEvtClose.Call(handles[i])
+			if err != nil {
+				for _, handle := range handles[i+1 : returned] {
+					procEvtClose.Call(handle)
+				}
+				return events, err
+			}
+
+			event, err := ParseHistoryEvent([]byte(xmlText))
+			if err != nil {
+				for _, handle := range handles[i+1 : returned] {
+					procEvtClose.Call(handle)
+				}
+				return events, err
+			}
+			events = append(events, event)
+		}
+	}
+}
+
+// openEventLogSession opens a session to the event log of a remote computer.
+// The copy of the password passed to Windows is cleared once the session is
+// open.
+func openEventLogSession(server, domain, username, password string) (uintptr, error) {
+	login := evtRPCLoginInfo{Flags: evtRPCLoginAuthNegotiate}
+
+	var err error
+	if login.Server, err = syscall.UTF16PtrFromString(server); err != nil {
+		return 0, err
+	}
+	var passwordBuf []uint16
+	if username != "" {
+		if login.User, err = syscall.UTF16PtrFromString(username); err != nil {
+			return 0, err
+		}
+		if domain != "" {
+			if login.Domain, err = syscall.UTF16PtrFromString(domain); err != nil {
+				return 0, err
+			}
+		}
+		if passwordBuf, err = syscall.UTF16FromString(password); err != nil {
+			return 0, err
+		}
+		login.Password = &passwordBuf[0]
+	}
+
+	session, _, err := procEvtOpenSession.Call(evtRPCLogin, uintptr(unsafe.Pointer(&login)), 0, 0)
+	for i := range passwordBuf {
+		passwordBuf[i] = 0
+	}
+	if session == 0 {
+		return 0, fmt.Errorf("error connecting to event log of %s: %v", server, err)
+	}
+
+	return session, nil
+}
+
+// renderEventXML renders an event as XML, reusing buf if it's large enough.
+func renderEventXML(event uintptr, buf []uint16) (string, []uint16, error) {
+	for {
+		var used, propertyCount uint32
+		var bufPtr uintptr
+		if len(buf) != 0 {
+			bufPtr = uintptr(unsafe.Pointer(&buf[0]))
+		}
+		ok, _, err := procEvtRender.Call(0, event, evtRenderEventXML, uintptr(len(buf)*2), bufPtr, uintptr(unsafe.Pointer(&used)), uintptr(unsafe.Pointer(&propertyCount)))
+		if ok != 0 {
+			return syscall.UTF16ToString(buf[:used/2]), buf, nil
+		}
+		if err != syscall.Errno(errorInsufficientBuffer) {
+			return "", buf, fmt.Errorf("error rendering event: %v", err)
+		}
+		buf = make([]uint16, (used+1)/2)
+	}
+}
@@ -0,0 +1,704 @@
+package taskmaster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	evtxFileSignature    = "ElfFile\x00"
+	evtxChunkSignature   = "ElfChnk\x00"
+	evtxFileHeaderSize   = 128
+	evtxChunkSize        = 0x10000
+	evtxChunkHeaderSize  = 0x200
+	evtxRecordSignature  = 0x00002a2a
+	evtxRecordHeaderSize = 24
+
+	// the maximum nesting of templates and BinXML values
+	binXMLMaxDepth = 32
+)
+
+// BinXML tokens, the 0x40 bit of a token is a flag and is ignored here
+const (
+	binXMLEndOfStream          = 0x00
+	binXMLOpenStartElement     = 0x01
+	binXMLCloseStartElement    = 0x02
+	binXMLCloseEmptyElement    = 0x03
+	binXMLEndElement           = 0x04
+	binXMLValueText            = 0x05
+	binXMLAttribute            = 0x06
+	binXMLCDATASection         = 0x07
+	binXMLCharRef              = 0x08
+	binXMLEntityRef            = 0x09
+	binXMLPITarget             = 0x0a
+	binXMLPIData               = 0x0b
+	binXMLTemplateInstance     = 0x0c
+	binXMLNormalSubstitution   = 0x0d
+	binXMLOptionalSubstitution = 0x0e
+	binXMLFragmentHeader       = 0x0f
+
+	binXMLHasMoreFlag = 0x40
+)
+
+// BinXML value types
+const (
+	binXMLTypeNull       = 0x00
+	binXMLTypeString     = 0x01
+	binXMLTypeAnsiString = 0x02
+	binXMLTypeInt8       = 0x03
+	binXMLTypeUInt8      = 0x04
+	binXMLTypeInt16      = 0x05
+	binXMLTypeUInt16     = 0x06
+	binXMLTypeInt32      = 0x07
+	binXMLTypeUInt32     = 0x08
+	binXMLTypeInt64      = 0x09
+	binXMLTypeUInt64     = 0x0a
+	binXMLTypeReal32     = 0x0b
+	binXMLTypeReal64     = 0x0c
+	binXMLTypeBool       = 0x0d
+	binXMLTypeBinary     = 0x0e
+	binXMLTypeGUID       = 0x0f
+	binXMLTypeSizeT      = 0x10
+	binXMLTypeFileTime   = 0x11
+	binXMLTypeSystemTime = 0x12
+	binXMLTypeSID        = 0x13
+	binXMLTypeHexInt32   = 0x14
+	binXMLTypeHexInt64   = 0x15
+	binXMLTypeBinXML     = 0x21
+	binXMLTypeArray      = 0x80
+)
+
+// EVTXRecord is an event record read from an EVTX file.
+type EVTXRecord struct {
+	RecordID uint64    // the identifier of the record
+	Written  time.Time // when the record was written
+	XML      string    // the event rendered as XML
+}
+
+// EVTXReader reads event records from an EVTX event log file, such as
+// Microsoft-Windows-TaskScheduler%4Operational.evtx. Only the parts of the
+// format needed to render events as XML are supported, checksums aren't verified.
+type EVTXReader struct {
+	r        io.Reader
+	chunk    []byte
+	chunkNum int
+	offset   int // the offset of the next record in the current chunk
+	end      int // the offset of the free space in the current chunk
+}
+
+// EVTXRecordError is returned by EVTXReader.Next when a record can't be read
+// or rendered. Reading can continue with the next record.
+type EVTXRecordError struct {
+	Chunk    int    // the number of the chunk of the record, starting from 0
+	Offset   int    // the offset of the record in its chunk
+	RecordID uint64 // the identifier of the record, or 0 if its header is invalid
+	Err      error
+}
+
+func (e *EVTXRecordError) Error() string {
+	if e.RecordID != 0 {
+		return fmt.Sprintf("error reading record %d at offset %#x of chunk %d: %v", e.RecordID, e.Offset, e.Chunk, e.Err)
+	}
+
+	return fmt.Sprintf("error reading record at offset %#x of chunk %d: %v", e.Offset, e.Chunk, e.Err)
+}
+
+// NewEVTXReader reads the file header of an EVTX file and returns a reader
+// of its event records.
+func NewEVTXReader(r io.Reader) (*EVTXReader, error) {
+	header := make([]byte, evtxFileHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("error reading EVTX file header: %v", err)
+	}
+	if string(header[:8]) != evtxFileSignature {
+		return nil, fmt.Errorf("error reading EVTX file header: invalid signature %q", header[:8])
+	}
+	if majorVersion := binary.LittleEndian.Uint16(header[38:]); majorVersion != 3 {
+		return nil, fmt.Errorf("error reading EVTX file header: unsupported version %d", majorVersion)
+	}
+
+	blockSize := int64(binary.LittleEndian.Uint16(header[40:]))
+	if blockSize < evtxFileHeaderSize {
+		return nil, fmt.Errorf("error reading EVTX file header: invalid header block size %d", blockSize)
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, blockSize-evtxFileHeaderSize); err != nil {
+		return nil, fmt.Errorf("error reading EVTX file header: %v", err)
+	}
+
+	return &EVTXReader{r: r}, nil
+}
+
+// Next returns the next event record. At the end of the file, Next returns
+// io.EOF. If a record can't be read or rendered, an *EVTXRecordError is
+// returned and the next call to Next continues with the following record, or
+// with the next chunk if the size of the record is unknown. Other errors are
+// returned if the file or a chunk header can't be read.
+func (e *EVTXReader) Next() (EVTXRecord, error) {
+	for e.chunk == nil || e.offset+evtxRecordHeaderSize > e.end {
+		if err := e.nextChunk(); err != nil {
+			return EVTXRecord{}, err
+		}
+	}
+
+	offset := e.offset
+	if binary.LittleEndian.Uint32(e.chunk[offset:]) != evtxRecordSignature {
+		// the rest of the chunk can't be read without knowing the size of the record
+		e.offset = e.end
+		return EVTXRecord{}, &EVTXRecordError{Chunk: e.chunkNum - 1, Offset: offset, Err: errors.New("invalid signature")}
+	}
+	size := int(binary.LittleEndian.Uint32(e.chunk[offset+4:]))
+	if size < evtxRecordHeaderSize+4 || offset+size > e.end {
+		e.offset = e.end
+		return EVTXRecord{}, &EVTXRecordError{Chunk: e.chunkNum - 1, Offset: offset, Err: fmt.Errorf("invalid size %d", size)}
+	}
+	e.offset += size
+
+	record := EVTXRecord{
+		RecordID: binary.LittleEndian.Uint64(e.chunk[offset+8:]),
+		Written:  fileTimeToTime(binary.LittleEndian.Uint64(e.chunk[offset+16:])),
+	}
+	xmlText, err := renderBinXML(e.chunk, offset+evtxRecordHeaderSize, offset+size-4)
+	if err != nil {
+		return record, &EVTXRecordError{Chunk: e.chunkNum - 1, Offset: offset, RecordID: record.RecordID, Err: err}
+	}
+	record.XML = xmlText
+
+	return record, nil
+}
+
+func (e *EVTXReader) nextChunk() error {
+	if e.chunk == nil {
+		e.chunk = make([]byte, evtxChunkSize)
+	}
+
+	for {
+		_, err := io.ReadFull(e.r, e.chunk)
+		if err == io.EOF {
+			return io.EOF
+		} else if err != nil {
+			return fmt.Errorf("error reading chunk %d: %v", e.chunkNum, err)
+		}
+		e.chunkNum++
+
+		// unused chunks are zeroed
+		if string(e.chunk[:8]) != evtxChunkSignature {
+			continue
+		}
+		end := int(binary.LittleEndian.Uint32(e.chunk[48:]))
+		if end < evtxChunkHeaderSize || end > evtxChunkSize {
+			return fmt.Errorf("error reading chunk %d: invalid free space offset %#x", e.chunkNum-1, end)
+		}
+		e.offset = evtxChunkHeaderSize
+		e.end = end
+
+		return nil
+	}
+}
+
+// HistoryRecordsError is returned by ReadHistoryEVTX when records were skipped
+// because they couldn't be read or parsed.
+type HistoryRecordsError struct {
+	Errs []error // the errors of the skipped records, in the order of the file
+}
+
+func (e *HistoryRecordsError) Error() string {
+	if len(e.Errs) == 1 {
+		return fmt.Sprintf("1 record was skipped: %v", e.Errs[0])
+	}
+
+	return fmt.Sprintf("%d records were skipped, the first: %v", len(e.Errs), e.Errs[0])
+}
+
+// ReadHistoryEVTX reads and parses the events of a Task Scheduler operational
+// event log file. The events can be correlated into runs with CorrelateRuns.
+// Records that can't be read or parsed, which are common in damaged or
+// partially overwritten logs, are skipped, and the events of the other
+// records are returned along with a *HistoryRecordsError. Reading stops if the
+// file or a chunk header can't be read, and the events read so far are
+// returned along with the error.
+func ReadHistoryEVTX(r io.Reader) ([]HistoryEvent, error) {
+	reader, err := NewEVTXReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		events  []HistoryEvent
+		skipped []error
+	)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if _, ok := err.(*EVTXRecordError); ok {
+			skipped = append(skipped, err)
+			continue
+		} else if err != nil {
+			if len(skipped) != 0 {
+				return events, fmt.Errorf("%v; %v", err, &HistoryRecordsError{Errs: skipped})
+			}
+			return events, err
+		}
+
+		event, err := ParseHistoryEvent([]byte(record.XML))
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("error parsing record %d: %v", record.RecordID, err))
+			continue
+		}
+		events = append(events, event)
+	}
+	if len(skipped) != 0 {
+		return events, &HistoryRecordsError{Errs: skipped}
+	}
+
+	return events, nil
+}
+
+// fileTimeToTime converts a FILETIME, the number of 100 nanosecond intervals
+// since January 1, 1601 UTC, to a time.
+func fileTimeToTime(fileTime uint64) time.Time {
+	if fileTime == 0 {
+		return time.Time{}
+	}
+
+	// the number of 100 nanosecond intervals between 1601 and 1970
+	const epochDiff = 116444736000000000
+	t := int64(fileTime - epochDiff)
+
+	return time.Unix(t/1e7, (t%1e7)*100).UTC()
+}
+
+// binXMLError is used to abort rendering of malformed BinXML.
+type binXMLError string
+
+// binXMLSubstitution is a substitution value of a template instance.
+type binXMLSubstitution struct {
+	valueType byte
+	offset    int // the offset of the value in the chunk
+	data      []byte
+}
+
+// binXMLRenderer renders BinXML to XML text. Offsets of names and templates
+// are relative to the start of the chunk.
+type binXMLRenderer struct {
+	chunk []byte
+	pos   int
+	end   int
+	depth int
+}
+
+func renderBinXML(chunk []byte, start, end int) (xmlText string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(binXMLError); ok {
+				err = fmt.Errorf("invalid BinXML: %s", string(e))
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	var buf bytes.Buffer
+	r := &binXMLRenderer{chunk: chunk, pos: start, end: end}
+	r.renderFragment(&buf, nil)
+
+	return buf.String(), nil
+}
+
+func (r *binXMLRenderer) fail(format string, args ...interface{}) {
+	panic(binXMLError(fmt.Sprintf(format, args...)))
+}
+
+func (r *binXMLRenderer) need(n int) {
+	if n < 0 || r.pos+n > r.end {
+		r.fail("unexpected end of data at offset %#x", r.pos)
+	}
+}
+
+func (r *binXMLRenderer) peek() byte {
+	r.need(1)
+	return r.chunk[r.pos]
+}
+
+func (r *binXMLRenderer) u8() byte {
+	r.need(1)
+	r.pos++
+	return r.chunk[r.pos-1]
+}
+
+func (r *binXMLRenderer) u16() uint16 {
+	r.need(2)
+	r.pos += 2
+	return binary.LittleEndian.Uint16(r.chunk[r.pos-2:])
+}
+
+func (r *binXMLRenderer) u32() uint32 {
+	r.need(4)
+	r.pos += 4
+	return binary.LittleEndian.Uint32(r.chunk[r.pos-4:])
+}
+
+func (r *binXMLRenderer) bytes(n int) []byte {
+	r.need(n)
+	r.pos += n
+	return r.chunk[r.pos-n : r.pos]
+}
+
+// wstring reads a UTF-16 string prefixed with its length in characters.
+func (r *binXMLRenderer) wstring() string {
+	n := int(r.u16())
+	return decodeUTF16(r.bytes(2 * n))
+}
+
+// name reads the offset of a name and returns the name. If the name is stored
+// right after its offset, it is skipped.
+func (r *binXMLRenderer) name() string {
+	offset := int(r.u32())
+
+	// names are stored as the offset of the next name, a hash, the number
+	// of characters and the characters followed by a null character
+	if offset < 0 || offset+8 > len(r.chunk) {
+		r.fail("invalid name offset %#x", offset)
+	}
+	n := int(binary.LittleEndian.Uint16(r.chunk[offset+6:]))
+	if offset+8+2*n > len(r.chunk) {
+		r.fail("invalid name at offset %#x", offset)
+	}
+	name := decodeUTF16(r.chunk[offset+8 : offset+8+2*n])
+
+	if offset == r.pos {
+		r.bytes(8 + 2*n + 2)
+	}
+
+	return name
+}
+
+// sub returns a renderer of the data at offset in the chunk.
+func (r *binXMLRenderer) sub(start, end int) *binXMLRenderer {
+	if r.depth >= binXMLMaxDepth {
+		r.fail("templates nested too deeply")
+	}
+	if start < 0 || start > end || end > len(r.chunk) {
+		r.fail("invalid offset %#x", start)
+	}
+
+	return &binXMLRenderer{chunk: r.chunk, pos: start, end: end, depth: r.depth + 1}
+}
+
+func (r *binXMLRenderer) renderFragment(buf *bytes.Buffer, values []binXMLSubstitution) {
+	for {
+		switch token := r.peek(); token &^ binXMLHasMoreFlag {
+		case binXMLEndOfStream:
+			r.pos++
+			return
+		case binXMLFragmentHeader:
+			// token, major version, minor version and flags
+			r.bytes(4)
+		case binXMLTemplateInstance:
+			r.renderTemplateInstance(buf)
+		case binXMLOpenStartElement:
+			r.renderElement(buf, values)
+		default:
+			r.fail("unexpected token %#x at offset %#x", token, r.pos)
+		}
+	}
+}
+
+func (r *binXMLRenderer) renderTemplateInstance(buf *bytes.Buffer) {
+	r.bytes(2) // token and an unknown byte
+	r.u32()    // template identifier
+	templateOffset := int(r.u32())
+
+	// templates are defined by the offset of the next template, a GUID, the
+	// size of the template data and the template data
+	if templateOffset < 0 || templateOffset+24 > len(r.chunk) {
+		r.fail("invalid template offset %#x", templateOffset)
+	}
+	templateSize := int(binary.LittleEndian.Uint32(r.chunk[templateOffset+20:]))
+	templateEnd := templateOffset + 24 + templateSize
+	if templateEnd > len(r.chunk) {
+		r.fail("invalid template at offset %#x", templateOffset)
+	}
+	// the template is stored right after the first instance of it
+	if templateOffset == r.pos {
+		r.bytes(24 + templateSize)
+	}
+
+	n := int(r.u32())
+	r.need(4 * n)
+	values := make([]binXMLSubstitution, n)
+	for i := range values {
+		size := int(r.u16())
+		values[i].valueType = r.u8()
+		r.u8()
+		values[i].data = make([]byte, size)
+	}
+	for i := range values {
+		values[i].offset = r.pos
+		values[i].data = r.bytes(len(values[i].data))
+	}
+
+	r.sub(templateOffset+24, templateEnd).renderFragment(buf, values)
+}
+
+func (r *binXMLRenderer) renderElement(buf *bytes.Buffer, values []binXMLSubstitution) {
+	token := r.u8()
+	r.u16() // dependency identifier
+	r.u32() // data size
+	name := r.name()
+
+	buf.WriteString("<" + name)
+	if token&binXMLHasMoreFlag != 0 {
+		r.u32() // attribute list size
+		for r.peek()&^binXMLHasMoreFlag == binXMLAttribute {
+			r.pos++
+			attrName := r.name()
+
+			var value bytes.Buffer
+			for r.isValueToken() {
+				r.renderValue(&value, values)
+			}
+			buf.WriteString(" " + attrName + `="`)
+			buf.Write(value.Bytes())
+			buf.WriteString(`"`)
+		}
+	}
+
+	switch token := r.u8(); token {
+	case binXMLCloseEmptyElement:
+		buf.WriteString("/>")
+		return
+	case binXMLCloseStartElement:
+		buf.WriteString(">")
+	default:
+		r.fail("unexpected token %#x at offset %#x", token, r.pos-1)
+	}
+
+	for {
+		switch token := r.peek(); token &^ binXMLHasMoreFlag {
+		case binXMLEndElement:
+			r.pos++
+			buf.WriteString("</" + name + ">")
+			return
+		case binXMLOpenStartElement:
+			r.renderElement(buf, values)
+		case binXMLTemplateInstance:
+			r.renderTemplateInstance(buf)
+		case binXMLPITarget:
+			r.pos++
+			buf.WriteString("<?" + r.name())
+		case binXMLPIData:
+			r.pos++
+			buf.WriteString(" " + r.wstring() + "?>")
+		default:
+			if !r.isValueToken() {
+				r.fail("unexpected token %#x at offset %#x", token, r.pos)
+			}
+			r.renderValue(buf, values)
+		}
+	}
+}
+
+func (r *binXMLRenderer) isValueToken() bool {
+	switch r.peek() &^ binXMLHasMoreFlag {
+	case binXMLValueText, binXMLCDATASection, binXMLCharRef, binXMLEntityRef, binXMLNormalSubstitution, binXMLOptionalSubstitution:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *binXMLRenderer) renderValue(buf *bytes.Buffer, values []binXMLSubstitution) {
+	switch token := r.u8(); token &^ binXMLHasMoreFlag {
+	case binXMLValueText:
+		if valueType := r.u8(); valueType != binXMLTypeString {
+			r.fail("unsupported value type %#x at offset %#x", valueType, r.pos-1)
+		}
+		xml.EscapeText(buf, []byte(r.wstring()))
+	case binXMLCDATASection:
+		xml.EscapeText(buf, []byte(r.wstring()))
+	case binXMLCharRef:
+		fmt.Fprintf(buf, "&#%d;", r.u16())
+	case binXMLEntityRef:
+		buf.WriteString("&" + r.name() + ";")
+	case binXMLNormalSubstitution, binXMLOptionalSubstitution:
+		id := int(r.u16())
+		r.u8() // value type
+		if id >= len(values) {
+			r.fail("invalid substitution %d at offset %#x", id, r.pos-3)
+		}
+		r.renderSubstitution(buf, values[id])
+	}
+}
+
+func (r *binXMLRenderer) renderSubstitution(buf *bytes.Buffer, value binXMLSubstitution) {
+	switch value.valueType {
+	case binXMLTypeNull:
+	case binXMLTypeBinXML:
+		r.sub(value.offset, value.offset+len(value.data)).renderFragment(buf, nil)
+	default:
+		text, err := formatBinXMLValue(value.valueType, value.data)
+		if err != nil {
+			r.fail("%v", err)
+		}
+		xml.EscapeText(buf, []byte(text))
+	}
+}
+
+// binXMLTypeSizes are the sizes of fixed size value types.
+var binXMLTypeSizes = map[byte]int{
+	binXMLTypeInt8:       1,
+	binXMLTypeUInt8:      1,
+	binXMLTypeInt16:      2,
+	binXMLTypeUInt16:     2,
+	binXMLTypeInt32:      4,
+	binXMLTypeUInt32:     4,
+	binXMLTypeInt64:      8,
+	binXMLTypeUInt64:     8,
+	binXMLTypeReal32:     4,
+	binXMLTypeReal64:     8,
+	binXMLTypeBool:       4,
+	binXMLTypeGUID:       16,
+	binXMLTypeFileTime:   8,
+	binXMLTypeSystemTime: 16,
+	binXMLTypeHexInt32:   4,
+	binXMLTypeHexInt64:   8,
+}
+
+// formatBinXMLValue formats a substitution value the way Windows renders it.
+func formatBinXMLValue(valueType byte, data []byte) (string, error) {
+	if valueType&binXMLTypeArray != 0 {
+		elemType := valueType &^ binXMLTypeArray
+		if elemType == binXMLTypeString {
+			return strings.Join(strings.Split(strings.TrimRight(decodeUTF16(data), "\x00"), "\x00"), ","), nil
+		}
+
+		size, ok := binXMLTypeSizes[elemType]
+		if !ok || len(data)%size != 0 {
+			return "", fmt.Errorf("unsupported array value type %#x", valueType)
+		}
+		elems := make([]string, 0, len(data)/size)
+		for i := 0; i < len(data); i += size {
+			elem, err := formatBinXMLValue(elemType, data[i:i+size])
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, elem)
+		}
+
+		return strings.Join(elems, ","), nil
+	}
+
+	if size, ok := binXMLTypeSizes[valueType]; ok && len(data) < size {
+		return "", fmt.Errorf("value of type %#x is too short", valueType)
+	}
+
+	le := binary.LittleEndian
+	switch valueType {
+	case binXMLTypeString:
+		return strings.TrimRight(decodeUTF16(data), "\x00"), nil
+	case binXMLTypeAnsiString:
+		return strings.TrimRight(string(data), "\x00"), nil
+	case binXMLTypeInt8:
+		return strconv.Itoa(int(int8(data[0]))), nil
+	case binXMLTypeUInt8:
+		return strconv.Itoa(int(data[0])), nil
+	case binXMLTypeInt16:
+		return strconv.Itoa(int(int16(le.Uint16(data)))), nil
+	case binXMLTypeUInt16:
+		return strconv.Itoa(int(le.Uint16(data))), nil
+	case binXMLTypeInt32:
+		return strconv.FormatInt(int64(int32(le.Uint32(data))), 10), nil
+	case binXMLTypeUInt32:
+		return strconv.FormatUint(uint64(le.Uint32(data)), 10), nil
+	case binXMLTypeInt64:
+		return strconv.FormatInt(int64(le.Uint64(data)), 10), nil
+	case binXMLTypeUInt64:
+		return strconv.FormatUint(le.Uint64(data), 10), nil
+	case binXMLTypeReal32:
+		return strconv.FormatFloat(float64(math.Float32frombits(le.Uint32(data))), 'g', -1, 32), nil
+	case binXMLTypeReal64:
+		return strconv.FormatFloat(math.Float64frombits(le.Uint64(data)), 'g', -1, 64), nil
+	case binXMLTypeBool:
+		return strconv.FormatBool(le.Uint32(data) != 0), nil
+	case binXMLTypeBinary:
+		return strings.ToUpper(hex.EncodeToString(data)), nil
+	case binXMLTypeGUID:
+		return formatGUID(data), nil
+	case binXMLTypeSizeT:
+		if len(data) == 4 {
+			return fmt.Sprintf("0x%08x", le.Uint32(data)), nil
+		} else if len(data) == 8 {
+			return fmt.Sprintf("0x%016x", le.Uint64(data)), nil
+		}
+		return "", fmt.Errorf("invalid size of SizeT value: %d", len(data))
+	case binXMLTypeFileTime:
+		t := fileTimeToTime(le.Uint64(data))
+		if t.IsZero() {
+			return "", nil
+		}
+		return t.Format("2006-01-02T15:04:05.0000000Z"), nil
+	case binXMLTypeSystemTime:
+		t := time.Date(int(le.Uint16(data)), time.Month(le.Uint16(data[2:])), int(le.Uint16(data[6:])),
+			int(le.Uint16(data[8:])), int(le.Uint16(data[10:])), int(le.Uint16(data[12:])),
+			int(le.Uint16(data[14:]))*int(time.Millisecond), time.UTC)
+		return t.Format("2006-01-02T15:04:05.0000000Z"), nil
+	case binXMLTypeSID:
+		return formatSID(data)
+	case binXMLTypeHexInt32:
+		return fmt.Sprintf("0x%x", le.Uint32(data)), nil
+	case binXMLTypeHexInt64:
+		return fmt.Sprintf("0x%x", le.Uint64(data)), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %#x", valueType)
+	}
+}
+
+// formatGUID formats a binary GUID as a string such as
+// {00000000-0000-0000-0000-000000000000}.
+func formatGUID(data []byte) string {
+	le := binary.LittleEndian
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", le.Uint32(data), le.Uint16(data[4:]), le.Uint16(data[6:]), data[8:10], data[10:16])
+}
+
+// formatSID formats a binary security identifier as a string such as S-1-5-18.
+func formatSID(data []byte) (string, error) {
+	if len(data) < 8 {
+		return "", fmt.Errorf("SID is too short")
+	}
+	n := int(data[1])
+	if len(data) < 8+4*n {
+		return "", fmt.Errorf("SID is too short")
+	}
+
+	var authority uint64
+	for _, b := range data[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+	sid := fmt.Sprintf("S-%d-%d", data[0], authority)
+	for i := 0; i < n; i++ {
+		sid += fmt.Sprintf("-%d", binary.LittleEndian.Uint32(data[8+4*i:]))
+	}
+
+	return sid, nil
+}
+
+func decodeUTF16(b []byte) string {
+	chars := make([]uint16, len(b)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+
+	return string(utf16.Decode(chars))
+}
@@ -0,0 +1,408 @@
+package taskmaster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// evtxBuilder writes a chunk of an EVTX file, used to test EVTXReader.
+type evtxBuilder struct {
+	chunk []byte
+	names map[string]int
+}
+
+type evtxTestValue struct {
+	valueType byte
+	write     func()
+}
+
+func newEVTXBuilder() *evtxBuilder {
+	b := &evtxBuilder{
+		chunk: make([]byte, evtxChunkHeaderSize),
+		names: make(map[string]int),
+	}
+	copy(b.chunk, evtxChunkSignature)
+
+	return b
+}
+
+func (b *evtxBuilder) u8(v byte) {
+	b.chunk = append(b.chunk, v)
+}
+
+func (b *evtxBuilder) u16(v uint16) {
+	b.chunk = append(b.chunk, 0, 0)
+	binary.LittleEndian.PutUint16(b.chunk[len(b.chunk)-2:], v)
+}
+
+func (b *evtxBuilder) u32(v uint32) {
+	b.chunk = append(b.chunk, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b.chunk[len(b.chunk)-4:], v)
+}
+
+func (b *evtxBuilder) u64(v uint64) {
+	b.u32(uint32(v))
+	b.u32(uint32(v >> 32))
+}
+
+func (b *evtxBuilder) utf16(s string) {
+	for _, c := range utf16.Encode([]rune(s)) {
+		b.u16(c)
+	}
+}
+
+// name writes a name inline the first time it is used, and as an offset
+// to the first occurrence afterwards.
+func (b *evtxBuilder) name(s string) {
+	if offset, ok := b.names[s]; ok {
+		b.u32(uint32(offset))
+		return
+	}
+
+	offset := len(b.chunk) + 4
+	b.names[s] = offset
+	b.u32(uint32(offset))
+	b.u32(0)
+	b.u16(0)
+	b.u16(uint16(len(utf16.Encode([]rune(s)))))
+	b.utf16(s)
+	b.u16(0)
+}
+
+func (b *evtxBuilder) fragmentHeader() {
+	b.chunk = append(b.chunk, binXMLFragmentHeader, 1, 1, 0)
+}
+
+func (b *evtxBuilder) startElement(name string, hasAttrs bool) {
+	if hasAttrs {
+		b.u8(binXMLOpenStartElement | binXMLHasMoreFlag)
+	} else {
+		b.u8(binXMLOpenStartElement)
+	}
+	b.u16(0)
+	b.u32(0)
+	b.name(name)
+	if hasAttrs {
+		b.u32(0)
+	}
+}
+
+func (b *evtxBuilder) attr(name string, value func()) {
+	b.u8(binXMLAttribute)
+	b.name(name)
+	value()
+}
+
+func (b *evtxBuilder) text(s string) func() {
+	return func() {
+		b.u8(binXMLValueText)
+		b.u8(binXMLTypeString)
+		b.u16(uint16(len(utf16.Encode([]rune(s)))))
+		b.utf16(s)
+	}
+}
+
+func (b *evtxBuilder) sub(id uint16, valueType byte) func() {
+	return func() {
+		b.u8(binXMLOptionalSubstitution)
+		b.u16(id)
+		b.u8(valueType)
+	}
+}
+
+// element writes an element with a single attribute and content, either of
+// which may be nil.
+func (b *evtxBuilder) element(name, attrName string, attrValue, content func()) {
+	b.startElement(name, attrName != "")
+	if attrName != "" {
+		b.attr(attrName, attrValue)
+	}
+	if content == nil {
+		b.u8(binXMLCloseEmptyElement)
+		return
+	}
+	b.u8(binXMLCloseStartElement)
+	content()
+	b.u8(binXMLEndElement)
+}
+
+// templateInstance writes a template instance. If *templateOffset is 0, the
+// template is defined inline with body and its offset is stored.
+func (b *evtxBuilder) templateInstance(templateOffset *int, body func(), values []evtxTestValue) {
+	b.u8(binXMLTemplateInstance)
+	b.u8(1)
+	b.u32(0)
+	if *templateOffset == 0 {
+		*templateOffset = len(b.chunk) + 4
+		b.u32(uint32(*templateOffset))
+
+		b.u32(0)
+		b.chunk = append(b.chunk, make([]byte, 16)...)
+		sizeOffset := len(b.chunk)
+		b.u32(0)
+		b.fragmentHeader()
+		body()
+		b.u8(binXMLEndOfStream)
+		binary.LittleEndian.PutUint32(b.chunk[sizeOffset:], uint32(len(b.chunk)-sizeOffset-4))
+	} else {
+		b.u32(uint32(*templateOffset))
+	}
+
+	b.u32(uint32(len(values)))
+	descriptors := len(b.chunk)
+	for _, value := range values {
+		b.u16(0)
+		b.u8(value.valueType)
+		b.u8(0)
+	}
+	for i, value := range values {
+		start := len(b.chunk)
+		value.write()
+		binary.LittleEndian.PutUint16(b.chunk[descriptors+4*i:], uint16(len(b.chunk)-start))
+	}
+}
+
+func (b *evtxBuilder) record(id uint64, written time.Time, data func()) {
+	start := len(b.chunk)
+	b.u32(evtxRecordSignature)
+	b.u32(0)
+	b.u64(id)
+	b.u64(timeToFileTime(written))
+	data()
+	size := uint32(len(b.chunk) - start + 4)
+	b.u32(size)
+	binary.LittleEndian.PutUint32(b.chunk[start+4:], size)
+}
+
+func (b *evtxBuilder) file() []byte {
+	binary.LittleEndian.PutUint32(b.chunk[48:], uint32(len(b.chunk)))
+
+	header := make([]byte, 0x1000)
+	copy(header, evtxFileSignature)
+	binary.LittleEndian.PutUint32(header[32:], evtxFileHeaderSize)
+	binary.LittleEndian.PutUint16(header[36:], 1)
+	binary.LittleEndian.PutUint16(header[38:], 3)
+	binary.LittleEndian.PutUint16(header[40:], 0x1000)
+	binary.LittleEndian.PutUint16(header[42:], 2)
+
+	chunk := make([]byte, evtxChunkSize)
+	copy(chunk, b.chunk)
+	// the second chunk is unused
+	file := append(header, chunk...)
+
+	return append(file, make([]byte, evtxChunkSize)...)
+}
+
+func timeToFileTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+func buildTestEVTX() []byte {
+	b := newEVTXBuilder()
+	start := time.Date(2020, 1, 1, 1, 0, 0, 0, time.UTC)
+
+	var taskTemplate, userDataTemplate int
+	taskTemplateBody := func() {
+		b.element("Event", "xmlns", b.text("http://schemas.microsoft.com/win/2004/08/events/event"), func() {
+			b.element("System", "", nil, func() {
+				b.element("Provider", "Name", b.text("Microsoft-Windows-TaskScheduler"), nil)
+				b.element("EventID", "", nil, b.sub(0, binXMLTypeUInt16))
+				b.element("Keywords", "", nil, b.sub(1, binXMLTypeHexInt64))
+				b.element("TimeCreated", "SystemTime", b.sub(2, binXMLTypeFileTime), nil)
+				b.element("EventRecordID", "", nil, b.sub(3, binXMLTypeUInt64))
+				b.element("Correlation", "ActivityID", b.sub(4, binXMLTypeGUID), nil)
+				b.element("Computer", "", nil, b.sub(5, binXMLTypeString))
+				b.element("Security", "UserID", b.sub(6, binXMLTypeSID), nil)
+			})
+			b.element("EventData", "Name", b.text("TaskEvent"), func() {
+				b.element("Data", "Name", b.text("TaskName"), b.sub(7, binXMLTypeString))
+				b.element("Data", "Name", b.text("UserContext"), b.sub(8, binXMLTypeString))
+				b.element("Data", "Name", b.text("InstanceId"), b.sub(9, binXMLTypeGUID))
+			})
+		})
+	}
+	taskValues := func(eventID uint16, recordID uint64, t time.Time, instance byte) []evtxTestValue {
+		guid := func(last byte) func() {
+			return func() {
+				b.chunk = append(b.chunk, 0xaa, 0xaa, 0xaa, 0xaa, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, last)
+			}
+		}
+		return []evtxTestValue{
+			{binXMLTypeUInt16, func() { b.u16(eventID) }},
+			{binXMLTypeHexInt64, func() { b.u64(0x8000000000000000) }},
+			{binXMLTypeFileTime, func() { b.u64(timeToFileTime(t)) }},
+			{binXMLTypeUInt64, func() { b.u64(recordID) }},
+			{binXMLTypeGUID, guid(0xff)},
+			{binXMLTypeString, func() { b.utf16("HOST & CO\x00") }},
+			{binXMLTypeSID, func() { b.chunk = append(b.chunk, 1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0) }},
+			{binXMLTypeString, func() { b.utf16(`\Taskmaster\Task`) }},
+			{binXMLTypeString, func() { b.utf16(`DOMAIN\user`) }},
+			{binXMLTypeGUID, guid(instance)},
+		}
+	}
+
+	b.record(1, start, func() {
+		b.fragmentHeader()
+		b.templateInstance(&taskTemplate, taskTemplateBody, taskValues(100, 1, start, 1))
+		b.u8(binXMLEndOfStream)
+	})
+	b.record(2, start.Add(5*time.Second), func() {
+		b.fragmentHeader()
+		b.templateInstance(&taskTemplate, taskTemplateBody, taskValues(102, 2, start.Add(5*time.Second), 1))
+		b.u8(binXMLEndOfStream)
+	})
+	// the event data of this event is a nested BinXML value
+	b.record(3, start.Add(time.Hour), func() {
+		b.fragmentHeader()
+		b.templateInstance(&userDataTemplate, func() {
+			b.element("Event", "", nil, func() {
+				b.element("System", "", nil, func() {
+					b.element("EventID", "", nil, b.sub(0, binXMLTypeUInt16))
+					b.element("EventRecordID", "", nil, b.sub(1, binXMLTypeUInt64))
+				})
+				b.sub(2, binXMLTypeBinXML)()
+			})
+		}, []evtxTestValue{
+			{binXMLTypeUInt16, func() { b.u16(141) }},
+			{binXMLTypeUInt64, func() { b.u64(3) }},
+			{binXMLTypeBinXML, func() {
+				b.fragmentHeader()
+				b.element("EventData", "", nil, func() {
+					b.element("Data", "Name", b.text("TaskName"), b.text(`\Taskmaster\Task`))
+					b.element("Data", "Name", b.text("UserName"), func() {
+						b.text("DOMAIN")()
+						b.u8(binXMLCharRef)
+						b.u16('\\')
+						b.text("admin")()
+					})
+				})
+				b.u8(binXMLEndOfStream)
+			}},
+		})
+		b.u8(binXMLEndOfStream)
+	})
+
+	return b.file()
+}
+
+func TestEVTXReader(t *testing.T) {
+	reader, err := NewEVTXReader(bytes.NewReader(buildTestEVTX()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records []EVTXRecord
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+
+	expected := `<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System>` +
+		`<Provider Name="Microsoft-Windows-TaskScheduler"/><EventID>100</EventID><Keywords>0x8000000000000000</Keywords>` +
+		`<TimeCreated SystemTime="2020-01-01T01:00:00.0000000Z"/><EventRecordID>1</EventRecordID>` +
+		`<Correlation ActivityID="{AAAAAAAA-0000-0000-0000-0000000000FF}"/><Computer>HOST &amp; CO</Computer>` +
+		`<Security UserID="S-1-5-18"/></System><EventData Name="TaskEvent"><Data Name="TaskName">\Taskmaster\Task</Data>` +
+		`<Data Name="UserContext">DOMAIN\user</Data><Data Name="InstanceId">{AAAAAAAA-0000-0000-0000-000000000001}</Data>` +
+		`</EventData></Event>`
+	if records[0].XML != expected {
+		t.Errorf("unexpected XML:\n%s\nexpected:\n%s", records[0].XML, expected)
+	}
+	if records[0].RecordID != 1 || !records[0].Written.Equal(time.Date(2020, 1, 1, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected record header %d %v", records[0].RecordID, records[0].Written)
+	}
+
+	expected = `<Event><System><EventID>141</EventID><EventRecordID>3</EventRecordID></System>` +
+		`<EventData><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="UserName">DOMAIN&#92;admin</Data></EventData></Event>`
+	if records[2].XML != expected {
+		t.Errorf("unexpected XML:\n%s\nexpected:\n%s", records[2].XML, expected)
+	}
+}
+
+func TestReadHistoryEVTX(t *testing.T) {
+	events, err := ReadHistoryEVTX(bytes.NewReader(buildTestEVTX()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	deleted, ok := events[2].(TaskDeletedEvent)
+	if !ok {
+		t.Fatalf("expected TaskDeletedEvent, got %T", events[2])
+	}
+	if deleted.UserName != `DOMAIN\admin` || deleted.TaskPath != `\Taskmaster\Task` {
+		t.Errorf("unexpected event %+v", deleted)
+	}
+
+	runs := CorrelateRuns(events)
+	if len(runs) != 1 {
+		t.Fatalf("expected 1 run, got %d", len(runs))
+	}
+	if runs[0].Status != RunSucceeded || runs[0].Duration() != 5*time.Second || runs[0].InstanceGUID != "{AAAAAAAA-0000-0000-0000-000000000001}" {
+		t.Errorf("unexpected run %+v", runs[0])
+	}
+}
+
+func TestEVTXReaderInvalid(t *testing.T) {
+	file := buildTestEVTX()
+
+	if _, err := NewEVTXReader(bytes.NewReader(file[:64])); err == nil {
+		t.Error("reading a truncated header should fail")
+	}
+	if _, err := NewEVTXReader(strings.NewReader(strings.Repeat("x", 0x1000))); err == nil {
+		t.Error("reading a file with an invalid signature should fail")
+	}
+
+	// corrupt the first token of the first record
+	corrupt := append([]byte(nil), file...)
+	corrupt[0x1000+evtxChunkHeaderSize+evtxRecordHeaderSize] = 0xff
+	reader, err := NewEVTXReader(bytes.NewReader(corrupt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); err == nil {
+		t.Error("rendering a corrupt record should fail")
+	} else if recordErr, ok := err.(*EVTXRecordError); !ok || recordErr.RecordID != 1 || recordErr.Chunk != 0 || recordErr.Offset != evtxChunkHeaderSize {
+		t.Errorf("expected record error of record 1, got %#v", err)
+	}
+	// the following records should still be readable
+	record, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.RecordID != 2 {
+		t.Errorf("expected record 2, got %d", record.RecordID)
+	}
+
+	// corrupt records are skipped
+	events, err := ReadHistoryEVTX(bytes.NewReader(corrupt))
+	if recordsErr, ok := err.(*HistoryRecordsError); !ok || len(recordsErr.Errs) != 1 {
+		t.Errorf("expected 1 skipped record, got %v", err)
+	}
+	if len(events) != 2 || events[0].Header().RecordID != 2 {
+		t.Errorf("expected the events of records 2 and 3, got %+v", events)
+	}
+
+	reader, err = NewEVTXReader(bytes.NewReader(file[:0x1000+evtxChunkSize/2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); err == nil || err == io.EOF {
+		t.Errorf("reading a truncated chunk should fail, got %v", err)
+	} else if _, ok := err.(*EVTXRecordError); ok {
+		t.Errorf("reading a truncated chunk should fail reading the chunk, got %v", err)
+	}
+}
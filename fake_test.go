@@ -0,0 +1,359 @@
+package taskmaster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fakeScheduler is an in-memory Scheduler used to test code that manages
+// tasks without Windows.
+type fakeScheduler struct {
+	mu           sync.Mutex
+	tasks        map[string]RegisteredTask
+	runs         []string
+	disconnected bool
+	// sds are the security descriptors of tasks
+	sds map[string]string
+	// passwords are the passwords tasks were registered with
+	passwords map[string]string
+	// folders are the paths of the folders that exist, other than the root
+	folders map[string]bool
+
+	// err is returned by every operation if set
+	err error
+	// failPaths makes operations on specific task paths fail
+	failPaths map[string]error
+	// overwriteErr makes overwriting a task delete it and fail, like
+	// TaskService does when the new definition is rejected
+	overwriteErr error
+}
+
+func newFakeScheduler(tasks ...RegisteredTask) *fakeScheduler {
+	f := &fakeScheduler{
+		tasks:     make(map[string]RegisteredTask),
+		failPaths: make(map[string]error),
+		sds:       make(map[string]string),
+		passwords: make(map[string]string),
+		folders:   make(map[string]bool),
+	}
+	for _, task := range tasks {
+		f.tasks[task.Path] = task
+		f.addFolders(task.Path)
+	}
+
+	return f
+}
+
+// addFolders creates the folders of the task at path, like the Task
+// Scheduler service does when the task is registered.
+func (f *fakeScheduler) addFolders(path string) {
+	for folder := path[:strings.LastIndex(path, `\`)]; folder != ""; folder = folder[:strings.LastIndex(folder, `\`)] {
+		f.folders[folder] = true
+	}
+}
+
+func (f *fakeScheduler) check(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if f.err != nil {
+		return f.err
+	}
+
+	return f.failPaths[path]
+}
+
+func (f *fakeScheduler) GetRegisteredTasksContext(ctx context.Context) (RegisteredTaskCollection, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(ctx, ""); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(f.tasks))
+	for path := range f.tasks {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var tasks RegisteredTaskCollection
+	for _, path := range paths {
+		tasks = append(tasks, f.tasks[path])
+	}
+
+	return tasks, nil
+}
+
+func (f *fakeScheduler) GetRegisteredTaskContext(ctx context.Context, path string) (RegisteredTask, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(ctx, path); err != nil {
+		return RegisteredTask{}, err
+	}
+	task, ok := f.tasks[path]
+	if !ok {
+		return RegisteredTask{}, fmt.Errorf("task %s doesn't exist", path)
+	}
+
+	return task, nil
+}
+
+func (f *fakeScheduler) CreateTaskExContext(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType, overwrite bool) (RegisteredTask, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(ctx, path); err != nil {
+		return RegisteredTask{}, false, err
+	}
+	if task, ok := f.tasks[path]; ok && !overwrite {
+		return task, false, nil
+	} else if ok && f.overwriteErr != nil {
+		delete(f.tasks, path)
+		return RegisteredTask{}, false, f.overwriteErr
+	}
+
+	task := RegisteredTask{
+		Name:       path[strings.LastIndex(path, `\`)+1:],
+		Path:       path,
+		Definition: newTaskDef,
+		Enabled:    newTaskDef.Settings.Enabled,
+		State:      TASK_STATE_READY,
+	}
+	if !task.Enabled {
+		task.State = TASK_STATE_DISABLED
+	}
+	f.tasks[path] = task
+	f.passwords[path] = password
+	f.addFolders(path)
+
+	return task, true, nil
+}
+
+func (f *fakeScheduler) UpdateTaskExContext(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType) (RegisteredTask, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(ctx, path); err != nil {
+		return RegisteredTask{}, err
+	}
+	task, ok := f.tasks[path]
+	if !ok {
+		return RegisteredTask{}, fmt.Errorf("task %s doesn't exist", path)
+	}
+	task.Definition = newTaskDef
+	task.Enabled = newTaskDef.Settings.Enabled
+	f.tasks[path] = task
+
+	return task, nil
+}
+
+func (f *fakeScheduler) DeleteTaskContext(ctx context.Context, path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(ctx, path); err != nil {
+		return err
+	}
+	if _, ok := f.tasks[path]; !ok {
+		return fmt.Errorf("task %s doesn't exist", path)
+	}
+	delete(f.tasks, path)
+
+	return nil
+}
+
+func (f *fakeScheduler) RunTaskContext(ctx context.Context, path string, args ...string) (RunningTask, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(ctx, path); err != nil {
+		return RunningTask{}, err
+	}
+	task, ok := f.tasks[path]
+	if !ok {
+		return RunningTask{}, fmt.Errorf("task %s doesn't exist", path)
+	}
+	f.runs = append(f.runs, path)
+
+	return RunningTask{
+		Name:         task.Name,
+		Path:         path,
+		InstanceGUID: fmt.Sprintf("{%08d-0000-0000-0000-000000000000}", len(f.runs)),
+		State:        TASK_STATE_RUNNING,
+	}, nil
+}
+
+func (f *fakeScheduler) Disconnect() {
+	f.mu.Lock()
+	f.disconnected = true
+	f.mu.Unlock()
+}
+
+func newFakeTask(path string) RegisteredTask {
+	return RegisteredTask{
+		Name:    path[strings.LastIndex(path, `\`)+1:],
+		Path:    path,
+		Enabled: true,
+		State:   TASK_STATE_READY,
+		Definition: Definition{
+			Actions: []Action{ExecAction{Path: "cmd.exe"}},
+			Settings: TaskSettings{
+				Enabled: true,
+			},
+		},
+	}
+}
+
+func (f *fakeScheduler) GetTaskFolderContext(ctx context.Context, path string) (TaskFolder, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(ctx, path); err != nil {
+		return TaskFolder{}, err
+	}
+
+	root := &TaskFolder{Path: path}
+	if path != `\` {
+		root.Name = path[strings.LastIndex(path, `\`)+1:]
+	}
+	folders := map[string]*TaskFolder{path: root}
+
+	var getFolder func(string) *TaskFolder
+	getFolder = func(folderPath string) *TaskFolder {
+		if folder, ok := folders[folderPath]; ok {
+			return folder
+		}
+
+		parentPath := folderPath[:strings.LastIndex(folderPath, `\`)]
+		if parentPath == "" {
+			parentPath = `\`
+		}
+		folder := &TaskFolder{
+			Name: folderPath[strings.LastIndex(folderPath, `\`)+1:],
+			Path: folderPath,
+		}
+		parent := getFolder(parentPath)
+		parent.SubFolders = append(parent.SubFolders, folder)
+		folders[folderPath] = folder
+
+		return folder
+	}
+
+	prefix := strings.TrimSuffix(path, `\`) + `\`
+	var folderPaths []string
+	for folderPath := range f.folders {
+		if strings.HasPrefix(folderPath, prefix) {
+			folderPaths = append(folderPaths, folderPath)
+		}
+	}
+	sort.Strings(folderPaths)
+	for _, folderPath := range folderPaths {
+		getFolder(folderPath)
+	}
+
+	paths := make([]string, 0, len(f.tasks))
+	for taskPath := range f.tasks {
+		if strings.HasPrefix(taskPath, prefix) {
+			paths = append(paths, taskPath)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, taskPath := range paths {
+		folderPath := taskPath[:strings.LastIndex(taskPath, `\`)]
+		if folderPath == "" {
+			folderPath = `\`
+		}
+		folder := getFolder(folderPath)
+		folder.RegisteredTasks = append(folder.RegisteredTasks, f.tasks[taskPath])
+	}
+
+	return *root, nil
+}
+
+func (f *fakeScheduler) setTask(task RegisteredTask) {
+	f.mu.Lock()
+	f.tasks[task.Path] = task
+	f.addFolders(task.Path)
+	f.mu.Unlock()
+}
+
+func (f *fakeScheduler) DeleteFolderContext(ctx context.Context, path string, deleteRecursively bool) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(ctx, path); err != nil {
+		return false, err
+	}
+	prefix := strings.TrimSuffix(path, `\`) + `\`
+	for taskPath := range f.tasks {
+		if strings.HasPrefix(taskPath, prefix) {
+			if !deleteRecursively {
+				return false, nil
+			}
+			delete(f.tasks, taskPath)
+		}
+	}
+	for folderPath := range f.folders {
+		if strings.HasPrefix(folderPath, prefix) {
+			if !deleteRecursively {
+				return false, nil
+			}
+			delete(f.folders, folderPath)
+		}
+	}
+	delete(f.folders, path)
+
+	return true, nil
+}
+
+func (f *fakeScheduler) folderExists(ctx context.Context, path string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(ctx, path); err != nil {
+		return false, err
+	}
+
+	return path == `\` || f.folders[path], nil
+}
+
+func (f *fakeScheduler) getSecurityDescriptor(ctx context.Context, path string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(ctx, path); err != nil {
+		return "", err
+	}
+	if _, ok := f.tasks[path]; !ok {
+		return "", fmt.Errorf("task %s doesn't exist", path)
+	}
+
+	return f.sds[path], nil
+}
+
+func (f *fakeScheduler) registerTask(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType, sddl string) (RegisteredTask, error) {
+	f.mu.Lock()
+	if _, ok := f.tasks[path]; ok {
+		f.mu.Unlock()
+		return RegisteredTask{}, fmt.Errorf("task %s already exists", path)
+	}
+	f.mu.Unlock()
+
+	task, _, err := f.CreateTaskExContext(ctx, path, newTaskDef, username, password, logonType, false)
+	if err != nil {
+		return RegisteredTask{}, err
+	}
+
+	f.mu.Lock()
+	f.sds[path] = sddl
+	f.passwords[path] = password
+	f.mu.Unlock()
+
+	return task, nil
+}
@@ -4,6 +4,7 @@ package taskmaster
 
 import (
 	"fmt"
+	"sort"
 
 	ole "github.com/go-ole/go-ole"
 	"github.com/go-ole/go-ole/oleutil"
@@ -24,7 +25,10 @@ func fillDefinitionObj(definition Definition, definitionObj *ole.IDispatch) erro
 
 	principalObj := oleutil.MustGetProperty(definitionObj, "Principal").ToIDispatch()
 	defer principalObj.Release()
-	fillPrincipalObj(definition.Principal, principalObj)
+	err = fillPrincipalObj(definition.Principal, principalObj)
+	if err != nil {
+		return fmt.Errorf("error filling IPrincipal object: %v", err)
+	}
 
 	regInfoObj := oleutil.MustGetProperty(definitionObj, "RegistrationInfo").ToIDispatch()
 	defer regInfoObj.Release()
@@ -32,7 +36,10 @@ func fillDefinitionObj(definition Definition, definitionObj *ole.IDispatch) erro
 
 	settingsObj := oleutil.MustGetProperty(definitionObj, "Settings").ToIDispatch()
 	defer settingsObj.Release()
-	fillTaskSettingsObj(definition.Settings, settingsObj)
+	err = fillTaskSettingsObj(definition.Settings, settingsObj)
+	if err != nil {
+		return fmt.Errorf("error filling ITaskSettings object: %v", err)
+	}
 
 	triggersObj := oleutil.MustGetProperty(definitionObj, "Triggers").ToIDispatch()
 	defer triggersObj.Release()
@@ -41,6 +48,20 @@ func fillDefinitionObj(definition Definition, definitionObj *ole.IDispatch) erro
 		return fmt.Errorf("error filling ITrigger objects: %v", err)
 	}
 
+	// custom triggers can't be created with ITriggerCollection, so they
+	// are added to the XML of the definition instead
+	xmlText := oleutil.MustGetProperty(definitionObj, "XmlText").ToString()
+	newXMLText, err := insertCustomTriggersXML(xmlText, definition.Triggers)
+	if err != nil {
+		return err
+	}
+	if newXMLText != xmlText {
+		_, err = oleutil.PutProperty(definitionObj, "XmlText", newXMLText)
+		if err != nil {
+			return fmt.Errorf("error adding custom triggers: %w", getTaskSchedulerError(err))
+		}
+	}
+
 	return nil
 }
 func fillActionsObj(actions []Action, actionsObj *ole.IDispatch) error {
@@ -48,7 +69,7 @@ func fillActionsObj(actions []Action, actionsObj *ole.IDispatch) error {
 		actionType := action.GetType()
 		res, err := oleutil.CallMethod(actionsObj, "Create", uint(actionType))
 		if err != nil {
-			return fmt.Errorf("error creating IAction object: %v", getTaskSchedulerError(err))
+			return fmt.Errorf("error creating IAction object: %w", getTaskSchedulerError(err))
 		}
 		actionObj := res.ToIDispatch()
 		defer actionObj.Release()
@@ -70,19 +91,82 @@ func fillActionsObj(actions []Action, actionsObj *ole.IDispatch) error {
 
 			oleutil.MustPutProperty(comHandlerActionObj, "ClassId", comHandlerAction.ClassID)
 			oleutil.MustPutProperty(comHandlerActionObj, "Data", comHandlerAction.Data)
+		case TASK_ACTION_SEND_EMAIL:
+			emailAction := action.(EmailAction)
+			emailActionObj := actionObj.MustQueryInterface(ole.NewGUID("{10f62c64-7e16-4314-a0c2-0c3683f99d40}"))
+			defer emailActionObj.Release()
+
+			oleutil.MustPutProperty(emailActionObj, "Server", emailAction.Server)
+			oleutil.MustPutProperty(emailActionObj, "From", emailAction.From)
+			oleutil.MustPutProperty(emailActionObj, "To", emailAction.To)
+			oleutil.MustPutProperty(emailActionObj, "Cc", emailAction.Cc)
+			oleutil.MustPutProperty(emailActionObj, "Bcc", emailAction.Bcc)
+			oleutil.MustPutProperty(emailActionObj, "ReplyTo", emailAction.ReplyTo)
+			oleutil.MustPutProperty(emailActionObj, "Subject", emailAction.Subject)
+			oleutil.MustPutProperty(emailActionObj, "Body", emailAction.Body)
+			if len(emailAction.Attachments) != 0 {
+				oleutil.MustPutProperty(emailActionObj, "Attachments", emailAction.Attachments)
+			}
+
+			headerFieldsObj := oleutil.MustGetProperty(emailActionObj, "HeaderFields").ToIDispatch()
+			defer headerFieldsObj.Release()
+
+			// sort the header fields so they're always registered in the same order
+			names := make([]string, 0, len(emailAction.HeaderFields))
+			for name := range emailAction.HeaderFields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				_, err = oleutil.CallMethod(headerFieldsObj, "Create", name, emailAction.HeaderFields[name])
+				if err != nil {
+					return fmt.Errorf("error creating header field %s: %w", name, getTaskSchedulerError(err))
+				}
+			}
+		case TASK_ACTION_SHOW_MESSAGE:
+			showMessageAction := action.(ShowMessageAction)
+			showMessageActionObj := actionObj.MustQueryInterface(ole.NewGUID("{505e9e68-af89-46b8-a30f-56162a83d537}"))
+			defer showMessageActionObj.Release()
+
+			oleutil.MustPutProperty(showMessageActionObj, "Title", showMessageAction.Title)
+			oleutil.MustPutProperty(showMessageActionObj, "MessageBody", showMessageAction.Body)
 		}
 	}
 
 	return nil
 }
 
-func fillPrincipalObj(principal Principal, principalObj *ole.IDispatch) {
+func fillPrincipalObj(principal Principal, principalObj *ole.IDispatch) error {
 	oleutil.MustPutProperty(principalObj, "DisplayName", principal.Name)
 	oleutil.MustPutProperty(principalObj, "GroupId", principal.GroupID)
 	oleutil.MustPutProperty(principalObj, "Id", principal.ID)
 	oleutil.MustPutProperty(principalObj, "LogonType", uint(principal.LogonType))
 	oleutil.MustPutProperty(principalObj, "RunLevel", uint(principal.RunLevel))
 	oleutil.MustPutProperty(principalObj, "UserId", principal.UserID)
+
+	// the process token SID type and required privileges can only be set
+	// for service accounts
+	if principal.LogonType != TASK_LOGON_SERVICE_ACCOUNT {
+		return nil
+	}
+
+	principal2Obj, err := principalObj.QueryInterface(ole.NewGUID("{248919ae-e345-4a6d-8aeb-e0d3165c904e}"))
+	if err != nil {
+		return fmt.Errorf("error getting IPrincipal2 object: %v", err)
+	}
+	defer principal2Obj.Release()
+
+	if principal.ProcessTokenSidType != nil {
+		oleutil.MustPutProperty(principal2Obj, "ProcessTokenSidType", uint(*principal.ProcessTokenSidType))
+	}
+	for _, privilege := range principal.RequiredPrivileges {
+		_, err = oleutil.CallMethod(principal2Obj, "AddRequiredPrivilege", privilege)
+		if err != nil {
+			return fmt.Errorf("error adding required privilege %s: %w", privilege, getTaskSchedulerError(err))
+		}
+	}
+
+	return nil
 }
 
 func fillRegistrationInfoObj(regInfo RegistrationInfo, regInfoObj *ole.IDispatch) {
@@ -96,7 +180,7 @@ func fillRegistrationInfoObj(regInfo RegistrationInfo, regInfoObj *ole.IDispatch
 	oleutil.MustPutProperty(regInfoObj, "Version", regInfo.Version)
 }
 
-func fillTaskSettingsObj(settings TaskSettings, settingsObj *ole.IDispatch) {
+func fillTaskSettingsObj(settings TaskSettings, settingsObj *ole.IDispatch) error {
 	oleutil.MustPutProperty(settingsObj, "AllowDemandStart", settings.AllowDemandStart)
 	oleutil.MustPutProperty(settingsObj, "AllowHardTerminate", settings.AllowHardTerminate)
 	oleutil.MustPutProperty(settingsObj, "Compatibility", uint(settings.Compatibility))
@@ -128,13 +212,56 @@ func fillTaskSettingsObj(settings TaskSettings, settingsObj *ole.IDispatch) {
 	oleutil.MustPutProperty(settingsObj, "StartWhenAvailable", settings.StartWhenAvailable)
 	oleutil.MustPutProperty(settingsObj, "StopIfGoingOnBatteries", settings.StopIfGoingOnBatteries)
 	oleutil.MustPutProperty(settingsObj, "WakeToRun", settings.WakeToRun)
+
+	// ITaskSettings2 and ITaskSettings3 aren't available before Windows 7
+	// and Windows 8, which is only an error if their settings are used
+	settings2Obj, err := settingsObj.QueryInterface(ole.NewGUID("{2c05c3f0-6eed-4c05-a15f-ed7d7a98a369}"))
+	if err != nil {
+		if settings.DisallowStartOnRemoteAppSession || settings.UseUnifiedSchedulingEngine {
+			return fmt.Errorf("error getting ITaskSettings2 object: %v", err)
+		}
+	} else {
+		defer settings2Obj.Release()
+		oleutil.MustPutProperty(settings2Obj, "DisallowStartOnRemoteAppSession", settings.DisallowStartOnRemoteAppSession)
+		oleutil.MustPutProperty(settings2Obj, "UseUnifiedSchedulingEngine", settings.UseUnifiedSchedulingEngine)
+	}
+
+	settings3Obj, err := settingsObj.QueryInterface(ole.NewGUID("{0ad9d0d7-0c7f-4ebb-9a5f-d1c648dca528}"))
+	if err != nil {
+		if settings.Volatile || settings.MaintenanceSettings != (MaintenanceSettings{}) {
+			return fmt.Errorf("error getting ITaskSettings3 object: %v", err)
+		}
+
+		return nil
+	}
+	defer settings3Obj.Release()
+	oleutil.MustPutProperty(settings3Obj, "Volatile", settings.Volatile)
+
+	if settings.MaintenanceSettings.Period.IsZero() {
+		return nil
+	}
+	res, err := oleutil.CallMethod(settings3Obj, "CreateMaintenanceSettings")
+	if err != nil {
+		return fmt.Errorf("error creating IMaintenanceSettings object: %w", getTaskSchedulerError(err))
+	}
+	maintenanceSettingsObj := res.ToIDispatch()
+	defer maintenanceSettingsObj.Release()
+	oleutil.MustPutProperty(maintenanceSettingsObj, "Period", PeriodToString(settings.MaintenanceSettings.Period))
+	oleutil.MustPutProperty(maintenanceSettingsObj, "Deadline", PeriodToString(settings.MaintenanceSettings.Deadline))
+	oleutil.MustPutProperty(maintenanceSettingsObj, "Exclusive", settings.MaintenanceSettings.Exclusive)
+
+	return nil
 }
 
 func fillTaskTriggersObj(triggers []Trigger, triggersObj *ole.IDispatch) error {
 	for _, trigger := range triggers {
+		if _, ok := trigger.(CustomTrigger); ok {
+			continue
+		}
+
 		res, err := oleutil.CallMethod(triggersObj, "Create", uint(trigger.GetType()))
 		if err != nil {
-			return fmt.Errorf("error creating ITrigger object: %v", getTaskSchedulerError(err))
+			return fmt.Errorf("error creating ITrigger object: %w", getTaskSchedulerError(err))
 		}
 		triggerObj := res.ToIDispatch()
 		defer triggerObj.Release()
@@ -176,7 +303,7 @@ func fillTaskTriggersObj(triggers []Trigger, triggersObj *ole.IDispatch) error {
 			for name, value := range t.ValueQueries {
 				_, err = oleutil.CallMethod(valueQueriesObj, "Create", name, value)
 				if err != nil {
-					return fmt.Errorf("error creating value %s: %v", name, getTaskSchedulerError(err))
+					return fmt.Errorf("error creating value %s: %w", name, getTaskSchedulerError(err))
 				}
 			}
 		case IdleTrigger:
@@ -229,9 +356,6 @@ func fillTaskTriggersObj(triggers []Trigger, triggersObj *ole.IDispatch) error {
 			oleutil.MustPutProperty(sessionStateChangeTriggerObj, "Delay", t.Delay.String())
 			oleutil.MustPutProperty(sessionStateChangeTriggerObj, "StateChange", uint(t.StateChange))
 			oleutil.MustPutProperty(sessionStateChangeTriggerObj, "UserId", t.UserId)
-			// need to find GUID
-			/*case TASK_TRIGGER_CUSTOM_TRIGGER_01:
-			return nil*/
 		}
 	}
 
@@ -0,0 +1,480 @@
+package taskmaster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultFleetConcurrency is the number of hosts a Fleet operates on at once
+// if FleetOptions.Concurrency is not set.
+const DefaultFleetConcurrency = 10
+
+// Host is a computer managed by a Fleet, along with the credentials used to
+// connect to its Task Scheduler service.
+type Host struct {
+	Name       string        // the unique name of the host in the fleet
+	ServerName string        // the computer to connect to. If empty, Name is used
+	Domain     string        // the domain of the user to connect as
+	Username   string        // the user to connect as. If empty, the current token is used
+	Password   string        // the password of the user to connect as
+	Timeout    time.Duration // overrides FleetOptions.HostTimeout for this host if set
+	Interval   time.Duration // overrides FleetOptions.HostInterval for this host if set
+}
+
+func (h Host) serverName() string {
+	if h.ServerName != "" {
+		return h.ServerName
+	}
+
+	return h.Name
+}
+
+// FleetOptions controls how a Fleet connects to and operates on its hosts.
+//
+// HostTimeout is enforced through the context passed to the dial function
+// and the operation. Connecting is abandoned once it expires, but operations
+// only check their context between COM calls, so an operation may take longer
+// than HostTimeout if a COM call to an unresponsive host is already running.
+type FleetOptions struct {
+	Concurrency  int           // the maximum number of hosts that are operated on at once. Defaults to DefaultFleetConcurrency
+	HostTimeout  time.Duration // the deadline of the context connecting to and operating on a single host. Zero means no timeout
+	HostInterval time.Duration // the minimum amount of time between the start of two operations on the same host
+
+	// Dial connects to the Task Scheduler service of a host. If nil,
+	// ConnectWithOptionsContext is used.
+	Dial func(ctx context.Context, host Host) (Scheduler, error)
+}
+
+// Fleet runs operations against the Task Scheduler services of many hosts
+// concurrently. Connections to hosts are made when a host is first operated on
+// and are reused by later operations. A Fleet is safe for concurrent use by
+// multiple goroutines.
+type Fleet struct {
+	opts FleetOptions
+
+	mu    sync.Mutex
+	hosts map[string]*fleetHost
+	order []string
+}
+
+type fleetHost struct {
+	Host
+
+	mu        sync.Mutex
+	conn      Scheduler
+	nextStart time.Time
+}
+
+// FleetOperation is an operation that is run against a single connected host.
+// The value it returns is reported in the HostResult of the host.
+type FleetOperation func(ctx context.Context, host string, svc Scheduler) (interface{}, error)
+
+// HostResult is the outcome of running a FleetOperation on a single host.
+type HostResult struct {
+	Host        string
+	Value       interface{}   // the value returned by the operation
+	Err         error         // the error connecting to the host or returned by the operation
+	Unreachable bool          // indicates that connecting to the host failed, so the operation wasn't run
+	Duration    time.Duration // how long connecting to and operating on the host took
+}
+
+// FleetResults are the results of running a FleetOperation on hosts, in the
+// order the hosts were added to the Fleet.
+type FleetResults []HostResult
+
+// NewFleet returns an empty Fleet.
+func NewFleet(opts FleetOptions) *Fleet {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultFleetConcurrency
+	}
+	if opts.Dial == nil {
+		opts.Dial = dialTaskService
+	}
+
+	return &Fleet{
+		opts:  opts,
+		hosts: make(map[string]*fleetHost),
+	}
+}
+
+// AddHost adds a host to the fleet. The name of the host must be unique.
+func (f *Fleet) AddHost(host Host) error {
+	if host.Name == "" {
+		return errors.New("host name is required")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.hosts[host.Name]; ok {
+		return fmt.Errorf("host %s already exists", host.Name)
+	}
+	f.hosts[host.Name] = &fleetHost{Host: host}
+	f.order = append(f.order, host.Name)
+
+	return nil
+}
+
+// RemoveHost removes a host from the fleet, and disconnects from it if a
+// connection was made. RemoveHost returns false if the host doesn't exist.
+func (f *Fleet) RemoveHost(name string) bool {
+	f.mu.Lock()
+	host, ok := f.hosts[name]
+	if ok {
+		delete(f.hosts, name)
+		for i := range f.order {
+			if f.order[i] == name {
+				f.order = append(f.order[:i], f.order[i+1:]...)
+				break
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	if ok {
+		host.disconnect()
+	}
+
+	return ok
+}
+
+// Hosts returns the names of the hosts in the fleet, in the order they were added.
+func (f *Fleet) Hosts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.order...)
+}
+
+// Close disconnects from every host in the fleet. Hosts are reconnected to
+// if the fleet is used again.
+func (f *Fleet) Close() {
+	f.mu.Lock()
+	hosts := make([]*fleetHost, 0, len(f.hosts))
+	for _, host := range f.hosts {
+		hosts = append(hosts, host)
+	}
+	f.mu.Unlock()
+
+	for _, host := range hosts {
+		host.disconnect()
+	}
+}
+
+// Run runs op on every host in the fleet, and returns the result of every host.
+// A failure on one host doesn't stop op from being run on the other hosts. If
+// ctx is canceled, hosts that weren't operated on yet report the error of ctx.
+func (f *Fleet) Run(ctx context.Context, op FleetOperation) FleetResults {
+	return f.RunOn(ctx, f.Hosts(), op)
+}
+
+// RunOn is like Run, but only runs op on the specified hosts. Hosts that are
+// not in the fleet report an error.
+func (f *Fleet) RunOn(ctx context.Context, hosts []string, op FleetOperation) FleetResults {
+	results := make(FleetResults, len(hosts))
+	sem := make(chan struct{}, f.opts.Concurrency)
+
+	var wg sync.WaitGroup
+	for i, name := range hosts {
+		results[i].Host = name
+
+		f.mu.Lock()
+		host, ok := f.hosts[name]
+		f.mu.Unlock()
+		if !ok {
+			results[i].Err = fmt.Errorf("host %s is not in the fleet", name)
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *HostResult, host *fleetHost) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			f.runHost(ctx, host, op, result)
+		}(&results[i], host)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (f *Fleet) runHost(ctx context.Context, host *fleetHost, op FleetOperation, result *HostResult) {
+	if err := host.wait(ctx, f.hostInterval(host)); err != nil {
+		result.Err = err
+		return
+	}
+
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	if timeout := f.hostTimeout(host); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	conn, err := host.connect(ctx, f.opts.Dial)
+	if err != nil {
+		result.Err = fmt.Errorf("error connecting to host %s: %v", host.Name, err)
+		result.Unreachable = true
+		return
+	}
+
+	result.Value, result.Err = op(ctx, host.Name, conn)
+	if isConnectionError(result.Err) || !isConnected(conn) {
+		// the connection is broken, reconnect the next time the host is used
+		host.dropConn(conn)
+	}
+}
+
+// isConnectionError reports whether err means that the connection to the
+// Task Scheduler service is broken.
+func isConnectionError(err error) bool {
+	return errors.Is(err, ErrConnectionFailure) || errors.Is(err, ErrNotConnected)
+}
+
+// isConnected reports whether conn is still connected, if it can tell.
+func isConnected(conn Scheduler) bool {
+	if c, ok := conn.(interface{ IsConnected() bool }); ok {
+		return c.IsConnected()
+	}
+
+	return true
+}
+
+func (f *Fleet) hostTimeout(host *fleetHost) time.Duration {
+	if host.Timeout > 0 {
+		return host.Timeout
+	}
+
+	return f.opts.HostTimeout
+}
+
+func (f *Fleet) hostInterval(host *fleetHost) time.Duration {
+	if host.Interval > 0 {
+		return host.Interval
+	}
+
+	return f.opts.HostInterval
+}
+
+// wait reserves the next time an operation may start on the host, and sleeps
+// until then.
+func (h *fleetHost) wait(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+
+	h.mu.Lock()
+	now := time.Now()
+	start := h.nextStart
+	if start.Before(now) {
+		start = now
+	}
+	h.nextStart = start.Add(interval)
+	h.mu.Unlock()
+
+	delay := start.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *fleetHost) connect(ctx context.Context, dial func(context.Context, Host) (Scheduler, error)) (Scheduler, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn != nil {
+		return h.conn, nil
+	}
+
+	conn, err := dial(ctx, h.Host)
+	if err != nil {
+		return nil, err
+	}
+	h.conn = conn
+
+	return conn, nil
+}
+
+func (h *fleetHost) dropConn(conn Scheduler) {
+	h.mu.Lock()
+	if h.conn != conn {
+		conn = nil
+	} else {
+		h.conn = nil
+	}
+	h.mu.Unlock()
+
+	if conn != nil {
+		conn.Disconnect()
+	}
+}
+
+func (h *fleetHost) disconnect() {
+	h.mu.Lock()
+	conn := h.conn
+	h.conn = nil
+	h.mu.Unlock()
+
+	if conn != nil {
+		conn.Disconnect()
+	}
+}
+
+// Succeeded returns the results of the hosts the operation succeeded on.
+func (r FleetResults) Succeeded() FleetResults {
+	var succeeded FleetResults
+	for _, result := range r {
+		if result.Err == nil {
+			succeeded = append(succeeded, result)
+		}
+	}
+
+	return succeeded
+}
+
+// Failed returns the results of the hosts the operation failed on, including
+// unreachable hosts.
+func (r FleetResults) Failed() FleetResults {
+	var failed FleetResults
+	for _, result := range r {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+
+	return failed
+}
+
+// Unreachable returns the names of the hosts that couldn't be connected to.
+func (r FleetResults) Unreachable() []string {
+	var unreachable []string
+	for _, result := range r {
+		if result.Unreachable {
+			unreachable = append(unreachable, result.Host)
+		}
+	}
+
+	return unreachable
+}
+
+// Errors returns the errors of the hosts the operation failed on, keyed by
+// host name.
+func (r FleetResults) Errors() map[string]error {
+	errs := make(map[string]error)
+	for _, result := range r {
+		if result.Err != nil {
+			errs[result.Host] = result.Err
+		}
+	}
+
+	return errs
+}
+
+// Err returns an error summarizing the hosts the operation failed on, or nil if
+// it succeeded on every host.
+func (r FleetResults) Err() error {
+	errs := r.Errors()
+	if len(errs) == 0 {
+		return nil
+	}
+
+	hosts := make([]string, 0, len(errs))
+	for host := range errs {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	return fmt.Errorf("operation failed on %d of %d hosts: %s: %v", len(errs), len(r), hosts[0], errs[hosts[0]])
+}
+
+// EnumerateTasksOp returns an operation that gets all registered tasks of a
+// host. The value of each result is a RegisteredTaskCollection, which must
+// be released.
+func EnumerateTasksOp() FleetOperation {
+	return func(ctx context.Context, _ string, svc Scheduler) (interface{}, error) {
+		tasks, err := svc.GetRegisteredTasksContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return tasks, nil
+	}
+}
+
+// CreateTaskOp returns an operation that creates a task on a host. The value of
+// each result is the created RegisteredTask, which must be released.
+func CreateTaskOp(path string, newTaskDef Definition, overwrite bool) FleetOperation {
+	return func(ctx context.Context, host string, svc Scheduler) (interface{}, error) {
+		task, created, err := svc.CreateTaskExContext(ctx, path, newTaskDef, "", "", newTaskDef.Principal.LogonType, overwrite)
+		if err != nil {
+			return nil, err
+		}
+		if !created {
+			task.Release()
+			return nil, fmt.Errorf("task %s already exists on host %s", path, host)
+		}
+
+		return task, nil
+	}
+}
+
+// UpdateTaskOp returns an operation that updates a task on a host. The value of
+// each result is the updated RegisteredTask, which must be released.
+func UpdateTaskOp(path string, newTaskDef Definition) FleetOperation {
+	return func(ctx context.Context, _ string, svc Scheduler) (interface{}, error) {
+		task, err := svc.UpdateTaskExContext(ctx, path, newTaskDef, "", "", newTaskDef.Principal.LogonType)
+		if err != nil {
+			return nil, err
+		}
+
+		return task, nil
+	}
+}
+
+// DeleteTaskOp returns an operation that deletes a task on a host.
+func DeleteTaskOp(path string) FleetOperation {
+	return func(ctx context.Context, _ string, svc Scheduler) (interface{}, error) {
+		return nil, svc.DeleteTaskContext(ctx, path)
+	}
+}
+
+// RunTaskOp returns an operation that starts a task on a host. The value of
+// each result is the RunningTask, which must be released.
+func RunTaskOp(path string, args ...string) FleetOperation {
+	return func(ctx context.Context, _ string, svc Scheduler) (interface{}, error) {
+		runningTask, err := svc.RunTaskContext(ctx, path, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		return runningTask, nil
+	}
+}
@@ -0,0 +1,325 @@
+package taskmaster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeDialer struct {
+	mu      sync.Mutex
+	svcs    map[string]*fakeScheduler
+	dials   map[string]int
+	dialErr map[string]error
+	delay   time.Duration
+}
+
+func newFakeDialer(hosts ...string) *fakeDialer {
+	d := &fakeDialer{
+		svcs:    make(map[string]*fakeScheduler),
+		dials:   make(map[string]int),
+		dialErr: make(map[string]error),
+	}
+	for _, host := range hosts {
+		d.svcs[host] = newFakeScheduler(newFakeTask(`\Taskmaster\` + host))
+	}
+
+	return d
+}
+
+func (d *fakeDialer) dial(ctx context.Context, host Host) (Scheduler, error) {
+	if d.delay > 0 {
+		select {
+		case <-time.After(d.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.dials[host.Name]++
+	if err := d.dialErr[host.Name]; err != nil {
+		return nil, err
+	}
+
+	return d.svcs[host.serverName()], nil
+}
+
+func newTestFleet(t *testing.T, opts FleetOptions, d *fakeDialer, hosts ...string) *Fleet {
+	opts.Dial = d.dial
+	fleet := NewFleet(opts)
+	for _, host := range hosts {
+		if err := fleet.AddHost(Host{Name: host}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return fleet
+}
+
+func TestFleetAddRemoveHost(t *testing.T) {
+	d := newFakeDialer("a", "b", "c")
+	fleet := newTestFleet(t, FleetOptions{}, d, "a", "b", "c")
+
+	if err := fleet.AddHost(Host{Name: "a"}); err == nil {
+		t.Error("adding a duplicate host should fail")
+	}
+	if err := fleet.AddHost(Host{}); err == nil {
+		t.Error("adding a host without a name should fail")
+	}
+
+	fleet.Run(context.Background(), EnumerateTasksOp())
+	if !fleet.RemoveHost("b") {
+		t.Fatal("host b should have been removed")
+	}
+	if fleet.RemoveHost("b") {
+		t.Error("host b was already removed")
+	}
+	if !d.svcs["b"].disconnected {
+		t.Error("removed host should have been disconnected")
+	}
+
+	hosts := fleet.Hosts()
+	if len(hosts) != 2 || hosts[0] != "a" || hosts[1] != "c" {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+}
+
+func TestFleetRunAggregatesResults(t *testing.T) {
+	d := newFakeDialer("a", "b", "c", "d")
+	d.dialErr["b"] = ErrConnectionFailure
+	d.svcs["c"].err = errors.New("access denied")
+	fleet := newTestFleet(t, FleetOptions{}, d, "a", "b", "c", "d")
+
+	results := fleet.Run(context.Background(), EnumerateTasksOp())
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	for i, host := range []string{"a", "b", "c", "d"} {
+		if results[i].Host != host {
+			t.Errorf("result %d should be for host %s, got %s", i, host, results[i].Host)
+		}
+	}
+
+	succeeded := results.Succeeded()
+	if len(succeeded) != 2 {
+		t.Fatalf("expected 2 hosts to succeed, got %d", len(succeeded))
+	}
+	for _, result := range succeeded {
+		tasks := result.Value.(RegisteredTaskCollection)
+		if len(tasks) != 1 || tasks[0].Path != `\Taskmaster\`+result.Host {
+			t.Errorf("unexpected tasks for host %s: %v", result.Host, tasks)
+		}
+	}
+
+	unreachable := results.Unreachable()
+	if len(unreachable) != 1 || unreachable[0] != "b" {
+		t.Errorf("expected host b to be unreachable, got %v", unreachable)
+	}
+	errs := results.Errors()
+	if len(errs) != 2 || errs["b"] == nil || errs["c"] == nil {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if results.Err() == nil {
+		t.Error("results should have an error")
+	}
+}
+
+func TestFleetReusesConnections(t *testing.T) {
+	d := newFakeDialer("a", "b")
+	fleet := newTestFleet(t, FleetOptions{}, d, "a", "b")
+
+	for i := 0; i < 3; i++ {
+		if err := fleet.Run(context.Background(), EnumerateTasksOp()).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.dials["a"] != 1 || d.dials["b"] != 1 {
+		t.Errorf("expected a single dial per host, got %v", d.dials)
+	}
+
+	// a broken connection should be reconnected the next time it is used
+	d.svcs["a"].err = ErrConnectionFailure
+	fleet.Run(context.Background(), EnumerateTasksOp())
+	d.svcs["a"].err = nil
+	if err := fleet.Run(context.Background(), EnumerateTasksOp()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d.dials["a"] != 2 {
+		t.Errorf("expected host a to be dialed twice, got %d", d.dials["a"])
+	}
+
+	// TaskService wraps connection errors
+	for i, err := range []error{
+		fmt.Errorf("error getting registered tasks: %w", ErrConnectionFailure),
+		fmt.Errorf("error getting registered tasks: %w", ErrNotConnected),
+	} {
+		d.svcs["b"].err = err
+		fleet.Run(context.Background(), EnumerateTasksOp())
+		d.svcs["b"].err = nil
+		if err := fleet.Run(context.Background(), EnumerateTasksOp()).Err(); err != nil {
+			t.Fatal(err)
+		}
+		if d.dials["b"] != i+2 {
+			t.Errorf("expected host b to be dialed %d times after a wrapped connection error, got %d", i+2, d.dials["b"])
+		}
+	}
+
+	// errors are only classified by what they wrap, not by their messages
+	d.svcs["b"].err = fmt.Errorf("task failed: %v", ErrConnectionFailure)
+	fleet.Run(context.Background(), EnumerateTasksOp())
+	d.svcs["b"].err = nil
+	if d.dials["b"] != 3 {
+		t.Errorf("expected host b to stay connected after an error mentioning a connection failure, got %d dials", d.dials["b"])
+	}
+
+	fleet.Close()
+	if !d.svcs["a"].disconnected || !d.svcs["b"].disconnected {
+		t.Error("all hosts should have been disconnected")
+	}
+}
+
+func TestFleetConcurrencyLimit(t *testing.T) {
+	var hosts []string
+	for i := 0; i < 20; i++ {
+		hosts = append(hosts, fmt.Sprintf("host%d", i))
+	}
+	d := newFakeDialer(hosts...)
+	fleet := newTestFleet(t, FleetOptions{Concurrency: 3}, d, hosts...)
+
+	var inFlight, maxInFlight int32
+	results := fleet.Run(context.Background(), func(ctx context.Context, host string, svc Scheduler) (interface{}, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+
+		return host, nil
+	})
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if maxInFlight > 3 {
+		t.Errorf("at most 3 hosts should be operated on at once, got %d", maxInFlight)
+	}
+	for i, result := range results {
+		if result.Value != hosts[i] {
+			t.Errorf("result %d has value %v, expected %s", i, result.Value, hosts[i])
+		}
+	}
+}
+
+func TestFleetHostTimeout(t *testing.T) {
+	d := newFakeDialer("slow", "fast")
+	fleet := NewFleet(FleetOptions{Dial: d.dial})
+	fleet.AddHost(Host{Name: "slow", Timeout: 10 * time.Millisecond})
+	fleet.AddHost(Host{Name: "fast"})
+
+	results := fleet.Run(context.Background(), func(ctx context.Context, host string, svc Scheduler) (interface{}, error) {
+		if host == "slow" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+
+		return nil, nil
+	})
+	if !errors.Is(results[0].Err, context.DeadlineExceeded) {
+		t.Errorf("slow host should have timed out, got %v", results[0].Err)
+	}
+	if results[1].Err != nil {
+		t.Errorf("fast host should have succeeded, got %v", results[1].Err)
+	}
+}
+
+func TestFleetHostInterval(t *testing.T) {
+	d := newFakeDialer("a")
+	fleet := newTestFleet(t, FleetOptions{HostInterval: 20 * time.Millisecond}, d, "a")
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := fleet.Run(context.Background(), EnumerateTasksOp()).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("operations on the same host should be rate limited, took %v", elapsed)
+	}
+}
+
+func TestFleetCanceled(t *testing.T) {
+	d := newFakeDialer("a", "b")
+	fleet := newTestFleet(t, FleetOptions{}, d, "a", "b")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := fleet.Run(ctx, EnumerateTasksOp())
+	for _, result := range results {
+		if !errors.Is(result.Err, context.Canceled) && !result.Unreachable {
+			t.Errorf("host %s should have failed with context.Canceled, got %v", result.Host, result.Err)
+		}
+	}
+}
+
+func TestFleetOps(t *testing.T) {
+	d := newFakeDialer("a", "b")
+	fleet := newTestFleet(t, FleetOptions{}, d, "a", "b")
+	ctx := context.Background()
+	def := newFakeTask(`\Taskmaster\New`).Definition
+
+	if err := fleet.Run(ctx, CreateTaskOp(`\Taskmaster\New`, def, false)).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fleet.Run(ctx, CreateTaskOp(`\Taskmaster\New`, def, false)).Err(); err == nil {
+		t.Error("creating an existing task without overwriting should fail")
+	}
+
+	def.RegistrationInfo.Author = "Taskmaster"
+	if err := fleet.Run(ctx, UpdateTaskOp(`\Taskmaster\New`, def)).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d.svcs["a"].tasks[`\Taskmaster\New`].Definition.RegistrationInfo.Author != "Taskmaster" {
+		t.Error("task should have been updated")
+	}
+
+	results := fleet.Run(ctx, RunTaskOp(`\Taskmaster\New`))
+	if err := results.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Value.(RunningTask).Path != `\Taskmaster\New` {
+		t.Errorf("unexpected running task: %v", results[0].Value)
+	}
+
+	if err := fleet.Run(ctx, DeleteTaskOp(`\Taskmaster\New`)).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// failed operations have no value
+	for _, op := range []FleetOperation{
+		EnumerateTasksOp(),
+		CreateTaskOp(`\Taskmaster\Missing`, def, false),
+		UpdateTaskOp(`\Taskmaster\Missing`, def),
+		RunTaskOp(`\Taskmaster\Missing`),
+	} {
+		d.svcs["a"].err = errors.New("access denied")
+		results := fleet.RunOn(ctx, []string{"a"}, op)
+		d.svcs["a"].err = nil
+		if results[0].Err == nil || results[0].Value != nil {
+			t.Errorf("expected an error and no value, got %v and %#v", results[0].Err, results[0].Value)
+		}
+	}
+	if _, ok := d.svcs["b"].tasks[`\Taskmaster\New`]; ok {
+		t.Error("task should have been deleted")
+	}
+}
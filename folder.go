@@ -0,0 +1,177 @@
+package taskmaster
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Walk calls fn for the folder and every one of its subfolders, depth first.
+// Returning ErrSkipFolder from fn skips the subfolders of the folder, and
+// returning ErrStopEnumeration stops the walk. Any other error stops the walk
+// and is returned by Walk.
+func (f *TaskFolder) Walk(fn func(folder *TaskFolder) error) error {
+	err := f.walk(fn)
+	if err == ErrStopEnumeration {
+		return nil
+	}
+
+	return err
+}
+
+func (f *TaskFolder) walk(fn func(folder *TaskFolder) error) error {
+	err := fn(f)
+	if err == ErrSkipFolder {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, subFolder := range f.SubFolders {
+		if err := subFolder.walk(fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Find returns the folder at path, which is either the folder itself or one
+// of its subfolders. Paths are compared case insensitively.
+func (f *TaskFolder) Find(path string) (*TaskFolder, bool) {
+	target := NormalizeTaskPath(path)
+	if !target.HasPrefix(f.taskPath()) {
+		return nil, false
+	}
+
+	var found *TaskFolder
+	f.Walk(func(folder *TaskFolder) error {
+		if folder.taskPath().Equal(target) {
+			found = folder
+			return ErrStopEnumeration
+		}
+		if !target.HasPrefix(folder.taskPath()) {
+			return ErrSkipFolder
+		}
+		return nil
+	})
+
+	return found, found != nil
+}
+
+// FindTask returns the registered task at path in the folder or one of its
+// subfolders. Paths are compared case insensitively.
+func (f *TaskFolder) FindTask(path string) (*RegisteredTask, bool) {
+	folder, ok := f.Find(TaskPath(path).Folder().String())
+	if !ok {
+		return nil, false
+	}
+
+	target := NormalizeTaskPath(path)
+	for i := range folder.RegisteredTasks {
+		if TaskPath(folder.RegisteredTasks[i].Path).Equal(target) {
+			return &folder.RegisteredTasks[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Flatten returns the registered tasks of the folder and all its subfolders,
+// depth first.
+func (f *TaskFolder) Flatten() RegisteredTaskCollection {
+	var tasks RegisteredTaskCollection
+	f.Walk(func(folder *TaskFolder) error {
+		tasks = append(tasks, folder.RegisteredTasks...)
+		return nil
+	})
+
+	return tasks
+}
+
+// TaskCount returns the number of registered tasks in the folder and all its
+// subfolders.
+func (f *TaskFolder) TaskCount() int {
+	var count int
+	f.Walk(func(folder *TaskFolder) error {
+		count += len(folder.RegisteredTasks)
+		return nil
+	})
+
+	return count
+}
+
+// TaskCounts returns the number of registered tasks directly in the folder
+// and each of its subfolders, keyed by folder path.
+func (f *TaskFolder) TaskCounts() map[string]int {
+	counts := make(map[string]int)
+	f.Walk(func(folder *TaskFolder) error {
+		counts[folder.Path] = len(folder.RegisteredTasks)
+		return nil
+	})
+
+	return counts
+}
+
+// Tree renders the folder and its subfolders as a tree, with the number of
+// tasks in every folder and its subfolders. If includeTasks is true, the
+// names of the tasks of every folder are rendered under its subfolders.
+func (f *TaskFolder) Tree(includeTasks bool) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s (%s)\n", f.displayName(), pluralizeTasks(f.TaskCount()))
+	f.writeTree(&sb, "", includeTasks)
+
+	return sb.String()
+}
+
+func (f *TaskFolder) writeTree(sb *strings.Builder, indent string, includeTasks bool) {
+	children := len(f.SubFolders)
+	if includeTasks {
+		children += len(f.RegisteredTasks)
+	}
+
+	writeChild := func(i int, line string) string {
+		if i == children-1 {
+			sb.WriteString(indent + "└── " + line + "\n")
+			return indent + "    "
+		}
+		sb.WriteString(indent + "├── " + line + "\n")
+		return indent + "│   "
+	}
+
+	for i, subFolder := range f.SubFolders {
+		childIndent := writeChild(i, fmt.Sprintf("%s (%s)", subFolder.displayName(), pluralizeTasks(subFolder.TaskCount())))
+		subFolder.writeTree(sb, childIndent, includeTasks)
+	}
+	if includeTasks {
+		for i, task := range f.RegisteredTasks {
+			name := task.Name
+			if name == "" {
+				name = TaskPath(task.Path).Name()
+			}
+			writeChild(len(f.SubFolders)+i, name)
+		}
+	}
+}
+
+func (f *TaskFolder) displayName() string {
+	if f.taskPath().IsRoot() {
+		return `\`
+	}
+	if f.Name != "" {
+		return f.Name
+	}
+
+	return f.taskPath().Name()
+}
+
+func (f *TaskFolder) taskPath() TaskPath {
+	return NormalizeTaskPath(f.Path)
+}
+
+func pluralizeTasks(n int) string {
+	if n == 1 {
+		return "1 task"
+	}
+
+	return fmt.Sprintf("%d tasks", n)
+}
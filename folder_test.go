@@ -0,0 +1,119 @@
+package taskmaster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTaskFolderWalk(t *testing.T) {
+	root := newEnumerateTestFolder()
+
+	var paths []string
+	err := root.Walk(func(folder *TaskFolder) error {
+		paths = append(paths, folder.Path)
+		if folder.Path == `\Microsoft\Windows` {
+			return ErrSkipFolder
+		}
+		if folder.Path == `\Microsoft\Office` {
+			return ErrStopEnumeration
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`\`, `\Microsoft`, `\Microsoft\Windows`, `\Microsoft\Office`}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+}
+
+func TestTaskFolderFind(t *testing.T) {
+	root := newEnumerateTestFolder()
+
+	folder, ok := root.Find(`\microsoft\windows\update`)
+	if !ok || folder.Path != `\Microsoft\Windows\Update` {
+		t.Errorf("unexpected folder %v", folder)
+	}
+	if folder, ok := root.Find(`\`); !ok || folder != root {
+		t.Errorf("expected root folder, got %v", folder)
+	}
+	if _, ok := root.Find(`\Microsoft\Linux`); ok {
+		t.Error("expected folder not to be found")
+	}
+
+	task, ok := root.FindTask(`\Microsoft\Windows\Cleanup`)
+	if !ok || task.Path != `\Microsoft\Windows\Cleanup` {
+		t.Errorf("unexpected task %v", task)
+	}
+	// the task can be modified through the returned pointer
+	task.Enabled = true
+	if !root.SubFolders[0].SubFolders[0].RegisteredTasks[1].Enabled {
+		t.Error("expected task in folder to be modified")
+	}
+	if _, ok := root.FindTask(`\Microsoft\Windows`); ok {
+		t.Error("expected folder not to be found as a task")
+	}
+}
+
+func TestTaskFolderFlatten(t *testing.T) {
+	root := newEnumerateTestFolder()
+
+	var paths []string
+	for _, task := range root.Flatten() {
+		paths = append(paths, task.Path)
+	}
+	expected := []string{`\Root`, `\Microsoft\Task`, `\Microsoft\Windows\Defrag`, `\Microsoft\Windows\Cleanup`, `\Microsoft\Windows\Update\Scan`, `\Microsoft\Office\Telemetry`, `\Backup\Nightly`}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+
+	if count := root.TaskCount(); count != len(expected) {
+		t.Errorf("expected %d tasks, got %d", len(expected), count)
+	}
+	counts := root.TaskCounts()
+	if counts[`\Microsoft\Windows`] != 2 || counts[`\Microsoft`] != 1 || len(counts) != 6 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+}
+
+func TestTaskFolderTree(t *testing.T) {
+	root := newEnumerateTestFolder()
+	root.SubFolders = root.SubFolders[:1]
+	root.SubFolders[0].SubFolders[0].SubFolders = nil
+
+	expected := `\ (5 tasks)
+├── Microsoft (4 tasks)
+│   ├── Windows (2 tasks)
+│   │   ├── Defrag
+│   │   └── Cleanup
+│   ├── Office (1 task)
+│   │   └── Telemetry
+│   └── Task
+└── Root
+`
+	if tree := root.Tree(true); tree != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, tree)
+	}
+
+	expected = `\ (5 tasks)
+└── Microsoft (4 tasks)
+    ├── Windows (2 tasks)
+    └── Office (1 task)
+`
+	if tree := root.Tree(false); tree != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, tree)
+	}
+}
+
+func TestTaskFolderRelease(t *testing.T) {
+	root := newEnumerateTestFolder()
+	root.Release()
+
+	root.Walk(func(folder *TaskFolder) error {
+		if !folder.isReleased {
+			t.Errorf("folder %s was not released", folder.Path)
+		}
+		return nil
+	})
+}
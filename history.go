@@ -0,0 +1,703 @@
+package taskmaster
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TaskSchedulerOperationalLog is the name of the event log Task Scheduler
+// records the history of tasks to.
+const TaskSchedulerOperationalLog = "Microsoft-Windows-TaskScheduler/Operational"
+
+// historyQuery returns the XPath query selecting the events of the task at
+// path logged since the given time, or all of its events if since is zero.
+func historyQuery(path string, since time.Time) (string, error) {
+	// XPath string literals have no escapes, so they must be delimited with
+	// a quote the path doesn't contain
+	var taskName string
+	switch {
+	case !strings.Contains(path, "'"):
+		taskName = "'" + path + "'"
+	case !strings.Contains(path, `"`):
+		taskName = `"` + path + `"`
+	default:
+		return "", fmt.Errorf("task path %s can't be queried as it contains both single and double quotes", path)
+	}
+
+	condition := fmt.Sprintf("EventData[Data[@Name='TaskName']=%s]", taskName)
+	if !since.IsZero() {
+		condition += fmt.Sprintf(" and System[TimeCreated[@SystemTime>='%s']]", since.UTC().Format(time.RFC3339Nano))
+	}
+
+	return "*[" + condition + "]", nil
+}
+
+// HistoryEvent is an event from the Task Scheduler operational event log.
+// The concrete type of a HistoryEvent depends on its event ID.
+type HistoryEvent interface {
+	Header() EventHeader
+}
+
+// EventHeader holds the information common to all Task Scheduler events.
+type EventHeader struct {
+	ID         uint      // the event ID
+	RecordID   uint64    // the position of the event in the event log
+	Time       time.Time // when the event was logged
+	Computer   string    // the computer the event was logged on
+	ActivityID string    // correlates the events of a single operation
+	TaskPath   string    // the path of the task the event is about
+}
+
+// Header returns the header of the event.
+func (h EventHeader) Header() EventHeader {
+	return h
+}
+
+// TriggerCause is what caused an instance of a task to be launched.
+type TriggerCause uint
+
+const (
+	UnknownCause TriggerCause = iota
+	TimeTriggered
+	EventTriggered
+	RegistrationTriggered
+	UserTriggered
+	BootTriggered
+	LogonTriggered
+)
+
+func (c TriggerCause) String() string {
+	switch c {
+	case UnknownCause:
+		return "Unknown"
+	case TimeTriggered:
+		return "Time Trigger"
+	case EventTriggered:
+		return "Event Trigger"
+	case RegistrationTriggered:
+		return "Registration Trigger"
+	case UserTriggered:
+		return "User"
+	case BootTriggered:
+		return "Boot Trigger"
+	case LogonTriggered:
+		return "Logon Trigger"
+	default:
+		return ""
+	}
+}
+
+// TaskTriggeredEvent is logged when a task is launched by a trigger or a user.
+// Event IDs 107, 108, 109, 110, 118 and 119.
+type TaskTriggeredEvent struct {
+	EventHeader
+	InstanceGUID string
+	UserContext  string // the user that launched the task, only set if Cause is UserTriggered or LogonTriggered
+	Cause        TriggerCause
+}
+
+// TaskStartedEvent is logged when an instance of a task is started. Event ID 100.
+type TaskStartedEvent struct {
+	EventHeader
+	InstanceGUID string
+	UserContext  string
+}
+
+// TaskStartFailedEvent is logged when a task fails to launch. Event ID 101.
+type TaskStartFailedEvent struct {
+	EventHeader
+	UserContext string
+	ResultCode  TaskResult
+}
+
+// TaskCompletedEvent is logged when an instance of a task completes. Event ID 102.
+type TaskCompletedEvent struct {
+	EventHeader
+	InstanceGUID string
+	UserContext  string
+}
+
+// InstanceStartFailedEvent is logged when an instance of a task fails to start. Event ID 103.
+type InstanceStartFailedEvent struct {
+	EventHeader
+	InstanceGUID string
+	UserContext  string
+	ResultCode   TaskResult
+}
+
+// TaskRegisteredEvent is logged when a task is registered. Event ID 106.
+type TaskRegisteredEvent struct {
+	EventHeader
+	UserContext string
+}
+
+// TaskTerminatedEvent is logged when an instance of a task is stopped. Event ID 111.
+type TaskTerminatedEvent struct {
+	EventHeader
+	InstanceGUID string
+}
+
+// ProcessCreatedEvent is logged when the process of an exec action is created. Event ID 129.
+type ProcessCreatedEvent struct {
+	EventHeader
+	Path      string // the path to the executable
+	ProcessID uint
+	Priority  uint
+}
+
+// TaskUpdatedEvent is logged when the registration of a task is updated. Event ID 140.
+type TaskUpdatedEvent struct {
+	EventHeader
+	UserName string
+}
+
+// TaskDeletedEvent is logged when a task is deleted. Event ID 141.
+type TaskDeletedEvent struct {
+	EventHeader
+	UserName string
+}
+
+// ActionStartedEvent is logged when an action of a task is started. Event ID 200.
+type ActionStartedEvent struct {
+	EventHeader
+	InstanceGUID string
+	ActionName   string
+	EnginePID    uint
+}
+
+// ActionCompletedEvent is logged when an action of a task completes. Event ID 201.
+type ActionCompletedEvent struct {
+	EventHeader
+	InstanceGUID string
+	ActionName   string
+	ResultCode   TaskResult
+	EnginePID    uint
+}
+
+// ActionFailedEvent is logged when an action of a task fails. Event ID 202.
+type ActionFailedEvent struct {
+	EventHeader
+	InstanceGUID string
+	ActionName   string
+	ResultCode   TaskResult
+}
+
+// ActionStartFailedEvent is logged when an action of a task fails to start. Event ID 203.
+type ActionStartFailedEvent struct {
+	EventHeader
+	InstanceGUID string
+	ActionName   string
+	ResultCode   TaskResult
+}
+
+// LaunchIgnoredEvent is logged when a task isn't launched because an instance
+// of it is already running. Event ID 322.
+type LaunchIgnoredEvent struct {
+	EventHeader
+	InstanceGUID string
+}
+
+// LaunchQueuedEvent is logged when a task is queued because an instance
+// of it is already running. Event ID 325.
+type LaunchQueuedEvent struct {
+	EventHeader
+	InstanceGUID string
+}
+
+// UnknownHistoryEvent is an event whose ID isn't recognized.
+type UnknownHistoryEvent struct {
+	EventHeader
+	Data map[string]string // the named event data of the event
+}
+
+type eventXML struct {
+	System struct {
+		EventID       uint   `xml:"EventID"`
+		EventRecordID uint64 `xml:"EventRecordID"`
+		TimeCreated   struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		} `xml:"TimeCreated"`
+		Correlation struct {
+			ActivityID string `xml:"ActivityID,attr"`
+		} `xml:"Correlation"`
+		Computer string `xml:"Computer"`
+	} `xml:"System"`
+	EventData struct {
+		Data []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Data"`
+	} `xml:"EventData"`
+}
+
+// ParseHistoryEvent parses a single event of the Task Scheduler operational
+// event log rendered as XML.
+func ParseHistoryEvent(data []byte) (HistoryEvent, error) {
+	var e eventXML
+	if err := xml.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("error parsing event XML: %v", err)
+	}
+
+	return e.parse()
+}
+
+// ParseHistoryEvents parses events of the Task Scheduler operational event log
+// rendered as XML, such as the output of 'wevtutil qe /f:xml' or an event log
+// saved as XML from Event Viewer.
+func ParseHistoryEvents(r io.Reader) ([]HistoryEvent, error) {
+	var events []HistoryEvent
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return events, fmt.Errorf("error parsing event XML: %v", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Event" {
+			continue
+		}
+
+		var e eventXML
+		if err = decoder.DecodeElement(&e, &start); err != nil {
+			return events, fmt.Errorf("error parsing event XML: %v", err)
+		}
+		event, err := e.parse()
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (e eventXML) parse() (HistoryEvent, error) {
+	data := make(map[string]string, len(e.EventData.Data))
+	for _, d := range e.EventData.Data {
+		data[d.Name] = strings.TrimSpace(d.Value)
+	}
+
+	header := EventHeader{
+		ID:         e.System.EventID,
+		RecordID:   e.System.EventRecordID,
+		Computer:   e.System.Computer,
+		ActivityID: e.System.Correlation.ActivityID,
+		TaskPath:   data["TaskName"],
+	}
+	if e.System.TimeCreated.SystemTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, e.System.TimeCreated.SystemTime)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing time of event %d: %v", header.RecordID, err)
+		}
+		header.Time = t
+	}
+
+	p := eventDataParser{data: data}
+	var event HistoryEvent
+	switch header.ID {
+	case 100:
+		event = TaskStartedEvent{
+			EventHeader:  header,
+			InstanceGUID: p.instanceGUID(),
+			UserContext:  data["UserContext"],
+		}
+	case 101:
+		event = TaskStartFailedEvent{
+			EventHeader: header,
+			UserContext: data["UserContext"],
+			ResultCode:  p.resultCode(),
+		}
+	case 102:
+		event = TaskCompletedEvent{
+			EventHeader:  header,
+			InstanceGUID: p.instanceGUID(),
+			UserContext:  data["UserContext"],
+		}
+	case 103:
+		event = InstanceStartFailedEvent{
+			EventHeader:  header,
+			InstanceGUID: p.instanceGUID(),
+			UserContext:  data["UserContext"],
+			ResultCode:   p.resultCode(),
+		}
+	case 106:
+		event = TaskRegisteredEvent{
+			EventHeader: header,
+			UserContext: data["UserContext"],
+		}
+	case 107, 108, 109, 110, 118, 119:
+		causes := map[uint]TriggerCause{
+			107: TimeTriggered,
+			108: EventTriggered,
+			109: RegistrationTriggered,
+			110: UserTriggered,
+			118: BootTriggered,
+			119: LogonTriggered,
+		}
+		userContext := data["UserContext"]
+		if userContext == "" {
+			userContext = data["UserName"]
+		}
+		event = TaskTriggeredEvent{
+			EventHeader:  header,
+			InstanceGUID: p.instanceGUID(),
+			UserContext:  userContext,
+			Cause:        causes[header.ID],
+		}
+	case 111:
+		event = TaskTerminatedEvent{
+			EventHeader:  header,
+			InstanceGUID: p.instanceGUID(),
+		}
+	case 129:
+		event = ProcessCreatedEvent{
+			EventHeader: header,
+			Path:        data["Path"],
+			ProcessID:   p.number("ProcessID"),
+			Priority:    p.number("Priority"),
+		}
+	case 140:
+		event = TaskUpdatedEvent{
+			EventHeader: header,
+			UserName:    data["UserName"],
+		}
+	case 141:
+		event = TaskDeletedEvent{
+			EventHeader: header,
+			UserName:    data["UserName"],
+		}
+	case 200:
+		event = ActionStartedEvent{
+			EventHeader:  header,
+			InstanceGUID: p.instanceGUID(),
+			ActionName:   data["ActionName"],
+			EnginePID:    p.number("EnginePID"),
+		}
+	case 201:
+		event = ActionCompletedEvent{
+			EventHeader:  header,
+			InstanceGUID: p.instanceGUID(),
+			ActionName:   data["ActionName"],
+			ResultCode:   p.resultCode(),
+			EnginePID:    p.number("EnginePID"),
+		}
+	case 202:
+		event = ActionFailedEvent{
+			EventHeader:  header,
+			InstanceGUID: p.instanceGUID(),
+			ActionName:   data["ActionName"],
+			ResultCode:   p.resultCode(),
+		}
+	case 203:
+		event = ActionStartFailedEvent{
+			EventHeader:  header,
+			InstanceGUID: p.instanceGUID(),
+			ActionName:   data["ActionName"],
+			ResultCode:   p.resultCode(),
+		}
+	case 322:
+		event = LaunchIgnoredEvent{
+			EventHeader:  header,
+			InstanceGUID: p.instanceGUID(),
+		}
+	case 325:
+		event = LaunchQueuedEvent{
+			EventHeader:  header,
+			InstanceGUID: p.instanceGUID(),
+		}
+	default:
+		event = UnknownHistoryEvent{
+			EventHeader: header,
+			Data:        data,
+		}
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("error parsing data of event %d: %v", header.RecordID, p.err)
+	}
+
+	return event, nil
+}
+
+// eventDataParser parses named event data, keeping the first error encountered.
+type eventDataParser struct {
+	data map[string]string
+	err  error
+}
+
+func (p *eventDataParser) instanceGUID() string {
+	if guid, ok := p.data["InstanceId"]; ok {
+		return guid
+	}
+
+	return p.data["TaskInstanceId"]
+}
+
+func (p *eventDataParser) number(name string) uint {
+	value, ok := p.data[name]
+	if !ok || value == "" {
+		return 0
+	}
+
+	n, err := strconv.ParseUint(value, 0, 32)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("invalid %s: %v", name, err)
+	}
+
+	return uint(n)
+}
+
+// resultCode parses result codes, which are logged as decimal or hexadecimal,
+// signed or unsigned numbers.
+func (p *eventDataParser) resultCode() TaskResult {
+	value, ok := p.data["ResultCode"]
+	if !ok || value == "" {
+		return 0
+	}
+
+	n, err := strconv.ParseInt(value, 0, 64)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("invalid ResultCode: %v", err)
+	}
+
+	return TaskResult(uint32(n))
+}
+
+// RunStatus is the outcome of a run of a task.
+type RunStatus uint
+
+const (
+	RunPending     RunStatus = iota // the task was launched but hasn't started yet
+	RunQueued                       // the task was queued because another instance was running
+	RunInProgress                   // the task is running
+	RunSucceeded                    // the task completed and its last action returned 0
+	RunFailed                       // an action of the task failed or returned a non zero result
+	RunTerminated                   // the task was stopped
+	RunStartFailed                  // the task couldn't be started
+	RunIgnored                      // the task wasn't started because another instance was running
+)
+
+func (s RunStatus) String() string {
+	switch s {
+	case RunPending:
+		return "Pending"
+	case RunQueued:
+		return "Queued"
+	case RunInProgress:
+		return "In Progress"
+	case RunSucceeded:
+		return "Succeeded"
+	case RunFailed:
+		return "Failed"
+	case RunTerminated:
+		return "Terminated"
+	case RunStartFailed:
+		return "Start Failed"
+	case RunIgnored:
+		return "Ignored"
+	default:
+		return ""
+	}
+}
+
+// ActionRecord is a run of a single action of a task.
+type ActionRecord struct {
+	Name       string
+	StartTime  time.Time
+	EndTime    time.Time
+	ResultCode TaskResult
+	EnginePID  uint
+	Failed     bool
+}
+
+// RunRecord is a single run of a task, built from the history events of a
+// task instance.
+type RunRecord struct {
+	TaskPath     string
+	InstanceGUID string
+	Cause        TriggerCause
+	UserContext  string
+	TriggerTime  time.Time // when the task was launched
+	StartTime    time.Time // when the task started
+	EndTime      time.Time // when the task completed, failed or was stopped
+	Actions      []ActionRecord
+	ProcessID    uint       // the process ID of the first exec action
+	ResultCode   TaskResult // the result of the last action that completed or failed
+	Status       RunStatus
+	Events       []HistoryEvent // the events of the run, in the order they were logged
+}
+
+// Duration returns how long the task ran for, or 0 if it didn't both start and end.
+func (r RunRecord) Duration() time.Duration {
+	if r.StartTime.IsZero() || r.EndTime.IsZero() {
+		return 0
+	}
+
+	return r.EndTime.Sub(r.StartTime)
+}
+
+// CorrelateRuns groups history events into task runs by task path and instance
+// GUID. Events that aren't about a run, such as TaskRegisteredEvent, are ignored.
+// Runs are ordered by their first event.
+func CorrelateRuns(events []HistoryEvent) []RunRecord {
+	sorted := make([]HistoryEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		hi, hj := sorted[i].Header(), sorted[j].Header()
+		if !hi.Time.Equal(hj.Time) {
+			return hi.Time.Before(hj.Time)
+		}
+		return hi.RecordID < hj.RecordID
+	})
+
+	var runs []*RunRecord
+	runIndex := make(map[string]*RunRecord)
+	getRun := func(header EventHeader, instanceGUID string) *RunRecord {
+		key := strings.ToLower(header.TaskPath) + "|" + strings.ToLower(instanceGUID)
+		if run, ok := runIndex[key]; ok {
+			return run
+		}
+
+		run := &RunRecord{
+			TaskPath:     header.TaskPath,
+			InstanceGUID: instanceGUID,
+		}
+		runs = append(runs, run)
+		runIndex[key] = run
+
+		return run
+	}
+	// lastAction returns the last action of the run with the given name that hasn't ended yet
+	lastAction := func(run *RunRecord, name string) *ActionRecord {
+		for i := len(run.Actions) - 1; i >= 0; i-- {
+			if run.Actions[i].Name == name && run.Actions[i].EndTime.IsZero() {
+				return &run.Actions[i]
+			}
+		}
+		run.Actions = append(run.Actions, ActionRecord{Name: name})
+
+		return &run.Actions[len(run.Actions)-1]
+	}
+	finished := func(run *RunRecord) bool {
+		return run.Status >= RunSucceeded
+	}
+
+	for _, event := range sorted {
+		header := event.Header()
+
+		var run *RunRecord
+		switch e := event.(type) {
+		case TaskTriggeredEvent:
+			run = getRun(header, e.InstanceGUID)
+			run.Cause = e.Cause
+			run.TriggerTime = header.Time
+			if e.UserContext != "" {
+				run.UserContext = e.UserContext
+			}
+		case LaunchQueuedEvent:
+			run = getRun(header, e.InstanceGUID)
+			if run.Status == RunPending {
+				run.Status = RunQueued
+			}
+		case LaunchIgnoredEvent:
+			run = getRun(header, e.InstanceGUID)
+			run.Status = RunIgnored
+		case TaskStartedEvent:
+			run = getRun(header, e.InstanceGUID)
+			run.StartTime = header.Time
+			run.UserContext = e.UserContext
+			if !finished(run) {
+				run.Status = RunInProgress
+			}
+		case TaskStartFailedEvent:
+			// these events have no instance GUID, so each is a separate run
+			run = getRun(header, fmt.Sprintf("#%d", header.RecordID))
+			run.InstanceGUID = ""
+			run.UserContext = e.UserContext
+			run.EndTime = header.Time
+			run.ResultCode = e.ResultCode
+			run.Status = RunStartFailed
+		case InstanceStartFailedEvent:
+			run = getRun(header, e.InstanceGUID)
+			run.UserContext = e.UserContext
+			run.EndTime = header.Time
+			run.ResultCode = e.ResultCode
+			run.Status = RunStartFailed
+		case ProcessCreatedEvent:
+			// these events have no instance GUID, attribute them to the
+			// latest run of the task that is in progress
+			for i := len(runs) - 1; i >= 0; i-- {
+				if strings.EqualFold(runs[i].TaskPath, header.TaskPath) && runs[i].Status == RunInProgress {
+					run = runs[i]
+					break
+				}
+			}
+			if run != nil && run.ProcessID == 0 {
+				run.ProcessID = e.ProcessID
+			}
+		case ActionStartedEvent:
+			run = getRun(header, e.InstanceGUID)
+			run.Actions = append(run.Actions, ActionRecord{
+				Name:      e.ActionName,
+				StartTime: header.Time,
+				EnginePID: e.EnginePID,
+			})
+		case ActionCompletedEvent:
+			run = getRun(header, e.InstanceGUID)
+			action := lastAction(run, e.ActionName)
+			action.EndTime = header.Time
+			action.ResultCode = e.ResultCode
+			action.EnginePID = e.EnginePID
+			run.ResultCode = e.ResultCode
+		case ActionFailedEvent:
+			run = getRun(header, e.InstanceGUID)
+			action := lastAction(run, e.ActionName)
+			action.EndTime = header.Time
+			action.ResultCode = e.ResultCode
+			action.Failed = true
+			run.ResultCode = e.ResultCode
+			run.Status = RunFailed
+		case ActionStartFailedEvent:
+			run = getRun(header, e.InstanceGUID)
+			action := lastAction(run, e.ActionName)
+			action.EndTime = header.Time
+			action.ResultCode = e.ResultCode
+			action.Failed = true
+			run.ResultCode = e.ResultCode
+			run.Status = RunFailed
+		case TaskCompletedEvent:
+			run = getRun(header, e.InstanceGUID)
+			run.EndTime = header.Time
+			if !finished(run) {
+				if run.ResultCode == 0 {
+					run.Status = RunSucceeded
+				} else {
+					run.Status = RunFailed
+				}
+			}
+		case TaskTerminatedEvent:
+			run = getRun(header, e.InstanceGUID)
+			run.EndTime = header.Time
+			run.Status = RunTerminated
+		}
+
+		if run != nil {
+			run.Events = append(run.Events, event)
+		}
+	}
+
+	records := make([]RunRecord, len(runs))
+	for i, run := range runs {
+		records[i] = *run
+	}
+
+	return records
+}
@@ -0,0 +1,167 @@
+package taskmaster
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testHistoryXML = `<Events>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>106</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T00:00:00.0000000Z"/><EventRecordID>1</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000001}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event106"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="UserContext">DOMAIN\user</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>107</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T01:00:00.0000000Z"/><EventRecordID>2</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000002}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event107"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="InstanceId">{AAAAAAAA-0000-0000-0000-000000000000}</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>100</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T01:00:00.1000000Z"/><EventRecordID>3</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000003}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event100"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="UserContext">DOMAIN\user</Data><Data Name="InstanceId">{AAAAAAAA-0000-0000-0000-000000000000}</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>200</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T01:00:00.2000000Z"/><EventRecordID>4</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000004}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event200"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="ActionName">C:\Windows\System32\cmd.exe</Data><Data Name="TaskInstanceId">{AAAAAAAA-0000-0000-0000-000000000000}</Data><Data Name="EnginePID">1234</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>129</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T01:00:00.3000000Z"/><EventRecordID>5</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000005}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event129"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="Path">C:\Windows\System32\cmd.exe</Data><Data Name="ProcessID">5678</Data><Data Name="Priority">16384</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>201</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T01:00:05.2000000Z"/><EventRecordID>6</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000006}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event201"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="TaskInstanceId">{aaaaaaaa-0000-0000-0000-000000000000}</Data><Data Name="ActionName">C:\Windows\System32\cmd.exe</Data><Data Name="ResultCode">0</Data><Data Name="EnginePID">1234</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>102</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T01:00:05.3000000Z"/><EventRecordID>7</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000007}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event102"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="UserContext">DOMAIN\user</Data><Data Name="InstanceId">{AAAAAAAA-0000-0000-0000-000000000000}</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>110</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T02:00:00.0000000Z"/><EventRecordID>8</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000008}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event110"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="InstanceId">{BBBBBBBB-0000-0000-0000-000000000000}</Data><Data Name="UserContext">DOMAIN\admin</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>100</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T02:00:00.1000000Z"/><EventRecordID>9</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000009}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event100"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="UserContext">DOMAIN\user</Data><Data Name="InstanceId">{BBBBBBBB-0000-0000-0000-000000000000}</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>200</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T02:00:00.2000000Z"/><EventRecordID>10</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000010}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event200"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="ActionName">C:\missing.exe</Data><Data Name="TaskInstanceId">{BBBBBBBB-0000-0000-0000-000000000000}</Data><Data Name="EnginePID">1234</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>203</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T02:00:00.3000000Z"/><EventRecordID>11</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000011}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event203"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="TaskInstanceId">{BBBBBBBB-0000-0000-0000-000000000000}</Data><Data Name="ActionName">C:\missing.exe</Data><Data Name="ResultCode">2147942402</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>102</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T02:00:00.4000000Z"/><EventRecordID>12</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000012}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event102"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="UserContext">DOMAIN\user</Data><Data Name="InstanceId">{BBBBBBBB-0000-0000-0000-000000000000}</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>101</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T03:00:00.0000000Z"/><EventRecordID>13</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000013}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event101"><Data Name="TaskName">\Taskmaster\Task</Data><Data Name="UserContext">DOMAIN\user</Data><Data Name="ResultCode">0x8007052E</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>107</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T04:00:00.0000000Z"/><EventRecordID>14</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000014}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event107"><Data Name="TaskName">\Taskmaster\Other</Data><Data Name="InstanceId">{CCCCCCCC-0000-0000-0000-000000000000}</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>100</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T04:00:00.1000000Z"/><EventRecordID>15</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000015}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event100"><Data Name="TaskName">\Taskmaster\Other</Data><Data Name="UserContext">DOMAIN\user</Data><Data Name="InstanceId">{CCCCCCCC-0000-0000-0000-000000000000}</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>325</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T04:01:00.0000000Z"/><EventRecordID>16</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000016}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event325"><Data Name="TaskName">\Taskmaster\Other</Data><Data Name="InstanceId">{DDDDDDDD-0000-0000-0000-000000000000}</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>322</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T04:02:00.0000000Z"/><EventRecordID>17</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000017}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event322"><Data Name="TaskName">\Taskmaster\Other</Data><Data Name="InstanceId">{EEEEEEEE-0000-0000-0000-000000000000}</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>111</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T04:03:00.1000000Z"/><EventRecordID>18</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000018}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event111"><Data Name="TaskName">\Taskmaster\Other</Data><Data Name="InstanceId">{CCCCCCCC-0000-0000-0000-000000000000}</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>141</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T05:00:00.0000000Z"/><EventRecordID>19</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000019}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event141"><Data Name="TaskName">\Taskmaster\Other</Data><Data Name="UserName">DOMAIN\admin</Data></EventData></Event>
+<Event xmlns="http://schemas.microsoft.com/win/2004/08/events/event"><System><Provider Name="Microsoft-Windows-TaskScheduler" Guid="{de7b24ea-73c8-4a09-985d-5bdadcfa9017}"/><EventID>999</EventID><Version>0</Version><Level>4</Level><TimeCreated SystemTime="2020-01-01T05:00:00.0000000Z"/><EventRecordID>20</EventRecordID><Correlation ActivityID="{00000000-0000-0000-0000-000000000020}"/><Channel>Microsoft-Windows-TaskScheduler/Operational</Channel><Computer>HOST</Computer><Security UserID="S-1-5-18"/></System><EventData Name="Event999"><Data Name="TaskName">\Taskmaster\Other</Data><Data Name="Custom">value</Data></EventData></Event>
+</Events>`
+
+func TestParseHistoryEvents(t *testing.T) {
+	events, err := ParseHistoryEvents(strings.NewReader(testHistoryXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 20 {
+		t.Fatalf("expected 20 events, got %d", len(events))
+	}
+
+	header := events[0].Header()
+	expectedHeader := EventHeader{
+		ID:         106,
+		RecordID:   1,
+		Time:       time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Computer:   "HOST",
+		ActivityID: "{00000000-0000-0000-0000-000000000001}",
+		TaskPath:   `\Taskmaster\Task`,
+	}
+	if header != expectedHeader {
+		t.Errorf("expected header %+v, got %+v", expectedHeader, header)
+	}
+
+	processCreated, ok := events[4].(ProcessCreatedEvent)
+	if !ok {
+		t.Fatalf("expected ProcessCreatedEvent, got %T", events[4])
+	}
+	if processCreated.ProcessID != 5678 || processCreated.Priority != 16384 || processCreated.Path != `C:\Windows\System32\cmd.exe` {
+		t.Errorf("unexpected event %+v", processCreated)
+	}
+
+	startFailed, ok := events[12].(TaskStartFailedEvent)
+	if !ok {
+		t.Fatalf("expected TaskStartFailedEvent, got %T", events[12])
+	}
+	if startFailed.ResultCode != 0x8007052E {
+		t.Errorf("expected result code 0x8007052E, got %#x", uint32(startFailed.ResultCode))
+	}
+
+	unknown, ok := events[19].(UnknownHistoryEvent)
+	if !ok {
+		t.Fatalf("expected UnknownHistoryEvent, got %T", events[19])
+	}
+	if unknown.Data["Custom"] != "value" {
+		t.Errorf("unexpected data %v", unknown.Data)
+	}
+
+	if _, err := ParseHistoryEvent([]byte(`<Event><System><EventID>201</EventID></System><EventData><Data Name="ResultCode">abc</Data></EventData></Event>`)); err == nil {
+		t.Error("parsing an invalid result code should fail")
+	}
+	event, err := ParseHistoryEvent([]byte(`<Event><System><EventID>118</EventID></System><EventData><Data Name="TaskName">\Task</Data></EventData></Event>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.(TaskTriggeredEvent).Cause != BootTriggered {
+		t.Errorf("expected a boot trigger, got %v", event.(TaskTriggeredEvent).Cause)
+	}
+}
+
+func TestCorrelateRuns(t *testing.T) {
+	events, err := ParseHistoryEvents(strings.NewReader(testHistoryXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the order of the events shouldn't matter
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	runs := CorrelateRuns(events)
+
+	expected := []struct {
+		path   string
+		guid   string
+		status RunStatus
+		cause  TriggerCause
+		result TaskResult
+		events int
+	}{
+		{`\Taskmaster\Task`, "{AAAAAAAA-0000-0000-0000-000000000000}", RunSucceeded, TimeTriggered, 0, 6},
+		{`\Taskmaster\Task`, "{BBBBBBBB-0000-0000-0000-000000000000}", RunFailed, UserTriggered, 0x80070002, 5},
+		{`\Taskmaster\Task`, "", RunStartFailed, UnknownCause, 0x8007052E, 1},
+		{`\Taskmaster\Other`, "{CCCCCCCC-0000-0000-0000-000000000000}", RunTerminated, TimeTriggered, 0, 3},
+		{`\Taskmaster\Other`, "{DDDDDDDD-0000-0000-0000-000000000000}", RunQueued, UnknownCause, 0, 1},
+		{`\Taskmaster\Other`, "{EEEEEEEE-0000-0000-0000-000000000000}", RunIgnored, UnknownCause, 0, 1},
+	}
+	if len(runs) != len(expected) {
+		t.Fatalf("expected %d runs, got %d", len(expected), len(runs))
+	}
+	for i, run := range runs {
+		e := expected[i]
+		if run.TaskPath != e.path || run.InstanceGUID != e.guid || run.Status != e.status || run.Cause != e.cause || run.ResultCode != e.result || len(run.Events) != e.events {
+			t.Errorf("run %d: expected %+v, got path %s guid %s status %s cause %s result %#x with %d events",
+				i, e, run.TaskPath, run.InstanceGUID, run.Status, run.Cause, uint32(run.ResultCode), len(run.Events))
+		}
+	}
+
+	run := runs[0]
+	if run.ProcessID != 5678 {
+		t.Errorf("expected process ID 5678, got %d", run.ProcessID)
+	}
+	if run.Duration() != 5200*time.Millisecond {
+		t.Errorf("expected a duration of 5.2s, got %v", run.Duration())
+	}
+	if len(run.Actions) != 1 || run.Actions[0].EndTime.Sub(run.Actions[0].StartTime) != 5*time.Second || run.Actions[0].EnginePID != 1234 {
+		t.Errorf("unexpected actions %+v", run.Actions)
+	}
+	if runs[1].UserContext != `DOMAIN\user` || !runs[1].Actions[0].Failed {
+		t.Errorf("unexpected run %+v", runs[1])
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	since := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		path  string
+		since time.Time
+		query string
+	}{
+		{`\Task`, time.Time{}, `*[EventData[Data[@Name='TaskName']='\Task']]`},
+		{`\O'Brien's task`, time.Time{}, `*[EventData[Data[@Name='TaskName']="\O'Brien's task"]]`},
+		{`\Task`, since, `*[EventData[Data[@Name='TaskName']='\Task'] and System[TimeCreated[@SystemTime>='2020-01-02T03:04:05Z']]]`},
+	}
+
+	for _, tt := range tests {
+		query, err := historyQuery(tt.path, tt.since)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.path, err)
+		} else if query != tt.query {
+			t.Errorf("%s: expected query %s, got %s", tt.path, tt.query, query)
+		}
+	}
+
+	if _, err := historyQuery(`\'"`, time.Time{}); err == nil {
+		t.Error("expected error for a path with both kinds of quotes")
+	}
+}
@@ -0,0 +1,326 @@
+package taskmaster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConflictPolicy decides what copying a task to another host does when a task
+// already exists at the destination path.
+type ConflictPolicy uint
+
+const (
+	ConflictFail      ConflictPolicy = iota // fail without changing the existing task
+	ConflictSkip                            // leave the existing task as it is
+	ConflictOverwrite                       // replace the existing task
+)
+
+func (c ConflictPolicy) String() string {
+	switch c {
+	case ConflictFail:
+		return "Fail"
+	case ConflictSkip:
+		return "Skip"
+	case ConflictOverwrite:
+		return "Overwrite"
+	default:
+		return ""
+	}
+}
+
+// MappingRule replaces From with To. From is compared case insensitively.
+type MappingRule struct {
+	From string
+	To   string
+}
+
+// mapPrefix replaces the prefix of s with the To of the first rule whose From
+// is a prefix of s.
+func mapPrefix(s string, rules []MappingRule) string {
+	for _, rule := range rules {
+		if len(s) >= len(rule.From) && strings.EqualFold(s[:len(rule.From)], rule.From) {
+			return rule.To + s[len(rule.From):]
+		}
+	}
+
+	return s
+}
+
+// mapDomain replaces the domain of an account in DOMAIN\user form with the To
+// of the first rule whose From is the domain.
+func mapDomain(account string, rules []MappingRule) string {
+	i := strings.IndexByte(account, '\\')
+	if i == -1 {
+		return account
+	}
+
+	for _, rule := range rules {
+		if strings.EqualFold(account[:i], rule.From) {
+			return rule.To + account[i:]
+		}
+	}
+
+	return account
+}
+
+// RewriteRules change the parts of a task definition that differ between
+// hosts. For every field, the first matching rule is applied.
+type RewriteRules struct {
+	// UserDomains replace the domains of the user and group IDs of the
+	// principal and of logon and session state change triggers. Only IDs in
+	// DOMAIN\user form are changed, and From is the whole domain.
+	UserDomains []MappingRule
+	// Authors replace the prefix of the author of the task.
+	Authors []MappingRule
+	// ExecPaths replace the prefixes of the paths and working directories of
+	// exec actions.
+	ExecPaths []MappingRule
+}
+
+// Rewrite returns a copy of def with the rules applied. def isn't changed.
+// The XMLText of the copy is cleared, as it isn't rewritten.
+func (r RewriteRules) Rewrite(def Definition) Definition {
+	def.XMLText = ""
+	def.RegistrationInfo.Author = mapPrefix(def.RegistrationInfo.Author, r.Authors)
+	def.Principal.UserID = mapDomain(def.Principal.UserID, r.UserDomains)
+	def.Principal.GroupID = mapDomain(def.Principal.GroupID, r.UserDomains)
+
+	if def.Actions != nil {
+		actions := make([]Action, len(def.Actions))
+		for i, action := range def.Actions {
+			if execAction, ok := action.(ExecAction); ok {
+				execAction.Path = mapPrefix(execAction.Path, r.ExecPaths)
+				execAction.WorkingDir = mapPrefix(execAction.WorkingDir, r.ExecPaths)
+				action = execAction
+			}
+			actions[i] = action
+		}
+		def.Actions = actions
+	}
+
+	if def.Triggers != nil {
+		triggers := make([]Trigger, len(def.Triggers))
+		for i, trigger := range def.Triggers {
+			switch t := trigger.(type) {
+			case LogonTrigger:
+				t.UserID = mapDomain(t.UserID, r.UserDomains)
+				trigger = t
+			case SessionStateChangeTrigger:
+				t.UserId = mapDomain(t.UserId, r.UserDomains)
+				trigger = t
+			}
+			triggers[i] = trigger
+		}
+		def.Triggers = triggers
+	}
+
+	return def
+}
+
+// CopyOptions changes how tasks are copied to another host.
+type CopyOptions struct {
+	// Rewrite changes the definitions of the tasks before they are
+	// registered on the destination host.
+	Rewrite RewriteRules
+	// DropNetworkSettings clears the network profile settings of the tasks,
+	// which identify network profiles of the source host.
+	DropNetworkSettings bool
+	// Conflict decides what happens when a task already exists at the
+	// destination path. The zero value fails.
+	Conflict ConflictPolicy
+	// Passwords are the passwords of the users that tasks with the
+	// TASK_LOGON_PASSWORD or TASK_LOGON_INTERACTIVE_TOKEN_OR_PASSWORD logon
+	// types run as, keyed by user ID after the rules of Rewrite are applied.
+	// With ConflictOverwrite, the passwords of the users existing tasks run
+	// as are needed too, to restore them if they can't be overwritten. User
+	// IDs are compared case insensitively.
+	Passwords map[string]string
+}
+
+// CopyResult is the result of copying one task to another host.
+type CopyResult struct {
+	SrcPath string
+	DstPath string
+	// Task is the task at the destination path: the copy, or the existing
+	// task if Skipped is true. It is unset if Err is set.
+	Task    RegisteredTask
+	Skipped bool  // a task already existed at the destination path and was left as it was
+	Err     error // the error copying the task, if any
+}
+
+// CopyResults are the results of copying the tasks of a folder to another
+// host.
+type CopyResults []CopyResult
+
+// Errors returns the errors of the tasks that couldn't be copied, keyed by
+// source path.
+func (r CopyResults) Errors() map[string]error {
+	errs := make(map[string]error)
+	for _, result := range r {
+		if result.Err != nil {
+			errs[result.SrcPath] = result.Err
+		}
+	}
+
+	return errs
+}
+
+// Err returns an error summarizing the tasks that couldn't be copied, or nil
+// if every task was copied or skipped.
+func (r CopyResults) Err() error {
+	errs := r.Errors()
+	if len(errs) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(errs))
+	for path := range errs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return fmt.Errorf("error copying %d of %d tasks: %s: %v", len(errs), len(r), paths[0], errs[paths[0]])
+}
+
+// Release frees the destination tasks of the results.
+func (r CopyResults) Release() {
+	for i := range r {
+		r[i].Task.Release()
+	}
+}
+
+// CopyTaskBetween copies the task at srcPath on the src host to dstPath on the
+// dst host, which may be connected to different Task Scheduler services. The
+// definition of the task is changed as described by opts, and the copy has
+// the enabled state of the source task. Security descriptors aren't copied,
+// as they may refer to accounts that only exist on the source host.
+//
+// The returned bool is false if a task already existed at dstPath and was
+// left as it was because opts.Conflict is ConflictSkip, in which case the
+// existing task is returned.
+func CopyTaskBetween(src, dst Scheduler, srcPath, dstPath string, opts CopyOptions) (RegisteredTask, bool, error) {
+	return CopyTaskBetweenContext(context.Background(), src, dst, srcPath, dstPath, opts)
+}
+
+// CopyTaskBetweenContext is like CopyTaskBetween, but ctx is passed to every
+// call to src and dst.
+func CopyTaskBetweenContext(ctx context.Context, src, dst Scheduler, srcPath, dstPath string, opts CopyOptions) (RegisteredTask, bool, error) {
+	if err := TaskPath(dstPath).Validate(); err != nil {
+		return RegisteredTask{}, false, err
+	}
+
+	task, err := src.GetRegisteredTaskContext(ctx, srcPath)
+	if err != nil {
+		return RegisteredTask{}, false, err
+	}
+	defer task.Release()
+
+	return copyTaskBetween(ctx, dst, task, dstPath, opts)
+}
+
+// CopyFolderBetween copies the tasks of the folder at srcPath on the src host
+// and its subfolders to the folder at dstPath on the dst host, like
+// CopyTaskBetween. Every task is attempted even if copying some of them
+// fails; the returned error is that of getting the source folder, and the
+// errors of the tasks are in the results. The results must be released.
+func CopyFolderBetween(src, dst Scheduler, srcPath, dstPath string, opts CopyOptions) (CopyResults, error) {
+	return CopyFolderBetweenContext(context.Background(), src, dst, srcPath, dstPath, opts)
+}
+
+// CopyFolderBetweenContext is like CopyFolderBetween, but ctx is passed to
+// every call to src and dst.
+func CopyFolderBetweenContext(ctx context.Context, src, dst Scheduler, srcPath, dstPath string, opts CopyOptions) (CopyResults, error) {
+	srcFolder, dstFolder := NormalizeTaskPath(srcPath), NormalizeTaskPath(dstPath)
+	if err := dstFolder.Validate(); err != nil {
+		return nil, err
+	}
+
+	folder, err := src.GetTaskFolderContext(ctx, srcFolder.String())
+	if err != nil {
+		return nil, err
+	}
+	defer folder.Release()
+
+	var results CopyResults
+	for _, task := range folder.Flatten() {
+		relPath := string(NormalizeTaskPath(task.Path)[len(srcFolder):])
+		result := CopyResult{
+			SrcPath: task.Path,
+			DstPath: dstFolder.Join(relPath).String(),
+		}
+
+		var copied bool
+		result.Task, copied, result.Err = copyTaskBetween(ctx, dst, task, result.DstPath, opts)
+		result.Skipped = !copied && result.Err == nil
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// copyTaskBetween registers the rewritten definition of task at dstPath on
+// dst.
+func copyTaskBetween(ctx context.Context, dst Scheduler, task RegisteredTask, dstPath string, opts CopyOptions) (RegisteredTask, bool, error) {
+	def := opts.Rewrite.Rewrite(task.Definition)
+	def.Settings.Enabled = task.Enabled
+	if opts.DropNetworkSettings {
+		def.Settings.NetworkSettings = NetworkSettings{}
+	}
+
+	var username, password string
+	if logonTypeNeedsPassword(def.Principal.LogonType) {
+		var ok bool
+		username = def.Principal.UserID
+		if password, ok = lookupPassword(opts.Passwords, username); !ok {
+			return RegisteredTask{}, false, fmt.Errorf("password of user %s is needed to register task %s", username, dstPath)
+		}
+	}
+
+	newTask, created, err := dst.CreateTaskExContext(ctx, dstPath, def, username, password, def.Principal.LogonType, false)
+	if err != nil {
+		return RegisteredTask{}, false, fmt.Errorf("error copying task %s to %s: %w", task.Path, dstPath, err)
+	}
+	if created || opts.Conflict == ConflictSkip {
+		return newTask, created, nil
+	}
+	if opts.Conflict == ConflictFail {
+		newTask.Release()
+		return RegisteredTask{}, false, fmt.Errorf("error copying task %s to %s: task already exists", task.Path, dstPath)
+	}
+
+	return overwriteTaskBetween(ctx, dst, task.Path, newTask, def, username, password, opts)
+}
+
+// overwriteTaskBetween replaces the existing task with def. Overwriting deletes
+// the existing task before registering def, so the existing task is saved
+// first and registered again if def can't be registered.
+func overwriteTaskBetween(ctx context.Context, dst Scheduler, srcPath string, existing RegisteredTask, def Definition, username, password string, opts CopyOptions) (RegisteredTask, bool, error) {
+	defer existing.Release()
+
+	var existingUsername, existingPassword string
+	if logonTypeNeedsPassword(existing.Definition.Principal.LogonType) {
+		var ok bool
+		existingUsername = existing.Definition.Principal.UserID
+		if existingPassword, ok = lookupPassword(opts.Passwords, existingUsername); !ok {
+			return RegisteredTask{}, false, fmt.Errorf("password of user %s is needed to restore task %s if overwriting it fails", existingUsername, existing.Path)
+		}
+	}
+	snapshot, err := snapshotTask(ctx, dst, existing, true)
+	if err != nil {
+		return RegisteredTask{}, false, err
+	}
+
+	newTask, _, err := dst.CreateTaskExContext(ctx, existing.Path, def, username, password, def.Principal.LogonType, true)
+	if err != nil {
+		err = fmt.Errorf("error copying task %s to %s: %w", srcPath, existing.Path, err)
+		// restore even if ctx is canceled
+		if restoreErr := recreateTask(context.Background(), dst, existing.Path, snapshot, existingUsername, existingPassword); restoreErr != nil {
+			return RegisteredTask{}, false, fmt.Errorf("%v; error restoring the existing task: %v", err, restoreErr)
+		}
+		return RegisteredTask{}, false, err
+	}
+
+	return newTask, true, nil
+}
@@ -0,0 +1,211 @@
+package taskmaster
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRewriteRules(t *testing.T) {
+	def := Definition{
+		Actions: []Action{
+			ExecAction{Path: `D:\Staging\bin\tool.exe`, WorkingDir: `d:\staging\data`},
+			ExecAction{Path: `C:\Windows\System32\cmd.exe`},
+			ComHandlerAction{ClassID: "{00000000-0000-0000-0000-000000000000}"},
+		},
+		Principal: Principal{UserID: `staging\svc`, GroupID: `STAGINGHOST\Admins`},
+		RegistrationInfo: RegistrationInfo{
+			Author: `STAGING\alice`,
+		},
+		Triggers: []Trigger{
+			LogonTrigger{UserID: `STAGING\bob`},
+			SessionStateChangeTrigger{UserId: `SYSTEM`},
+			BootTrigger{},
+		},
+		XMLText: "<Task />",
+	}
+	rules := RewriteRules{
+		UserDomains: []MappingRule{{From: "STAGING", To: "PROD"}},
+		Authors:     []MappingRule{{From: `staging\`, To: `PROD\`}},
+		ExecPaths: []MappingRule{
+			{From: `D:\Staging\bin`, To: `E:\Apps\bin`},
+			{From: `D:\Staging`, To: `E:\Apps`},
+		},
+	}
+
+	got := rules.Rewrite(def)
+	if got.Principal.UserID != `PROD\svc` {
+		t.Errorf("unexpected user ID %q", got.Principal.UserID)
+	}
+	if got.Principal.GroupID != `STAGINGHOST\Admins` {
+		t.Errorf("expected group of other domain to be unchanged, got %q", got.Principal.GroupID)
+	}
+	if got.RegistrationInfo.Author != `PROD\alice` {
+		t.Errorf("unexpected author %q", got.RegistrationInfo.Author)
+	}
+
+	exec := got.Actions[0].(ExecAction)
+	if exec.Path != `E:\Apps\bin\tool.exe` || exec.WorkingDir != `E:\Apps\data` {
+		t.Errorf("unexpected exec action %+v", exec)
+	}
+	if path := got.Actions[1].(ExecAction).Path; path != `C:\Windows\System32\cmd.exe` {
+		t.Errorf("expected unmatched path to be unchanged, got %q", path)
+	}
+	if !reflect.DeepEqual(got.Actions[2], def.Actions[2]) {
+		t.Errorf("expected COM handler action to be unchanged, got %+v", got.Actions[2])
+	}
+
+	if userID := got.Triggers[0].(LogonTrigger).UserID; userID != `PROD\bob` {
+		t.Errorf("unexpected logon trigger user ID %q", userID)
+	}
+	if userID := got.Triggers[1].(SessionStateChangeTrigger).UserId; userID != "SYSTEM" {
+		t.Errorf("expected user ID without domain to be unchanged, got %q", userID)
+	}
+	if got.XMLText != "" {
+		t.Errorf("expected stale XML to be cleared, got %q", got.XMLText)
+	}
+
+	// the original definition must not be changed
+	if def.Actions[0].(ExecAction).Path != `D:\Staging\bin\tool.exe` || def.Triggers[0].(LogonTrigger).UserID != `STAGING\bob` {
+		t.Error("Rewrite changed the original definition")
+	}
+}
+
+func TestCopyTaskBetween(t *testing.T) {
+	disabled := newFakeTask(`\Staging\Report`)
+	disabled.Enabled = false
+	disabled.Definition.Settings.NetworkSettings = NetworkSettings{ID: "{1}", Name: "Staging LAN"}
+	withPassword := newFakeTask(`\Staging\Sync`)
+	withPassword.Definition.Principal = Principal{UserID: `STAGING\svc`, LogonType: TASK_LOGON_PASSWORD}
+
+	src := newFakeScheduler(disabled, withPassword)
+	dst := newFakeScheduler()
+	opts := CopyOptions{
+		Rewrite:             RewriteRules{UserDomains: []MappingRule{{From: "STAGING", To: "PROD"}}},
+		DropNetworkSettings: true,
+		Passwords:           map[string]string{`prod\SVC`: "hunter2"},
+	}
+
+	task, copied, err := CopyTaskBetween(src, dst, disabled.Path, `\Prod\Report`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !copied {
+		t.Error("expected task to be copied")
+	}
+	if task.Enabled || dst.tasks[`\Prod\Report`].Enabled {
+		t.Error("expected copied task to stay disabled")
+	}
+	if ns := dst.tasks[`\Prod\Report`].Definition.Settings.NetworkSettings; ns != (NetworkSettings{}) {
+		t.Errorf("expected network settings to be dropped, got %+v", ns)
+	}
+	if ns := src.tasks[disabled.Path].Definition.Settings.NetworkSettings; ns.Name != "Staging LAN" {
+		t.Errorf("expected source task to be unchanged, got %+v", ns)
+	}
+
+	if _, _, err := CopyTaskBetween(src, dst, withPassword.Path, `\Prod\Sync`, CopyOptions{}); err == nil {
+		t.Error("expected error copying a password task without its password")
+	}
+	if _, _, err := CopyTaskBetween(src, dst, withPassword.Path, `\Prod\Sync`, opts); err != nil {
+		t.Fatal(err)
+	}
+	if userID := dst.tasks[`\Prod\Sync`].Definition.Principal.UserID; userID != `PROD\svc` {
+		t.Errorf("unexpected user ID %q", userID)
+	}
+	if password := dst.passwords[`\Prod\Sync`]; password != "hunter2" {
+		t.Errorf("expected task to be registered with password, got %q", password)
+	}
+
+	// conflicts
+	if _, _, err := CopyTaskBetween(src, dst, withPassword.Path, `\Prod\Report`, opts); err == nil {
+		t.Error("expected error copying over an existing task")
+	}
+	if dst.tasks[`\Prod\Report`].Definition.Principal.UserID != "" {
+		t.Error("expected existing task to be unchanged")
+	}
+
+	opts.Conflict = ConflictSkip
+	task, copied, err = CopyTaskBetween(src, dst, withPassword.Path, `\Prod\Report`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied || task.Path != `\Prod\Report` || task.Definition.Principal.UserID != "" {
+		t.Errorf("expected existing task to be skipped, got %v %+v", copied, task)
+	}
+
+	opts.Conflict = ConflictOverwrite
+	if _, copied, err = CopyTaskBetween(src, dst, withPassword.Path, `\Prod\Report`, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !copied || dst.tasks[`\Prod\Report`].Definition.Principal.UserID != `PROD\svc` {
+		t.Error("expected existing task to be overwritten")
+	}
+
+	// the existing task is restored if it can't be overwritten
+	dst.sds[`\Prod\Report`] = "D:(A;;FA;;;BA)"
+	dst.overwriteErr = errors.New("invalid definition")
+	if _, _, err := CopyTaskBetween(src, dst, disabled.Path, `\Prod\Report`, opts); err == nil {
+		t.Error("expected error overwriting with an invalid definition")
+	}
+	existing, ok := dst.tasks[`\Prod\Report`]
+	if !ok || existing.Definition.Principal.UserID != `PROD\svc` || dst.passwords[`\Prod\Report`] != "hunter2" || dst.sds[`\Prod\Report`] != "D:(A;;FA;;;BA)" {
+		t.Errorf("expected existing task to be restored, got %+v", existing)
+	}
+	dst.overwriteErr = nil
+
+	if _, _, err := CopyTaskBetween(src, dst, `\Missing`, `\Prod\Missing`, opts); err == nil {
+		t.Error("expected error copying a missing task")
+	}
+	if _, _, err := CopyTaskBetween(src, dst, disabled.Path, `\Prod\Bad|Name`, opts); err == nil {
+		t.Error("expected error copying to an invalid path")
+	}
+}
+
+func TestCopyFolderBetween(t *testing.T) {
+	src := newFakeScheduler(
+		newFakeTask(`\Staging\A`),
+		newFakeTask(`\Staging\Sub\B`),
+		newFakeTask(`\Staging\Sub\C`),
+		newFakeTask(`\Other\D`),
+	)
+	dst := newFakeScheduler(newFakeTask(`\Prod\A`))
+	dst.failPaths[`\Prod\Sub\C`] = errors.New("access denied")
+
+	results, err := CopyFolderBetween(src, dst, `\Staging`, `\Prod`, CopyOptions{Conflict: ConflictSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer results.Release()
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	want := []struct {
+		src, dst string
+		skipped  bool
+		failed   bool
+	}{
+		{`\Staging\A`, `\Prod\A`, true, false},
+		{`\Staging\Sub\B`, `\Prod\Sub\B`, false, false},
+		{`\Staging\Sub\C`, `\Prod\Sub\C`, false, true},
+	}
+	for i, w := range want {
+		result := results[i]
+		if result.SrcPath != w.src || result.DstPath != w.dst || result.Skipped != w.skipped || (result.Err != nil) != w.failed {
+			t.Errorf("unexpected result %d: %+v", i, result)
+		}
+	}
+	if err := results.Err(); err == nil {
+		t.Error("expected error of failed task")
+	}
+	if errs := results.Errors(); len(errs) != 1 || errs[`\Staging\Sub\C`] == nil {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if paths := dst.paths(); !reflect.DeepEqual(paths, []string{`\Prod\A`, `\Prod\Sub\B`}) {
+		t.Errorf("unexpected destination tasks %v", paths)
+	}
+
+	if _, err := CopyFolderBetween(src, dst, `\Staging`, `\Bad|Folder`, CopyOptions{}); err == nil {
+		t.Error("expected error copying to an invalid path")
+	}
+}
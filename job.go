@@ -0,0 +1,778 @@
+package taskmaster
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+	"unicode/utf16"
+
+	"github.com/rickb777/date/period"
+)
+
+const (
+	jobFixedSize       = 68
+	jobTriggerSize     = 48
+	jobFileVersion     = 1
+	jobProductVersion  = 0x0501
+	jobMaxRunTimeInfty = 0xFFFFFFFF
+)
+
+// JobFlags specifies the settings of a Task Scheduler 1.0 task.
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-tsch/0d6383e4-de92-43e7-b0bb-a60cfa36379f
+type JobFlags uint32
+
+const (
+	TASK_FLAG_INTERACTIVE                  JobFlags = 0x1    // the task can interact with the logged-on user
+	TASK_FLAG_DELETE_WHEN_DONE             JobFlags = 0x2    // the task is deleted when there are no more scheduled run times
+	TASK_FLAG_DISABLED                     JobFlags = 0x4    // the task is disabled
+	TASK_FLAG_START_ONLY_IF_IDLE           JobFlags = 0x10   // the task starts only if the computer is idle
+	TASK_FLAG_KILL_ON_IDLE_END             JobFlags = 0x20   // the task is stopped when the computer stops being idle
+	TASK_FLAG_DONT_START_IF_ON_BATTERIES   JobFlags = 0x40   // the task doesn't start if the computer is running on batteries
+	TASK_FLAG_KILL_IF_GOING_ON_BATTERIES   JobFlags = 0x80   // the task is stopped if the computer switches to batteries
+	TASK_FLAG_RUN_ONLY_IF_DOCKED           JobFlags = 0x100  // the task runs only if the computer is docked
+	TASK_FLAG_HIDDEN                       JobFlags = 0x200  // the task is hidden
+	TASK_FLAG_RUN_IF_CONNECTED_TO_INTERNET JobFlags = 0x400  // the task runs only if the computer is connected to the internet
+	TASK_FLAG_RESTART_ON_IDLE_RESUME       JobFlags = 0x800  // the task is restarted when the computer becomes idle again
+	TASK_FLAG_SYSTEM_REQUIRED              JobFlags = 0x1000 // the computer is woken to run the task
+	TASK_FLAG_RUN_ONLY_IF_LOGGED_ON        JobFlags = 0x2000 // the task runs only if the user is logged on
+)
+
+// JobPriority specifies the priority class of the process of a Task Scheduler
+// 1.0 task.
+type JobPriority uint32
+
+const (
+	NORMAL_PRIORITY_CLASS   JobPriority = 0x20
+	IDLE_PRIORITY_CLASS     JobPriority = 0x40
+	HIGH_PRIORITY_CLASS     JobPriority = 0x80
+	REALTIME_PRIORITY_CLASS JobPriority = 0x100
+)
+
+// JobTriggerType specifies the type of a Task Scheduler 1.0 trigger.
+// https://docs.microsoft.com/en-us/windows/desktop/api/mstask/ne-mstask-task_trigger_type
+type JobTriggerType uint32
+
+const (
+	TASK_TIME_TRIGGER_ONCE            JobTriggerType = iota // the trigger fires once at the start time
+	TASK_TIME_TRIGGER_DAILY                                 // the trigger fires every DaysInterval days
+	TASK_TIME_TRIGGER_WEEKLY                                // the trigger fires on DaysOfWeek every WeeksInterval weeks
+	TASK_TIME_TRIGGER_MONTHLYDATE                           // the trigger fires on DaysOfMonth of Months
+	TASK_TIME_TRIGGER_MONTHLYDOW                            // the trigger fires on DaysOfWeek of WhichWeek of Months
+	TASK_EVENT_TRIGGER_ON_IDLE                              // the trigger fires when the computer becomes idle
+	TASK_EVENT_TRIGGER_AT_SYSTEMSTART                       // the trigger fires when the computer starts
+	TASK_EVENT_TRIGGER_AT_LOGON                             // the trigger fires when any user logs on
+)
+
+func (t JobTriggerType) String() string {
+	switch t {
+	case TASK_TIME_TRIGGER_ONCE:
+		return "Once"
+	case TASK_TIME_TRIGGER_DAILY:
+		return "Daily"
+	case TASK_TIME_TRIGGER_WEEKLY:
+		return "Weekly"
+	case TASK_TIME_TRIGGER_MONTHLYDATE:
+		return "Monthly"
+	case TASK_TIME_TRIGGER_MONTHLYDOW:
+		return "Monthly Day of the Week"
+	case TASK_EVENT_TRIGGER_ON_IDLE:
+		return "Idle"
+	case TASK_EVENT_TRIGGER_AT_SYSTEMSTART:
+		return "Boot"
+	case TASK_EVENT_TRIGGER_AT_LOGON:
+		return "Logon"
+	default:
+		return ""
+	}
+}
+
+// JobTriggerFlags specifies the behavior of a Task Scheduler 1.0 trigger.
+type JobTriggerFlags uint32
+
+const (
+	TASK_TRIGGER_FLAG_HAS_END_DATE         JobTriggerFlags = 0x1 // the trigger has an end date
+	TASK_TRIGGER_FLAG_KILL_AT_DURATION_END JobTriggerFlags = 0x2 // the task is stopped at the end of the repetition duration
+	TASK_TRIGGER_FLAG_DISABLED             JobTriggerFlags = 0x4 // the trigger is disabled
+)
+
+// the week of the month of a TASK_TIME_TRIGGER_MONTHLYDOW trigger that is
+// the last week
+const jobLastWeek = 5
+
+// Job is a task in the .job file format of Task Scheduler 1.0, which is used by
+// Windows XP and Windows Server 2003 and stored in the C:\Windows\Tasks folder.
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-tsch/b8bea9df-8a5d-4d6c-a79c-f7cee2dbd0e7
+type Job struct {
+	ProductVersion       uint16 // the version of Windows that wrote the file
+	UUID                 [16]byte
+	ErrorRetryCount      uint16 // not used by the Task Scheduler service
+	ErrorRetryInterval   uint16 // not used by the Task Scheduler service
+	IdleDeadline         uint16 // the number of minutes to wait for the computer to become idle
+	IdleWait             uint16 // the number of minutes the computer must be idle before the task starts
+	Priority             JobPriority
+	MaxRunTime           uint32 // the number of milliseconds the task may run for, or 0xFFFFFFFF for no limit
+	ExitCode             uint32 // the exit code of the last run of the task
+	Status               TaskResult
+	Flags                JobFlags
+	LastRunTime          time.Time // the local time the task was last run at
+	RunningInstanceCount uint16
+	AppName              string
+	Parameters           string
+	WorkingDirectory     string
+	Author               string
+	Comment              string
+	UserData             []byte // data set by the application that created the task
+	ReservedData         []byte // data used by the Task Scheduler service
+	Triggers             []JobTrigger
+	Signature            []byte // the optional signature that follows the triggers
+}
+
+// JobTrigger is a trigger of a Task Scheduler 1.0 task. Dates and times are
+// local times and are represented in UTC, the same as task dates that have no
+// time zone.
+type JobTrigger struct {
+	Type            JobTriggerType
+	Flags           JobTriggerFlags
+	Start           time.Time  // the first day the trigger fires on and the time of day it fires at
+	End             time.Time  // the last day the trigger fires on. Only used if Flags has TASK_TRIGGER_FLAG_HAS_END_DATE
+	MinutesDuration uint32     // the number of minutes the task is repeated for
+	MinutesInterval uint32     // the number of minutes between repetitions of the task
+	DaysInterval    uint16     // the days between runs of a TASK_TIME_TRIGGER_DAILY trigger
+	WeeksInterval   uint16     // the weeks between runs of a TASK_TIME_TRIGGER_WEEKLY trigger
+	DaysOfWeek      DayOfWeek  // the days a TASK_TIME_TRIGGER_WEEKLY or TASK_TIME_TRIGGER_MONTHLYDOW trigger fires on
+	DaysOfMonth     DayOfMonth // the days a TASK_TIME_TRIGGER_MONTHLYDATE trigger fires on
+	Months          Month      // the months a TASK_TIME_TRIGGER_MONTHLYDATE or TASK_TIME_TRIGGER_MONTHLYDOW trigger fires in
+	WhichWeek       uint16     // the week of the month a TASK_TIME_TRIGGER_MONTHLYDOW trigger fires in, from 1 to 4, or 5 for the last week
+}
+
+// ReadJob reads a task in the .job file format.
+func ReadJob(r io.Reader) (Job, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return ParseJob(data)
+}
+
+// ParseJob parses a task in the .job file format.
+func ParseJob(data []byte) (job Job, err error) {
+	defer recoverTaskCacheError(&err)
+
+	if len(data) < jobFixedSize {
+		return Job{}, errors.New("invalid job file: file is too short")
+	}
+
+	r := &taskCacheReader{data: data}
+	job.ProductVersion = r.u16()
+	if fileVersion := r.u16(); fileVersion != jobFileVersion {
+		return Job{}, fmt.Errorf("invalid job file: unsupported file version %d", fileVersion)
+	}
+	copy(job.UUID[:], r.bytes(16))
+	appNameOffset := int(r.u16())
+	triggerOffset := int(r.u16())
+	job.ErrorRetryCount = r.u16()
+	job.ErrorRetryInterval = r.u16()
+	job.IdleDeadline = r.u16()
+	job.IdleWait = r.u16()
+	job.Priority = JobPriority(r.u32())
+	job.MaxRunTime = r.u32()
+	job.ExitCode = r.u32()
+	job.Status = TaskResult(r.u32())
+	job.Flags = JobFlags(r.u32())
+	job.LastRunTime = r.systemTime()
+
+	job.RunningInstanceCount = r.u16()
+	if appNameOffset < r.pos || appNameOffset > len(data) {
+		return Job{}, fmt.Errorf("invalid job file: invalid application name offset %#x", appNameOffset)
+	}
+	r.pos = appNameOffset
+	job.AppName = r.jobString()
+	job.Parameters = r.jobString()
+	job.WorkingDirectory = r.jobString()
+	job.Author = r.jobString()
+	job.Comment = r.jobString()
+	job.UserData = r.bytes(int(r.u16()))
+	job.ReservedData = r.bytes(int(r.u16()))
+
+	if triggerOffset < r.pos || triggerOffset > len(data) {
+		return Job{}, fmt.Errorf("invalid job file: invalid trigger offset %#x", triggerOffset)
+	}
+	r.pos = triggerOffset
+	numTriggers := int(r.u16())
+	for i := 0; i < numTriggers; i++ {
+		job.Triggers = append(job.Triggers, r.jobTrigger())
+	}
+	if !r.done() {
+		job.Signature = r.bytes(len(data) - r.pos)
+	}
+
+	return job, nil
+}
+
+// jobString reads a null terminated UTF-16 string prefixed with its length in
+// characters, including the null character.
+func (r *taskCacheReader) jobString() string {
+	n := int(r.u16())
+	s := decodeUTF16(r.bytes(2 * n))
+	if n > 0 && s[len(s)-1] == 0 {
+		s = s[:len(s)-1]
+	}
+
+	return s
+}
+
+// systemTime reads a SYSTEMTIME structure.
+func (r *taskCacheReader) systemTime() time.Time {
+	year := int(r.u16())
+	month := time.Month(r.u16())
+	// day of the week
+	r.u16()
+	day := int(r.u16())
+	hour := int(r.u16())
+	minute := int(r.u16())
+	second := int(r.u16())
+	milliseconds := int(r.u16())
+	if year == 0 {
+		return time.Time{}
+	}
+
+	return time.Date(year, month, day, hour, minute, second, milliseconds*int(time.Millisecond), time.UTC)
+}
+
+func (r *taskCacheReader) jobTrigger() JobTrigger {
+	offset := r.pos
+	if size := r.u16(); size != jobTriggerSize {
+		r.fail("invalid trigger size %d at offset %#x", size, offset)
+	}
+	// reserved
+	r.u16()
+
+	beginYear, beginMonth, beginDay := int(r.u16()), time.Month(r.u16()), int(r.u16())
+	endYear, endMonth, endDay := int(r.u16()), time.Month(r.u16()), int(r.u16())
+	startHour, startMinute := int(r.u16()), int(r.u16())
+
+	trigger := JobTrigger{
+		MinutesDuration: r.u32(),
+		MinutesInterval: r.u32(),
+		Flags:           JobTriggerFlags(r.u32()),
+		Type:            JobTriggerType(r.u32()),
+	}
+	if beginYear != 0 {
+		trigger.Start = time.Date(beginYear, beginMonth, beginDay, startHour, startMinute, 0, 0, time.UTC)
+	}
+	if endYear != 0 {
+		trigger.End = time.Date(endYear, endMonth, endDay, 0, 0, 0, 0, time.UTC)
+	}
+
+	specific0, specific1, specific2 := r.u16(), r.u16(), r.u16()
+	// padding and reserved
+	r.skip(6)
+
+	switch trigger.Type {
+	case TASK_TIME_TRIGGER_DAILY:
+		trigger.DaysInterval = specific0
+	case TASK_TIME_TRIGGER_WEEKLY:
+		trigger.WeeksInterval = specific0
+		trigger.DaysOfWeek = DayOfWeek(specific1)
+	case TASK_TIME_TRIGGER_MONTHLYDATE:
+		trigger.DaysOfMonth = DayOfMonth(uint32(specific1)<<16 | uint32(specific0))
+		trigger.Months = Month(specific2)
+	case TASK_TIME_TRIGGER_MONTHLYDOW:
+		trigger.WhichWeek = specific0
+		trigger.DaysOfWeek = DayOfWeek(specific1)
+		trigger.Months = Month(specific2)
+	}
+
+	return trigger
+}
+
+// WriteJob writes a task in the .job file format.
+func WriteJob(w io.Writer, job Job) error {
+	data, err := job.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+
+	return err
+}
+
+// MarshalBinary encodes the task in the .job file format.
+func (j Job) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	write := func(v interface{}) {
+		binary.Write(&buf, le, v)
+	}
+
+	productVersion := j.ProductVersion
+	if productVersion == 0 {
+		productVersion = jobProductVersion
+	}
+	for _, s := range []string{j.AppName, j.Parameters, j.WorkingDirectory, j.Author, j.Comment} {
+		if len(utf16.Encode([]rune(s))) >= 0xFFFF {
+			return nil, errors.New("string is too long to be stored in a job file")
+		}
+	}
+	if len(j.UserData) > 0xFFFF || len(j.ReservedData) > 0xFFFF || len(j.Triggers) > 0xFFFF {
+		return nil, errors.New("data is too long to be stored in a job file")
+	}
+
+	// the strings, user data and reserved data are written first to
+	// know the offset of the triggers
+	var varBuf bytes.Buffer
+	for _, s := range []string{j.AppName, j.Parameters, j.WorkingDirectory, j.Author, j.Comment} {
+		writeJobString(&varBuf, s)
+	}
+	binary.Write(&varBuf, le, uint16(len(j.UserData)))
+	varBuf.Write(j.UserData)
+	binary.Write(&varBuf, le, uint16(len(j.ReservedData)))
+	varBuf.Write(j.ReservedData)
+
+	appNameOffset := jobFixedSize + 2
+	triggerOffset := appNameOffset + varBuf.Len()
+	if triggerOffset > 0xFFFF {
+		return nil, errors.New("data is too long to be stored in a job file")
+	}
+
+	write(productVersion)
+	write(uint16(jobFileVersion))
+	buf.Write(j.UUID[:])
+	write(uint16(appNameOffset))
+	write(uint16(triggerOffset))
+	write(j.ErrorRetryCount)
+	write(j.ErrorRetryInterval)
+	write(j.IdleDeadline)
+	write(j.IdleWait)
+	write(uint32(j.Priority))
+	write(j.MaxRunTime)
+	write(j.ExitCode)
+	write(uint32(j.Status))
+	write(uint32(j.Flags))
+	writeSystemTime(&buf, j.LastRunTime)
+
+	write(j.RunningInstanceCount)
+	buf.Write(varBuf.Bytes())
+
+	write(uint16(len(j.Triggers)))
+	for _, t := range j.Triggers {
+		var specific [3]uint16
+		switch t.Type {
+		case TASK_TIME_TRIGGER_DAILY:
+			specific[0] = t.DaysInterval
+		case TASK_TIME_TRIGGER_WEEKLY:
+			specific[0] = t.WeeksInterval
+			specific[1] = uint16(t.DaysOfWeek)
+		case TASK_TIME_TRIGGER_MONTHLYDATE:
+			specific[0] = uint16(t.DaysOfMonth)
+			specific[1] = uint16(t.DaysOfMonth >> 16)
+			specific[2] = uint16(t.Months)
+		case TASK_TIME_TRIGGER_MONTHLYDOW:
+			specific[0] = t.WhichWeek
+			specific[1] = uint16(t.DaysOfWeek)
+			specific[2] = uint16(t.Months)
+		}
+
+		write(uint16(jobTriggerSize))
+		write(uint16(0))
+		writeJobDate(&buf, t.Start)
+		writeJobDate(&buf, t.End)
+		write([]uint16{uint16(t.Start.Hour()), uint16(t.Start.Minute())})
+		write(t.MinutesDuration)
+		write(t.MinutesInterval)
+		write(uint32(t.Flags))
+		write(uint32(t.Type))
+		write(specific[:])
+		write([]uint16{0, 0, 0})
+	}
+	buf.Write(j.Signature)
+
+	return buf.Bytes(), nil
+}
+
+func writeJobString(buf *bytes.Buffer, s string) {
+	if s == "" {
+		binary.Write(buf, binary.LittleEndian, uint16(0))
+		return
+	}
+
+	chars := append(utf16.Encode([]rune(s)), 0)
+	binary.Write(buf, binary.LittleEndian, uint16(len(chars)))
+	binary.Write(buf, binary.LittleEndian, chars)
+}
+
+func writeJobDate(buf *bytes.Buffer, t time.Time) {
+	if t.IsZero() {
+		buf.Write(make([]byte, 6))
+		return
+	}
+
+	binary.Write(buf, binary.LittleEndian, []uint16{uint16(t.Year()), uint16(t.Month()), uint16(t.Day())})
+}
+
+func writeSystemTime(buf *bytes.Buffer, t time.Time) {
+	if t.IsZero() {
+		buf.Write(make([]byte, 16))
+		return
+	}
+
+	binary.Write(buf, binary.LittleEndian, []uint16{
+		uint16(t.Year()), uint16(t.Month()), uint16(t.Weekday()), uint16(t.Day()),
+		uint16(t.Hour()), uint16(t.Minute()), uint16(t.Second()), uint16(t.Nanosecond() / int(time.Millisecond)),
+	})
+}
+
+// Definition converts the task to a task definition. The account a task runs
+// as is not stored in .job files, so the UserID of the principal is not set.
+func (j Job) Definition() (Definition, error) {
+	def := Definition{
+		Actions: []Action{ExecAction{
+			Path:       j.AppName,
+			Args:       j.Parameters,
+			WorkingDir: j.WorkingDirectory,
+		}},
+		RegistrationInfo: RegistrationInfo{
+			Author:      j.Author,
+			Description: j.Comment,
+		},
+		Principal: Principal{
+			LogonType: TASK_LOGON_PASSWORD,
+		},
+	}
+	if j.Flags&TASK_FLAG_RUN_ONLY_IF_LOGGED_ON != 0 {
+		def.Principal.LogonType = TASK_LOGON_INTERACTIVE_TOKEN
+	}
+
+	settings := TaskSettings{
+		AllowDemandStart:          true,
+		AllowHardTerminate:        true,
+		Compatibility:             TASK_COMPATIBILITY_V1,
+		DontStartOnBatteries:      j.Flags&TASK_FLAG_DONT_START_IF_ON_BATTERIES != 0,
+		Enabled:                   j.Flags&TASK_FLAG_DISABLED == 0,
+		Hidden:                    j.Flags&TASK_FLAG_HIDDEN != 0,
+		MultipleInstances:         TASK_INSTANCES_IGNORE_NEW,
+		Priority:                  jobPriorityToPriority(j.Priority),
+		RunOnlyIfIdle:             j.Flags&TASK_FLAG_START_ONLY_IF_IDLE != 0,
+		RunOnlyIfNetworkAvailable: j.Flags&TASK_FLAG_RUN_IF_CONNECTED_TO_INTERNET != 0,
+		StopIfGoingOnBatteries:    j.Flags&TASK_FLAG_KILL_IF_GOING_ON_BATTERIES != 0,
+		WakeToRun:                 j.Flags&TASK_FLAG_SYSTEM_REQUIRED != 0,
+		IdleSettings: IdleSettings{
+			IdleDuration:  period.NewHMS(0, int(j.IdleWait), 0),
+			RestartOnIdle: j.Flags&TASK_FLAG_RESTART_ON_IDLE_RESUME != 0,
+			StopOnIdleEnd: j.Flags&TASK_FLAG_KILL_ON_IDLE_END != 0,
+			WaitTimeout:   period.NewHMS(0, int(j.IdleDeadline), 0),
+		},
+	}
+	if j.Flags&TASK_FLAG_DELETE_WHEN_DONE != 0 {
+		settings.DeleteExpiredTaskAfter = "PT0S"
+	}
+	if j.MaxRunTime != jobMaxRunTimeInfty {
+		settings.TimeLimit = secondsToPeriod(j.MaxRunTime / 1000)
+	}
+	def.Settings = settings
+
+	for i, t := range j.Triggers {
+		trigger, err := t.trigger()
+		if err != nil {
+			return Definition{}, fmt.Errorf("error converting trigger %d: %v", i, err)
+		}
+		def.Triggers = append(def.Triggers, trigger)
+	}
+
+	return def, nil
+}
+
+func (t JobTrigger) trigger() (Trigger, error) {
+	taskTrigger := TaskTrigger{
+		Enabled: t.Flags&TASK_TRIGGER_FLAG_DISABLED == 0,
+		RepetitionPattern: RepetitionPattern{
+			RepetitionDuration: period.NewHMS(0, int(t.MinutesDuration), 0),
+			RepetitionInterval: period.NewHMS(0, int(t.MinutesInterval), 0),
+			StopAtDurationEnd:  t.Flags&TASK_TRIGGER_FLAG_KILL_AT_DURATION_END != 0,
+		},
+		StartBoundary: t.Start,
+	}
+	if t.Flags&TASK_TRIGGER_FLAG_HAS_END_DATE != 0 {
+		// the trigger fires on the end date as well
+		taskTrigger.EndBoundary = t.End.Add(24*time.Hour - time.Second)
+	}
+
+	switch t.Type {
+	case TASK_TIME_TRIGGER_ONCE:
+		return TimeTrigger{TaskTrigger: taskTrigger}, nil
+	case TASK_TIME_TRIGGER_DAILY:
+		return DailyTrigger{
+			TaskTrigger: taskTrigger,
+			DayInterval: DayInterval(t.DaysInterval),
+		}, nil
+	case TASK_TIME_TRIGGER_WEEKLY:
+		return WeeklyTrigger{
+			TaskTrigger:  taskTrigger,
+			DaysOfWeek:   t.DaysOfWeek,
+			WeekInterval: WeekInterval(t.WeeksInterval),
+		}, nil
+	case TASK_TIME_TRIGGER_MONTHLYDATE:
+		return MonthlyTrigger{
+			TaskTrigger:  taskTrigger,
+			DaysOfMonth:  t.DaysOfMonth,
+			MonthsOfYear: t.Months,
+		}, nil
+	case TASK_TIME_TRIGGER_MONTHLYDOW:
+		trigger := MonthlyDOWTrigger{
+			TaskTrigger:  taskTrigger,
+			DaysOfWeek:   t.DaysOfWeek,
+			MonthsOfYear: t.Months,
+		}
+		if t.WhichWeek == jobLastWeek {
+			trigger.RunOnLastWeekOfMonth = true
+		} else if t.WhichWeek >= 1 && t.WhichWeek < jobLastWeek {
+			trigger.WeeksOfMonth = 1 << (t.WhichWeek - 1)
+		} else {
+			return nil, fmt.Errorf("invalid week of the month %d", t.WhichWeek)
+		}
+
+		return trigger, nil
+	case TASK_EVENT_TRIGGER_ON_IDLE:
+		return IdleTrigger{TaskTrigger: taskTrigger}, nil
+	case TASK_EVENT_TRIGGER_AT_SYSTEMSTART:
+		return BootTrigger{TaskTrigger: taskTrigger}, nil
+	case TASK_EVENT_TRIGGER_AT_LOGON:
+		return LogonTrigger{TaskTrigger: taskTrigger}, nil
+	default:
+		return nil, fmt.Errorf("unknown trigger type %d", t.Type)
+	}
+}
+
+// JobFromDefinition converts a task definition to a task in the .job file
+// format. An error is returned if the definition uses features that Task
+// Scheduler 1.0 doesn't support, such as multiple actions, actions other than
+// exec actions, event triggers or principals with a group or highest run level.
+// Durations are truncated to whole minutes and start times to the minute.
+func JobFromDefinition(def Definition) (Job, error) {
+	if len(def.Actions) != 1 {
+		return Job{}, fmt.Errorf("V1 tasks must have exactly one action, definition has %d", len(def.Actions))
+	}
+	execAction, ok := def.Actions[0].(ExecAction)
+	if !ok {
+		return Job{}, fmt.Errorf("V1 tasks only support exec actions, not %s actions", def.Actions[0].GetType())
+	}
+	if def.Data != "" {
+		return Job{}, errors.New("V1 tasks don't support Data")
+	}
+
+	principal := def.Principal
+	if principal.GroupID != "" || principal.LogonType == TASK_LOGON_GROUP {
+		return Job{}, errors.New("V1 tasks can't run as a group")
+	}
+	if principal.LogonType == TASK_LOGON_S4U {
+		return Job{}, errors.New("V1 tasks don't support S4U logons")
+	}
+	if principal.RunLevel == TASK_RUNLEVEL_HIGHEST {
+		return Job{}, errors.New("V1 tasks don't support the highest run level")
+	}
+
+	settings := def.Settings
+	switch {
+	case !settings.AllowDemandStart:
+		return Job{}, errors.New("V1 tasks can always be started on demand")
+	case !settings.AllowHardTerminate:
+		return Job{}, errors.New("V1 tasks can always be terminated")
+	case settings.MultipleInstances != TASK_INSTANCES_IGNORE_NEW:
+		return Job{}, fmt.Errorf("V1 tasks don't support the %s multiple instances policy", settings.MultipleInstances)
+	case settings.RestartCount != 0 || !settings.RestartInterval.IsZero():
+		return Job{}, errors.New("V1 tasks can't be restarted on failure")
+	case settings.NetworkSettings != NetworkSettings{}:
+		return Job{}, errors.New("V1 tasks don't support network settings")
+	case settings.StartWhenAvailable:
+		return Job{}, errors.New("V1 tasks can't be started when available")
+	case settings.DeleteExpiredTaskAfter != "" && settings.DeleteExpiredTaskAfter != "PT0S":
+		return Job{}, errors.New("V1 tasks can only be deleted right after they expire")
+	}
+
+	job := Job{
+		IdleDeadline:     uint16(periodToMinutes(settings.WaitTimeout)),
+		IdleWait:         uint16(periodToMinutes(settings.IdleDuration)),
+		Priority:         priorityToJobPriority(settings.Priority),
+		MaxRunTime:       jobMaxRunTimeInfty,
+		Status:           SCHED_S_TASK_HAS_NOT_RUN,
+		AppName:          execAction.Path,
+		Parameters:       execAction.Args,
+		WorkingDirectory: execAction.WorkingDir,
+		Author:           def.RegistrationInfo.Author,
+		Comment:          def.RegistrationInfo.Description,
+	}
+	if _, err := rand.Read(job.UUID[:]); err != nil {
+		return Job{}, fmt.Errorf("error generating UUID: %v", err)
+	}
+	if !settings.TimeLimit.IsZero() {
+		job.MaxRunTime = uint32(settings.TimeLimit.DurationApprox() / time.Millisecond)
+	}
+
+	flags := []struct {
+		set  bool
+		flag JobFlags
+	}{
+		{settings.DeleteExpiredTaskAfter == "PT0S", TASK_FLAG_DELETE_WHEN_DONE},
+		{!settings.Enabled, TASK_FLAG_DISABLED},
+		{settings.RunOnlyIfIdle, TASK_FLAG_START_ONLY_IF_IDLE},
+		{settings.StopOnIdleEnd, TASK_FLAG_KILL_ON_IDLE_END},
+		{settings.DontStartOnBatteries, TASK_FLAG_DONT_START_IF_ON_BATTERIES},
+		{settings.StopIfGoingOnBatteries, TASK_FLAG_KILL_IF_GOING_ON_BATTERIES},
+		{settings.Hidden, TASK_FLAG_HIDDEN},
+		{settings.RunOnlyIfNetworkAvailable, TASK_FLAG_RUN_IF_CONNECTED_TO_INTERNET},
+		{settings.RestartOnIdle, TASK_FLAG_RESTART_ON_IDLE_RESUME},
+		{settings.WakeToRun, TASK_FLAG_SYSTEM_REQUIRED},
+		{principal.LogonType == TASK_LOGON_INTERACTIVE_TOKEN, TASK_FLAG_RUN_ONLY_IF_LOGGED_ON},
+	}
+	for _, f := range flags {
+		if f.set {
+			job.Flags |= f.flag
+		}
+	}
+
+	for i, t := range def.Triggers {
+		trigger, err := triggerToJobTrigger(t)
+		if err != nil {
+			return Job{}, fmt.Errorf("error converting trigger %d: %v", i, err)
+		}
+		job.Triggers = append(job.Triggers, trigger)
+	}
+
+	return job, nil
+}
+
+func triggerToJobTrigger(t Trigger) (JobTrigger, error) {
+	if !t.GetExecutionTimeLimit().IsZero() {
+		return JobTrigger{}, errors.New("V1 triggers don't support execution time limits")
+	}
+
+	trigger := JobTrigger{
+		Start:           t.GetStartBoundary().Truncate(time.Minute),
+		MinutesDuration: periodToMinutes(t.GetRepetitionDuration()),
+		MinutesInterval: periodToMinutes(t.GetRepetitionInterval()),
+	}
+	if end := t.GetEndBoundary(); !end.IsZero() {
+		trigger.End = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+		trigger.Flags |= TASK_TRIGGER_FLAG_HAS_END_DATE
+	}
+	if t.GetStopAtDurationEnd() {
+		trigger.Flags |= TASK_TRIGGER_FLAG_KILL_AT_DURATION_END
+	}
+	if !t.GetEnabled() {
+		trigger.Flags |= TASK_TRIGGER_FLAG_DISABLED
+	}
+
+	switch t := t.(type) {
+	case TimeTrigger:
+		if !t.RandomDelay.IsZero() {
+			return JobTrigger{}, errors.New("V1 triggers don't support random delays")
+		}
+		trigger.Type = TASK_TIME_TRIGGER_ONCE
+	case DailyTrigger:
+		if !t.RandomDelay.IsZero() {
+			return JobTrigger{}, errors.New("V1 triggers don't support random delays")
+		}
+		trigger.Type = TASK_TIME_TRIGGER_DAILY
+		trigger.DaysInterval = uint16(t.DayInterval)
+	case WeeklyTrigger:
+		if !t.RandomDelay.IsZero() {
+			return JobTrigger{}, errors.New("V1 triggers don't support random delays")
+		}
+		trigger.Type = TASK_TIME_TRIGGER_WEEKLY
+		trigger.WeeksInterval = uint16(t.WeekInterval)
+		trigger.DaysOfWeek = t.DaysOfWeek
+	case MonthlyTrigger:
+		if !t.RandomDelay.IsZero() {
+			return JobTrigger{}, errors.New("V1 triggers don't support random delays")
+		}
+		if t.RunOnLastWeekOfMonth || t.DaysOfMonth&LastDayOfMonth != 0 {
+			return JobTrigger{}, errors.New("V1 triggers can't run on the last day of the month")
+		}
+		trigger.Type = TASK_TIME_TRIGGER_MONTHLYDATE
+		trigger.DaysOfMonth = t.DaysOfMonth
+		trigger.Months = t.MonthsOfYear
+	case MonthlyDOWTrigger:
+		if !t.RandomDelay.IsZero() {
+			return JobTrigger{}, errors.New("V1 triggers don't support random delays")
+		}
+		trigger.Type = TASK_TIME_TRIGGER_MONTHLYDOW
+		trigger.DaysOfWeek = t.DaysOfWeek
+		trigger.Months = t.MonthsOfYear
+
+		weeks := t.WeeksOfMonth
+		if t.RunOnLastWeekOfMonth {
+			weeks |= LastWeek
+		}
+		switch weeks {
+		case First:
+			trigger.WhichWeek = 1
+		case Second:
+			trigger.WhichWeek = 2
+		case Third:
+			trigger.WhichWeek = 3
+		case Fourth:
+			trigger.WhichWeek = 4
+		case LastWeek:
+			trigger.WhichWeek = jobLastWeek
+		default:
+			return JobTrigger{}, errors.New("V1 triggers can only run on one week of the month")
+		}
+	case IdleTrigger:
+		trigger.Type = TASK_EVENT_TRIGGER_ON_IDLE
+	case BootTrigger:
+		if !t.Delay.IsZero() {
+			return JobTrigger{}, errors.New("V1 triggers don't support delays")
+		}
+		trigger.Type = TASK_EVENT_TRIGGER_AT_SYSTEMSTART
+	case LogonTrigger:
+		if !t.Delay.IsZero() {
+			return JobTrigger{}, errors.New("V1 triggers don't support delays")
+		}
+		if t.UserID != "" {
+			return JobTrigger{}, errors.New("V1 logon triggers can't be limited to a user")
+		}
+		trigger.Type = TASK_EVENT_TRIGGER_AT_LOGON
+	default:
+		return JobTrigger{}, fmt.Errorf("V1 tasks don't support %s triggers", t.GetType())
+	}
+
+	return trigger, nil
+}
+
+func periodToMinutes(p period.Period) uint32 {
+	return uint32(p.DurationApprox() / time.Minute)
+}
+
+// jobPriorityToPriority converts a priority class to the closest task priority.
+func jobPriorityToPriority(p JobPriority) uint {
+	switch p {
+	case REALTIME_PRIORITY_CLASS:
+		return 0
+	case HIGH_PRIORITY_CLASS:
+		return 1
+	case IDLE_PRIORITY_CLASS:
+		return 9
+	default:
+		return 4
+	}
+}
+
+// priorityToJobPriority converts a task priority to the closest priority class.
+func priorityToJobPriority(p uint) JobPriority {
+	switch {
+	case p == 0:
+		return REALTIME_PRIORITY_CLASS
+	case p == 1:
+		return HIGH_PRIORITY_CLASS
+	case p >= 9:
+		return IDLE_PRIORITY_CLASS
+	default:
+		return NORMAL_PRIORITY_CLASS
+	}
+}
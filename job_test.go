@@ -0,0 +1,214 @@
+package taskmaster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickb777/date/period"
+)
+
+func TestJobRoundTrip(t *testing.T) {
+	job := Job{
+		ProductVersion:   0x0600,
+		UUID:             [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		IdleDeadline:     60,
+		IdleWait:         10,
+		Priority:         NORMAL_PRIORITY_CLASS,
+		MaxRunTime:       72 * 60 * 60 * 1000,
+		Status:           SCHED_S_TASK_HAS_NOT_RUN,
+		Flags:            TASK_FLAG_DELETE_WHEN_DONE | TASK_FLAG_RUN_ONLY_IF_LOGGED_ON,
+		LastRunTime:      time.Date(2006, time.March, 4, 5, 6, 7, 8*int(time.Millisecond), time.UTC),
+		AppName:          `C:\Windows\notepad.exe`,
+		Parameters:       "log.txt",
+		WorkingDirectory: `C:\`,
+		Author:           `CORP\admin`,
+		UserData:         []byte{0xAA, 0xBB},
+		ReservedData:     []byte{0, 0, 0, 0, 0, 0, 0, 0},
+		Triggers: []JobTrigger{
+			{
+				Type:         TASK_TIME_TRIGGER_DAILY,
+				Flags:        TASK_TRIGGER_FLAG_HAS_END_DATE,
+				Start:        time.Date(2006, time.March, 1, 8, 30, 0, 0, time.UTC),
+				End:          time.Date(2007, time.March, 1, 0, 0, 0, 0, time.UTC),
+				DaysInterval: 2,
+			},
+			{
+				Type:        TASK_TIME_TRIGGER_MONTHLYDATE,
+				Start:       time.Date(2006, time.March, 1, 0, 0, 0, 0, time.UTC),
+				DaysOfMonth: One | Seventeen | ThirtyOne,
+				Months:      January | December,
+			},
+			{
+				Type:       TASK_TIME_TRIGGER_MONTHLYDOW,
+				Start:      time.Date(2006, time.March, 1, 0, 0, 0, 0, time.UTC),
+				WhichWeek:  jobLastWeek,
+				DaysOfWeek: Friday,
+				Months:     June,
+			},
+		},
+		Signature: bytes.Repeat([]byte{1}, 68),
+	}
+
+	data, err := job.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset := binary.LittleEndian.Uint16(data[20:]); offset != 0x46 {
+		t.Errorf("expected application name offset 0x46, got %#x", offset)
+	}
+	if size := len(data) - len(job.Signature) - int(binary.LittleEndian.Uint16(data[22:])); size != 2+3*jobTriggerSize {
+		t.Errorf("expected %d bytes of triggers, got %d", 2+3*jobTriggerSize, size)
+	}
+
+	parsed, err := ParseJob(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(job, parsed) {
+		t.Errorf("expected %+v, got %+v", job, parsed)
+	}
+}
+
+func TestParseJobErrors(t *testing.T) {
+	data, err := Job{Triggers: []JobTrigger{{Type: TASK_EVENT_TRIGGER_AT_LOGON}}}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short", data[:jobFixedSize-1]},
+		{"truncated trigger", data[:len(data)-1]},
+		{"file version", append(append([]byte{}, data[:2]...), append([]byte{2, 0}, data[4:]...)...)},
+	}
+	for _, test := range tests {
+		if _, err := ParseJob(test.data); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func TestJobDefinition(t *testing.T) {
+	job := Job{
+		IdleDeadline: 60,
+		IdleWait:     10,
+		Priority:     IDLE_PRIORITY_CLASS,
+		MaxRunTime:   jobMaxRunTimeInfty,
+		Flags:        TASK_FLAG_DISABLED | TASK_FLAG_KILL_ON_IDLE_END | TASK_FLAG_SYSTEM_REQUIRED,
+		AppName:      "backup.exe",
+		Parameters:   "/full",
+		Comment:      "Nightly backup",
+		Triggers: []JobTrigger{
+			{
+				Type:            TASK_TIME_TRIGGER_WEEKLY,
+				Flags:           TASK_TRIGGER_FLAG_HAS_END_DATE | TASK_TRIGGER_FLAG_KILL_AT_DURATION_END,
+				Start:           time.Date(2006, time.March, 1, 23, 0, 0, 0, time.UTC),
+				End:             time.Date(2006, time.April, 1, 0, 0, 0, 0, time.UTC),
+				MinutesDuration: 120,
+				MinutesInterval: 30,
+				WeeksInterval:   1,
+				DaysOfWeek:      Monday | Friday,
+			},
+			{
+				Type:      TASK_TIME_TRIGGER_MONTHLYDOW,
+				Flags:     TASK_TRIGGER_FLAG_DISABLED,
+				Start:     time.Date(2006, time.March, 1, 0, 0, 0, 0, time.UTC),
+				WhichWeek: 2,
+			},
+			{Type: TASK_EVENT_TRIGGER_AT_SYSTEMSTART},
+		},
+	}
+
+	def, err := job.Definition()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if action := def.Actions[0].(ExecAction); action.Path != "backup.exe" || action.Args != "/full" {
+		t.Errorf("unexpected action %+v", action)
+	}
+	if def.RegistrationInfo.Description != "Nightly backup" {
+		t.Errorf("unexpected description %q", def.RegistrationInfo.Description)
+	}
+	if def.Principal.LogonType != TASK_LOGON_PASSWORD {
+		t.Errorf("expected logon type %s, got %s", TASK_LOGON_PASSWORD, def.Principal.LogonType)
+	}
+	settings := def.Settings
+	if settings.Enabled || !settings.StopOnIdleEnd || !settings.WakeToRun || settings.Priority != 9 ||
+		!settings.TimeLimit.IsZero() || settings.WaitTimeout != period.NewHMS(0, 60, 0) {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+
+	weekly := def.Triggers[0].(WeeklyTrigger)
+	if weekly.DaysOfWeek != Monday|Friday || weekly.WeekInterval != 1 || !weekly.StopAtDurationEnd ||
+		weekly.RepetitionInterval != period.NewHMS(0, 30, 0) ||
+		!weekly.EndBoundary.Equal(time.Date(2006, time.April, 1, 23, 59, 59, 0, time.UTC)) {
+		t.Errorf("unexpected weekly trigger %+v", weekly)
+	}
+	if monthly := def.Triggers[1].(MonthlyDOWTrigger); monthly.Enabled || monthly.WeeksOfMonth != Second {
+		t.Errorf("unexpected monthly trigger %+v", monthly)
+	}
+	if _, ok := def.Triggers[2].(BootTrigger); !ok {
+		t.Errorf("expected boot trigger, got %T", def.Triggers[2])
+	}
+
+	// converting the definition back results in the same task
+	converted, err := JobFromDefinition(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted.UUID = job.UUID
+	converted.Status = job.Status
+	if !reflect.DeepEqual(job, converted) {
+		t.Errorf("expected %+v, got %+v", job, converted)
+	}
+}
+
+func TestJobFromDefinitionErrors(t *testing.T) {
+	newDef := func() Definition {
+		return Definition{
+			Actions: []Action{ExecAction{Path: "a.exe"}},
+			Settings: TaskSettings{
+				AllowDemandStart:   true,
+				AllowHardTerminate: true,
+				MultipleInstances:  TASK_INSTANCES_IGNORE_NEW,
+			},
+		}
+	}
+
+	if _, err := JobFromDefinition(newDef()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Definition)
+		errMsg string
+	}{
+		{"multiple actions", func(d *Definition) { d.Actions = append(d.Actions, ExecAction{}) }, "exactly one action"},
+		{"COM action", func(d *Definition) { d.Actions = []Action{ComHandlerAction{}} }, "only support exec actions"},
+		{"group", func(d *Definition) { d.Principal.GroupID = "Users" }, "group"},
+		{"run level", func(d *Definition) { d.Principal.RunLevel = TASK_RUNLEVEL_HIGHEST }, "run level"},
+		{"instances", func(d *Definition) { d.Settings.MultipleInstances = TASK_INSTANCES_PARALLEL }, "multiple instances"},
+		{"restart", func(d *Definition) { d.Settings.RestartCount = 3 }, "restarted"},
+		{"event trigger", func(d *Definition) { d.Triggers = []Trigger{EventTrigger{}} }, "trigger 0"},
+		{"logon user", func(d *Definition) { d.Triggers = []Trigger{LogonTrigger{UserID: "bob"}} }, "limited to a user"},
+		{"boot delay", func(d *Definition) { d.Triggers = []Trigger{BootTrigger{Delay: period.NewHMS(0, 1, 0)}} }, "delays"},
+		{"weeks", func(d *Definition) { d.Triggers = []Trigger{MonthlyDOWTrigger{WeeksOfMonth: First | Third}} }, "one week"},
+		{"last day", func(d *Definition) { d.Triggers = []Trigger{MonthlyTrigger{RunOnLastWeekOfMonth: true}} }, "last day"},
+	}
+	for _, test := range tests {
+		def := newDef()
+		test.modify(&def)
+		_, err := JobFromDefinition(def)
+		if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.errMsg, err)
+		}
+	}
+}
@@ -3,10 +3,12 @@
 package taskmaster
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/user"
+	"runtime"
 	"strings"
 	"time"
 
@@ -18,36 +20,57 @@ import (
 // S_FALSE is returned by CoInitialize if it was already called on this thread.
 const S_FALSE = 0x00000001
 
-func (t *TaskService) initialize() error {
-	var err error
+// These select the parts of a security descriptor.
+const (
+	ownerSecurityInformation = 0x1
+	groupSecurityInformation = 0x2
+	daclSecurityInformation  = 0x4
+	saclSecurityInformation  = 0x8
+)
+
+// comInvoker locks the goroutine of a dispatcher to its OS thread and
+// initializes COM on that thread, so that every COM call of a TaskService
+// is made from the thread that called CoInitialize.
+type comInvoker struct{}
 
-	err = ole.CoInitialize(0)
+func (comInvoker) setup() error {
+	runtime.LockOSThread()
+
+	err := ole.CoInitialize(0)
 	if err != nil {
 		code := err.(*ole.OleError).Code()
 		if code != ole.S_OK && code != S_FALSE {
+			runtime.UnlockOSThread()
 			return err
 		}
 	}
 
+	return nil
+}
+
+func (comInvoker) teardown() {
+	ole.CoUninitialize()
+	runtime.UnlockOSThread()
+}
+
+func (t *TaskService) initialize() error {
+	var err error
+
 	schedClassID, err := ole.ClassIDFrom("Schedule.Service.1")
 	if err != nil {
-		ole.CoUninitialize()
 		return getTaskSchedulerError(err)
 	}
 	taskSchedulerObj, err := ole.CreateInstance(schedClassID, nil)
 	if err != nil {
-		ole.CoUninitialize()
 		return getTaskSchedulerError(err)
 	}
 	if taskSchedulerObj == nil {
-		ole.CoUninitialize()
 		return errors.New("Could not create ITaskService object")
 	}
 	defer taskSchedulerObj.Release()
 
 	tskSchdlr := taskSchedulerObj.MustQueryInterface(ole.IID_IDispatch)
 	t.taskServiceObj = tskSchdlr
-	t.isInitialized = true
 
 	return nil
 }
@@ -56,7 +79,13 @@ func (t *TaskService) initialize() error {
 // token for authentication. This function must run before any other functions
 // in taskmaster can be used.
 func Connect() (TaskService, error) {
-	return ConnectWithOptions("", "", "", "")
+	return ConnectWithOptionsContext(context.Background(), "", "", "", "")
+}
+
+// ConnectContext is like Connect, but the connection attempt is abandoned if
+// ctx is canceled or its deadline expires before it completes.
+func ConnectContext(ctx context.Context) (TaskService, error) {
+	return ConnectWithOptionsContext(ctx, "", "", "", "")
 }
 
 // ConnectWithOptions connects to a local or remote Task Scheduler service. This
@@ -64,26 +93,70 @@ func Connect() (TaskService, error) {
 // serverName parameter is empty, a connection to the local Task Scheduler service
 // will be attempted. If the user and password parameters are empty, the current
 // token will be used for authentication.
+//
+// All COM calls of the returned TaskService are made from a single, dedicated
+// OS thread, so the TaskService and the tasks it returns are safe for concurrent
+// use by multiple goroutines. Calls are executed one at a time, in the order
+// they were made.
+//
+// The Context variants of the methods of TaskService check ctx between COM
+// calls, but don't interrupt a COM call that is already running: they return
+// once it completes, even if ctx is done by then. A call to an unreachable
+// remote computer may block until Windows gives up on it, and other calls of
+// the TaskService wait behind it.
 func ConnectWithOptions(serverName, domain, username, password string) (TaskService, error) {
+	return ConnectWithOptionsContext(context.Background(), serverName, domain, username, password)
+}
+
+// ConnectWithOptionsContext is like ConnectWithOptions, but the connection attempt
+// is abandoned if ctx is canceled or its deadline expires before it completes.
+// Connecting to an unreachable remote computer can block for a long time, so
+// callers should set a deadline on ctx when connecting to remote computers.
+func ConnectWithOptionsContext(ctx context.Context, serverName, domain, username, password string) (TaskService, error) {
+	if err := ctx.Err(); err != nil {
+		return TaskService{}, err
+	}
+
+	d, err := newDispatcher(comInvoker{})
+	if err != nil {
+		return TaskService{}, fmt.Errorf("error initializing COM: %v", err)
+	}
+
+	taskService := &TaskService{dispatcher: d}
+	select {
+	case err = <-d.start(ctx, func() error {
+		return taskService.connect(serverName, domain, username, password)
+	}):
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		// a connection attempt that is still running will be freed once
+		// it finishes, as calls are executed in order
+		go taskService.Disconnect()
+		return TaskService{}, err
+	}
+
+	return *taskService, nil
+}
+
+func (t *TaskService) connect(serverName, domain, username, password string) error {
 	var err error
-	var taskService TaskService
 
-	if !taskService.isInitialized {
-		err = taskService.initialize()
-		if err != nil {
-			return TaskService{}, fmt.Errorf("error initializing ITaskService object: %v", err)
-		}
+	err = t.initialize()
+	if err != nil {
+		return fmt.Errorf("error initializing ITaskService object: %v", err)
 	}
 
-	_, err = oleutil.CallMethod(taskService.taskServiceObj, "Connect", serverName, username, domain, password)
+	_, err = oleutil.CallMethod(t.taskServiceObj, "Connect", serverName, username, domain, password)
 	if err != nil {
-		return TaskService{}, fmt.Errorf("error connecting to Task Scheduler service: %v", getTaskSchedulerError(err))
+		return fmt.Errorf("error connecting to Task Scheduler service: %w", getTaskSchedulerError(err))
 	}
 
 	if serverName == "" {
 		serverName, err = os.Hostname()
 		if err != nil {
-			return TaskService{}, err
+			return err
 		}
 	}
 	if domain == "" {
@@ -92,54 +165,92 @@ func ConnectWithOptions(serverName, domain, username, password string) (TaskServ
 	if username == "" {
 		currentUser, err := user.Current()
 		if err != nil {
-			return TaskService{}, err
+			return err
 		}
 		username = strings.Split(currentUser.Username, `\`)[1]
 	}
-	taskService.connectedDomain = domain
-	taskService.connectedComputerName = serverName
-	taskService.connectedUser = username
+	t.connectedDomain = domain
+	t.connectedComputerName = serverName
+	t.connectedUser = username
+
+	res, err := oleutil.CallMethod(t.taskServiceObj, "GetFolder", `\`)
+	if err != nil {
+		return fmt.Errorf("error getting the root folder: %w", getTaskSchedulerError(err))
+	}
+	t.rootFolderObj = res.ToIDispatch()
+	t.isConnected = true
+
+	return nil
+}
 
-	res, err := oleutil.CallMethod(taskService.taskServiceObj, "GetFolder", `\`)
+// dialTaskService connects to the Task Scheduler service of a fleet host.
+func dialTaskService(ctx context.Context, host Host) (Scheduler, error) {
+	taskService, err := ConnectWithOptionsContext(ctx, host.serverName(), host.Domain, host.Username, host.Password)
 	if err != nil {
-		return TaskService{}, fmt.Errorf("error getting the root folder: %v", getTaskSchedulerError(err))
+		return nil, err
 	}
-	taskService.rootFolderObj = res.ToIDispatch()
-	taskService.isConnected = true
 
-	return taskService, nil
+	return &taskService, nil
 }
 
 // Disconnect frees all the Task Scheduler COM objects that have been created.
 // If this function is not called before the parent program terminates,
-// memory leaks will occur.
+// memory leaks will occur. Registered and running tasks that were returned
+// by the TaskService must be released before Disconnect is called.
 func (t *TaskService) Disconnect() {
-	if t.isConnected {
-		t.taskServiceObj.Release()
-		t.rootFolderObj.Release()
-	}
-	if t.isInitialized {
-		ole.CoUninitialize()
-	}
+	t.dispatcher.run(context.Background(), func() error {
+		if t.rootFolderObj != nil {
+			t.rootFolderObj.Release()
+		}
+		if t.taskServiceObj != nil {
+			t.taskServiceObj.Release()
+		}
 
-	t.isInitialized = false
-	t.isConnected = false
+		return nil
+	})
+	t.dispatcher.stop()
 }
 
 // GetRunningTasks enumerates the Task Scheduler database for all currently running tasks.
 func (t *TaskService) GetRunningTasks() (RunningTaskCollection, error) {
+	return t.GetRunningTasksContext(context.Background())
+}
+
+// GetRunningTasksContext is like GetRunningTasks, but stops enumerating running
+// tasks and returns the error of ctx if ctx is canceled.
+func (t *TaskService) GetRunningTasksContext(ctx context.Context) (RunningTaskCollection, error) {
+	var runningTasks RunningTaskCollection
+
+	err := t.dispatcher.run(ctx, func() error {
+		var err error
+		runningTasks, err = t.getRunningTasks(ctx)
+		return err
+	})
+	if err != nil {
+		runningTasks.Release()
+		return nil, err
+	}
+
+	return runningTasks, nil
+}
+
+func (t *TaskService) getRunningTasks(ctx context.Context) (RunningTaskCollection, error) {
 	var runningTasks RunningTaskCollection
 
 	res, err := oleutil.CallMethod(t.taskServiceObj, "GetRunningTasks", TASK_ENUM_HIDDEN)
 	if err != nil {
-		return nil, fmt.Errorf("error getting running tasks: %v", getTaskSchedulerError(err))
+		return nil, fmt.Errorf("error getting running tasks: %w", getTaskSchedulerError(err))
 	}
 	runningTasksObj := res.ToIDispatch()
 	defer runningTasksObj.Release()
 	err = oleutil.ForEach(runningTasksObj, func(v *ole.VARIANT) error {
 		task := v.ToIDispatch()
+		if err := ctx.Err(); err != nil {
+			task.Release()
+			return err
+		}
 
-		runningTask, err := parseRunningTask(task)
+		runningTask, err := parseRunningTask(task, t.dispatcher)
 		if err != nil {
 			return fmt.Errorf("error parsing running task: %v", err)
 		}
@@ -148,7 +259,7 @@ func (t *TaskService) GetRunningTasks() (RunningTaskCollection, error) {
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return runningTasks, err
 	}
 
 	return runningTasks, nil
@@ -156,6 +267,29 @@ func (t *TaskService) GetRunningTasks() (RunningTaskCollection, error) {
 
 // GetRegisteredTasks enumerates the Task Scheduler database for all currently registered tasks.
 func (t *TaskService) GetRegisteredTasks() (RegisteredTaskCollection, error) {
+	return t.GetRegisteredTasksContext(context.Background())
+}
+
+// GetRegisteredTasksContext is like GetRegisteredTasks, but ctx is checked before
+// every folder and task is enumerated. If ctx is canceled, the tasks enumerated
+// so far are released and the error of ctx is returned.
+func (t *TaskService) GetRegisteredTasksContext(ctx context.Context) (RegisteredTaskCollection, error) {
+	var registeredTasks RegisteredTaskCollection
+
+	err := t.dispatcher.run(ctx, func() error {
+		var err error
+		registeredTasks, err = t.getRegisteredTasks(ctx)
+		return err
+	})
+	if err != nil {
+		registeredTasks.Release()
+		return nil, err
+	}
+
+	return registeredTasks, nil
+}
+
+func (t *TaskService) getRegisteredTasks(ctx context.Context) (RegisteredTaskCollection, error) {
 	var (
 		err             error
 		registeredTasks RegisteredTaskCollection
@@ -164,14 +298,18 @@ func (t *TaskService) GetRegisteredTasks() (RegisteredTaskCollection, error) {
 	// get tasks from root folder
 	res, err := oleutil.CallMethod(t.rootFolderObj, "GetTasks", int(TASK_ENUM_HIDDEN))
 	if err != nil {
-		return nil, fmt.Errorf("error getting tasks of root folder: %v", getTaskSchedulerError(err))
+		return nil, fmt.Errorf("error getting tasks of root folder: %w", getTaskSchedulerError(err))
 	}
 	rootTaskCollection := res.ToIDispatch()
 	defer rootTaskCollection.Release()
 	err = oleutil.ForEach(rootTaskCollection, func(v *ole.VARIANT) error {
 		task := v.ToIDispatch()
+		if err := ctx.Err(); err != nil {
+			task.Release()
+			return err
+		}
 
-		registeredTask, path, err := parseRegisteredTask(task)
+		registeredTask, path, err := parseRegisteredTask(task, t.dispatcher)
 		if err != nil {
 			return fmt.Errorf("error parsing registered task %s: %v", path, err)
 		}
@@ -180,12 +318,12 @@ func (t *TaskService) GetRegisteredTasks() (RegisteredTaskCollection, error) {
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return registeredTasks, err
 	}
 
 	res, err = oleutil.CallMethod(t.rootFolderObj, "GetFolders", 0)
 	if err != nil {
-		return nil, fmt.Errorf("error getting task folders of root folder: %v", getTaskSchedulerError(err))
+		return registeredTasks, fmt.Errorf("error getting task folders of root folder: %w", getTaskSchedulerError(err))
 	}
 	taskFolderList := res.ToIDispatch()
 	defer taskFolderList.Release()
@@ -196,17 +334,25 @@ func (t *TaskService) GetRegisteredTasks() (RegisteredTaskCollection, error) {
 		taskFolder := v.ToIDispatch()
 		defer taskFolder.Release()
 
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		res, err := oleutil.CallMethod(taskFolder, "GetTasks", int(TASK_ENUM_HIDDEN))
 		if err != nil {
-			return fmt.Errorf("error getting tasks of folder: %v", getTaskSchedulerError(err))
+			return fmt.Errorf("error getting tasks of folder: %w", getTaskSchedulerError(err))
 		}
 		taskCollection := res.ToIDispatch()
 		defer taskCollection.Release()
 
 		err = oleutil.ForEach(taskCollection, func(v *ole.VARIANT) error {
 			task := v.ToIDispatch()
+			if err := ctx.Err(); err != nil {
+				task.Release()
+				return err
+			}
 
-			registeredTask, path, err := parseRegisteredTask(task)
+			registeredTask, path, err := parseRegisteredTask(task, t.dispatcher)
 			if err != nil {
 				return fmt.Errorf("error parsing registered task %s: %v", path, err)
 			}
@@ -220,7 +366,7 @@ func (t *TaskService) GetRegisteredTasks() (RegisteredTaskCollection, error) {
 
 		res, err = oleutil.CallMethod(taskFolder, "GetFolders", 0)
 		if err != nil {
-			return fmt.Errorf("error getting subfolders of folder: %v", getTaskSchedulerError(err))
+			return fmt.Errorf("error getting subfolders of folder: %w", getTaskSchedulerError(err))
 		}
 		taskFolderList := res.ToIDispatch()
 		defer taskFolderList.Release()
@@ -235,7 +381,7 @@ func (t *TaskService) GetRegisteredTasks() (RegisteredTaskCollection, error) {
 
 	err = oleutil.ForEach(taskFolderList, enumTaskFolders)
 	if err != nil {
-		return nil, err
+		return registeredTasks, err
 	}
 
 	return registeredTasks, nil
@@ -245,16 +391,36 @@ func (t *TaskService) GetRegisteredTasks() (RegisteredTaskCollection, error) {
 // pointer to it if it exists. If it doesn't exist, nil will be returned in place of
 // the registered task.
 func (t *TaskService) GetRegisteredTask(path string) (RegisteredTask, error) {
+	return t.GetRegisteredTaskContext(context.Background(), path)
+}
+
+// GetRegisteredTaskContext is like GetRegisteredTask, but returns the error of
+// ctx without getting the registered task if ctx is already canceled.
+func (t *TaskService) GetRegisteredTaskContext(ctx context.Context, path string) (RegisteredTask, error) {
 	if path[0] != '\\' {
 		return RegisteredTask{}, ErrInvalidPath
 	}
 
+	var task RegisteredTask
+	err := t.dispatcher.run(ctx, func() error {
+		var err error
+		task, err = t.getRegisteredTask(path)
+		return err
+	})
+	if err != nil {
+		return RegisteredTask{}, err
+	}
+
+	return task, nil
+}
+
+func (t *TaskService) getRegisteredTask(path string) (RegisteredTask, error) {
 	taskObj, err := oleutil.CallMethod(t.rootFolderObj, "GetTask", path)
 	if err != nil {
-		return RegisteredTask{}, fmt.Errorf("error getting registered task %s: %v", path, getTaskSchedulerError(err))
+		return RegisteredTask{}, fmt.Errorf("error getting registered task %s: %w", path, getTaskSchedulerError(err))
 	}
 
-	task, _, err := parseRegisteredTask(taskObj.ToIDispatch())
+	task, _, err := parseRegisteredTask(taskObj.ToIDispatch(), t.dispatcher)
 	if err != nil {
 		return RegisteredTask{}, fmt.Errorf("error parsing registered task %s: %v", path, err)
 	}
@@ -262,27 +428,147 @@ func (t *TaskService) GetRegisteredTask(path string) (RegisteredTask, error) {
 	return task, nil
 }
 
+// RunTask starts an instance of the registered task at the specified path. If the
+// task was started successfully, the running task will be returned.
+// https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nf-taskschd-iregisteredtask-runex
+func (t *TaskService) RunTask(path string, args ...string) (RunningTask, error) {
+	return t.RunTaskContext(context.Background(), path, args...)
+}
+
+// RunTaskContext is like RunTask, but returns the error of ctx without starting
+// the task if ctx is already canceled.
+func (t *TaskService) RunTaskContext(ctx context.Context, path string, args ...string) (RunningTask, error) {
+	if path[0] != '\\' {
+		return RunningTask{}, ErrInvalidPath
+	}
+
+	var runningTask RunningTask
+	err := t.dispatcher.run(ctx, func() error {
+		res, err := oleutil.CallMethod(t.rootFolderObj, "GetTask", path)
+		if err != nil {
+			return fmt.Errorf("error getting registered task %s: %w", path, getTaskSchedulerError(err))
+		}
+		taskObj := res.ToIDispatch()
+		defer taskObj.Release()
+
+		if !oleutil.MustGetProperty(taskObj, "Enabled").Value().(bool) {
+			return fmt.Errorf("error running registered task %s: cannot run a disabled task", path)
+		}
+
+		runningTaskObj, err := oleutil.CallMethod(taskObj, "RunEx", args, int(TASK_RUN_NO_FLAGS), 0, "")
+		if err != nil {
+			return fmt.Errorf("error running registered task %s: %w", path, getTaskSchedulerError(err))
+		}
+
+		runningTask, err = parseRunningTask(runningTaskObj.ToIDispatch(), t.dispatcher)
+		return err
+	})
+	if err != nil {
+		return RunningTask{}, err
+	}
+
+	return runningTask, nil
+}
+
+// GetRunHistory returns the runs of the registered task at path that were
+// logged since the given time, or all logged runs if since is zero. The runs
+// are read from the Task Scheduler operational event log, which is disabled
+// by default and must be enabled for history to be recorded. The event log of
+// a remote computer is read with the credentials of the current user.
+func (t *TaskService) GetRunHistory(path string, since time.Time) ([]RunRecord, error) {
+	return t.GetRunHistoryExContext(context.Background(), path, since, "", "", "")
+}
+
+// GetRunHistoryContext is like GetRunHistory, but stops reading the event log
+// once ctx is done.
+func (t *TaskService) GetRunHistoryContext(ctx context.Context, path string, since time.Time) ([]RunRecord, error) {
+	return t.GetRunHistoryExContext(ctx, path, since, "", "", "")
+}
+
+// GetRunHistoryEx is like GetRunHistory, but the event log of a remote computer
+// is read with the credentials of the given user. If username is empty, the
+// credentials of the current user are used. The credentials aren't used for
+// the local event log.
+func (t *TaskService) GetRunHistoryEx(path string, since time.Time, domain, username, password string) ([]RunRecord, error) {
+	return t.GetRunHistoryExContext(context.Background(), path, since, domain, username, password)
+}
+
+// GetRunHistoryExContext is like GetRunHistoryEx, but stops reading the event
+// log once ctx is done.
+func (t *TaskService) GetRunHistoryExContext(ctx context.Context, path string, since time.Time, domain, username, password string) ([]RunRecord, error) {
+	if !t.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	if path[0] != '\\' {
+		return nil, ErrInvalidPath
+	}
+
+	query, err := historyQuery(path, since)
+	if err != nil {
+		return nil, err
+	}
+	var server string
+	if hostname, _ := os.Hostname(); !strings.EqualFold(t.connectedComputerName, hostname) {
+		server = t.connectedComputerName
+	}
+
+	events, err := queryHistoryEvents(ctx, server, domain, username, password, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying history of task %s: %v", path, err)
+	}
+
+	return CorrelateRuns(events), nil
+}
+
 // GetTaskFolders enumerates the Task Schedule database for all task folders and currently
 // registered tasks.
-func (t TaskService) GetTaskFolders() (TaskFolder, error) {
-	return t.GetTaskFolder(`\`)
+func (t *TaskService) GetTaskFolders() (TaskFolder, error) {
+	return t.GetTaskFolderContext(context.Background(), `\`)
+}
+
+// GetTaskFoldersContext is like GetTaskFolders, but ctx is checked before every
+// folder and task is enumerated.
+func (t *TaskService) GetTaskFoldersContext(ctx context.Context) (TaskFolder, error) {
+	return t.GetTaskFolderContext(ctx, `\`)
 }
 
 // GetTaskFolder enumerates the Task Schedule database for all task sub folders and currently
 // registered tasks under the folder specified, if it exists. If it doesn't exist, nil will be
 // returned in place of the task folder.
-func (t TaskService) GetTaskFolder(path string) (TaskFolder, error) {
+func (t *TaskService) GetTaskFolder(path string) (TaskFolder, error) {
+	return t.GetTaskFolderContext(context.Background(), path)
+}
+
+// GetTaskFolderContext is like GetTaskFolder, but ctx is checked before every
+// folder and task is enumerated. If ctx is canceled, the tasks enumerated so far
+// are released and the error of ctx is returned.
+func (t *TaskService) GetTaskFolderContext(ctx context.Context, path string) (TaskFolder, error) {
 	if path[0] != '\\' {
 		return TaskFolder{}, ErrInvalidPath
 	}
 
+	var taskFolder TaskFolder
+	err := t.dispatcher.run(ctx, func() error {
+		var err error
+		taskFolder, err = t.getTaskFolder(ctx, path)
+		return err
+	})
+	if err != nil {
+		taskFolder.Release()
+		return TaskFolder{}, err
+	}
+
+	return taskFolder, nil
+}
+
+func (t *TaskService) getTaskFolder(ctx context.Context, path string) (TaskFolder, error) {
 	var topFolderObj *ole.IDispatch
 	if path == `\` {
 		topFolderObj = t.rootFolderObj
 	} else {
 		topFolder, err := oleutil.CallMethod(t.taskServiceObj, "GetFolder", path)
 		if err != nil {
-			return TaskFolder{}, fmt.Errorf("error getting folder %s: %v", path, getTaskSchedulerError(err))
+			return TaskFolder{}, fmt.Errorf("error getting folder %s: %w", path, getTaskSchedulerError(err))
 		}
 		topFolderObj = topFolder.ToIDispatch()
 		defer topFolderObj.Release()
@@ -291,15 +577,19 @@ func (t TaskService) GetTaskFolder(path string) (TaskFolder, error) {
 	// get tasks from the top folder
 	res, err := oleutil.CallMethod(topFolderObj, "GetTasks", int(TASK_ENUM_HIDDEN))
 	if err != nil {
-		return TaskFolder{}, fmt.Errorf("error getting tasks of folder %s: %v", path, getTaskSchedulerError(err))
+		return TaskFolder{}, fmt.Errorf("error getting tasks of folder %s: %w", path, getTaskSchedulerError(err))
 	}
 	topFolderTaskCollection := res.ToIDispatch()
 	defer topFolderTaskCollection.Release()
 	topFolder := TaskFolder{Path: `\`}
 	err = oleutil.ForEach(topFolderTaskCollection, func(v *ole.VARIANT) error {
 		task := v.ToIDispatch()
+		if err := ctx.Err(); err != nil {
+			task.Release()
+			return err
+		}
 
-		registeredTask, path, err := parseRegisteredTask(task)
+		registeredTask, path, err := parseRegisteredTask(task, t.dispatcher)
 		if err != nil {
 			return fmt.Errorf("error parsing registered task %s: %v", path, err)
 		}
@@ -308,12 +598,12 @@ func (t TaskService) GetTaskFolder(path string) (TaskFolder, error) {
 		return nil
 	})
 	if err != nil {
-		return TaskFolder{}, err
+		return topFolder, err
 	}
 
 	res, err = oleutil.CallMethod(topFolderObj, "GetFolders", 0)
 	if err != nil {
-		return TaskFolder{}, fmt.Errorf("error getting subfolders of folder %s: %v", path, getTaskSchedulerError(err))
+		return topFolder, fmt.Errorf("error getting subfolders of folder %s: %w", path, getTaskSchedulerError(err))
 	}
 	taskFolderList := res.ToIDispatch()
 	defer taskFolderList.Release()
@@ -326,11 +616,15 @@ func (t TaskService) GetTaskFolder(path string) (TaskFolder, error) {
 			taskFolder := v.ToIDispatch()
 			defer taskFolder.Release()
 
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
 			name := oleutil.MustGetProperty(taskFolder, "Name").ToString()
 			path := oleutil.MustGetProperty(taskFolder, "Path").ToString()
 			res, err := oleutil.CallMethod(taskFolder, "GetTasks", int(TASK_ENUM_HIDDEN))
 			if err != nil {
-				return fmt.Errorf("error getting tasks of folder %s: %v", path, getTaskSchedulerError(err))
+				return fmt.Errorf("error getting tasks of folder %s: %w", path, getTaskSchedulerError(err))
 			}
 			taskCollection := res.ToIDispatch()
 			defer taskCollection.Release()
@@ -340,10 +634,18 @@ func (t TaskService) GetTaskFolder(path string) (TaskFolder, error) {
 				Path: path,
 			}
 
+			// add the subfolder before enumerating its tasks so they
+			// are released if enumeration fails
+			parentFolder.SubFolders = append(parentFolder.SubFolders, taskSubFolder)
+
 			err = oleutil.ForEach(taskCollection, func(v *ole.VARIANT) error {
 				task := v.ToIDispatch()
+				if err := ctx.Err(); err != nil {
+					task.Release()
+					return err
+				}
 
-				registeredTask, path, err := parseRegisteredTask(task)
+				registeredTask, path, err := parseRegisteredTask(task, t.dispatcher)
 				if err != nil {
 					return fmt.Errorf("error parsing registered task %s: %v", path, err)
 				}
@@ -355,11 +657,9 @@ func (t TaskService) GetTaskFolder(path string) (TaskFolder, error) {
 				return err
 			}
 
-			parentFolder.SubFolders = append(parentFolder.SubFolders, taskSubFolder)
-
 			res, err = oleutil.CallMethod(taskFolder, "GetFolders", 0)
 			if err != nil {
-				return fmt.Errorf("error getting subfolders of folder %s: %v", path, getTaskSchedulerError(err))
+				return fmt.Errorf("error getting subfolders of folder %s: %w", path, getTaskSchedulerError(err))
 			}
 			taskFolderList := res.ToIDispatch()
 			defer taskFolderList.Release()
@@ -377,15 +677,119 @@ func (t TaskService) GetTaskFolder(path string) (TaskFolder, error) {
 
 	err = oleutil.ForEach(taskFolderList, initEnumTaskFolders(&topFolder))
 	if err != nil {
-		return TaskFolder{}, err
+		return topFolder, err
 	}
 
 	return topFolder, nil
 }
 
+// EnumerateTasks calls fn for every registered task of the folder at path and
+// its subfolders, depth first. Unlike GetTaskFolder, folders are listed one at
+// a time as they are visited, folders are pruned according to opts before
+// they are listed, and fn can stop the enumeration early. fn is not called on
+// the goroutine that makes COM calls, so it may use the TaskService. Tasks
+// passed to fn must be released when they are no longer needed.
+func (t *TaskService) EnumerateTasks(path string, opts EnumerateOptions, fn TaskVisitor) error {
+	return t.EnumerateTasksContext(context.Background(), path, opts, fn)
+}
+
+// EnumerateTasksContext is like EnumerateTasks, but ctx is checked before every
+// folder and task is enumerated.
+func (t *TaskService) EnumerateTasksContext(ctx context.Context, path string, opts EnumerateOptions, fn TaskVisitor) error {
+	if path[0] != '\\' {
+		return ErrInvalidPath
+	}
+
+	return enumerateTasks(ctx, path, opts, t.listTaskFolder, fn)
+}
+
+// listTaskFolder returns the tasks and the paths of the subfolders of the
+// folder at path.
+func (t *TaskService) listTaskFolder(ctx context.Context, path string, opts EnumerateOptions) (RegisteredTaskCollection, []string, error) {
+	var (
+		tasks      RegisteredTaskCollection
+		subFolders []string
+	)
+
+	err := t.dispatcher.run(ctx, func() error {
+		folderObj := t.rootFolderObj
+		if path != `\` {
+			res, err := oleutil.CallMethod(t.taskServiceObj, "GetFolder", path)
+			if err != nil {
+				return fmt.Errorf("error getting folder %s: %w", path, getTaskSchedulerError(err))
+			}
+			folderObj = res.ToIDispatch()
+			defer folderObj.Release()
+		}
+
+		var enumFlags TaskEnumFlags
+		if !opts.ExcludeHidden {
+			enumFlags = TASK_ENUM_HIDDEN
+		}
+		res, err := oleutil.CallMethod(folderObj, "GetTasks", int(enumFlags))
+		if err != nil {
+			return fmt.Errorf("error getting tasks of folder %s: %w", path, getTaskSchedulerError(err))
+		}
+		taskCollection := res.ToIDispatch()
+		defer taskCollection.Release()
+
+		err = oleutil.ForEach(taskCollection, func(v *ole.VARIANT) error {
+			task := v.ToIDispatch()
+			if err := ctx.Err(); err != nil {
+				task.Release()
+				return err
+			}
+
+			if opts.SummaryOnly {
+				tasks = append(tasks, parseRegisteredTaskSummary(task, t.dispatcher))
+				return nil
+			}
+
+			registeredTask, path, err := parseRegisteredTask(task, t.dispatcher)
+			if err != nil {
+				return fmt.Errorf("error parsing registered task %s: %v", path, err)
+			}
+			tasks = append(tasks, registeredTask)
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		res, err = oleutil.CallMethod(folderObj, "GetFolders", 0)
+		if err != nil {
+			return fmt.Errorf("error getting subfolders of folder %s: %w", path, getTaskSchedulerError(err))
+		}
+		folderList := res.ToIDispatch()
+		defer folderList.Release()
+
+		return oleutil.ForEach(folderList, func(v *ole.VARIANT) error {
+			folder := v.ToIDispatch()
+			defer folder.Release()
+
+			subFolders = append(subFolders, oleutil.MustGetProperty(folder, "Path").ToString())
+			return nil
+		})
+	})
+	if err != nil {
+		tasks.Release()
+		return nil, nil, err
+	}
+
+	return tasks, subFolders, nil
+}
+
+// Watch takes a snapshot of the task folder at folderPath and its subfolders
+// every interval, and sends an event for every change between two successive
+// snapshots. See the Watch function for details.
+func (t *TaskService) Watch(ctx context.Context, folderPath string, interval time.Duration) <-chan TaskEvent {
+	return Watch(ctx, t, folderPath, interval)
+}
+
 // NewTaskDefinition returns a new task definition that can be used to register a new task.
 // Task settings and properties are set to Task Scheduler default values.
-func (t TaskService) NewTaskDefinition() Definition {
+func (t *TaskService) NewTaskDefinition() Definition {
 	var newDef Definition
 
 	newDef.Principal.LogonType = TASK_LOGON_INTERACTIVE_TOKEN
@@ -421,13 +825,30 @@ func (t TaskService) NewTaskDefinition() Definition {
 // true if the task was successfully registered, and false if the overwrite parameter
 // is false and a task at the specified path already exists.
 func (t *TaskService) CreateTask(path string, newTaskDef Definition, overwrite bool) (RegisteredTask, bool, error) {
-	return t.CreateTaskEx(path, newTaskDef, "", "", newTaskDef.Principal.LogonType, overwrite)
+	return t.CreateTaskExContext(context.Background(), path, newTaskDef, "", "", newTaskDef.Principal.LogonType, overwrite)
+}
+
+// CreateTaskContext is like CreateTask, but returns the error of ctx without
+// creating the task if ctx is already canceled.
+func (t *TaskService) CreateTaskContext(ctx context.Context, path string, newTaskDef Definition, overwrite bool) (RegisteredTask, bool, error) {
+	return t.CreateTaskExContext(ctx, path, newTaskDef, "", "", newTaskDef.Principal.LogonType, overwrite)
 }
 
 // CreateTaskEx creates a registered task on the connected computer. CreateTaskEx returns
 // true if the task was successfully registered, and false if the overwrite parameter
 // is false and a task at the specified path already exists.
+// Existing tasks are overwritten by deleting them first; use RegisterTask to
+// update them in place.
 func (t *TaskService) CreateTaskEx(path string, newTaskDef Definition, username, password string, logonType TaskLogonType, overwrite bool) (RegisteredTask, bool, error) {
+	return t.CreateTaskExContext(context.Background(), path, newTaskDef, username, password, logonType, overwrite)
+}
+
+// CreateTaskExContext is like CreateTaskEx, but ctx is checked before every
+// step of creating the task. If ctx is canceled, the remaining steps are
+// skipped and the error of ctx is returned. Once an existing task has been
+// deleted to be overwritten, ctx is no longer checked, so the task is always
+// replaced.
+func (t *TaskService) CreateTaskExContext(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType, overwrite bool) (RegisteredTask, bool, error) {
 	var err error
 
 	if path[0] != '\\' {
@@ -436,37 +857,61 @@ func (t *TaskService) CreateTaskEx(path string, newTaskDef Definition, username,
 		return RegisteredTask{}, false, err
 	}
 
+	var (
+		newTask RegisteredTask
+		created bool
+	)
+	err = t.dispatcher.run(ctx, func() error {
+		var err error
+		newTask, created, err = t.createTask(ctx, path, newTaskDef, username, password, logonType, overwrite)
+		return err
+	})
+	if err != nil {
+		return RegisteredTask{}, false, err
+	}
+
+	return newTask, created, nil
+}
+
+func (t *TaskService) createTask(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType, overwrite bool) (RegisteredTask, bool, error) {
+	var err error
+
 	nameIndex := strings.LastIndex(path, `\`)
 	folderPath := path[:nameIndex]
 
 	if !t.taskFolderExist(folderPath) {
 		_, err = oleutil.CallMethod(t.rootFolderObj, "CreateFolder", folderPath, "")
 		if err != nil {
-			return RegisteredTask{}, false, fmt.Errorf("error creating folder %s: %v", path, getTaskSchedulerError(err))
+			return RegisteredTask{}, false, fmt.Errorf("error creating folder %s: %w", path, getTaskSchedulerError(err))
 		}
 	} else {
 		if t.registeredTaskExist(path) {
 			if !overwrite {
-				task, err := t.GetRegisteredTask(path)
+				task, err := t.getRegisteredTask(path)
 				if err != nil {
 					return RegisteredTask{}, false, err
 				}
 
 				return task, false, nil
 			}
+			if err = ctx.Err(); err != nil {
+				return RegisteredTask{}, false, err
+			}
 			_, err = oleutil.CallMethod(t.rootFolderObj, "DeleteTask", path, 0)
 			if err != nil {
-				return RegisteredTask{}, false, fmt.Errorf("error deleting registered task %s: %v", path, getTaskSchedulerError(err))
+				return RegisteredTask{}, false, fmt.Errorf("error deleting registered task %s: %w", path, getTaskSchedulerError(err))
 			}
 		}
 	}
 
-	newTaskObj, err := t.modifyTask(path, newTaskDef, username, password, logonType, TASK_CREATE)
+	// ctx isn't checked here, as an overwritten task has already been
+	// deleted and must be replaced
+	newTaskObj, err := t.modifyTask(path, newTaskDef, username, password, logonType, TASK_CREATE, "")
 	if err != nil {
-		return RegisteredTask{}, false, fmt.Errorf("error creating registered task %s: %v", path, err)
+		return RegisteredTask{}, false, fmt.Errorf("error creating registered task %s: %w", path, err)
 	}
 
-	newTask, _, err := parseRegisteredTask(newTaskObj)
+	newTask, _, err := parseRegisteredTask(newTaskObj, t.dispatcher)
 	if err != nil {
 		return RegisteredTask{}, false, fmt.Errorf("error parsing registered task %s: %v", path, err)
 	}
@@ -474,13 +919,92 @@ func (t *TaskService) CreateTaskEx(path string, newTaskDef Definition, username,
 	return newTask, true, nil
 }
 
+// RegisterTask registers a task on the connected computer as described by
+// opts, which expose the flags of RegisterTaskDefinition. RegisterTask
+// returns true if the task was created, and false if a task at the specified
+// path already exists and was updated in place or, if opts.CreateOrUpdate is
+// false, left as it is.
+// https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nf-taskschd-itaskfolder-registertaskdefinition
+func (t *TaskService) RegisterTask(path string, newTaskDef Definition, opts RegisterOptions) (RegisteredTask, bool, error) {
+	return t.RegisterTaskContext(context.Background(), path, newTaskDef, opts)
+}
+
+// RegisterTaskContext is like RegisterTask, but ctx is checked before every
+// step of registering the task. If ctx is canceled, the remaining steps are
+// skipped and the error of ctx is returned.
+func (t *TaskService) RegisterTaskContext(ctx context.Context, path string, newTaskDef Definition, opts RegisterOptions) (RegisteredTask, bool, error) {
+	if err := TaskPath(path).Validate(); err != nil {
+		return RegisteredTask{}, false, err
+	} else if err = validateDefinition(newTaskDef); err != nil {
+		return RegisteredTask{}, false, err
+	}
+
+	var (
+		newTask RegisteredTask
+		created bool
+	)
+	err := t.dispatcher.run(ctx, func() error {
+		folderPath := TaskPath(path).Folder().String()
+		if !t.taskFolderExist(folderPath) {
+			if !opts.CreateFolders {
+				return fmt.Errorf("error registering task %s: folder %s doesn't exist", path, folderPath)
+			}
+			_, err := oleutil.CallMethod(t.rootFolderObj, "CreateFolder", folderPath, "")
+			if err != nil {
+				return fmt.Errorf("error creating folder %s: %w", folderPath, getTaskSchedulerError(err))
+			}
+			created = true
+		} else {
+			created = !t.registeredTaskExist(path)
+			if !created && !opts.CreateOrUpdate {
+				var err error
+				newTask, err = t.getRegisteredTask(path)
+				return err
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		newTaskObj, err := t.modifyTask(path, newTaskDef, opts.Username, opts.Password, newTaskDef.Principal.LogonType, opts.flags(), opts.SDDL)
+		if err != nil {
+			return fmt.Errorf("error registering task %s: %w", path, err)
+		}
+
+		newTask, _, err = parseRegisteredTask(newTaskObj, t.dispatcher)
+		if err != nil {
+			return fmt.Errorf("error parsing registered task %s: %v", path, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return RegisteredTask{}, false, err
+	}
+
+	return newTask, created, nil
+}
+
 // UpdateTask updates a registered task.
 func (t *TaskService) UpdateTask(path string, newTaskDef Definition) (RegisteredTask, error) {
-	return t.UpdateTaskEx(path, newTaskDef, "", "", newTaskDef.Principal.LogonType)
+	return t.UpdateTaskExContext(context.Background(), path, newTaskDef, "", "", newTaskDef.Principal.LogonType)
+}
+
+// UpdateTaskContext is like UpdateTask, but returns the error of ctx without
+// updating the task if ctx is already canceled.
+func (t *TaskService) UpdateTaskContext(ctx context.Context, path string, newTaskDef Definition) (RegisteredTask, error) {
+	return t.UpdateTaskExContext(ctx, path, newTaskDef, "", "", newTaskDef.Principal.LogonType)
 }
 
 // UpdateTaskEx updates a registered task.
 func (t *TaskService) UpdateTaskEx(path string, newTaskDef Definition, username, password string, logonType TaskLogonType) (RegisteredTask, error) {
+	return t.UpdateTaskExContext(context.Background(), path, newTaskDef, username, password, logonType)
+}
+
+// UpdateTaskExContext is like UpdateTaskEx, but returns the error of ctx without
+// updating the task if ctx is already canceled.
+func (t *TaskService) UpdateTaskExContext(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType) (RegisteredTask, error) {
 	var err error
 
 	if path[0] != '\\' {
@@ -489,21 +1013,113 @@ func (t *TaskService) UpdateTaskEx(path string, newTaskDef Definition, username,
 		return RegisteredTask{}, err
 	}
 
-	newTaskObj, err := t.modifyTask(path, newTaskDef, username, password, logonType, TASK_UPDATE)
+	var newTask RegisteredTask
+	err = t.dispatcher.run(ctx, func() error {
+		newTaskObj, err := t.modifyTask(path, newTaskDef, username, password, logonType, TASK_UPDATE, "")
+		if err != nil {
+			return fmt.Errorf("error updating %s task: %w", path, err)
+		}
+
+		// update the internal database of registered tasks
+		newTask, _, err = parseRegisteredTask(newTaskObj, t.dispatcher)
+		if err != nil {
+			return fmt.Errorf("error parsing registered task %s: %v", path, err)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return RegisteredTask{}, fmt.Errorf("error updating %s task: %v", path, err)
+		return RegisteredTask{}, err
+	}
+
+	return newTask, nil
+}
+
+// ValidateTask checks whether newTaskDef could be registered at path without
+// registering it. The definition is validated locally, and the Task Scheduler
+// service is asked to validate it with the TASK_VALIDATE_ONLY flag. The
+// returned error is only set if the service couldn't be asked; problems
+// with the definition are described by the report.
+func (t *TaskService) ValidateTask(path string, newTaskDef Definition, username, password string, logonType TaskLogonType) (ValidationReport, error) {
+	return t.ValidateTaskContext(context.Background(), path, newTaskDef, username, password, logonType)
+}
+
+// ValidateTaskContext is like ValidateTask, but returns the error of ctx
+// without asking the service to validate the definition if ctx is already
+// canceled.
+func (t *TaskService) ValidateTaskContext(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType) (ValidationReport, error) {
+	report := ValidationReport{
+		Path:     path,
+		PathErr:  TaskPath(path).Validate(),
+		LocalErr: validateDefinition(newTaskDef),
+		Warnings: DefinitionWarnings(newTaskDef),
 	}
 
-	// update the internal database of registered tasks
-	newTask, _, err := parseRegisteredTask(newTaskObj)
+	err := t.dispatcher.run(ctx, func() error {
+		if report.PathErr == nil {
+			report.Exists = t.registeredTaskExist(path)
+		}
+
+		taskObj, err := t.modifyTask(path, newTaskDef, username, password, logonType, TASK_VALIDATE_ONLY, "")
+		if err != nil {
+			report.ServiceErr = err
+		} else if taskObj != nil {
+			taskObj.Release()
+		}
+
+		return nil
+	})
 	if err != nil {
-		return RegisteredTask{}, fmt.Errorf("error parsing registered task %s: %v", path, err)
+		return ValidationReport{}, err
 	}
 
-	return newTask, nil
+	return report, nil
+}
+
+// DryRunCreateTask checks what CreateTaskEx would do without registering the
+// task. newTaskDef is validated like ValidateTask, and the report's Skipped
+// is set if a task is registered at path and overwrite is false, in which
+// case CreateTaskEx would leave the task as it is.
+func (t *TaskService) DryRunCreateTask(path string, newTaskDef Definition, username, password string, logonType TaskLogonType, overwrite bool) (ValidationReport, error) {
+	return t.DryRunCreateTaskContext(context.Background(), path, newTaskDef, username, password, logonType, overwrite)
+}
+
+// DryRunCreateTaskContext is like DryRunCreateTask, but returns the error of
+// ctx without asking the service to validate the definition if ctx is
+// already canceled.
+func (t *TaskService) DryRunCreateTaskContext(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType, overwrite bool) (ValidationReport, error) {
+	report, err := t.ValidateTaskContext(ctx, path, newTaskDef, username, password, logonType)
+	if err != nil {
+		return ValidationReport{}, err
+	}
+	report.Skipped = report.Exists && !overwrite
+
+	return report, nil
 }
 
-func (t *TaskService) modifyTask(path string, newTaskDef Definition, username, password string, logonType TaskLogonType, flags TaskCreationFlags) (*ole.IDispatch, error) {
+// DryRunUpdateTask checks what UpdateTaskEx would do without updating the
+// task. newTaskDef is validated like ValidateTask, and the report's PathErr
+// is set if no task is registered at path.
+func (t *TaskService) DryRunUpdateTask(path string, newTaskDef Definition, username, password string, logonType TaskLogonType) (ValidationReport, error) {
+	return t.DryRunUpdateTaskContext(context.Background(), path, newTaskDef, username, password, logonType)
+}
+
+// DryRunUpdateTaskContext is like DryRunUpdateTask, but returns the error of
+// ctx without asking the service to validate the definition if ctx is
+// already canceled.
+func (t *TaskService) DryRunUpdateTaskContext(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType) (ValidationReport, error) {
+	report, err := t.ValidateTaskContext(ctx, path, newTaskDef, username, password, logonType)
+	if err != nil {
+		return ValidationReport{}, err
+	}
+	if report.PathErr == nil && !report.Exists {
+		report.PathErr = fmt.Errorf("task %s doesn't exist", path)
+	}
+
+	return report, nil
+}
+
+func (t *TaskService) modifyTask(path string, newTaskDef Definition, username, password string, logonType TaskLogonType, flags TaskCreationFlags, sddl string) (*ole.IDispatch, error) {
 	// set default UserID if UserID and GroupID both aren't set
 	if newTaskDef.Principal.UserID == "" && newTaskDef.Principal.GroupID == "" {
 		newTaskDef.Principal.UserID = t.connectedDomain + `\` + t.connectedUser
@@ -511,7 +1127,7 @@ func (t *TaskService) modifyTask(path string, newTaskDef Definition, username, p
 
 	res, err := oleutil.CallMethod(t.taskServiceObj, "NewTask", 0)
 	if err != nil {
-		return nil, fmt.Errorf("error creating new task: %v", getTaskSchedulerError(err))
+		return nil, fmt.Errorf("error creating new task: %w", getTaskSchedulerError(err))
 	}
 	newTaskDefObj := res.ToIDispatch()
 	defer newTaskDefObj.Release()
@@ -521,34 +1137,190 @@ func (t *TaskService) modifyTask(path string, newTaskDef Definition, username, p
 		return nil, fmt.Errorf("error filling ITaskDefinition: %v", err)
 	}
 
-	newTaskObj, err := oleutil.CallMethod(t.rootFolderObj, "RegisterTaskDefinition", path, newTaskDefObj, int(flags), username, password, int(logonType), "")
+	newTaskObj, err := oleutil.CallMethod(t.rootFolderObj, "RegisterTaskDefinition", path, newTaskDefObj, int(flags), username, password, int(logonType), sddl)
 	if err != nil {
-		return nil, fmt.Errorf("error registering task: %v", getTaskSchedulerError(err))
+		return nil, fmt.Errorf("error registering task: %w", getTaskSchedulerError(err))
 	}
 
 	return newTaskObj.ToIDispatch(), nil
 }
 
+// MoveTask moves the registered task at srcPath to dstPath, which can be used
+// to rename a task. The task is registered at dstPath with the same
+// definition, enabled state, principal and security descriptor, and then the
+// task at srcPath is deleted. If the task at srcPath can't be deleted, the new
+// task is deleted. If rolling back fails, a *MoveError describing the tasks
+// that were left behind is returned.
+func (t *TaskService) MoveTask(srcPath, dstPath string, opts MoveOptions) (RegisteredTask, error) {
+	return t.MoveTaskContext(context.Background(), srcPath, dstPath, opts)
+}
+
+// MoveTaskContext is like MoveTask, but ctx is checked before every step of
+// moving the task. If ctx is canceled, the changes made so far are rolled
+// back.
+func (t *TaskService) MoveTaskContext(ctx context.Context, srcPath, dstPath string, opts MoveOptions) (RegisteredTask, error) {
+	if srcPath[0] != '\\' || dstPath[0] != '\\' {
+		return RegisteredTask{}, ErrInvalidPath
+	}
+
+	return moveTask(ctx, t, srcPath, dstPath, opts, false)
+}
+
+// CopyTask registers a copy of the registered task at srcPath at dstPath,
+// with the same definition, enabled state, principal and security descriptor.
+func (t *TaskService) CopyTask(srcPath, dstPath string, opts MoveOptions) (RegisteredTask, error) {
+	return t.CopyTaskContext(context.Background(), srcPath, dstPath, opts)
+}
+
+// CopyTaskContext is like CopyTask, but returns the error of ctx without
+// copying the task if ctx is already canceled.
+func (t *TaskService) CopyTaskContext(ctx context.Context, srcPath, dstPath string, opts MoveOptions) (RegisteredTask, error) {
+	if srcPath[0] != '\\' || dstPath[0] != '\\' {
+		return RegisteredTask{}, ErrInvalidPath
+	}
+
+	return moveTask(ctx, t, srcPath, dstPath, opts, true)
+}
+
+// MoveFolder moves the registered tasks of the folder at srcPath and its
+// subfolders to the folder at dstPath, keeping their paths relative to the
+// folder, and deletes the folder at srcPath. Every task is copied before any
+// task is deleted, and the changes are rolled back like MoveTask if a task
+// can't be copied or deleted, which also deletes the folders created at
+// dstPath. Subfolders without tasks are not recreated. Only the moved tasks
+// are deleted: the folder at srcPath and its subfolders are deleted once they
+// are empty, and an error is returned if tasks or folders were added to them
+// during the move.
+func (t *TaskService) MoveFolder(srcPath, dstPath string, opts MoveOptions) error {
+	return t.MoveFolderContext(context.Background(), srcPath, dstPath, opts)
+}
+
+// MoveFolderContext is like MoveFolder, but ctx is checked before every step of
+// moving the tasks. If ctx is canceled, the changes made so far are rolled
+// back.
+func (t *TaskService) MoveFolderContext(ctx context.Context, srcPath, dstPath string, opts MoveOptions) error {
+	if srcPath[0] != '\\' || dstPath[0] != '\\' {
+		return ErrInvalidPath
+	}
+
+	return moveFolder(ctx, t, srcPath, dstPath, opts)
+}
+
+func (t *TaskService) getSecurityDescriptor(ctx context.Context, path string) (string, error) {
+	var sddl string
+	err := t.dispatcher.run(ctx, func() error {
+		res, err := oleutil.CallMethod(t.rootFolderObj, "GetTask", path)
+		if err != nil {
+			return fmt.Errorf("error getting registered task %s: %w", path, getTaskSchedulerError(err))
+		}
+		taskObj := res.ToIDispatch()
+		defer taskObj.Release()
+
+		securityInformation := ownerSecurityInformation | groupSecurityInformation | daclSecurityInformation
+		res, err = oleutil.CallMethod(taskObj, "GetSecurityDescriptor", securityInformation|saclSecurityInformation)
+		if err != nil {
+			// reading the SACL needs SeSecurityPrivilege
+			if code := getOLEErrorCode(err); code == 0x80070522 || code == 0x80070005 {
+				res, err = oleutil.CallMethod(taskObj, "GetSecurityDescriptor", securityInformation)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("error getting security descriptor of registered task %s: %w", path, getTaskSchedulerError(err))
+		}
+		sddl = res.ToString()
+
+		return nil
+	})
+
+	return sddl, err
+}
+
+func (t *TaskService) folderExists(ctx context.Context, path string) (bool, error) {
+	var exists bool
+	err := t.dispatcher.run(ctx, func() error {
+		exists = t.taskFolderExist(path)
+		return nil
+	})
+
+	return exists, err
+}
+
+func (t *TaskService) registerTask(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType, sddl string) (RegisteredTask, error) {
+	if err := validateDefinition(newTaskDef); err != nil {
+		return RegisteredTask{}, err
+	}
+
+	var newTask RegisteredTask
+	err := t.dispatcher.run(ctx, func() error {
+		folderPath := TaskPath(path).Folder().String()
+		if !t.taskFolderExist(folderPath) {
+			_, err := oleutil.CallMethod(t.rootFolderObj, "CreateFolder", folderPath, "")
+			if err != nil {
+				return fmt.Errorf("error creating folder %s: %w", folderPath, getTaskSchedulerError(err))
+			}
+		}
+
+		newTaskObj, err := t.modifyTask(path, newTaskDef, username, password, logonType, TASK_CREATE, sddl)
+		if err != nil {
+			return fmt.Errorf("error creating registered task %s: %w", path, err)
+		}
+
+		newTask, _, err = parseRegisteredTask(newTaskObj, t.dispatcher)
+		if err != nil {
+			return fmt.Errorf("error parsing registered task %s: %v", path, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return RegisteredTask{}, err
+	}
+
+	return newTask, nil
+}
+
 // DeleteFolder removes a task folder from the connected computer. If the deleteRecursively parameter
 // is set to true, all tasks and subfolders will be removed recursively. If it's set to false, DeleteFolder
 // will return true if the folder was empty and deleted successfully, and false otherwise.
 func (t *TaskService) DeleteFolder(path string, deleteRecursively bool) (bool, error) {
-	var err error
+	return t.DeleteFolderContext(context.Background(), path, deleteRecursively)
+}
 
+// DeleteFolderContext is like DeleteFolder, but ctx is checked before every task
+// and folder is deleted. If ctx is canceled during a recursive delete, the tasks
+// and folders that were already deleted stay deleted, and the error of ctx
+// is returned.
+func (t *TaskService) DeleteFolderContext(ctx context.Context, path string, deleteRecursively bool) (bool, error) {
 	if path[0] != '\\' {
 		return false, ErrInvalidPath
 	}
 
+	var deleted bool
+	err := t.dispatcher.run(ctx, func() error {
+		var err error
+		deleted, err = t.deleteFolder(ctx, path, deleteRecursively)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return deleted, nil
+}
+
+func (t *TaskService) deleteFolder(ctx context.Context, path string, deleteRecursively bool) (bool, error) {
+	var err error
+
 	taskFolder, err := oleutil.CallMethod(t.taskServiceObj, "GetFolder", path)
 	if err != nil {
-		return false, fmt.Errorf("error getting folder: %v", getTaskSchedulerError(err))
+		return false, fmt.Errorf("error getting folder: %w", getTaskSchedulerError(err))
 	}
 
 	taskFolderObj := taskFolder.ToIDispatch()
 	defer taskFolderObj.Release()
 	res, err := oleutil.CallMethod(taskFolderObj, "GetTasks", int(TASK_ENUM_HIDDEN))
 	if err != nil {
-		return false, fmt.Errorf("error getting tasks of folder: %v", getTaskSchedulerError(err))
+		return false, fmt.Errorf("error getting tasks of folder: %w", getTaskSchedulerError(err))
 	}
 	taskCollection := res.ToIDispatch()
 	defer taskCollection.Release()
@@ -558,7 +1330,7 @@ func (t *TaskService) DeleteFolder(path string, deleteRecursively bool) (bool, e
 
 	res, err = oleutil.CallMethod(taskFolderObj, "GetFolders", int(TASK_ENUM_HIDDEN))
 	if err != nil {
-		return false, fmt.Errorf("error getting the subfolders: %v", getTaskSchedulerError(err))
+		return false, fmt.Errorf("error getting the subfolders: %w", getTaskSchedulerError(err))
 	}
 	folderCollection := res.ToIDispatch()
 	defer folderCollection.Release()
@@ -574,7 +1346,11 @@ func (t *TaskService) DeleteFolder(path string, deleteRecursively bool) (bool, e
 
 			name := oleutil.MustGetProperty(taskObj, "Path").ToString()
 
-			return t.DeleteTask(name)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			return t.deleteTask(name)
 		}
 		err = oleutil.ForEach(taskCollection, deleteAllTasks)
 		if err != nil {
@@ -588,9 +1364,13 @@ func (t *TaskService) DeleteFolder(path string, deleteRecursively bool) (bool, e
 			folderObj := v.ToIDispatch()
 			defer folderObj.Release()
 
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+
 			res, err := oleutil.CallMethod(folderObj, "GetTasks", int(TASK_ENUM_HIDDEN))
 			if err != nil {
-				return fmt.Errorf("error getting tasks of folder: %v", getTaskSchedulerError(err))
+				return fmt.Errorf("error getting tasks of folder: %w", getTaskSchedulerError(err))
 			}
 			tasks := res.ToIDispatch()
 			defer tasks.Release()
@@ -602,7 +1382,7 @@ func (t *TaskService) DeleteFolder(path string, deleteRecursively bool) (bool, e
 
 			res, err = oleutil.CallMethod(folderObj, "GetFolders", int(TASK_ENUM_HIDDEN))
 			if err != nil {
-				return fmt.Errorf("error getting subfolders: %v", getTaskSchedulerError(err))
+				return fmt.Errorf("error getting subfolders: %w", getTaskSchedulerError(err))
 			}
 			subFolders := res.ToIDispatch()
 			defer subFolders.Release()
@@ -615,7 +1395,7 @@ func (t *TaskService) DeleteFolder(path string, deleteRecursively bool) (bool, e
 			currentFolderPath := oleutil.MustGetProperty(folderObj, "Path").ToString()
 			_, err = oleutil.CallMethod(t.rootFolderObj, "DeleteFolder", currentFolderPath, 0)
 			if err != nil {
-				return fmt.Errorf("error deleting task folder %s: %v", path, getTaskSchedulerError(err))
+				return fmt.Errorf("error deleting task folder %s: %w", path, getTaskSchedulerError(err))
 			}
 
 			return nil
@@ -628,10 +1408,14 @@ func (t *TaskService) DeleteFolder(path string, deleteRecursively bool) (bool, e
 		}
 	}
 
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+
 	// delete parent folder
 	_, err = oleutil.CallMethod(t.rootFolderObj, "DeleteFolder", path, 0)
 	if err != nil {
-		return false, fmt.Errorf("error deleting task folder %s: %v", path, getTaskSchedulerError(err))
+		return false, fmt.Errorf("error deleting task folder %s: %w", path, getTaskSchedulerError(err))
 	}
 
 	return true, nil
@@ -639,15 +1423,25 @@ func (t *TaskService) DeleteFolder(path string, deleteRecursively bool) (bool, e
 
 // DeleteTask removes a registered task from the connected computer.
 func (t *TaskService) DeleteTask(path string) error {
-	var err error
+	return t.DeleteTaskContext(context.Background(), path)
+}
 
+// DeleteTaskContext is like DeleteTask, but returns the error of ctx without
+// deleting the task if ctx is already canceled.
+func (t *TaskService) DeleteTaskContext(ctx context.Context, path string) error {
 	if path[0] != '\\' {
 		return ErrInvalidPath
 	}
 
-	_, err = oleutil.CallMethod(t.rootFolderObj, "DeleteTask", path, 0)
+	return t.dispatcher.run(ctx, func() error {
+		return t.deleteTask(path)
+	})
+}
+
+func (t *TaskService) deleteTask(path string) error {
+	_, err := oleutil.CallMethod(t.rootFolderObj, "DeleteTask", path, 0)
 	if err != nil {
-		return fmt.Errorf("error deleting task %s: %v", path, getTaskSchedulerError(err))
+		return fmt.Errorf("error deleting task %s: %w", path, getTaskSchedulerError(err))
 	}
 
 	return nil
@@ -3,7 +3,9 @@
 package taskmaster
 
 import (
+	"context"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -16,6 +18,16 @@ func TestLocalConnect(t *testing.T) {
 	taskService.Disconnect()
 }
 
+func TestConnectContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ConnectContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
 func TestCreateTask(t *testing.T) {
 	var err error
 	taskService, err := Connect()
@@ -212,6 +224,71 @@ func TestUpdateTask(t *testing.T) {
 	}
 }
 
+func TestRegisterTask(t *testing.T) {
+	taskService, err := Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTask := createTestTask(taskService)
+	defer taskService.Disconnect()
+
+	_, _, err = taskService.RegisterTask("\\Taskmaster\\Missing\\Task", testTask.Definition, RegisterOptions{})
+	if err == nil {
+		t.Fatal("expected error registering a task in a missing folder")
+	}
+
+	testTask.Definition.RegistrationInfo.Author = "Upserted"
+	task, created, err := taskService.RegisterTask("\\Taskmaster\\TestTask", testTask.Definition, RegisterOptions{CreateOrUpdate: true, Disable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer task.Release()
+	if created {
+		t.Error("task should have been updated in place")
+	}
+	if task.Enabled || task.Definition.RegistrationInfo.Author != "Upserted" {
+		t.Error("task was not updated")
+	}
+}
+
+func TestValidateTask(t *testing.T) {
+	taskService, err := Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTask := createTestTask(taskService)
+	defer taskService.Disconnect()
+
+	report, err := taskService.DryRunCreateTask("\\Taskmaster\\TestTask", testTask.Definition, "", "", testTask.Definition.Principal.LogonType, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() || !report.Exists || !report.Skipped {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	report, err = taskService.DryRunUpdateTask("\\Taskmaster\\ValidatedTask", testTask.Definition, "", "", testTask.Definition.Principal.LogonType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid() || report.PathErr == nil {
+		t.Fatal("expected error updating a missing task")
+	}
+	if task, err := taskService.GetRegisteredTask("\\Taskmaster\\ValidatedTask"); err == nil {
+		task.Release()
+		t.Fatal("validated task shouldn't have been registered")
+	}
+
+	testTask.Definition.Actions = nil
+	report, err = taskService.ValidateTask("\\Taskmaster\\ValidatedTask", testTask.Definition, "", "", testTask.Definition.Principal.LogonType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.LocalErr != ErrNoActions {
+		t.Fatalf("expected ErrNoActions, got %v", report.LocalErr)
+	}
+}
+
 func TestGetRegisteredTasks(t *testing.T) {
 	taskService, err := Connect()
 	if err != nil {
@@ -226,6 +303,90 @@ func TestGetRegisteredTasks(t *testing.T) {
 	rtc.Release()
 }
 
+func TestGetRegisteredTasksContextCanceled(t *testing.T) {
+	taskService, err := Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taskService.Disconnect()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rtc, err := taskService.GetRegisteredTasksContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if rtc != nil {
+		t.Error("no registered tasks should have been returned")
+	}
+
+	tf, err := taskService.GetTaskFoldersContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if tf.RegisteredTasks != nil || tf.SubFolders != nil {
+		t.Error("no task folders should have been returned")
+	}
+}
+
+func TestEnumerateLocalTasks(t *testing.T) {
+	taskService, err := Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taskService.Disconnect()
+
+	var visited int
+	opts := EnumerateOptions{
+		IncludeFolders: []string{`\Microsoft\Windows\*`},
+		MaxDepth:       4,
+		SummaryOnly:    true,
+	}
+	err = taskService.EnumerateTasks(`\`, opts, func(task RegisteredTask) error {
+		defer task.Release()
+
+		if task.Definition.Actions != nil {
+			t.Errorf("definition of task %s should not have been parsed", task.Path)
+		}
+		visited++
+		if visited == 10 {
+			return ErrStopEnumeration
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visited == 0 {
+		t.Error("no tasks were visited")
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	taskService, err := Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taskService.Disconnect()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rtc, err := taskService.GetRegisteredTasks()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rtc.Release()
+		}()
+	}
+	wg.Wait()
+}
+
 func TestGetTaskFolders(t *testing.T) {
 	taskService, err := Connect()
 	if err != nil {
@@ -260,6 +421,41 @@ func TestDeleteTask(t *testing.T) {
 	deletedTask.Release()
 }
 
+func TestMoveAndCopyLocalTask(t *testing.T) {
+	taskService, err := Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestTask(taskService)
+	defer taskService.Disconnect()
+
+	copiedTask, err := taskService.CopyTask("\\Taskmaster\\TestTask", "\\Taskmaster\\CopiedTask", MoveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copiedTask.Release()
+
+	movedTask, err := taskService.MoveTask("\\Taskmaster\\CopiedTask", "\\Taskmaster\\Moved\\MovedTask", MoveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	movedTask.Release()
+
+	if task, err := taskService.GetRegisteredTask("\\Taskmaster\\CopiedTask"); err == nil {
+		task.Release()
+		t.Error("moved task shouldn't still exist")
+	}
+
+	err = taskService.MoveFolder("\\Taskmaster\\Moved", "\\Taskmaster\\Renamed", MoveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = taskService.DeleteTask("\\Taskmaster\\Renamed\\MovedTask")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestDeleteFolder(t *testing.T) {
 	taskService, err := Connect()
 	if err != nil {
@@ -268,7 +464,18 @@ func TestDeleteFolder(t *testing.T) {
 	createTestTask(taskService)
 	defer taskService.Disconnect()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
 	var folderDeleted bool
+	folderDeleted, err = taskService.DeleteFolderContext(ctx, "\\Taskmaster", true)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if folderDeleted == true {
+		t.Error("folder shouldn't have been deleted")
+	}
+
 	folderDeleted, err = taskService.DeleteFolder("\\Taskmaster", false)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,237 @@
+package taskmaster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MigrateOptions changes how MigrateDeprecatedActions replaces deprecated
+// actions.
+type MigrateOptions struct {
+	// Compatibility is the compatibility definitions with replaced actions
+	// are raised to if theirs is lower. If zero, TASK_COMPATIBILITY_V2_2 is
+	// used, the compatibility of Windows 8, which no longer runs deprecated
+	// actions.
+	Compatibility TaskCompatibility
+	// UseMsg shows messages with msg.exe instead of a message box shown by
+	// PowerShell. msg.exe sends the message to every session on the
+	// computer, and only exists on the Pro, Enterprise and Server editions
+	// of Windows.
+	UseMsg bool
+}
+
+// ActionMigration is a deprecated action that was replaced by an exec action.
+type ActionMigration struct {
+	Index int        // the index of the action in the actions of the definition
+	Old   Action     // the deprecated action
+	New   ExecAction // the exec action that replaced it
+	// Notes describe the settings of the deprecated action that the exec
+	// action doesn't preserve exactly.
+	Notes []string
+}
+
+// Migration is the result of replacing the deprecated actions of a definition.
+type Migration struct {
+	Definition Definition        // the definition with the deprecated actions replaced
+	Actions    []ActionMigration // the replaced actions
+	Changes    []FieldChange     // the differences between the original and the migrated definition
+}
+
+// Migrated reports whether any action was replaced.
+func (m Migration) Migrated() bool {
+	return len(m.Actions) != 0
+}
+
+// MigrateDeprecatedActions replaces the email and show message actions of def,
+// which Windows 8 and later don't run, with exec actions that run PowerShell.
+// Email actions are replaced with Send-MailMessage, and show message actions
+// with a message box or msg.exe. def isn't changed; the migrated definition is
+// returned along with the replaced actions and the differences between the
+// definitions, so migrations can be previewed before they are applied. The
+// XMLText of the migrated definition is cleared if any action was replaced.
+func MigrateDeprecatedActions(def Definition, opts MigrateOptions) Migration {
+	migration := Migration{Definition: def}
+	if def.Actions != nil {
+		migration.Definition.Actions = make([]Action, len(def.Actions))
+		copy(migration.Definition.Actions, def.Actions)
+	}
+
+	for i, action := range def.Actions {
+		var (
+			execAction ExecAction
+			notes      []string
+		)
+		switch a := action.(type) {
+		case EmailAction:
+			execAction, notes = migrateEmailAction(a)
+		case ShowMessageAction:
+			execAction = migrateShowMessageAction(a, opts.UseMsg)
+		default:
+			continue
+		}
+
+		migration.Definition.Actions[i] = execAction
+		migration.Actions = append(migration.Actions, ActionMigration{
+			Index: i,
+			Old:   action,
+			New:   execAction,
+			Notes: notes,
+		})
+	}
+
+	if migration.Migrated() {
+		// the XML of def doesn't have the migrated actions
+		migration.Definition.XMLText = ""
+
+		compatibility := opts.Compatibility
+		if compatibility == TASK_COMPATIBILITY_AT {
+			compatibility = TASK_COMPATIBILITY_V2_2
+		}
+		if migration.Definition.Settings.Compatibility < compatibility {
+			migration.Definition.Settings.Compatibility = compatibility
+		}
+	}
+	migration.Changes = DiffDefinitions(def, migration.Definition)
+
+	return migration
+}
+
+func migrateEmailAction(action EmailAction) (ExecAction, []string) {
+	var notes []string
+	command := []string{"Send-MailMessage", "-SmtpServer", quotePowerShellString(action.Server), "-Encoding", "UTF8"}
+
+	if action.From != "" {
+		command = append(command, "-From", quotePowerShellString(action.From))
+	} else {
+		notes = append(notes, "Send-MailMessage requires a sender, but the action has none")
+	}
+	if to := quotePowerShellAddresses(action.To); to != "" {
+		command = append(command, "-To", to)
+	} else {
+		notes = append(notes, "Send-MailMessage requires a recipient, but the action has none in To")
+	}
+	if cc := quotePowerShellAddresses(action.Cc); cc != "" {
+		command = append(command, "-Cc", cc)
+	}
+	if bcc := quotePowerShellAddresses(action.Bcc); bcc != "" {
+		command = append(command, "-Bcc", bcc)
+	}
+
+	subject := action.Subject
+	if subject == "" {
+		subject = " "
+		notes = append(notes, "the empty subject was replaced with a space, as Send-MailMessage requires a subject")
+	}
+	command = append(command, "-Subject", quotePowerShellString(subject))
+	if action.Body != "" {
+		command = append(command, "-Body", quotePowerShellString(action.Body))
+	}
+	if len(action.Attachments) != 0 {
+		attachments := make([]string, len(action.Attachments))
+		for i, attachment := range action.Attachments {
+			attachments[i] = quotePowerShellString(attachment)
+		}
+		command = append(command, "-Attachments", strings.Join(attachments, ","))
+	}
+
+	if action.ReplyTo != "" {
+		notes = append(notes, fmt.Sprintf("the reply-to address %s was dropped, as Send-MailMessage doesn't support it", action.ReplyTo))
+	}
+	names := make([]string, 0, len(action.HeaderFields))
+	for name := range action.HeaderFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		notes = append(notes, fmt.Sprintf("the header field %s was dropped, as Send-MailMessage doesn't support header fields", name))
+	}
+
+	return powerShellAction(action.ID, strings.Join(command, " ")), notes
+}
+
+func migrateShowMessageAction(action ShowMessageAction, useMsg bool) ExecAction {
+	if useMsg {
+		message := action.Body
+		if action.Title != "" {
+			message = action.Title + "\r\n\r\n" + message
+		}
+
+		return ExecAction{
+			ID:   action.ID,
+			Path: "msg.exe",
+			Args: "* " + escapeWindowsArg(message),
+		}
+	}
+
+	command := fmt.Sprintf("Add-Type -AssemblyName System.Windows.Forms; [System.Windows.Forms.MessageBox]::Show(%s, %s) | Out-Null",
+		quotePowerShellString(action.Body), quotePowerShellString(action.Title))
+
+	return powerShellAction(action.ID, command)
+}
+
+// powerShellAction returns an exec action that runs command with PowerShell.
+func powerShellAction(id, command string) ExecAction {
+	return ExecAction{
+		ID:   id,
+		Path: "powershell.exe",
+		Args: "-NoProfile -NonInteractive -Command " + escapeWindowsArg(command),
+	}
+}
+
+// quotePowerShellString quotes s as a verbatim PowerShell string. PowerShell
+// treats typographic single quotes like ', so they are escaped as well.
+func quotePowerShellString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\'', '‘', '’', '‚', '‛':
+			sb.WriteRune(r)
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteByte('\'')
+
+	return sb.String()
+}
+
+// quotePowerShellAddresses quotes the semicolon or comma separated addresses
+// as a PowerShell array of strings.
+func quotePowerShellAddresses(addresses string) string {
+	var quoted []string
+	for _, address := range strings.FieldsFunc(addresses, func(r rune) bool { return r == ';' || r == ',' }) {
+		if address = strings.TrimSpace(address); address != "" {
+			quoted = append(quoted, quotePowerShellString(address))
+		}
+	}
+
+	return strings.Join(quoted, ",")
+}
+
+// escapeWindowsArg quotes s so that it is parsed as a single argument by
+// CommandLineToArgvW, the way syscall.EscapeArg does on Windows.
+func escapeWindowsArg(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	slashes := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			slashes++
+		case '"':
+			// backslashes before a quote are escaped, and so is the quote
+			sb.WriteString(strings.Repeat(`\`, slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		sb.WriteByte(c)
+	}
+	// backslashes before the closing quote are escaped
+	sb.WriteString(strings.Repeat(`\`, slashes))
+	sb.WriteByte('"')
+
+	return sb.String()
+}
@@ -0,0 +1,127 @@
+package taskmaster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigrateDeprecatedActions(t *testing.T) {
+	def := Definition{
+		Actions: []Action{
+			ExecAction{Path: "backup.exe"},
+			EmailAction{
+				ID:           "mail",
+				Server:       "smtp.example.com",
+				From:         "backup@example.com",
+				To:           "ops@example.com; dev@example.com",
+				Subject:      "Backup of O'Brien's files",
+				Body:         `Saved to "C:\Backups\"`,
+				ReplyTo:      "noreply@example.com",
+				HeaderFields: map[string]string{"X-Priority": "1"},
+				Attachments:  []string{`C:\Logs\backup.log`},
+			},
+			ShowMessageAction{Title: "Backup", Body: "It's done"},
+		},
+		Settings: TaskSettings{Compatibility: TASK_COMPATIBILITY_V2_1},
+		XMLText:  "<Task />",
+	}
+
+	migration := MigrateDeprecatedActions(def, MigrateOptions{})
+	if !migration.Migrated() || len(migration.Actions) != 2 {
+		t.Fatalf("expected 2 migrated actions, got %+v", migration.Actions)
+	}
+
+	email := migration.Actions[0]
+	if email.Index != 1 || !reflect.DeepEqual(email.Old, def.Actions[1]) {
+		t.Errorf("unexpected migration %+v", email)
+	}
+	expectedEmail := ExecAction{
+		ID:   "mail",
+		Path: "powershell.exe",
+		Args: `-NoProfile -NonInteractive -Command "Send-MailMessage -SmtpServer 'smtp.example.com' -Encoding UTF8 -From 'backup@example.com' -To 'ops@example.com','dev@example.com' -Subject 'Backup of O''Brien''s files' -Body 'Saved to \"C:\Backups\\\"' -Attachments 'C:\Logs\backup.log'"`,
+	}
+	if email.New != expectedEmail {
+		t.Errorf("expected %+v, got %+v", expectedEmail, email.New)
+	}
+	if len(email.Notes) != 2 {
+		t.Errorf("expected notes about the reply-to address and header field, got %v", email.Notes)
+	}
+
+	message := migration.Actions[1]
+	expectedMessage := ExecAction{
+		Path: "powershell.exe",
+		Args: `-NoProfile -NonInteractive -Command "Add-Type -AssemblyName System.Windows.Forms; [System.Windows.Forms.MessageBox]::Show('It''s done', 'Backup') | Out-Null"`,
+	}
+	if message.Index != 2 || message.New != expectedMessage || len(message.Notes) != 0 {
+		t.Errorf("unexpected migration %+v", message)
+	}
+
+	if migration.Definition.Actions[0] != def.Actions[0] || migration.Definition.Actions[1] != expectedEmail || migration.Definition.Actions[2] != expectedMessage {
+		t.Errorf("unexpected migrated actions %+v", migration.Definition.Actions)
+	}
+	if migration.Definition.Settings.Compatibility != TASK_COMPATIBILITY_V2_2 {
+		t.Errorf("expected compatibility to be raised to %v, got %v", TASK_COMPATIBILITY_V2_2, migration.Definition.Settings.Compatibility)
+	}
+	if migration.Definition.XMLText != "" {
+		t.Errorf("expected stale XML to be cleared, got %q", migration.Definition.XMLText)
+	}
+	if _, ok := def.Actions[1].(EmailAction); !ok || def.Settings.Compatibility != TASK_COMPATIBILITY_V2_1 || def.XMLText == "" {
+		t.Error("the original definition was changed")
+	}
+
+	var fields []string
+	for _, change := range migration.Changes {
+		fields = append(fields, change.Field)
+	}
+	if expected := []string{"Actions[1]", "Actions[2]", "Settings.Compatibility"}; !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected changes of %v, got %v", expected, fields)
+	}
+}
+
+func TestMigrateDeprecatedActionsOptions(t *testing.T) {
+	def := Definition{
+		Actions: []Action{
+			ShowMessageAction{Title: "Backup", Body: `Saved to "D:\"`},
+			EmailAction{Server: "smtp"},
+		},
+		Settings: TaskSettings{Compatibility: TASK_COMPATIBILITY_V2_4},
+	}
+
+	migration := MigrateDeprecatedActions(def, MigrateOptions{UseMsg: true, Compatibility: TASK_COMPATIBILITY_V2_3})
+	expectedMessage := ExecAction{
+		Path: "msg.exe",
+		Args: "* \"Backup\r\n\r\nSaved to \\\"D:\\\\\\\"\"",
+	}
+	if migration.Actions[0].New != expectedMessage {
+		t.Errorf("expected %+v, got %+v", expectedMessage, migration.Actions[0].New)
+	}
+	if notes := migration.Actions[1].Notes; len(notes) != 3 {
+		t.Errorf("expected notes about the sender, recipient and subject, got %v", notes)
+	}
+	if migration.Definition.Settings.Compatibility != TASK_COMPATIBILITY_V2_4 {
+		t.Errorf("expected compatibility to stay %v, got %v", TASK_COMPATIBILITY_V2_4, migration.Definition.Settings.Compatibility)
+	}
+
+	unchanged := Definition{Actions: []Action{ExecAction{Path: "cmd.exe"}}}
+	if migration := MigrateDeprecatedActions(unchanged, MigrateOptions{}); migration.Migrated() || len(migration.Changes) != 0 || migration.Definition.Settings.Compatibility != TASK_COMPATIBILITY_AT {
+		t.Errorf("expected definition without deprecated actions to be unchanged, got %+v", migration)
+	}
+}
+
+func TestEscapeWindowsArg(t *testing.T) {
+	tests := []struct {
+		arg, escaped string
+	}{
+		{"", `""`},
+		{"a b", `"a b"`},
+		{`C:\dir\`, `"C:\dir\\"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`a\"b`, `"a\\\"b"`},
+	}
+
+	for _, tt := range tests {
+		if escaped := escapeWindowsArg(tt.arg); escaped != tt.escaped {
+			t.Errorf("%q: expected %s, got %s", tt.arg, tt.escaped, escaped)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package taskmaster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MoveOptions changes how tasks are moved and copied.
+type MoveOptions struct {
+	// Passwords are the passwords of the users that tasks with the
+	// TASK_LOGON_PASSWORD or TASK_LOGON_INTERACTIVE_TOKEN_OR_PASSWORD logon
+	// types run as, keyed by user ID. The Task Scheduler service doesn't
+	// reveal the passwords of registered tasks, so they have to be given to
+	// register the tasks again. User IDs are compared case insensitively.
+	Passwords map[string]string
+}
+
+// password returns the password of userID.
+func (o MoveOptions) password(userID string) (string, bool) {
+	return lookupPassword(o.Passwords, userID)
+}
+
+// lookupPassword returns the password of userID in passwords, comparing user
+// IDs case insensitively.
+func lookupPassword(passwords map[string]string, userID string) (string, bool) {
+	for user, password := range passwords {
+		if strings.EqualFold(user, userID) {
+			return password, true
+		}
+	}
+
+	return "", false
+}
+
+// MoveError is returned when moving or copying tasks fails after changes were
+// made. The changes are rolled back, and Created, Deleted and Folders describe
+// the changes that couldn't be rolled back. If all of them are empty, the
+// tasks were left as they were before.
+type MoveError struct {
+	Err         error    // the error that caused the move or copy to fail
+	RollbackErr error    // the first error that occurred while rolling back, if any
+	Created     []string // the paths of the tasks that were created and couldn't be deleted
+	Deleted     []string // the paths of the tasks that were deleted and couldn't be restored
+	Folders     []string // the paths of the folders that were created and couldn't be deleted
+}
+
+func (e *MoveError) Error() string {
+	if e.RollbackErr == nil {
+		return fmt.Sprintf("%v; changes were rolled back", e.Err)
+	}
+
+	return fmt.Sprintf("%v; error rolling back: %v; left created: %v; left deleted: %v; left folders: %v", e.Err, e.RollbackErr, e.Created, e.Deleted, e.Folders)
+}
+
+// taskMover is the part of TaskService used to move and copy tasks.
+type taskMover interface {
+	GetRegisteredTaskContext(ctx context.Context, path string) (RegisteredTask, error)
+	GetTaskFolderContext(ctx context.Context, path string) (TaskFolder, error)
+	DeleteTaskContext(ctx context.Context, path string) error
+	DeleteFolderContext(ctx context.Context, path string, deleteRecursively bool) (bool, error)
+	// folderExists returns whether the folder at path exists.
+	folderExists(ctx context.Context, path string) (bool, error)
+	// getSecurityDescriptor returns the SDDL of the security descriptor of
+	// the task at path, with its owner, group, DACL and, if the caller is
+	// allowed to read it, SACL.
+	getSecurityDescriptor(ctx context.Context, path string) (string, error)
+	// registerTask creates a task with the security descriptor sddl,
+	// creating its folder if needed. It fails if the task exists.
+	registerTask(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType, sddl string) (RegisteredTask, error)
+}
+
+// movedTask is a task that is moved or copied.
+type movedTask struct {
+	task    RegisteredTask
+	sddl    string
+	dstPath string
+}
+
+// prepareMove gets the security descriptor of task and checks that the
+// credentials it needs are known.
+func prepareMove(ctx context.Context, svc taskMover, task RegisteredTask, dstPath string, opts MoveOptions) (movedTask, error) {
+	if err := TaskPath(dstPath).Validate(); err != nil {
+		return movedTask{}, err
+	}
+	if logonTypeNeedsPassword(task.Definition.Principal.LogonType) {
+		if _, ok := opts.password(task.Definition.Principal.UserID); !ok {
+			return movedTask{}, fmt.Errorf("password of user %s is needed to register task %s", task.Definition.Principal.UserID, task.Path)
+		}
+	}
+
+	sddl, err := svc.getSecurityDescriptor(ctx, task.Path)
+	if err != nil {
+		return movedTask{}, fmt.Errorf("error getting security descriptor of task %s: %v", task.Path, err)
+	}
+
+	return movedTask{task: task, sddl: sddl, dstPath: dstPath}, nil
+}
+
+func logonTypeNeedsPassword(logonType TaskLogonType) bool {
+	return logonType == TASK_LOGON_PASSWORD || logonType == TASK_LOGON_INTERACTIVE_TOKEN_OR_PASSWORD
+}
+
+// register registers the moved task at path, preserving its enabled state,
+// principal and security descriptor.
+func (m movedTask) register(ctx context.Context, svc taskMover, path string, opts MoveOptions) (RegisteredTask, error) {
+	def := m.task.Definition
+	def.Settings.Enabled = m.task.Enabled
+
+	var username, password string
+	if logonTypeNeedsPassword(def.Principal.LogonType) {
+		username = def.Principal.UserID
+		password, _ = opts.password(username)
+	}
+
+	return svc.registerTask(ctx, path, def, username, password, def.Principal.LogonType, m.sddl)
+}
+
+// missingFolders returns the paths of the folders of the destination paths
+// of tasks that don't exist yet, deepest first.
+func missingFolders(ctx context.Context, svc taskMover, tasks []movedTask) ([]string, error) {
+	checked := make(map[string]bool)
+	var missing []TaskPath
+	for _, m := range tasks {
+		for folder := NormalizeTaskPath(m.dstPath).Folder(); !folder.IsRoot(); folder = folder.Folder() {
+			key := strings.ToLower(folder.String())
+			if checked[key] {
+				// its parents were checked too
+				break
+			}
+			checked[key] = true
+
+			exists, err := svc.folderExists(ctx, folder.String())
+			if err != nil {
+				return nil, fmt.Errorf("error checking folder %s: %v", folder, err)
+			}
+			if exists {
+				break
+			}
+			missing = append(missing, folder)
+		}
+	}
+
+	sort.SliceStable(missing, func(i, j int) bool {
+		return len(missing[i].Elements()) > len(missing[j].Elements())
+	})
+	paths := make([]string, len(missing))
+	for i, folder := range missing {
+		paths[i] = folder.String()
+	}
+
+	return paths, nil
+}
+
+// deleteFolders deletes the folders that were created for moved tasks,
+// which are ordered deepest first. Folders that can't be deleted or aren't
+// empty are added to moveErr.
+func deleteFolders(ctx context.Context, svc taskMover, folders []string, moveErr *MoveError) {
+	for _, folder := range folders {
+		deleted, err := svc.DeleteFolderContext(ctx, folder, false)
+		if err == nil && !deleted {
+			err = fmt.Errorf("folder isn't empty")
+		}
+		if err != nil {
+			if moveErr.RollbackErr == nil {
+				moveErr.RollbackErr = fmt.Errorf("error deleting folder %s: %v", folder, err)
+			}
+			moveErr.Folders = append(moveErr.Folders, folder)
+		}
+	}
+}
+
+// copyTasks registers every task at its destination path, and returns the
+// registered tasks and the paths of the folders that were created for them,
+// deepest first. If a task can't be registered, the tasks registered so far
+// and the created folders are deleted.
+func copyTasks(ctx context.Context, svc taskMover, tasks []movedTask, opts MoveOptions) (RegisteredTaskCollection, []string, error) {
+	newFolders, err := missingFolders(ctx, svc, tasks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var created RegisteredTaskCollection
+	for _, m := range tasks {
+		newTask, err := m.register(ctx, svc, m.dstPath, opts)
+		if err == nil {
+			created = append(created, newTask)
+			continue
+		}
+
+		moveErr := &MoveError{Err: fmt.Errorf("error registering task %s at %s: %v", m.task.Path, m.dstPath, err)}
+		// roll back even if ctx is canceled
+		rollbackCtx := context.Background()
+		for _, task := range created {
+			task.Release()
+			if err := svc.DeleteTaskContext(rollbackCtx, task.Path); err != nil {
+				if moveErr.RollbackErr == nil {
+					moveErr.RollbackErr = fmt.Errorf("error deleting task %s: %v", task.Path, err)
+				}
+				moveErr.Created = append(moveErr.Created, task.Path)
+			}
+		}
+		deleteFolders(rollbackCtx, svc, newFolders, moveErr)
+		if len(created) == 0 && moveErr.RollbackErr == nil {
+			return nil, nil, moveErr.Err
+		}
+		return nil, nil, moveErr
+	}
+
+	return created, newFolders, nil
+}
+
+// moveTasks copies every task to its destination path and then deletes the
+// original tasks. If a task can't be deleted, the deleted tasks are restored
+// and the copies and the folders created for them are deleted.
+func moveTasks(ctx context.Context, svc taskMover, tasks []movedTask, opts MoveOptions) (RegisteredTaskCollection, error) {
+	created, newFolders, err := copyTasks(ctx, svc, tasks, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range tasks {
+		err := svc.DeleteTaskContext(ctx, m.task.Path)
+		if err == nil {
+			continue
+		}
+
+		moveErr := &MoveError{Err: fmt.Errorf("error deleting task %s: %v", m.task.Path, err)}
+		rollbackCtx := context.Background()
+		for _, deleted := range tasks[:i] {
+			restored, err := deleted.register(rollbackCtx, svc, deleted.task.Path, opts)
+			if err != nil {
+				if moveErr.RollbackErr == nil {
+					moveErr.RollbackErr = fmt.Errorf("error restoring task %s: %v", deleted.task.Path, err)
+				}
+				moveErr.Deleted = append(moveErr.Deleted, deleted.task.Path)
+				// keep the copy so the task isn't lost
+				continue
+			}
+			restored.Release()
+			if err := svc.DeleteTaskContext(rollbackCtx, deleted.dstPath); err != nil {
+				if moveErr.RollbackErr == nil {
+					moveErr.RollbackErr = fmt.Errorf("error deleting task %s: %v", deleted.dstPath, err)
+				}
+				moveErr.Created = append(moveErr.Created, deleted.dstPath)
+			}
+		}
+		for _, copied := range tasks[i:] {
+			if err := svc.DeleteTaskContext(rollbackCtx, copied.dstPath); err != nil {
+				if moveErr.RollbackErr == nil {
+					moveErr.RollbackErr = fmt.Errorf("error deleting task %s: %v", copied.dstPath, err)
+				}
+				moveErr.Created = append(moveErr.Created, copied.dstPath)
+			}
+		}
+		deleteFolders(rollbackCtx, svc, newFolders, moveErr)
+		created.Release()
+
+		return nil, moveErr
+	}
+
+	return created, nil
+}
+
+// moveTask moves or copies the task at srcPath to dstPath.
+func moveTask(ctx context.Context, svc taskMover, srcPath, dstPath string, opts MoveOptions, keepSource bool) (RegisteredTask, error) {
+	if TaskPath(srcPath).Equal(TaskPath(dstPath)) {
+		return RegisteredTask{}, fmt.Errorf("source and destination of task %s are the same", srcPath)
+	}
+
+	task, err := svc.GetRegisteredTaskContext(ctx, srcPath)
+	if err != nil {
+		return RegisteredTask{}, err
+	}
+	defer task.Release()
+
+	m, err := prepareMove(ctx, svc, task, dstPath, opts)
+	if err != nil {
+		return RegisteredTask{}, err
+	}
+
+	var tasks RegisteredTaskCollection
+	if keepSource {
+		tasks, _, err = copyTasks(ctx, svc, []movedTask{m}, opts)
+	} else {
+		tasks, err = moveTasks(ctx, svc, []movedTask{m}, opts)
+	}
+	if err != nil {
+		return RegisteredTask{}, err
+	}
+
+	return tasks[0], nil
+}
+
+// moveFolder moves the tasks of the folder at srcPath and its subfolders to
+// the folder at dstPath, and then deletes the folder at srcPath and its
+// subfolders if they're empty. Only the moved tasks are deleted, so tasks and
+// folders created in srcPath during the move are kept.
+func moveFolder(ctx context.Context, svc taskMover, srcPath, dstPath string, opts MoveOptions) error {
+	src, dst := NormalizeTaskPath(srcPath), NormalizeTaskPath(dstPath)
+	if src.IsRoot() {
+		return fmt.Errorf("the root folder can't be moved")
+	}
+	if dst.HasPrefix(src) {
+		return fmt.Errorf("folder %s can't be moved into itself", src)
+	}
+
+	folder, err := svc.GetTaskFolderContext(ctx, src.String())
+	if err != nil {
+		return err
+	}
+	defer folder.Release()
+
+	var tasks []movedTask
+	for _, task := range folder.Flatten() {
+		relPath := string(NormalizeTaskPath(task.Path)[len(src):])
+		m, err := prepareMove(ctx, svc, task, dst.Join(relPath).String(), opts)
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, m)
+	}
+
+	created, err := moveTasks(ctx, svc, tasks, opts)
+	if err != nil {
+		return err
+	}
+	created.Release()
+
+	var folders []string
+	folder.Walk(func(f *TaskFolder) error {
+		folders = append(folders, f.Path)
+		return nil
+	})
+	// delete subfolders before their parents
+	var notEmpty string
+	for i := len(folders) - 1; i >= 0; i-- {
+		deleted, err := svc.DeleteFolderContext(ctx, folders[i], false)
+		if err != nil {
+			return fmt.Errorf("tasks were moved to %s, but error deleting folder %s: %v", dst, folders[i], err)
+		}
+		if !deleted && notEmpty == "" {
+			notEmpty = folders[i]
+		}
+	}
+	if notEmpty != "" {
+		return fmt.Errorf("tasks were moved to %s, but folder %s wasn't deleted because it isn't empty", dst, notEmpty)
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package taskmaster
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func (f *fakeScheduler) paths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var paths []string
+	for path := range f.tasks {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+func TestMoveTask(t *testing.T) {
+	disabled := newFakeTask(`\Folder\Disabled`)
+	disabled.Enabled = false
+	withPassword := newFakeTask(`\Folder\Password`)
+	withPassword.Definition.Principal = Principal{UserID: `CORP\svc`, LogonType: TASK_LOGON_PASSWORD}
+
+	svc := newFakeScheduler(disabled, withPassword)
+	svc.sds[disabled.Path] = "D:(A;;FA;;;BA)"
+	ctx := context.Background()
+
+	task, err := moveTask(ctx, svc, disabled.Path, `\Other\Renamed`, MoveOptions{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Enabled || svc.tasks[`\Other\Renamed`].Enabled {
+		t.Error("expected moved task to stay disabled")
+	}
+	if sd := svc.sds[`\Other\Renamed`]; sd != "D:(A;;FA;;;BA)" {
+		t.Errorf("expected security descriptor to be preserved, got %q", sd)
+	}
+	if paths := svc.paths(); !reflect.DeepEqual(paths, []string{`\Folder\Password`, `\Other\Renamed`}) {
+		t.Errorf("unexpected tasks %v", paths)
+	}
+
+	if _, err := moveTask(ctx, svc, withPassword.Path, `\Folder\Copy`, MoveOptions{}, true); err == nil {
+		t.Error("expected error copying a password task without its password")
+	}
+	opts := MoveOptions{Passwords: map[string]string{`corp\SVC`: "hunter2"}}
+	if _, err := moveTask(ctx, svc, withPassword.Path, `\Folder\Copy`, opts, true); err != nil {
+		t.Fatal(err)
+	}
+	if password := svc.passwords[`\Folder\Copy`]; password != "hunter2" {
+		t.Errorf("expected task to be registered with password, got %q", password)
+	}
+	if paths := svc.paths(); !reflect.DeepEqual(paths, []string{`\Folder\Copy`, `\Folder\Password`, `\Other\Renamed`}) {
+		t.Errorf("unexpected tasks %v", paths)
+	}
+
+	if _, err := moveTask(ctx, svc, `\Folder\Copy`, `\folder\copy`, opts, false); err == nil {
+		t.Error("expected error moving a task to itself")
+	}
+	if _, err := moveTask(ctx, svc, `\Folder\Copy`, `\Folder\Password`, opts, false); err == nil {
+		t.Error("expected error moving a task over an existing task")
+	}
+}
+
+func TestMoveTaskRollback(t *testing.T) {
+	svc := newFakeScheduler(newFakeTask(`\Task`))
+	ctx := context.Background()
+
+	// the source can't be deleted, so the copy is deleted
+	failing := &failingDeleteScheduler{fakeScheduler: svc, failDelete: map[string]bool{`\Task`: true}}
+	_, err := moveTask(ctx, failing, `\Task`, `\Moved`, MoveOptions{}, false)
+	moveErr, ok := err.(*MoveError)
+	if !ok {
+		t.Fatalf("expected *MoveError, got %v", err)
+	}
+	if moveErr.RollbackErr != nil || len(moveErr.Created) != 0 || len(moveErr.Deleted) != 0 {
+		t.Errorf("expected clean rollback, got %v", moveErr)
+	}
+	if paths := svc.paths(); !reflect.DeepEqual(paths, []string{`\Task`}) {
+		t.Errorf("unexpected tasks %v", paths)
+	}
+
+	// neither the source nor the copy can be deleted
+	failing.failDelete[`\Moved`] = true
+	_, err = moveTask(ctx, failing, `\Task`, `\Moved`, MoveOptions{}, false)
+	moveErr, ok = err.(*MoveError)
+	if !ok {
+		t.Fatalf("expected *MoveError, got %v", err)
+	}
+	if moveErr.RollbackErr == nil || !reflect.DeepEqual(moveErr.Created, []string{`\Moved`}) {
+		t.Errorf("expected copy to be left behind, got %v", moveErr)
+	}
+}
+
+func TestMoveFolder(t *testing.T) {
+	svc := newFakeScheduler(
+		newFakeTask(`\Src\A`),
+		newFakeTask(`\Src\Sub\B`),
+		newFakeTask(`\Other\C`),
+	)
+	ctx := context.Background()
+
+	if err := moveFolder(ctx, svc, `\Src`, `\Src\Sub`, MoveOptions{}); err == nil {
+		t.Error("expected error moving a folder into itself")
+	}
+
+	if err := moveFolder(ctx, svc, `\Src`, `\Dst`, MoveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`\Dst\A`, `\Dst\Sub\B`, `\Other\C`}
+	if paths := svc.paths(); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+
+	// the second task can't be registered, so the first copy is deleted
+	svc.failPaths[`\New\Sub\B`] = errors.New("invalid definition")
+	err := moveFolder(ctx, svc, `\Dst`, `\New`, MoveOptions{})
+	moveErr, ok := err.(*MoveError)
+	if !ok || moveErr.RollbackErr != nil {
+		t.Fatalf("expected rolled back *MoveError, got %v", err)
+	}
+	if paths := svc.paths(); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+	if svc.folders[`\New`] || svc.folders[`\New\Sub`] {
+		t.Errorf("expected created folders to be deleted, got %v", svc.folders)
+	}
+
+	// the second source task can't be deleted, so the first is restored
+	delete(svc.failPaths, `\New\Sub\B`)
+	failing := &failingDeleteScheduler{fakeScheduler: svc, failDelete: map[string]bool{`\Dst\Sub\B`: true}}
+	err = moveFolder(ctx, failing, `\Dst`, `\New`, MoveOptions{})
+	moveErr, ok = err.(*MoveError)
+	if !ok || moveErr.RollbackErr != nil {
+		t.Fatalf("expected rolled back *MoveError, got %v", err)
+	}
+	if paths := svc.paths(); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+	if svc.folders[`\New`] || svc.folders[`\New\Sub`] {
+		t.Errorf("expected created folders to be deleted, got %v", svc.folders)
+	}
+	if svc.folders[`\Src`] {
+		t.Error("expected moved folder to be deleted")
+	}
+
+	// a task added to the source during the move is kept with its folder
+	adding := &addingScheduler{fakeScheduler: svc, add: newFakeTask(`\Dst\Late`)}
+	if err := moveFolder(ctx, adding, `\Dst`, `\New`, MoveOptions{}); err == nil {
+		t.Error("expected error deleting a folder that isn't empty")
+	}
+	expected = []string{`\Dst\Late`, `\New\A`, `\New\Sub\B`, `\Other\C`}
+	if paths := svc.paths(); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+	if !svc.folders[`\Dst`] || svc.folders[`\Dst\Sub`] {
+		t.Errorf("expected only the folder that isn't empty to be kept, got %v", svc.folders)
+	}
+}
+
+// addingScheduler is a fakeScheduler that adds a task when the first task
+// is registered.
+type addingScheduler struct {
+	*fakeScheduler
+	add   RegisteredTask
+	added bool
+}
+
+func (a *addingScheduler) registerTask(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType, sddl string) (RegisteredTask, error) {
+	if !a.added {
+		a.added = true
+		a.setTask(a.add)
+	}
+
+	return a.fakeScheduler.registerTask(ctx, path, newTaskDef, username, password, logonType, sddl)
+}
+
+// failingDeleteScheduler is a fakeScheduler whose deletes of specific tasks
+// fail, while other operations on them succeed.
+type failingDeleteScheduler struct {
+	*fakeScheduler
+	failDelete map[string]bool
+}
+
+func (f *failingDeleteScheduler) DeleteTaskContext(ctx context.Context, path string) error {
+	if f.failDelete[path] {
+		return errors.New("access denied")
+	}
+
+	return f.fakeScheduler.DeleteTaskContext(ctx, path)
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/go-ole/go-ole/oleutil"
 )
 
-func parseRunningTask(task *ole.IDispatch) (RunningTask, error) {
+func parseRunningTask(task *ole.IDispatch, d *dispatcher) (RunningTask, error) {
 	var err error
 
 	currentAction, err := oleutil.GetProperty(task, "CurrentAction")
@@ -40,6 +40,7 @@ func parseRunningTask(task *ole.IDispatch) (RunningTask, error) {
 	}
 
 	runningTask := RunningTask{
+		dispatcher:    d,
 		taskObj:       task,
 		CurrentAction: currentAction.ToString(),
 		EnginePID:     uint(enginePID.Val),
@@ -52,17 +53,28 @@ func parseRunningTask(task *ole.IDispatch) (RunningTask, error) {
 	return runningTask, nil
 }
 
-func parseRegisteredTask(task *ole.IDispatch) (RegisteredTask, string, error) {
+// parseRegisteredTaskSummary parses the properties of a registered task
+// without parsing its definition.
+func parseRegisteredTaskSummary(task *ole.IDispatch, d *dispatcher) RegisteredTask {
+	return RegisteredTask{
+		dispatcher:     d,
+		taskObj:        task,
+		Name:           oleutil.MustGetProperty(task, "Name").ToString(),
+		Path:           oleutil.MustGetProperty(task, "Path").ToString(),
+		Enabled:        oleutil.MustGetProperty(task, "Enabled").Value().(bool),
+		State:          TaskState(oleutil.MustGetProperty(task, "State").Val),
+		MissedRuns:     uint(oleutil.MustGetProperty(task, "NumberOfMissedRuns").Val),
+		NextRunTime:    oleutil.MustGetProperty(task, "NextRunTime").Value().(time.Time),
+		LastRunTime:    oleutil.MustGetProperty(task, "LastRunTime").Value().(time.Time),
+		LastTaskResult: TaskResult(oleutil.MustGetProperty(task, "LastTaskResult").Val),
+	}
+}
+
+func parseRegisteredTask(task *ole.IDispatch, d *dispatcher) (RegisteredTask, string, error) {
 	var err error
 
-	name := oleutil.MustGetProperty(task, "Name").ToString()
-	path := oleutil.MustGetProperty(task, "Path").ToString()
-	enabled := oleutil.MustGetProperty(task, "Enabled").Value().(bool)
-	state := TaskState(oleutil.MustGetProperty(task, "State").Val)
-	missedRuns := uint(oleutil.MustGetProperty(task, "NumberOfMissedRuns").Val)
-	nextRunTime := oleutil.MustGetProperty(task, "NextRunTime").Value().(time.Time)
-	lastRunTime := oleutil.MustGetProperty(task, "LastRunTime").Value().(time.Time)
-	lastTaskResult := TaskResult(oleutil.MustGetProperty(task, "LastTaskResult").Val)
+	registeredTask := parseRegisteredTaskSummary(task, d)
+	path := registeredTask.Path
 
 	definition := oleutil.MustGetProperty(task, "Definition").ToIDispatch()
 	defer definition.Release()
@@ -126,6 +138,13 @@ func parseRegisteredTask(task *ole.IDispatch) (RegisteredTask, string, error) {
 		return RegisteredTask{}, path, fmt.Errorf("error parsing ITrigger object: %v", err)
 	}
 
+	// custom triggers are only defined in the XML of the task
+	xmlText := oleutil.MustGetProperty(definition, "XmlText").ToString()
+	taskTriggers, err = resolveCustomTriggers(taskTriggers, xmlText)
+	if err != nil {
+		return RegisteredTask{}, path, fmt.Errorf("error parsing custom triggers: %v", err)
+	}
+
 	taskDef := Definition{
 		Actions:          taskActions,
 		Context:          context,
@@ -133,20 +152,10 @@ func parseRegisteredTask(task *ole.IDispatch) (RegisteredTask, string, error) {
 		Settings:         *taskSettings,
 		RegistrationInfo: *registrationInfo,
 		Triggers:         taskTriggers,
+		XMLText:          xmlText,
 	}
 
-	registeredTask := RegisteredTask{
-		taskObj:        task,
-		Name:           name,
-		Path:           path,
-		Definition:     taskDef,
-		Enabled:        enabled,
-		State:          state,
-		MissedRuns:     missedRuns,
-		NextRunTime:    nextRunTime,
-		LastRunTime:    lastRunTime,
-		LastTaskResult: lastTaskResult,
-	}
+	registeredTask.Definition = taskDef
 
 	return registeredTask, path, nil
 }
@@ -180,11 +189,75 @@ func parseTaskAction(action *ole.IDispatch) (Action, error) {
 		}
 
 		return comHandlerAction, nil
+	case TASK_ACTION_SEND_EMAIL:
+		headerFieldsObj := oleutil.MustGetProperty(action, "HeaderFields").ToIDispatch()
+		defer headerFieldsObj.Release()
+
+		headerFields := make(map[string]string)
+		err := oleutil.ForEach(headerFieldsObj, func(v *ole.VARIANT) error {
+			headerField := v.ToIDispatch()
+			defer headerField.Release()
+
+			name := oleutil.MustGetProperty(headerField, "Name").ToString()
+			value := oleutil.MustGetProperty(headerField, "Value").ToString()
+
+			headerFields[name] = value
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error parsing header fields: %v", err)
+		}
+
+		emailAction := EmailAction{
+			ID:           id,
+			Server:       oleutil.MustGetProperty(action, "Server").ToString(),
+			From:         oleutil.MustGetProperty(action, "From").ToString(),
+			To:           oleutil.MustGetProperty(action, "To").ToString(),
+			Cc:           oleutil.MustGetProperty(action, "Cc").ToString(),
+			Bcc:          oleutil.MustGetProperty(action, "Bcc").ToString(),
+			ReplyTo:      oleutil.MustGetProperty(action, "ReplyTo").ToString(),
+			Subject:      oleutil.MustGetProperty(action, "Subject").ToString(),
+			Body:         oleutil.MustGetProperty(action, "Body").ToString(),
+			HeaderFields: headerFields,
+			Attachments:  variantToStrings(oleutil.MustGetProperty(action, "Attachments")),
+		}
+
+		return emailAction, nil
+	case TASK_ACTION_SHOW_MESSAGE:
+		showMessageAction := ShowMessageAction{
+			ID:    id,
+			Title: oleutil.MustGetProperty(action, "Title").ToString(),
+			Body:  oleutil.MustGetProperty(action, "MessageBody").ToString(),
+		}
+
+		return showMessageAction, nil
 	default:
 		return nil, errors.New("unsupported IAction type")
 	}
 }
 
+// variantToStrings returns the strings of a VARIANT holding an array of
+// strings or of VARIANTs holding strings.
+func variantToStrings(v *ole.VARIANT) []string {
+	array := v.ToArray()
+	if array == nil {
+		return nil
+	}
+	if vt, err := array.GetType(); err == nil && ole.VT(vt) == ole.VT_BSTR {
+		return array.ToStringArray()
+	}
+
+	var strs []string
+	for _, value := range array.ToValueArray() {
+		if s, ok := value.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+
+	return strs
+}
+
 func parsePrincipal(principleObj *ole.IDispatch) Principal {
 	name := oleutil.MustGetProperty(principleObj, "DisplayName").ToString()
 	groupID := oleutil.MustGetProperty(principleObj, "GroupId").ToString()
@@ -202,6 +275,23 @@ func parsePrincipal(principleObj *ole.IDispatch) Principal {
 		UserID:    userID,
 	}
 
+	// IPrincipal2 isn't available before Windows 7
+	principal2Obj, err := principleObj.QueryInterface(ole.NewGUID("{248919ae-e345-4a6d-8aeb-e0d3165c904e}"))
+	if err != nil {
+		return principle
+	}
+	defer principal2Obj.Release()
+
+	// other logon types always use the default process token SID type
+	if logonType == TASK_LOGON_SERVICE_ACCOUNT {
+		principle.ProcessTokenSidType = processTokenSidTypePtr(TaskProcessTokenSidType(oleutil.MustGetProperty(principal2Obj, "ProcessTokenSidType").Val))
+	}
+	privilegeCount := int(oleutil.MustGetProperty(principal2Obj, "RequiredPrivilegeCount").Val)
+	for i := 1; i <= privilegeCount; i++ {
+		privilege := oleutil.MustGetProperty(principal2Obj, "RequiredPrivilege", i).ToString()
+		principle.RequiredPrivileges = append(principle.RequiredPrivileges, privilege)
+	}
+
 	return principle
 }
 
@@ -311,6 +401,38 @@ func parseTaskSettings(settings *ole.IDispatch) (*TaskSettings, error) {
 		WakeToRun:                 wakeToRun,
 	}
 
+	// ITaskSettings2 and ITaskSettings3 aren't available before Windows 7
+	// and Windows 8
+	settings2, err := settings.QueryInterface(ole.NewGUID("{2c05c3f0-6eed-4c05-a15f-ed7d7a98a369}"))
+	if err != nil {
+		return taskSettings, nil
+	}
+	defer settings2.Release()
+	taskSettings.DisallowStartOnRemoteAppSession = oleutil.MustGetProperty(settings2, "DisallowStartOnRemoteAppSession").Value().(bool)
+	taskSettings.UseUnifiedSchedulingEngine = oleutil.MustGetProperty(settings2, "UseUnifiedSchedulingEngine").Value().(bool)
+
+	settings3, err := settings.QueryInterface(ole.NewGUID("{0ad9d0d7-0c7f-4ebb-9a5f-d1c648dca528}"))
+	if err != nil {
+		return taskSettings, nil
+	}
+	defer settings3.Release()
+	taskSettings.Volatile = oleutil.MustGetProperty(settings3, "Volatile").Value().(bool)
+
+	maintenanceSettings := oleutil.MustGetProperty(settings3, "MaintenanceSettings").ToIDispatch()
+	if maintenanceSettings == nil {
+		return taskSettings, nil
+	}
+	defer maintenanceSettings.Release()
+	taskSettings.MaintenanceSettings.Period, err = StringToPeriod(oleutil.MustGetProperty(maintenanceSettings, "Period").ToString())
+	if err != nil {
+		return nil, fmt.Errorf("error parsing maintenance Period field: %v", err)
+	}
+	taskSettings.MaintenanceSettings.Deadline, err = StringToPeriod(oleutil.MustGetProperty(maintenanceSettings, "Deadline").ToString())
+	if err != nil {
+		return nil, fmt.Errorf("error parsing maintenance Deadline field: %v", err)
+	}
+	taskSettings.MaintenanceSettings.Exclusive = oleutil.MustGetProperty(maintenanceSettings, "Exclusive").Value().(bool)
+
 	return taskSettings, nil
 }
 
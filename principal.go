@@ -0,0 +1,86 @@
+package taskmaster
+
+import (
+	"errors"
+	"fmt"
+)
+
+// knownPrivileges are the privileges the Task Scheduler schema allows
+// principals to require.
+// https://docs.microsoft.com/en-us/windows/desktop/taskschd/taskschedulerschema-privilegetype-simpletype
+var knownPrivileges = map[string]bool{
+	"SeAssignPrimaryTokenPrivilege":             true,
+	"SeAuditPrivilege":                          true,
+	"SeBackupPrivilege":                         true,
+	"SeChangeNotifyPrivilege":                   true,
+	"SeCreateGlobalPrivilege":                   true,
+	"SeCreatePagefilePrivilege":                 true,
+	"SeCreatePermanentPrivilege":                true,
+	"SeCreateSymbolicLinkPrivilege":             true,
+	"SeCreateTokenPrivilege":                    true,
+	"SeDebugPrivilege":                          true,
+	"SeDelegateSessionUserImpersonatePrivilege": true,
+	"SeEnableDelegationPrivilege":               true,
+	"SeImpersonatePrivilege":                    true,
+	"SeIncreaseBasePriorityPrivilege":           true,
+	"SeIncreaseQuotaPrivilege":                  true,
+	"SeIncreaseWorkingSetPrivilege":             true,
+	"SeLoadDriverPrivilege":                     true,
+	"SeLockMemoryPrivilege":                     true,
+	"SeMachineAccountPrivilege":                 true,
+	"SeManageVolumePrivilege":                   true,
+	"SeProfileSingleProcessPrivilege":           true,
+	"SeRelabelPrivilege":                        true,
+	"SeRemoteShutdownPrivilege":                 true,
+	"SeRestorePrivilege":                        true,
+	"SeSecurityPrivilege":                       true,
+	"SeShutdownPrivilege":                       true,
+	"SeSyncAgentPrivilege":                      true,
+	"SeSystemEnvironmentPrivilege":              true,
+	"SeSystemProfilePrivilege":                  true,
+	"SeSystemtimePrivilege":                     true,
+	"SeTakeOwnershipPrivilege":                  true,
+	"SeTcbPrivilege":                            true,
+	"SeTimeZonePrivilege":                       true,
+	"SeTrustedCredManAccessPrivilege":           true,
+	"SeUndockPrivilege":                         true,
+	"SeUnsolicitedInputPrivilege":               true,
+}
+
+// processTokenSidTypePtr returns a pointer to a copy of t.
+func processTokenSidTypePtr(t TaskProcessTokenSidType) *TaskProcessTokenSidType {
+	return &t
+}
+
+func validatePrincipal(principal Principal) error {
+	if principal.UserID != "" && principal.GroupID != "" {
+		return ErrInvalidPrinciple
+	}
+
+	if sidType := principal.ProcessTokenSidType; sidType != nil {
+		if *sidType > TASK_PROCESSTOKENSID_DEFAULT {
+			return errors.New("invalid Principal: invalid ProcessTokenSidType")
+		}
+		if principal.LogonType != TASK_LOGON_SERVICE_ACCOUNT && *sidType != TASK_PROCESSTOKENSID_DEFAULT {
+			return fmt.Errorf("invalid Principal: ProcessTokenSidType can only be %s with a service account logon", *sidType)
+		}
+	}
+	if principal.LogonType != TASK_LOGON_SERVICE_ACCOUNT {
+		if len(principal.RequiredPrivileges) != 0 {
+			return errors.New("invalid Principal: RequiredPrivileges can only be set with a service account logon")
+		}
+	}
+
+	seen := make(map[string]bool, len(principal.RequiredPrivileges))
+	for _, privilege := range principal.RequiredPrivileges {
+		if !knownPrivileges[privilege] {
+			return fmt.Errorf("invalid Principal: unknown privilege %q", privilege)
+		}
+		if seen[privilege] {
+			return fmt.Errorf("invalid Principal: privilege %q is required more than once", privilege)
+		}
+		seen[privilege] = true
+	}
+
+	return nil
+}
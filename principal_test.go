@@ -0,0 +1,60 @@
+package taskmaster
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidatePrincipal(t *testing.T) {
+	valid := []Principal{
+		{},
+		{UserID: "S-1-5-18", LogonType: TASK_LOGON_SERVICE_ACCOUNT, ProcessTokenSidType: processTokenSidTypePtr(TASK_PROCESSTOKENSID_NONE)},
+		{UserID: "S-1-5-18", LogonType: TASK_LOGON_SERVICE_ACCOUNT, ProcessTokenSidType: processTokenSidTypePtr(TASK_PROCESSTOKENSID_DEFAULT)},
+		{UserID: "S-1-5-19", LogonType: TASK_LOGON_SERVICE_ACCOUNT, ProcessTokenSidType: processTokenSidTypePtr(TASK_PROCESSTOKENSID_UNRESTRICTED), RequiredPrivileges: []string{"SeBackupPrivilege", "SeRestorePrivilege"}},
+		{UserID: `DOMAIN\user`, LogonType: TASK_LOGON_PASSWORD, ProcessTokenSidType: processTokenSidTypePtr(TASK_PROCESSTOKENSID_DEFAULT)},
+	}
+	for _, principal := range valid {
+		if err := validatePrincipal(principal); err != nil {
+			t.Errorf("%+v: unexpected error: %v", principal, err)
+		}
+	}
+
+	invalid := []Principal{
+		{UserID: `DOMAIN\user`, GroupID: "Users"},
+		{LogonType: TASK_LOGON_SERVICE_ACCOUNT, ProcessTokenSidType: processTokenSidTypePtr(3)},
+		{LogonType: TASK_LOGON_INTERACTIVE_TOKEN, ProcessTokenSidType: processTokenSidTypePtr(TASK_PROCESSTOKENSID_UNRESTRICTED)},
+		{LogonType: TASK_LOGON_PASSWORD, ProcessTokenSidType: processTokenSidTypePtr(TASK_PROCESSTOKENSID_NONE)},
+		{LogonType: TASK_LOGON_S4U, RequiredPrivileges: []string{"SeBackupPrivilege"}},
+		{LogonType: TASK_LOGON_SERVICE_ACCOUNT, RequiredPrivileges: []string{"SeFlyPrivilege"}},
+		{LogonType: TASK_LOGON_SERVICE_ACCOUNT, RequiredPrivileges: []string{"sebackupprivilege"}},
+		{LogonType: TASK_LOGON_SERVICE_ACCOUNT, RequiredPrivileges: []string{"SeBackupPrivilege", "SeBackupPrivilege"}},
+	}
+	for _, principal := range invalid {
+		if err := validatePrincipal(principal); err == nil {
+			t.Errorf("%+v: expected error", principal)
+		}
+	}
+}
+
+func TestPrincipalJSON(t *testing.T) {
+	principal := Principal{
+		ID:                  "LocalService",
+		UserID:              "S-1-5-19",
+		LogonType:           TASK_LOGON_SERVICE_ACCOUNT,
+		ProcessTokenSidType: processTokenSidTypePtr(TASK_PROCESSTOKENSID_UNRESTRICTED),
+		RequiredPrivileges:  []string{"SeBackupPrivilege"},
+	}
+
+	data, err := json.Marshal(principal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Principal
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, principal) {
+		t.Errorf("expected %+v after round trip, got %+v", principal, decoded)
+	}
+}
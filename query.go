@@ -0,0 +1,957 @@
+package taskmaster
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rickb777/date/period"
+)
+
+// TaskPredicate reports whether a registered task matches a condition.
+type TaskPredicate func(task RegisteredTask) bool
+
+// And returns a predicate that matches tasks matched by p and all of others.
+func (p TaskPredicate) And(others ...TaskPredicate) TaskPredicate {
+	return func(task RegisteredTask) bool {
+		if !p(task) {
+			return false
+		}
+		for _, other := range others {
+			if !other(task) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// Or returns a predicate that matches tasks matched by p or any of others.
+func (p TaskPredicate) Or(others ...TaskPredicate) TaskPredicate {
+	return func(task RegisteredTask) bool {
+		if p(task) {
+			return true
+		}
+		for _, other := range others {
+			if other(task) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Not returns a predicate that matches tasks not matched by p.
+func (p TaskPredicate) Not() TaskPredicate {
+	return func(task RegisteredTask) bool {
+		return !p(task)
+	}
+}
+
+// PathMatches returns a predicate that matches tasks whose path matches the
+// glob pattern. Patterns are matched case insensitively, '*' matches any
+// sequence of characters including path separators and '?' matches any one
+// character.
+func PathMatches(pattern string) TaskPredicate {
+	return func(task RegisteredTask) bool {
+		return globMatch(pattern, task.Path)
+	}
+}
+
+// StateIs returns a predicate that matches tasks in any of states.
+func StateIs(states ...TaskState) TaskPredicate {
+	return func(task RegisteredTask) bool {
+		for _, state := range states {
+			if task.State == state {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// HasTriggerType returns a predicate that matches tasks that have a trigger of
+// any of types.
+func HasTriggerType(types ...TaskTriggerType) TaskPredicate {
+	return func(task RegisteredTask) bool {
+		for _, trigger := range task.Definition.Triggers {
+			for _, typ := range types {
+				if trigger.GetType() == typ {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+}
+
+// HasActionType returns a predicate that matches tasks that have an action of
+// any of types.
+func HasActionType(types ...TaskActionType) TaskPredicate {
+	return func(task RegisteredTask) bool {
+		for _, action := range task.Definition.Actions {
+			for _, typ := range types {
+				if action.GetType() == typ {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+}
+
+// RunLevelIs returns a predicate that matches tasks whose principal runs with
+// the run level.
+func RunLevelIs(level TaskRunLevel) TaskPredicate {
+	return func(task RegisteredTask) bool {
+		return task.Definition.Principal.RunLevel == level
+	}
+}
+
+// NextRunBefore returns a predicate that matches tasks that are scheduled to
+// run next before t.
+func NextRunBefore(t time.Time) TaskPredicate {
+	return func(task RegisteredTask) bool {
+		return !task.NextRunTime.IsZero() && task.NextRunTime.Before(t)
+	}
+}
+
+// Filter returns the tasks of the collection that match pred.
+func (c RegisteredTaskCollection) Filter(pred TaskPredicate) RegisteredTaskCollection {
+	var filtered RegisteredTaskCollection
+	for _, task := range c {
+		if pred(task) {
+			filtered = append(filtered, task)
+		}
+	}
+
+	return filtered
+}
+
+// Query returns the tasks of the collection that match a query. See
+// ParseQuery for the syntax of queries.
+func (c RegisteredTaskCollection) Query(query string) (RegisteredTaskCollection, error) {
+	pred, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Filter(pred), nil
+}
+
+// SortBy returns a copy of the collection sorted by fields, which are the
+// field names accepted by ParseQuery. Fields prefixed with '-' are sorted in
+// descending order. Fields with multiple values are sorted by their first
+// value, and tasks without a value for a field are sorted last.
+func (c RegisteredTaskCollection) SortBy(fields ...string) (RegisteredTaskCollection, error) {
+	type sortField struct {
+		field      queryField
+		descending bool
+	}
+
+	sortFields := make([]sortField, len(fields))
+	for i, name := range fields {
+		descending := strings.HasPrefix(name, "-")
+		field, ok := queryFields[strings.ToLower(strings.TrimPrefix(name, "-"))]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		sortFields[i] = sortField{field: field, descending: descending}
+	}
+
+	sorted := make(RegisteredTaskCollection, len(c))
+	copy(sorted, c)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		for _, f := range sortFields {
+			valsI, valsJ := f.field.get(sorted[i]), f.field.get(sorted[j])
+			switch {
+			case len(valsI) == 0 && len(valsJ) == 0:
+				continue
+			case len(valsI) == 0:
+				return false
+			case len(valsJ) == 0:
+				return true
+			}
+
+			cmp := compareQueryValues(f.field.kind, valsI[0], valsJ[0])
+			if cmp == 0 {
+				continue
+			}
+			if f.descending {
+				return cmp > 0
+			}
+
+			return cmp < 0
+		}
+
+		return false
+	})
+
+	return sorted, nil
+}
+
+// Select returns the values of fields of every task of the collection, which
+// are the field names accepted by ParseQuery. Fields that can have multiple
+// values, such as the fields of actions and triggers, are returned as slices,
+// and other fields are nil if the task has no value for them.
+func (c RegisteredTaskCollection) Select(fields ...string) ([]map[string]interface{}, error) {
+	selected := make([]queryField, len(fields))
+	for i, name := range fields {
+		field, ok := queryFields[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		selected[i] = field
+	}
+
+	rows := make([]map[string]interface{}, len(c))
+	for i, task := range c {
+		row := make(map[string]interface{}, len(fields))
+		for j, field := range selected {
+			vals := field.get(task)
+			switch {
+			case field.multi:
+				row[fields[j]] = vals
+			case len(vals) == 0:
+				row[fields[j]] = nil
+			default:
+				row[fields[j]] = vals[0]
+			}
+		}
+		rows[i] = row
+	}
+
+	return rows, nil
+}
+
+// QueryFields returns the sorted names of the fields that can be used in
+// queries, SortBy and Select.
+func QueryFields() []string {
+	names := make([]string, 0, len(queryFields))
+	for name := range queryFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+type queryFieldKind uint
+
+const (
+	queryString queryFieldKind = iota
+	queryBool
+	queryNumber
+	queryEnum
+	queryTime
+)
+
+type queryField struct {
+	kind  queryFieldKind
+	multi bool          // the field can have multiple values
+	enums []interface{} // the values of enum fields, which are matched by name
+	get   func(task RegisteredTask) []interface{}
+}
+
+func stringField(get func(task RegisteredTask) string) queryField {
+	return queryField{kind: queryString, get: func(task RegisteredTask) []interface{} {
+		return []interface{}{get(task)}
+	}}
+}
+
+func boolField(get func(task RegisteredTask) bool) queryField {
+	return queryField{kind: queryBool, get: func(task RegisteredTask) []interface{} {
+		return []interface{}{get(task)}
+	}}
+}
+
+func numberField(get func(task RegisteredTask) interface{}) queryField {
+	return queryField{kind: queryNumber, get: func(task RegisteredTask) []interface{} {
+		return []interface{}{get(task)}
+	}}
+}
+
+func enumField(enums []interface{}, get func(task RegisteredTask) interface{}) queryField {
+	return queryField{kind: queryEnum, enums: enums, get: func(task RegisteredTask) []interface{} {
+		return []interface{}{get(task)}
+	}}
+}
+
+// timeField returns a time field. Zero times are treated as having no value.
+func timeField(get func(task RegisteredTask) time.Time) queryField {
+	return queryField{kind: queryTime, get: func(task RegisteredTask) []interface{} {
+		if t := get(task); !t.IsZero() {
+			return []interface{}{t}
+		}
+
+		return nil
+	}}
+}
+
+func actionField(kind queryFieldKind, enums []interface{}, get func(action Action) (interface{}, bool)) queryField {
+	return queryField{kind: kind, multi: true, enums: enums, get: func(task RegisteredTask) []interface{} {
+		var vals []interface{}
+		for _, action := range task.Definition.Actions {
+			if val, ok := get(action); ok {
+				vals = append(vals, val)
+			}
+		}
+
+		return vals
+	}}
+}
+
+func triggerField(kind queryFieldKind, enums []interface{}, get func(trigger Trigger) interface{}) queryField {
+	return queryField{kind: kind, multi: true, enums: enums, get: func(task RegisteredTask) []interface{} {
+		var vals []interface{}
+		for _, trigger := range task.Definition.Triggers {
+			val := get(trigger)
+			if t, ok := val.(time.Time); ok && t.IsZero() {
+				continue
+			}
+			vals = append(vals, val)
+		}
+
+		return vals
+	}}
+}
+
+var (
+	taskStates = []interface{}{
+		TASK_STATE_UNKNOWN, TASK_STATE_DISABLED, TASK_STATE_QUEUED, TASK_STATE_READY, TASK_STATE_RUNNING,
+	}
+	taskActionTypes = []interface{}{
+		TASK_ACTION_EXEC, TASK_ACTION_COM_HANDLER, TASK_ACTION_SEND_EMAIL, TASK_ACTION_SHOW_MESSAGE,
+	}
+	taskTriggerTypes = []interface{}{
+		TASK_TRIGGER_EVENT, TASK_TRIGGER_TIME, TASK_TRIGGER_DAILY, TASK_TRIGGER_WEEKLY, TASK_TRIGGER_MONTHLY,
+		TASK_TRIGGER_MONTHLYDOW, TASK_TRIGGER_IDLE, TASK_TRIGGER_REGISTRATION, TASK_TRIGGER_BOOT,
+		TASK_TRIGGER_LOGON, TASK_TRIGGER_SESSION_STATE_CHANGE, TASK_TRIGGER_CUSTOM_TRIGGER_01,
+	}
+	taskLogonTypes = []interface{}{
+		TASK_LOGON_NONE, TASK_LOGON_PASSWORD, TASK_LOGON_S4U, TASK_LOGON_INTERACTIVE_TOKEN, TASK_LOGON_GROUP,
+		TASK_LOGON_SERVICE_ACCOUNT, TASK_LOGON_INTERACTIVE_TOKEN_OR_PASSWORD,
+	}
+	taskRunLevels = []interface{}{
+		TASK_RUNLEVEL_LUA, TASK_RUNLEVEL_HIGHEST,
+	}
+	taskProcessTokenSidTypes = []interface{}{
+		TASK_PROCESSTOKENSID_NONE, TASK_PROCESSTOKENSID_UNRESTRICTED, TASK_PROCESSTOKENSID_DEFAULT,
+	}
+	taskCompatibilities = []interface{}{
+		TASK_COMPATIBILITY_AT, TASK_COMPATIBILITY_V1, TASK_COMPATIBILITY_V2, TASK_COMPATIBILITY_V2_1,
+		TASK_COMPATIBILITY_V2_2, TASK_COMPATIBILITY_V2_3, TASK_COMPATIBILITY_V2_4,
+	}
+	taskInstancesPolicies = []interface{}{
+		TASK_INSTANCES_PARALLEL, TASK_INSTANCES_QUEUE, TASK_INSTANCES_IGNORE_NEW, TASK_INSTANCES_STOP_EXISTING,
+	}
+)
+
+// queryFields are the fields that can be used in queries, keyed by their
+// lowercase names.
+var queryFields = map[string]queryField{
+	"name":        stringField(func(t RegisteredTask) string { return t.Name }),
+	"path":        stringField(func(t RegisteredTask) string { return t.Path }),
+	"enabled":     boolField(func(t RegisteredTask) bool { return t.Enabled }),
+	"state":       enumField(taskStates, func(t RegisteredTask) interface{} { return t.State }),
+	"missedruns":  numberField(func(t RegisteredTask) interface{} { return t.MissedRuns }),
+	"nextrun":     timeField(func(t RegisteredTask) time.Time { return t.NextRunTime }),
+	"lastrun":     timeField(func(t RegisteredTask) time.Time { return t.LastRunTime }),
+	"lastresult":  numberField(func(t RegisteredTask) interface{} { return t.LastTaskResult }),
+	"context":     stringField(func(t RegisteredTask) string { return t.Definition.Context }),
+	"author":      stringField(func(t RegisteredTask) string { return t.Definition.RegistrationInfo.Author }),
+	"description": stringField(func(t RegisteredTask) string { return t.Definition.RegistrationInfo.Description }),
+	"source":      stringField(func(t RegisteredTask) string { return t.Definition.RegistrationInfo.Source }),
+	"uri":         stringField(func(t RegisteredTask) string { return t.Definition.RegistrationInfo.URI }),
+	"version":     stringField(func(t RegisteredTask) string { return t.Definition.RegistrationInfo.Version }),
+	"date":        timeField(func(t RegisteredTask) time.Time { return t.Definition.RegistrationInfo.Date }),
+
+	"principal.id":        stringField(func(t RegisteredTask) string { return t.Definition.Principal.ID }),
+	"principal.userid":    stringField(func(t RegisteredTask) string { return t.Definition.Principal.UserID }),
+	"principal.groupid":   stringField(func(t RegisteredTask) string { return t.Definition.Principal.GroupID }),
+	"principal.logontype": enumField(taskLogonTypes, func(t RegisteredTask) interface{} { return t.Definition.Principal.LogonType }),
+	"principal.runlevel":  enumField(taskRunLevels, func(t RegisteredTask) interface{} { return t.Definition.Principal.RunLevel }),
+	"principal.processtokensidtype": {kind: queryEnum, enums: taskProcessTokenSidTypes, get: func(task RegisteredTask) []interface{} {
+		if sidType := task.Definition.Principal.ProcessTokenSidType; sidType != nil {
+			return []interface{}{*sidType}
+		}
+
+		return nil
+	}},
+	"principal.privilege": {kind: queryString, multi: true, get: func(task RegisteredTask) []interface{} {
+		var vals []interface{}
+		for _, privilege := range task.Definition.Principal.RequiredPrivileges {
+			vals = append(vals, privilege)
+		}
+
+		return vals
+	}},
+
+	"settings.enabled":           boolField(func(t RegisteredTask) bool { return t.Definition.Settings.Enabled }),
+	"settings.hidden":            boolField(func(t RegisteredTask) bool { return t.Definition.Settings.Hidden }),
+	"settings.priority":          numberField(func(t RegisteredTask) interface{} { return t.Definition.Settings.Priority }),
+	"settings.compatibility":     enumField(taskCompatibilities, func(t RegisteredTask) interface{} { return t.Definition.Settings.Compatibility }),
+	"settings.multipleinstances": enumField(taskInstancesPolicies, func(t RegisteredTask) interface{} { return t.Definition.Settings.MultipleInstances }),
+
+	"action.id": actionField(queryString, nil, func(a Action) (interface{}, bool) { return a.GetID(), true }),
+	"action.type": actionField(queryEnum, taskActionTypes, func(a Action) (interface{}, bool) {
+		return a.GetType(), true
+	}),
+	"action.path": actionField(queryString, nil, func(a Action) (interface{}, bool) {
+		exec, ok := a.(ExecAction)
+		return exec.Path, ok
+	}),
+	"action.args": actionField(queryString, nil, func(a Action) (interface{}, bool) {
+		exec, ok := a.(ExecAction)
+		return exec.Args, ok
+	}),
+	"action.workingdir": actionField(queryString, nil, func(a Action) (interface{}, bool) {
+		exec, ok := a.(ExecAction)
+		return exec.WorkingDir, ok
+	}),
+	"action.classid": actionField(queryString, nil, func(a Action) (interface{}, bool) {
+		com, ok := a.(ComHandlerAction)
+		return com.ClassID, ok
+	}),
+
+	"trigger.id":      triggerField(queryString, nil, func(t Trigger) interface{} { return t.GetID() }),
+	"trigger.type":    triggerField(queryEnum, taskTriggerTypes, func(t Trigger) interface{} { return t.GetType() }),
+	"trigger.enabled": triggerField(queryBool, nil, func(t Trigger) interface{} { return t.GetEnabled() }),
+	"trigger.start":   triggerField(queryTime, nil, func(t Trigger) interface{} { return t.GetStartBoundary() }),
+	"trigger.end":     triggerField(queryTime, nil, func(t Trigger) interface{} { return t.GetEndBoundary() }),
+}
+
+// compareQueryValues compares two values of a field, returning -1, 0 or 1.
+// Strings are compared case insensitively.
+func compareQueryValues(kind queryFieldKind, a, b interface{}) int {
+	switch kind {
+	case queryString:
+		return strings.Compare(strings.ToLower(a.(string)), strings.ToLower(b.(string)))
+	case queryBool:
+		boolA, boolB := a.(bool), b.(bool)
+		switch {
+		case boolA == boolB:
+			return 0
+		case boolB:
+			return -1
+		default:
+			return 1
+		}
+	case queryNumber, queryEnum:
+		numA, numB := queryNumberOf(a), queryNumberOf(b)
+		switch {
+		case numA < numB:
+			return -1
+		case numA > numB:
+			return 1
+		default:
+			return 0
+		}
+	case queryTime:
+		timeA, timeB := a.(time.Time), b.(time.Time)
+		switch {
+		case timeA.Before(timeB):
+			return -1
+		case timeA.After(timeB):
+			return 1
+		default:
+			return 0
+		}
+	default:
+		return 0
+	}
+}
+
+// queryNumberOf returns the value of an integer of any type.
+func queryNumberOf(v interface{}) int64 {
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return val.Int()
+	default:
+		return int64(val.Uint())
+	}
+}
+
+// normalizeEnumName lowercases an enum name and removes its spaces so that
+// names like "Monthly Day of the Week" can be written as identifiers.
+func normalizeEnumName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+// globMatch reports whether s matches the glob pattern case insensitively.
+// '*' matches any sequence of characters and '?' matches any one character.
+func globMatch(pattern, s string) bool {
+	p, str := []rune(strings.ToLower(pattern)), []rune(strings.ToLower(s))
+	// the positions to backtrack to after the last '*'
+	starP, starS := -1, 0
+	i, j := 0, 0
+	for j < len(str) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == str[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			starP, starS = i, j
+			i++
+		case starP != -1:
+			starS++
+			i, j = starP+1, starS
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+
+	return i == len(p)
+}
+
+// ParseQuery parses a query into a predicate. A query is made of comparisons
+// combined with '&&', '||', '!' and parentheses, such as:
+//
+//	path ~ "\\Microsoft\\*" && state == Running && trigger.type in (Daily, Weekly) && nextrun < now+1h
+//
+// A comparison is a field name, one of the operators '==', '!=', '<', '<=',
+// '>', '>=', '~' (glob match), '!~' and 'in', and a value. Boolean fields can
+// be used without an operator. QueryFields returns the names of the fields.
+//
+// Values are double quoted strings, in which '\\' and '\"' are escapes,
+// single quoted strings without escapes, numbers, identifiers and times.
+// Strings are compared case insensitively. Enum fields, such as state or
+// trigger.type, are compared to the names of their values ignoring case and
+// spaces, such as Running or MonthlyDayOfTheWeek, or to numbers. Time fields
+// are compared to quoted times or to 'now' plus or minus a duration, such as
+// now-30m or now+P1D, which is evaluated every time the predicate is called.
+//
+// Fields of actions and triggers have a value for every action or trigger, and
+// match if any value matches. The negated operators '!=' and '!~' match if no
+// value matches, and times that are not set have no value.
+func ParseQuery(query string) (TaskPredicate, error) {
+	return parseQuery(query, realClock{})
+}
+
+func parseQuery(query string, clk clock) (TaskPredicate, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+
+	p := &queryParser{tokens: tokens, clk: clk}
+	pred, err := p.parseOr()
+	if err == nil && p.peek().kind != queryTokenEOF {
+		err = p.errorf("unexpected %s", p.peek())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+
+	return pred, nil
+}
+
+type queryTokenKind uint
+
+const (
+	queryTokenEOF queryTokenKind = iota
+	queryTokenIdent
+	queryTokenNumber
+	queryTokenString
+	queryTokenSymbol
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	text string
+	pos  int
+}
+
+func (t queryToken) String() string {
+	if t.kind == queryTokenEOF {
+		return "end of query"
+	}
+
+	return strconv.Quote(t.text)
+}
+
+var querySymbols = []string{"&&", "||", "==", "!=", "<=", ">=", "!~", "=", "<", ">", "~", "!", "(", ")", ",", "+", "-"}
+
+func lexQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	isIdentChar := func(c byte) bool {
+		return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(query) && query[j] != '"'; j++ {
+				if query[j] == '\\' && j+1 < len(query) && (query[j+1] == '\\' || query[j+1] == '"') {
+					j++
+				}
+				sb.WriteByte(query[j])
+			}
+			if j == len(query) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, queryToken{kind: queryTokenString, text: sb.String(), pos: i})
+			i = j + 1
+		case c == '\'':
+			j := strings.IndexByte(query[i+1:], '\'')
+			if j == -1 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, queryToken{kind: queryTokenString, text: query[i+1 : i+1+j], pos: i})
+			i += j + 2
+		case isIdentChar(c):
+			j := i
+			for j < len(query) && isIdentChar(query[j]) {
+				j++
+			}
+			kind := queryTokenIdent
+			if c >= '0' && c <= '9' {
+				kind = queryTokenNumber
+			}
+			tokens = append(tokens, queryToken{kind: kind, text: query[i:j], pos: i})
+			i = j
+		default:
+			var symbol string
+			for _, s := range querySymbols {
+				if strings.HasPrefix(query[i:], s) {
+					symbol = s
+					break
+				}
+			}
+			if symbol == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+			tokens = append(tokens, queryToken{kind: queryTokenSymbol, text: symbol, pos: i})
+			i += len(symbol)
+		}
+	}
+
+	return append(tokens, queryToken{kind: queryTokenEOF, pos: len(query)}), nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+	clk    clock
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.tokens[p.pos]
+	if t.kind != queryTokenEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *queryParser) accept(symbol string) bool {
+	if t := p.peek(); t.kind == queryTokenSymbol && t.text == symbol {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, args...), p.peek().pos)
+}
+
+func (p *queryParser) parseOr() (TaskPredicate, error) {
+	pred, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		other, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		pred = pred.Or(other)
+	}
+
+	return pred, nil
+}
+
+func (p *queryParser) parseAnd() (TaskPredicate, error) {
+	pred, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		other, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		pred = pred.And(other)
+	}
+
+	return pred, nil
+}
+
+func (p *queryParser) parseUnary() (TaskPredicate, error) {
+	if p.accept("!") {
+		pred, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return pred.Not(), nil
+	}
+	if p.accept("(") {
+		pred, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.errorf("expected \")\", got %s", p.peek())
+		}
+		return pred, nil
+	}
+
+	return p.parseComparison()
+}
+
+// queryOperand is a value a field is compared to. Operands relative to now
+// are resolved when the predicate is called.
+type queryOperand struct {
+	value    interface{}
+	relative bool
+	offset   time.Duration
+}
+
+func (p *queryParser) parseComparison() (TaskPredicate, error) {
+	fieldToken := p.next()
+	if fieldToken.kind != queryTokenIdent {
+		return nil, fmt.Errorf("expected field name, got %s at position %d", fieldToken, fieldToken.pos)
+	}
+	field, ok := queryFields[strings.ToLower(fieldToken.text)]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q at position %d", fieldToken.text, fieldToken.pos)
+	}
+
+	opToken := p.peek()
+	op := opToken.text
+	switch {
+	case opToken.kind == queryTokenSymbol && (op == "==" || op == "=" || op == "!=" || op == "<" || op == "<=" || op == ">" || op == ">=" || op == "~" || op == "!~"):
+		p.next()
+	case opToken.kind == queryTokenIdent && strings.ToLower(op) == "in":
+		op = "in"
+		p.next()
+	case field.kind == queryBool:
+		// boolean fields without an operator match if they are true
+		return p.comparison(field, "==", []queryOperand{{value: true}}), nil
+	default:
+		return nil, p.errorf("expected operator, got %s", opToken)
+	}
+
+	switch op {
+	case "~", "!~":
+		if field.kind != queryString {
+			return nil, fmt.Errorf("operator %q can only be used with string fields at position %d", op, opToken.pos)
+		}
+	case "<", "<=", ">", ">=":
+		if field.kind == queryBool {
+			return nil, fmt.Errorf("operator %q can't be used with boolean fields at position %d", op, opToken.pos)
+		}
+	}
+
+	var operands []queryOperand
+	if op == "in" {
+		if !p.accept("(") {
+			return nil, p.errorf("expected \"(\", got %s", p.peek())
+		}
+		for {
+			operand, err := p.parseOperand(field)
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, operand)
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, p.errorf("expected \",\" or \")\", got %s", p.peek())
+			}
+		}
+		op = "=="
+	} else {
+		operand, err := p.parseOperand(field)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+
+	return p.comparison(field, op, operands), nil
+}
+
+func (p *queryParser) parseOperand(field queryField) (queryOperand, error) {
+	t := p.next()
+	if t.kind != queryTokenIdent && t.kind != queryTokenNumber && t.kind != queryTokenString {
+		return queryOperand{}, fmt.Errorf("expected value, got %s at position %d", t, t.pos)
+	}
+	invalid := func(kind string) error {
+		return fmt.Errorf("invalid %s %s at position %d", kind, t, t.pos)
+	}
+
+	switch field.kind {
+	case queryString:
+		return queryOperand{value: t.text}, nil
+	case queryBool:
+		b, err := strconv.ParseBool(t.text)
+		if err != nil || t.kind == queryTokenString {
+			return queryOperand{}, invalid("boolean")
+		}
+		return queryOperand{value: b}, nil
+	case queryNumber:
+		n, err := strconv.ParseInt(t.text, 0, 64)
+		if err != nil || t.kind != queryTokenNumber {
+			return queryOperand{}, invalid("number")
+		}
+		return queryOperand{value: n}, nil
+	case queryEnum:
+		if t.kind == queryTokenNumber {
+			n, err := strconv.ParseInt(t.text, 0, 64)
+			if err != nil {
+				return queryOperand{}, invalid("number")
+			}
+			return queryOperand{value: n}, nil
+		}
+		name := normalizeEnumName(t.text)
+		for _, enum := range field.enums {
+			if normalizeEnumName(fmt.Sprint(enum)) == name {
+				return queryOperand{value: queryNumberOf(enum)}, nil
+			}
+		}
+		return queryOperand{}, invalid("value")
+	case queryTime:
+		if t.kind == queryTokenString {
+			tm, err := time.Parse(time.RFC3339, t.text)
+			if err != nil {
+				tm, err = TaskDateToTime(t.text)
+			}
+			if err != nil {
+				return queryOperand{}, invalid("time")
+			}
+			return queryOperand{value: tm}, nil
+		}
+		if t.kind != queryTokenIdent || strings.ToLower(t.text) != "now" {
+			return queryOperand{}, invalid("time")
+		}
+
+		operand := queryOperand{relative: true}
+		sign := time.Duration(1)
+		switch {
+		case p.accept("+"):
+		case p.accept("-"):
+			sign = -1
+		default:
+			return operand, nil
+		}
+		d := p.next()
+		offset, err := parseQueryDuration(d.text)
+		if err != nil || d.kind == queryTokenString || d.kind == queryTokenSymbol {
+			return queryOperand{}, fmt.Errorf("invalid duration %s at position %d", d, d.pos)
+		}
+		operand.offset = sign * offset
+		return operand, nil
+	default:
+		return queryOperand{}, invalid("value")
+	}
+}
+
+// parseQueryDuration parses a Go duration, such as 1h30m, or an ISO 8601
+// duration, such as P1D.
+func parseQueryDuration(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	p, err := period.Parse(strings.ToUpper(s))
+	if err != nil {
+		return 0, errors.New("invalid duration")
+	}
+
+	return p.DurationApprox(), nil
+}
+
+// comparison returns a predicate that compares the values of a field to
+// operands. The predicate matches if any value matches any operand, or for
+// negated operators, if no value matches any operand.
+func (p *queryParser) comparison(field queryField, op string, operands []queryOperand) TaskPredicate {
+	negated := op == "!=" || op == "!~"
+	clk := p.clk
+
+	return func(task RegisteredTask) bool {
+		now := clk.Now()
+		for _, val := range field.get(task) {
+			for _, operand := range operands {
+				opVal := operand.value
+				if operand.relative {
+					opVal = now.Add(operand.offset)
+				}
+				if matchQueryValue(field.kind, op, val, opVal) {
+					return !negated
+				}
+			}
+		}
+
+		return negated
+	}
+}
+
+// matchQueryValue reports whether a value of a field matches an operand. For
+// negated operators, it reports whether the value matches the operator without
+// negation.
+func matchQueryValue(kind queryFieldKind, op string, val, operand interface{}) bool {
+	if op == "~" || op == "!~" {
+		return globMatch(operand.(string), val.(string))
+	}
+
+	var cmp int
+	if kind == queryNumber || kind == queryEnum {
+		cmp = compareQueryValues(kind, queryNumberOf(val), operand)
+	} else {
+		cmp = compareQueryValues(kind, val, operand)
+	}
+
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	default:
+		return cmp == 0
+	}
+}
@@ -0,0 +1,194 @@
+package taskmaster
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newQueryTestTasks(now time.Time) RegisteredTaskCollection {
+	return RegisteredTaskCollection{
+		{
+			Name:        "Defrag",
+			Path:        `\Microsoft\Windows\Defrag\ScheduledDefrag`,
+			State:       TASK_STATE_RUNNING,
+			NextRunTime: now.Add(30 * time.Minute),
+			Definition: Definition{
+				Actions:   []Action{ExecAction{Path: `C:\Windows\system32\defrag.exe`}},
+				Principal: Principal{RunLevel: TASK_RUNLEVEL_HIGHEST},
+				Triggers:  []Trigger{WeeklyTrigger{}, BootTrigger{}},
+			},
+		},
+		{
+			Name:        "Cleanup",
+			Path:        `\Microsoft\Windows\DiskCleanup\SilentCleanup`,
+			State:       TASK_STATE_READY,
+			MissedRuns:  3,
+			NextRunTime: now.Add(2 * time.Hour),
+			Definition: Definition{
+				Actions:   []Action{ComHandlerAction{ClassID: "{C15730E2-4F1E-4D52-B5A4-4C5D4D2A9F8E}"}},
+				Principal: Principal{RunLevel: TASK_RUNLEVEL_HIGHEST},
+				Triggers:  []Trigger{DailyTrigger{}},
+			},
+		},
+		{
+			Name:    "Backup",
+			Path:    `\Backup`,
+			Enabled: true,
+			State:   TASK_STATE_RUNNING,
+			Definition: Definition{
+				Actions: []Action{ExecAction{Path: "backup.exe"}, ExecAction{Path: "notify.exe"}},
+				Principal: Principal{
+					LogonType:           TASK_LOGON_SERVICE_ACCOUNT,
+					ProcessTokenSidType: processTokenSidTypePtr(TASK_PROCESSTOKENSID_UNRESTRICTED),
+					RequiredPrivileges:  []string{"SeBackupPrivilege", "SeRestorePrivilege"},
+				},
+				Triggers: []Trigger{
+					MonthlyDOWTrigger{TaskTrigger: TaskTrigger{StartBoundary: now.Add(-24 * time.Hour)}},
+				},
+			},
+		},
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	clk := newFakeClock()
+	tasks := newQueryTestTasks(clk.Now())
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{`path ~ "\\Microsoft\\*" && state == Running && trigger.type in (Daily, Weekly) && principal.runlevel == Highest && nextrun < now+1h`, []string{"Defrag"}},
+		{`path ~ '\Microsoft\*'`, []string{"Defrag", "Cleanup"}},
+		{`path !~ '\Microsoft\*'`, []string{"Backup"}},
+		{`name == backup`, []string{"Backup"}},
+		{`name != "Backup" && name != 'Cleanup'`, []string{"Defrag"}},
+		{`enabled`, []string{"Backup"}},
+		{`!enabled && missedruns >= 1`, []string{"Cleanup"}},
+		{`state == Ready || (state == running && enabled == false)`, []string{"Defrag", "Cleanup"}},
+		{`state > 3`, []string{"Defrag", "Backup"}},
+		{`trigger.type == MonthlyDayOfTheWeek`, []string{"Backup"}},
+		{`trigger.start < now`, []string{"Backup"}},
+		{`nextrun > now+P1D`, nil},
+		{`nextrun >= now-1m`, []string{"Defrag", "Cleanup"}},
+		{`nextrun < "2020-01-01T01:00:00Z"`, []string{"Defrag"}},
+		{`action.type == "COM Handler"`, []string{"Cleanup"}},
+		{`action.path == notify.exe`, []string{"Backup"}},
+		{`action.path != notify.exe`, []string{"Defrag", "Cleanup"}},
+		{`action.path ~ "*.exe"`, []string{"Defrag", "Backup"}},
+		{`principal.processtokensidtype == Unrestricted`, []string{"Backup"}},
+		{`principal.privilege == sebackupprivilege`, []string{"Backup"}},
+		{`principal.privilege != SeDebugPrivilege`, []string{"Defrag", "Cleanup", "Backup"}},
+	}
+	for _, test := range tests {
+		pred, err := parseQuery(test.query, clk)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.query, err)
+			continue
+		}
+
+		var names []string
+		for _, task := range tasks.Filter(pred) {
+			names = append(names, task.Name)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.query, test.expected, names)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	queries := []string{
+		``,
+		`owner == "bob"`,
+		`state == Sleeping`,
+		`enabled == maybe`,
+		`enabled > true`,
+		`missedruns ~ 1`,
+		`missedruns == many`,
+		`nextrun < tomorrow`,
+		`nextrun < now+soon`,
+		`name == "unterminated`,
+		`(name == a`,
+		`name == a b`,
+		`trigger.type in (Daily Weekly)`,
+		`name == a &`,
+	}
+	for _, query := range queries {
+		if _, err := ParseQuery(query); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+func TestRegisteredTaskCollectionSortBy(t *testing.T) {
+	tasks := newQueryTestTasks(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		fields   []string
+		expected []string
+	}{
+		{[]string{"name"}, []string{"Backup", "Cleanup", "Defrag"}},
+		{[]string{"-state", "name"}, []string{"Backup", "Defrag", "Cleanup"}},
+		// tasks without a next run time are sorted last
+		{[]string{"-nextrun"}, []string{"Cleanup", "Defrag", "Backup"}},
+	}
+	for _, test := range tests {
+		sorted, err := tasks.SortBy(test.fields...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, task := range sorted {
+			names = append(names, task.Name)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.fields, test.expected, names)
+		}
+	}
+
+	if _, err := tasks.SortBy("owner"); err == nil {
+		t.Error("expected error sorting by unknown field")
+	}
+}
+
+func TestRegisteredTaskCollectionSelect(t *testing.T) {
+	tasks := newQueryTestTasks(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	rows, err := tasks[2:].Select("name", "state", "action.path", "nextrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{{
+		"name":        "Backup",
+		"state":       TASK_STATE_RUNNING,
+		"action.path": []interface{}{"backup.exe", "notify.exe"},
+		"nextrun":     nil,
+	}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %v, got %v", expected, rows)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{`\Microsoft\*`, `\microsoft\Windows\Defrag`, true},
+		{`\Microsoft\*`, `\Microsoft`, false},
+		{`*Defrag`, `\Microsoft\Windows\Defrag`, true},
+		{`\Task?`, `\Task1`, true},
+		{`\Task?`, `\Task12`, false},
+		{`*a*b*`, `xaxxbx`, true},
+		{`*a*b`, `xaxxbx`, false},
+		{``, ``, true},
+	}
+	for _, test := range tests {
+		if match := globMatch(test.pattern, test.s); match != test.match {
+			t.Errorf("globMatch(%q, %q) = %v, expected %v", test.pattern, test.s, match, test.match)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package taskmaster
+
+// RegisterOptions changes how RegisterTask registers a task. The zero value
+// registers a new task with the principal of its definition, leaves an
+// existing task as it is and fails if the folder of the task doesn't exist.
+type RegisterOptions struct {
+	// Username and Password are the credentials the task is registered
+	// with. They are only needed by the logon types that store a password,
+	// and the logon type of the principal of the definition is used.
+	Username string
+	Password string
+	// CreateOrUpdate updates an existing task in place with
+	// TASK_CREATE_OR_UPDATE. Unlike overwriting it with CreateTask, which
+	// deletes it first, this keeps its history, last run time and security
+	// descriptor.
+	CreateOrUpdate bool
+	// Disable registers the task disabled with TASK_DISABLE, regardless of
+	// the settings of the definition.
+	Disable bool
+	// DontAddPrincipalACE registers the task with
+	// TASK_DONT_ADD_PRINCIPAL_ACE, so the principal of the task isn't given
+	// read and execute access to the task.
+	DontAddPrincipalACE bool
+	// IgnoreRegistrationTriggers registers the task with
+	// TASK_IGNORE_REGISTRATION_TRIGGERS, so its registration triggers don't
+	// start it when it's registered or updated.
+	IgnoreRegistrationTriggers bool
+	// SDDL is the security descriptor of the task in SDDL form. If empty,
+	// new tasks get the default security descriptor and updated tasks keep
+	// theirs.
+	SDDL string
+	// CreateFolders creates the folder of the task and its parents if they
+	// don't exist.
+	CreateFolders bool
+}
+
+// flags returns the TaskCreationFlags the options register tasks with.
+func (o RegisterOptions) flags() TaskCreationFlags {
+	flags := TASK_CREATE
+	if o.CreateOrUpdate {
+		flags = TASK_CREATE_OR_UPDATE
+	}
+	if o.Disable {
+		flags |= TASK_DISABLE
+	}
+	if o.DontAddPrincipalACE {
+		flags |= TASK_DONT_ADD_PRINCIPAL_ACE
+	}
+	if o.IgnoreRegistrationTriggers {
+		flags |= TASK_IGNORE_REGISTRATION_TRIGGERS
+	}
+
+	return flags
+}
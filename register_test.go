@@ -0,0 +1,24 @@
+package taskmaster
+
+import "testing"
+
+func TestRegisterOptionsFlags(t *testing.T) {
+	tests := []struct {
+		opts  RegisterOptions
+		flags TaskCreationFlags
+	}{
+		{RegisterOptions{}, TASK_CREATE},
+		{RegisterOptions{CreateOrUpdate: true}, TASK_CREATE_OR_UPDATE},
+		{RegisterOptions{Disable: true, SDDL: "D:(A;;FA;;;BA)"}, TASK_CREATE | TASK_DISABLE},
+		{
+			RegisterOptions{CreateOrUpdate: true, DontAddPrincipalACE: true, IgnoreRegistrationTriggers: true},
+			TASK_CREATE_OR_UPDATE | TASK_DONT_ADD_PRINCIPAL_ACE | TASK_IGNORE_REGISTRATION_TRIGGERS,
+		},
+	}
+
+	for _, tt := range tests {
+		if flags := tt.opts.flags(); flags != tt.flags {
+			t.Errorf("%+v: expected flags %#x, got %#x", tt.opts, tt.flags, flags)
+		}
+	}
+}
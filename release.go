@@ -0,0 +1,59 @@
+package taskmaster
+
+import "context"
+
+// Release frees the running task COM object. Must be called before
+// program termination to avoid memory leaks.
+func (r *RunningTask) Release() {
+	if !r.isReleased && r.taskObj != nil {
+		r.dispatcher.run(context.Background(), func() error {
+			r.taskObj.Release()
+			return nil
+		})
+		r.isReleased = true
+	}
+}
+
+// Release frees the registered task COM object. Must be called before
+// program termination to avoid memory leaks.
+func (r *RegisteredTask) Release() {
+	if !r.isReleased && r.taskObj != nil {
+		r.dispatcher.run(context.Background(), func() error {
+			r.taskObj.Release()
+			return nil
+		})
+		r.isReleased = true
+	}
+}
+
+// Release frees all the running task COM objects in the collection.
+// Must be called before program termination to avoid memory leaks.
+func (r RunningTaskCollection) Release() {
+	for i := range r {
+		r[i].Release()
+	}
+}
+
+// Release frees all the registered task COM objects in the collection.
+// Must be called before program termination to avoid memory leaks.
+func (r RegisteredTaskCollection) Release() {
+	for i := range r {
+		r[i].Release()
+	}
+}
+
+// Release frees all the registered task COM objects in the folder and
+// all subfolders. Must be called before program termination to avoid
+// memory leaks.
+func (f *TaskFolder) Release() {
+	if !f.isReleased {
+		for i := range f.RegisteredTasks {
+			f.RegisteredTasks[i].Release()
+		}
+		for _, subFolder := range f.SubFolders {
+			subFolder.Release()
+		}
+
+		f.isReleased = true
+	}
+}
@@ -0,0 +1,89 @@
+package taskmaster
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultRunPollInterval is how often RunAndWait checks if a running
+// task has completed.
+const DefaultRunPollInterval = time.Second
+
+// RunResult is the outcome of a task instance started by RunAndWait.
+type RunResult struct {
+	InstanceGUID string    // the GUID identifier of the instance that was run
+	StartTime    time.Time // the time the instance was started, on the clock of the computer it ran on. Not set if the instance timed out
+	// EndTime is the local time the instance was detected to have completed,
+	// or the local time the wait timed out. It's approximate, as instances
+	// are polled.
+	EndTime time.Time
+	// Duration is the local time from the instance being started to it being
+	// detected to have completed or the wait timing out. It's approximate,
+	// as instances are polled.
+	Duration time.Duration
+	Result   TaskResult // the result that was returned by the instance, or SCHED_S_TASK_RUNNING if the instance timed out
+	TimedOut bool       // true if the deadline of the context passed before the instance completed
+}
+
+// runWaiter polls a started task instance until it completes.
+type runWaiter struct {
+	clk      clock
+	interval time.Duration
+	// isRunning returns true if the instance is still running
+	isRunning func(ctx context.Context) (bool, error)
+	// lastRun returns the last run time and result of the registered task
+	lastRun func(ctx context.Context) (time.Time, TaskResult, error)
+}
+
+// wait polls the instance until it has completed and returns its result.
+// started is the local time the instance was started, and prevLastRun the
+// last run time of the registered task before it was started. If the deadline
+// of ctx passes first, a result with TimedOut set is returned.
+func (w runWaiter) wait(ctx context.Context, instanceGUID string, started, prevLastRun time.Time) (RunResult, error) {
+	result := RunResult{InstanceGUID: instanceGUID}
+
+	ticker := w.clk.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		running, err := w.isRunning(ctx)
+		if err == nil && !running {
+			var lastRunTime time.Time
+			var lastResult TaskResult
+			lastRunTime, lastResult, err = w.lastRun(ctx)
+			// the registered task may not have been updated yet, so its last
+			// run may still be running or be the run before the instance.
+			// Last run times are compared with each other and not with the
+			// local clock, as the clock of a remote computer may differ
+			if err == nil && lastResult != SCHED_S_TASK_RUNNING && !lastRunTime.Equal(prevLastRun) {
+				result.StartTime = lastRunTime
+				result.Result = lastResult
+				result.EndTime = w.clk.Now()
+				result.Duration = result.EndTime.Sub(started)
+
+				return result, nil
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			return result, err
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				result.Result = SCHED_S_TASK_RUNNING
+				result.EndTime = w.clk.Now()
+				result.Duration = result.EndTime.Sub(started)
+				result.TimedOut = true
+
+				return result, nil
+			}
+
+			return result, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package taskmaster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunWaiter(t *testing.T) {
+	clk := newFakeClock()
+	started := clk.now
+	prevLastRun := started.Add(-time.Hour)
+	// the clock of the computer the task runs on is behind the local clock
+	lastRunTime := started.Add(-10 * time.Minute)
+
+	var polls, lastRuns int
+	waiter := runWaiter{
+		clk:      clk,
+		interval: time.Second,
+		isRunning: func(ctx context.Context) (bool, error) {
+			polls++
+			return polls <= 2, nil
+		},
+		lastRun: func(ctx context.Context) (time.Time, TaskResult, error) {
+			lastRuns++
+			// the registered task isn't updated right away, so its last
+			// run is first still running and then a previous run
+			switch lastRuns {
+			case 1:
+				return lastRunTime, SCHED_S_TASK_RUNNING, nil
+			case 2:
+				return prevLastRun, 0, nil
+			}
+			return lastRunTime, 1, nil
+		},
+	}
+
+	results := make(chan RunResult)
+	go func() {
+		result, err := waiter.wait(context.Background(), "{guid}", started, prevLastRun)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result
+	}()
+
+	for i := 0; i < 4; i++ {
+		clk.wait()
+		clk.now = clk.now.Add(time.Second)
+		clk.tick()
+	}
+	result := <-results
+
+	if polls != 5 || lastRuns != 3 {
+		t.Errorf("expected 5 polls and 3 reads of the last run, got %d and %d", polls, lastRuns)
+	}
+	expected := RunResult{
+		InstanceGUID: "{guid}",
+		StartTime:    lastRunTime,
+		EndTime:      started.Add(4 * time.Second),
+		Duration:     4 * time.Second,
+		Result:       1,
+	}
+	if result != expected {
+		t.Errorf("expected result %+v, got %+v", expected, result)
+	}
+}
+
+func TestRunWaiterTimeout(t *testing.T) {
+	clk := newFakeClock()
+	waiter := runWaiter{
+		clk:      clk,
+		interval: time.Second,
+		isRunning: func(ctx context.Context) (bool, error) {
+			return true, nil
+		},
+		lastRun: func(ctx context.Context) (time.Time, TaskResult, error) {
+			t.Error("last run shouldn't be read while the instance is running")
+			return time.Time{}, 0, nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	result, err := waiter.wait(ctx, "{guid}", clk.now, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.TimedOut || result.Result != SCHED_S_TASK_RUNNING {
+		t.Errorf("expected result to have timed out while running, got %+v", result)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := waiter.wait(ctx, "{guid}", clk.now, time.Time{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRunWaiterError(t *testing.T) {
+	errPoll := errors.New("poll failed")
+	waiter := runWaiter{
+		clk:      newFakeClock(),
+		interval: time.Second,
+		isRunning: func(ctx context.Context) (bool, error) {
+			return false, errPoll
+		},
+	}
+
+	if _, err := waiter.wait(context.Background(), "{guid}", time.Now(), time.Time{}); err != errPoll {
+		t.Errorf("expected %v, got %v", errPoll, err)
+	}
+}
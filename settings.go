@@ -0,0 +1,58 @@
+package taskmaster
+
+import (
+	"errors"
+
+	"github.com/rickb777/date/period"
+)
+
+// minMaintenancePeriod is the minimum Period and Deadline of maintenance
+// settings.
+var minMaintenancePeriod = period.NewYMD(0, 0, 1)
+
+func validateSettings(settings TaskSettings) error {
+	if settings.Compatibility < TASK_COMPATIBILITY_V2_1 {
+		if settings.DisallowStartOnRemoteAppSession {
+			return errors.New("invalid TaskSettings: DisallowStartOnRemoteAppSession requires compatibility V2_1 or later")
+		}
+		if settings.UseUnifiedSchedulingEngine {
+			return errors.New("invalid TaskSettings: UseUnifiedSchedulingEngine requires compatibility V2_1 or later")
+		}
+	}
+
+	maintenance := settings.MaintenanceSettings
+	if settings.Compatibility < TASK_COMPATIBILITY_V2_2 {
+		if settings.Volatile {
+			return errors.New("invalid TaskSettings: Volatile requires compatibility V2_2 or later")
+		}
+		if maintenance != (MaintenanceSettings{}) {
+			return errors.New("invalid TaskSettings: MaintenanceSettings requires compatibility V2_2 or later")
+		}
+	}
+
+	if maintenance.Period.IsZero() {
+		if !maintenance.Deadline.IsZero() || maintenance.Exclusive {
+			return errors.New("invalid MaintenanceSettings: Period is required")
+		}
+
+		return nil
+	}
+	if periodLess(maintenance.Period, minMaintenancePeriod) {
+		return errors.New("invalid MaintenanceSettings: Period must be at least one day")
+	}
+	if !maintenance.Deadline.IsZero() {
+		if periodLess(maintenance.Deadline, minMaintenancePeriod) {
+			return errors.New("invalid MaintenanceSettings: Deadline must be at least one day")
+		}
+		if !periodLess(maintenance.Period, maintenance.Deadline) {
+			return errors.New("invalid MaintenanceSettings: Deadline must be greater than Period")
+		}
+	}
+
+	return nil
+}
+
+// periodLess reports whether a is shorter than b.
+func periodLess(a, b period.Period) bool {
+	return a.DurationApprox() < b.DurationApprox()
+}
@@ -0,0 +1,42 @@
+package taskmaster
+
+import (
+	"testing"
+
+	"github.com/rickb777/date/period"
+)
+
+func TestValidateSettings(t *testing.T) {
+	day := period.NewYMD(0, 0, 1)
+	week := period.NewYMD(0, 0, 7)
+
+	valid := []TaskSettings{
+		{},
+		{Compatibility: TASK_COMPATIBILITY_V2_1, DisallowStartOnRemoteAppSession: true, UseUnifiedSchedulingEngine: true},
+		{Compatibility: TASK_COMPATIBILITY_V2_2, Volatile: true},
+		{Compatibility: TASK_COMPATIBILITY_V2_2, MaintenanceSettings: MaintenanceSettings{Period: day}},
+		{Compatibility: TASK_COMPATIBILITY_V2_4, MaintenanceSettings: MaintenanceSettings{Period: day, Deadline: week, Exclusive: true}},
+	}
+	for _, settings := range valid {
+		if err := validateSettings(settings); err != nil {
+			t.Errorf("%+v: unexpected error: %v", settings, err)
+		}
+	}
+
+	invalid := []TaskSettings{
+		{Compatibility: TASK_COMPATIBILITY_V2, DisallowStartOnRemoteAppSession: true},
+		{Compatibility: TASK_COMPATIBILITY_V2, UseUnifiedSchedulingEngine: true},
+		{Compatibility: TASK_COMPATIBILITY_V2_1, Volatile: true},
+		{Compatibility: TASK_COMPATIBILITY_V2_1, MaintenanceSettings: MaintenanceSettings{Period: day}},
+		{Compatibility: TASK_COMPATIBILITY_V2_2, MaintenanceSettings: MaintenanceSettings{Exclusive: true}},
+		{Compatibility: TASK_COMPATIBILITY_V2_2, MaintenanceSettings: MaintenanceSettings{Deadline: week}},
+		{Compatibility: TASK_COMPATIBILITY_V2_2, MaintenanceSettings: MaintenanceSettings{Period: period.NewHMS(12, 0, 0)}},
+		{Compatibility: TASK_COMPATIBILITY_V2_2, MaintenanceSettings: MaintenanceSettings{Period: week, Deadline: week}},
+		{Compatibility: TASK_COMPATIBILITY_V2_2, MaintenanceSettings: MaintenanceSettings{Period: day, Deadline: period.NewHMS(12, 0, 0)}},
+	}
+	for _, settings := range invalid {
+		if err := validateSettings(settings); err == nil {
+			t.Errorf("%+v: expected error", settings)
+		}
+	}
+}
@@ -0,0 +1,565 @@
+package taskmaster
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rickb777/date/period"
+)
+
+// The Task Scheduler service caches the definitions of registered tasks in the
+// registry under HKLM\SOFTWARE\Microsoft\Windows NT\CurrentVersion\Schedule\TaskCache.
+// The Tasks subkey has a key for each task named by its GUID, and the Tree
+// subkey mirrors the folder hierarchy, mapping the path of each task to its GUID.
+//
+// The layouts of the binary values are undocumented. The decoders here follow
+// the layouts used by Windows 8 and later as reverse engineered by the forensics
+// community, and skip fields that are not understood.
+
+// signatures of the actions in the Actions value
+const (
+	taskCacheExecAction        = 0x6666
+	taskCacheComHandlerAction  = 0x7777
+	taskCacheEmailAction       = 0x8888
+	taskCacheShowMessageAction = 0x9999
+)
+
+// signatures of the triggers in the Triggers value
+const (
+	taskCacheWNFTrigger          = 0x6666
+	taskCacheSessionStateTrigger = 0x7777
+	taskCacheRegistrationTrigger = 0x8888
+	taskCacheLogonTrigger        = 0xAAAA
+	taskCacheEventTrigger        = 0xCCCC
+	taskCacheTimeTrigger         = 0xDDDD
+	taskCacheIdleTrigger         = 0xEEEE
+	taskCacheBootTrigger         = 0xFFFF
+)
+
+// schedule types of time triggers in the Triggers value
+const (
+	taskCacheScheduleTime = iota + 1
+	taskCacheScheduleDaily
+	taskCacheScheduleWeekly
+	taskCacheScheduleMonthly
+	taskCacheScheduleMonthlyDOW
+)
+
+// TaskCacheTask is a task in the TaskCache\Tasks registry key.
+type TaskCacheTask struct {
+	GUID               string // the name of the key of the task
+	Path               string // the path of the task
+	URI                string
+	Author             string
+	Description        string
+	Source             string
+	Version            string
+	Date               string
+	SecurityDescriptor string    // the SDDL of the task, not to be confused with the SD value of the Tree key
+	Context            string    // the ID of the principal the actions run as, only set by Windows 8 and later
+	Principal          Principal // the principal of the task. Only ID, Name and UserID are set
+	Actions            []Action
+	Triggers           []Trigger
+	DynamicInfo        TaskCacheDynamicInfo
+}
+
+// TaskCacheTreeEntry is a task or folder in the TaskCache\Tree registry key.
+type TaskCacheTreeEntry struct {
+	Path  string // the path of the task or folder, which is the path of its key relative to the Tree key
+	ID    string // the GUID of the task. Not set for folders
+	Index uint32 // the index of the task
+	SD    []byte // the security descriptor of the task or folder in self-relative format. If nil, the entry has no SD value
+}
+
+// TaskCacheDynamicInfo is the run time information of a task stored in the
+// DynamicInfo value of its TaskCache\Tasks key.
+type TaskCacheDynamicInfo struct {
+	CreationTime          time.Time  // the time the task was registered
+	LastRunTime           time.Time  // the time the task was last run
+	LastResult            TaskResult // the result that was returned the last time the task was run
+	LastSuccessfulRunTime time.Time  // the last time the task ran successfully. Only set by Windows 10 and later
+}
+
+// ParseTaskCacheTask parses the values of a TaskCache\Tasks\{GUID} registry key.
+// values maps the names of the values to their raw data. Values that are not
+// present are left unset.
+func ParseTaskCacheTask(guid string, values map[string][]byte) (TaskCacheTask, error) {
+	task := TaskCacheTask{
+		GUID:               guid,
+		Path:               regString(values["Path"]),
+		URI:                regString(values["URI"]),
+		Author:             regString(values["Author"]),
+		Description:        regString(values["Description"]),
+		Source:             regString(values["Source"]),
+		Version:            regString(values["Version"]),
+		Date:               regString(values["Date"]),
+		SecurityDescriptor: regString(values["SecurityDescriptor"]),
+	}
+
+	var err error
+	if data, ok := values["Actions"]; ok {
+		task.Context, task.Actions, err = ParseTaskCacheActions(data)
+		if err != nil {
+			return TaskCacheTask{}, fmt.Errorf("error parsing Actions value of task %s: %v", guid, err)
+		}
+	}
+	if data, ok := values["Triggers"]; ok {
+		task.Principal, task.Triggers, err = ParseTaskCacheTriggers(data)
+		if err != nil {
+			return TaskCacheTask{}, fmt.Errorf("error parsing Triggers value of task %s: %v", guid, err)
+		}
+	}
+	if data, ok := values["DynamicInfo"]; ok {
+		task.DynamicInfo, err = ParseTaskCacheDynamicInfo(data)
+		if err != nil {
+			return TaskCacheTask{}, fmt.Errorf("error parsing DynamicInfo value of task %s: %v", guid, err)
+		}
+	}
+
+	return task, nil
+}
+
+// ParseTaskCacheTreeEntry parses the values of a TaskCache\Tree registry key.
+// path is the path of the key relative to the Tree key, such as
+// \Microsoft\Windows\Defrag\ScheduledDefrag, and values maps the names of the
+// values to their raw data.
+func ParseTaskCacheTreeEntry(path string, values map[string][]byte) (TaskCacheTreeEntry, error) {
+	entry := TaskCacheTreeEntry{
+		Path: path,
+		ID:   regString(values["Id"]),
+		SD:   values["SD"],
+	}
+
+	if data, ok := values["Index"]; ok {
+		if len(data) != 4 {
+			return TaskCacheTreeEntry{}, fmt.Errorf("invalid Index value of %s: expected 4 bytes, got %d", path, len(data))
+		}
+		entry.Index = binary.LittleEndian.Uint32(data)
+	}
+
+	return entry, nil
+}
+
+// ParseTaskCacheDynamicInfo parses the DynamicInfo value of a TaskCache\Tasks key.
+func ParseTaskCacheDynamicInfo(data []byte) (TaskCacheDynamicInfo, error) {
+	// a version, the creation time, the last run time, the task
+	// state, the last result and on Windows 10 and later the last
+	// successful run time
+	if len(data) != 28 && len(data) != 36 {
+		return TaskCacheDynamicInfo{}, fmt.Errorf("invalid DynamicInfo length %d", len(data))
+	}
+
+	le := binary.LittleEndian
+	info := TaskCacheDynamicInfo{
+		CreationTime: fileTimeToTime(le.Uint64(data[4:])),
+		LastRunTime:  fileTimeToTime(le.Uint64(data[12:])),
+		LastResult:   TaskResult(le.Uint32(data[24:])),
+	}
+	if len(data) == 36 {
+		info.LastSuccessfulRunTime = fileTimeToTime(le.Uint64(data[28:]))
+	}
+
+	return info, nil
+}
+
+// ParseTaskCacheActions parses the Actions value of a TaskCache\Tasks key. It
+// returns the ID of the principal the actions run as, which is only stored by
+// Windows 8 and later, and the actions.
+func ParseTaskCacheActions(data []byte) (context string, actions []Action, err error) {
+	defer recoverTaskCacheError(&err)
+
+	r := &taskCacheReader{data: data}
+	version := r.u16()
+	if version >= 3 {
+		context = r.wstring()
+	}
+
+	for !r.done() {
+		signature := r.u16()
+		id := r.wstring()
+
+		switch signature {
+		case taskCacheExecAction:
+			action := ExecAction{
+				ID:         id,
+				Path:       r.wstring(),
+				Args:       r.wstring(),
+				WorkingDir: r.wstring(),
+			}
+			if version >= 3 {
+				// flags
+				r.u16()
+			}
+			actions = append(actions, action)
+		case taskCacheComHandlerAction:
+			actions = append(actions, ComHandlerAction{
+				ID:      id,
+				ClassID: formatGUID(r.bytes(16)),
+				Data:    r.wstring(),
+			})
+		case taskCacheEmailAction:
+			action := EmailAction{
+				ID:      id,
+				From:    r.wstring(),
+				To:      r.wstring(),
+				Cc:      r.wstring(),
+				Bcc:     r.wstring(),
+				ReplyTo: r.wstring(),
+				Server:  r.wstring(),
+				Subject: r.wstring(),
+				Body:    r.wstring(),
+			}
+			for n := r.u32(); n > 0; n-- {
+				action.Attachments = append(action.Attachments, r.wstring())
+			}
+			action.HeaderFields = make(map[string]string)
+			for n := r.u32(); n > 0; n-- {
+				name := r.wstring()
+				action.HeaderFields[name] = r.wstring()
+			}
+			actions = append(actions, action)
+		case taskCacheShowMessageAction:
+			actions = append(actions, ShowMessageAction{
+				ID:    id,
+				Title: r.wstring(),
+				Body:  r.wstring(),
+			})
+		default:
+			r.fail("unknown action signature %#x at offset %#x", signature, r.pos-2)
+		}
+	}
+
+	return context, actions, nil
+}
+
+// ParseTaskCacheTriggers parses the Triggers value of a TaskCache\Tasks key.
+// Besides the triggers, the value holds the principal of the task. Only the
+// ID, Name and UserID of the returned principal are set; UserID is the SID of
+// the user if the user name is not stored.
+func ParseTaskCacheTriggers(data []byte) (principal Principal, triggers []Trigger, err error) {
+	defer recoverTaskCacheError(&err)
+
+	r := &taskCacheReader{data: data}
+	version := r.u8()
+	if version < 0x15 || version > 0x17 {
+		r.fail("unsupported Triggers version %#x", version)
+	}
+	r.skip(7)
+	// the start and end boundaries of the task
+	r.tstime()
+	r.tstime()
+
+	// the job bucket holds the principal and some settings of the task
+	// flags
+	r.u32()
+	r.skip(4)
+	// CRC32 of the definition
+	r.u32()
+	r.skip(4)
+	principal.ID = r.alignedWString()
+	principal.Name = r.alignedWString()
+	principal.UserID = r.userInfo()
+	optionalSettingsLen := int(r.u32())
+	r.skip(4)
+	r.skip(optionalSettingsLen)
+	r.align()
+
+	for !r.done() {
+		trigger := r.trigger()
+		triggers = append(triggers, trigger)
+	}
+
+	return principal, triggers, nil
+}
+
+// regString decodes the data of a REG_SZ value.
+func regString(data []byte) string {
+	return strings.TrimRight(decodeUTF16(data), "\x00")
+}
+
+// secondsToPeriod converts a number of seconds to a period of hours, minutes
+// and seconds.
+func secondsToPeriod(seconds uint32) period.Period {
+	if seconds == 0 {
+		return period.Period{}
+	}
+
+	return period.NewHMS(int(seconds/3600), int(seconds%3600/60), int(seconds%60))
+}
+
+// taskCacheError is used to abort parsing of malformed TaskCache values.
+type taskCacheError string
+
+func recoverTaskCacheError(err *error) {
+	if r := recover(); r != nil {
+		if e, ok := r.(taskCacheError); ok {
+			*err = errors.New(string(e))
+			return
+		}
+		panic(r)
+	}
+}
+
+// taskCacheReader reads the little endian fields of a TaskCache value.
+type taskCacheReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *taskCacheReader) fail(format string, args ...interface{}) {
+	panic(taskCacheError(fmt.Sprintf(format, args...)))
+}
+
+func (r *taskCacheReader) done() bool {
+	return r.pos >= len(r.data)
+}
+
+func (r *taskCacheReader) bytes(n int) []byte {
+	if n < 0 || r.pos+n > len(r.data) {
+		r.fail("unexpected end of data at offset %#x", r.pos)
+	}
+	r.pos += n
+	return r.data[r.pos-n : r.pos]
+}
+
+func (r *taskCacheReader) skip(n int) {
+	r.bytes(n)
+}
+
+// align skips padding up to the next multiple of 8 bytes.
+func (r *taskCacheReader) align() {
+	if rem := r.pos % 8; rem != 0 {
+		r.skip(8 - rem)
+	}
+}
+
+func (r *taskCacheReader) u8() byte {
+	return r.bytes(1)[0]
+}
+
+func (r *taskCacheReader) u16() uint16 {
+	return binary.LittleEndian.Uint16(r.bytes(2))
+}
+
+func (r *taskCacheReader) u32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(4))
+}
+
+func (r *taskCacheReader) u64() uint64 {
+	return binary.LittleEndian.Uint64(r.bytes(8))
+}
+
+// wstring reads a UTF-16 string prefixed with its length in bytes.
+func (r *taskCacheReader) wstring() string {
+	n := int(r.u32())
+	return strings.TrimRight(decodeUTF16(r.bytes(n)), "\x00")
+}
+
+// alignedBytes reads data prefixed with its length in bytes, where both the
+// length and the data are padded to 8 bytes.
+func (r *taskCacheReader) alignedBytes() []byte {
+	n := int(r.u32())
+	r.skip(4)
+	data := r.bytes(n)
+	r.align()
+
+	return data
+}
+
+func (r *taskCacheReader) alignedWString() string {
+	return strings.TrimRight(decodeUTF16(r.alignedBytes()), "\x00")
+}
+
+// tstime reads a time that is either in UTC or local time depending on a
+// flag. Local times are returned as is in UTC, the same as task dates that
+// have no time zone.
+func (r *taskCacheReader) tstime() time.Time {
+	// is localized flag
+	r.u8()
+	r.skip(7)
+
+	return fileTimeToTime(r.u64())
+}
+
+// userInfo reads the user of a principal or trigger and returns its name, or
+// its SID if the name is not stored.
+func (r *taskCacheReader) userInfo() string {
+	skipUser := r.u8()
+	r.skip(7)
+	if skipUser != 0 {
+		return ""
+	}
+
+	skipSID := r.u8()
+	r.skip(7)
+	// SID type
+	r.u32()
+	r.skip(4)
+
+	var sid string
+	if skipSID == 0 {
+		var err error
+		sid, err = formatSID(r.alignedBytes())
+		if err != nil {
+			r.fail("invalid user SID: %v", err)
+		}
+	}
+	if userName := r.alignedWString(); userName != "" {
+		return userName
+	}
+
+	return sid
+}
+
+func (r *taskCacheReader) trigger() Trigger {
+	offset := r.pos
+	signature := r.u32()
+	r.skip(4)
+
+	startBoundary := r.tstime()
+	endBoundary := r.tstime()
+	delay := secondsToPeriod(r.u32())
+	executionTimeLimit := secondsToPeriod(r.u32())
+	repetitionInterval := secondsToPeriod(r.u32())
+	repetitionDuration := secondsToPeriod(r.u32())
+	// the repetition duration again
+	r.u32()
+	stopAtDurationEnd := r.u8() != 0
+	r.skip(3)
+	enabled := r.u8() != 0
+	r.skip(15)
+	id := r.alignedWString()
+
+	taskTrigger := TaskTrigger{
+		Enabled:            enabled,
+		EndBoundary:        endBoundary,
+		ExecutionTimeLimit: executionTimeLimit,
+		ID:                 id,
+		RepetitionPattern: RepetitionPattern{
+			RepetitionDuration: repetitionDuration,
+			RepetitionInterval: repetitionInterval,
+			StopAtDurationEnd:  stopAtDurationEnd,
+		},
+		StartBoundary: startBoundary,
+	}
+
+	switch signature {
+	case taskCacheBootTrigger:
+		return BootTrigger{
+			TaskTrigger: taskTrigger,
+			Delay:       delay,
+		}
+	case taskCacheIdleTrigger:
+		return IdleTrigger{
+			TaskTrigger: taskTrigger,
+		}
+	case taskCacheRegistrationTrigger:
+		return RegistrationTrigger{
+			TaskTrigger: taskTrigger,
+			Delay:       delay,
+		}
+	case taskCacheLogonTrigger:
+		return LogonTrigger{
+			TaskTrigger: taskTrigger,
+			Delay:       delay,
+			UserID:      r.userInfo(),
+		}
+	case taskCacheSessionStateTrigger:
+		stateChange := TaskSessionStateChangeType(r.u32())
+		r.skip(4)
+
+		return SessionStateChangeTrigger{
+			TaskTrigger: taskTrigger,
+			Delay:       delay,
+			StateChange: stateChange,
+			UserId:      r.userInfo(),
+		}
+	case taskCacheEventTrigger:
+		subscription := r.alignedWString()
+		numQueries := int(r.u32())
+		r.skip(4)
+		valueQueries := make(map[string]string)
+		for i := 0; i < numQueries; i++ {
+			name := r.alignedWString()
+			valueQueries[name] = r.alignedWString()
+		}
+
+		return EventTrigger{
+			TaskTrigger:  taskTrigger,
+			Delay:        delay,
+			Subscription: subscription,
+			ValueQueries: valueQueries,
+		}
+	case taskCacheWNFTrigger:
+		stateName := WNFStateName(r.u64())
+		data := r.alignedBytes()
+
+		return CustomTrigger{
+			TaskTrigger: taskTrigger,
+			Name:        wnfStateChangeTrigger,
+			Delay:       delay,
+			StateName:   stateName,
+			Data:        data,
+		}
+	case taskCacheTimeTrigger:
+		return r.timeTrigger(taskTrigger)
+	default:
+		r.fail("unknown trigger signature %#x at offset %#x", signature, offset)
+		return nil
+	}
+}
+
+func (r *taskCacheReader) timeTrigger(taskTrigger TaskTrigger) Trigger {
+	scheduleType := r.u32()
+	randomDelay := secondsToPeriod(r.u32())
+	field1 := r.u16()
+	field2 := r.u16()
+	field3 := r.u16()
+	r.skip(2)
+	daysOfMonth := r.u32()
+	r.skip(4)
+
+	switch scheduleType {
+	case taskCacheScheduleTime:
+		return TimeTrigger{
+			TaskTrigger: taskTrigger,
+			RandomDelay: randomDelay,
+		}
+	case taskCacheScheduleDaily:
+		return DailyTrigger{
+			TaskTrigger: taskTrigger,
+			DayInterval: DayInterval(field1),
+			RandomDelay: randomDelay,
+		}
+	case taskCacheScheduleWeekly:
+		return WeeklyTrigger{
+			TaskTrigger:  taskTrigger,
+			DaysOfWeek:   DayOfWeek(field1),
+			RandomDelay:  randomDelay,
+			WeekInterval: WeekInterval(field2),
+		}
+	case taskCacheScheduleMonthly:
+		return MonthlyTrigger{
+			TaskTrigger:          taskTrigger,
+			DaysOfMonth:          DayOfMonth(daysOfMonth) &^ LastDayOfMonth,
+			MonthsOfYear:         Month(field2),
+			RandomDelay:          randomDelay,
+			RunOnLastWeekOfMonth: DayOfMonth(daysOfMonth)&LastDayOfMonth != 0,
+		}
+	case taskCacheScheduleMonthlyDOW:
+		return MonthlyDOWTrigger{
+			TaskTrigger:          taskTrigger,
+			DaysOfWeek:           DayOfWeek(field1),
+			MonthsOfYear:         Month(field2),
+			RandomDelay:          randomDelay,
+			RunOnLastWeekOfMonth: Week(field3)&LastWeek != 0,
+			WeeksOfMonth:         Week(field3) &^ LastWeek,
+		}
+	default:
+		r.fail("unknown schedule type %d of time trigger", scheduleType)
+		return nil
+	}
+}
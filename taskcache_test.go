@@ -0,0 +1,419 @@
+package taskmaster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/rickb777/date/period"
+)
+
+// taskCacheBuilder encodes TaskCache registry values.
+type taskCacheBuilder struct {
+	buf bytes.Buffer
+}
+
+func (b *taskCacheBuilder) u8(v byte) {
+	b.buf.WriteByte(v)
+}
+
+func (b *taskCacheBuilder) u16(v uint16) {
+	binary.Write(&b.buf, binary.LittleEndian, v)
+}
+
+func (b *taskCacheBuilder) u32(v uint32) {
+	binary.Write(&b.buf, binary.LittleEndian, v)
+}
+
+func (b *taskCacheBuilder) u64(v uint64) {
+	binary.Write(&b.buf, binary.LittleEndian, v)
+}
+
+func (b *taskCacheBuilder) pad(n int) {
+	b.buf.Write(make([]byte, n))
+}
+
+func (b *taskCacheBuilder) align() {
+	if rem := b.buf.Len() % 8; rem != 0 {
+		b.pad(8 - rem)
+	}
+}
+
+func utf16Bytes(s string) []byte {
+	var buf bytes.Buffer
+	for _, c := range utf16.Encode([]rune(s)) {
+		binary.Write(&buf, binary.LittleEndian, c)
+	}
+
+	return buf.Bytes()
+}
+
+func (b *taskCacheBuilder) wstring(s string) {
+	data := utf16Bytes(s)
+	b.u32(uint32(len(data)))
+	b.buf.Write(data)
+}
+
+func (b *taskCacheBuilder) alignedBytes(data []byte) {
+	b.u32(uint32(len(data)))
+	b.pad(4)
+	b.buf.Write(data)
+	b.align()
+}
+
+func (b *taskCacheBuilder) alignedWString(s string) {
+	b.alignedBytes(utf16Bytes(s))
+}
+
+func (b *taskCacheBuilder) tstime(t time.Time) {
+	b.u8(0)
+	b.pad(7)
+	if t.IsZero() {
+		b.u64(0)
+	} else {
+		b.u64(timeToFileTime(t))
+	}
+}
+
+func (b *taskCacheBuilder) userInfo(sid []byte, userName string) {
+	b.u8(0)
+	b.pad(7)
+	if sid == nil {
+		b.u8(1)
+	} else {
+		b.u8(0)
+	}
+	b.pad(7)
+	b.u32(1)
+	b.pad(4)
+	if sid != nil {
+		b.alignedBytes(sid)
+	}
+	b.alignedWString(userName)
+}
+
+func (b *taskCacheBuilder) triggerHeader(signature uint32, start time.Time, delay, repetitionInterval uint32, id string) {
+	b.u32(signature)
+	b.pad(4)
+	b.tstime(start)
+	b.tstime(time.Time{})
+	b.u32(delay)
+	b.u32(0)
+	b.u32(repetitionInterval)
+	b.u32(0)
+	b.u32(0)
+	b.u8(0)
+	b.pad(3)
+	b.u8(1)
+	b.pad(15)
+	b.alignedWString(id)
+}
+
+// the SID S-1-5-18
+var testSystemSID = []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}
+
+func TestParseTaskCacheActions(t *testing.T) {
+	var b taskCacheBuilder
+	b.u16(3)
+	b.wstring("Author")
+	b.u16(taskCacheExecAction)
+	b.wstring("exec")
+	b.wstring("cmd.exe")
+	b.wstring("/c exit")
+	b.wstring(`C:\`)
+	b.u16(0)
+	b.u16(taskCacheComHandlerAction)
+	b.wstring("")
+	b.buf.Write([]byte{0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x34, 0x12, 0x12, 0x34, 0x12, 0x34, 0x56, 0x78, 0x9A, 0xBC})
+	b.wstring("data")
+
+	context, actions, err := ParseTaskCacheActions(b.buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if context != "Author" {
+		t.Errorf("expected context Author, got %q", context)
+	}
+	if len(actions) != 2 {
+		t.Fatalf("expected 2 actions, got %d", len(actions))
+	}
+	expectedExec := ExecAction{ID: "exec", Path: "cmd.exe", Args: "/c exit", WorkingDir: `C:\`}
+	if actions[0] != expectedExec {
+		t.Errorf("expected action %+v, got %+v", expectedExec, actions[0])
+	}
+	expectedComHandler := ComHandlerAction{ClassID: "{12345678-1234-1234-1234-123456789ABC}", Data: "data"}
+	if actions[1] != expectedComHandler {
+		t.Errorf("expected action %+v, got %+v", expectedComHandler, actions[1])
+	}
+
+	// truncated values return an error
+	if _, _, err := ParseTaskCacheActions(b.buf.Bytes()[:b.buf.Len()-3]); err == nil {
+		t.Error("expected error parsing truncated Actions value")
+	}
+
+	// Windows 7 does not store the context or flags
+	b = taskCacheBuilder{}
+	b.u16(1)
+	b.u16(taskCacheExecAction)
+	b.wstring("")
+	b.wstring("notepad.exe")
+	b.wstring("")
+	b.wstring("")
+	context, actions, err = ParseTaskCacheActions(b.buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if context != "" || len(actions) != 1 || actions[0].(ExecAction).Path != "notepad.exe" {
+		t.Errorf("unexpected context %q or actions %+v", context, actions)
+	}
+}
+
+func TestParseTaskCacheDeprecatedActions(t *testing.T) {
+	var b taskCacheBuilder
+	b.u16(3)
+	b.wstring("Author")
+	b.u16(taskCacheEmailAction)
+	b.wstring("mail")
+	for _, s := range []string{"backup@example.com", "ops@example.com", "", "", "", "smtp.example.com", "Backup finished", "Done."} {
+		b.wstring(s)
+	}
+	b.u32(1)
+	b.wstring(`C:\Logs\backup.log`)
+	b.u32(1)
+	b.wstring("X-Priority")
+	b.wstring("1")
+	b.u16(taskCacheShowMessageAction)
+	b.wstring("")
+	b.wstring("Backup")
+	b.wstring("Done.")
+
+	_, actions, err := ParseTaskCacheActions(b.buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 2 {
+		t.Fatalf("expected 2 actions, got %d", len(actions))
+	}
+	expectedEmail := EmailAction{
+		ID:           "mail",
+		Server:       "smtp.example.com",
+		From:         "backup@example.com",
+		To:           "ops@example.com",
+		Subject:      "Backup finished",
+		Body:         "Done.",
+		HeaderFields: map[string]string{"X-Priority": "1"},
+		Attachments:  []string{`C:\Logs\backup.log`},
+	}
+	if !reflect.DeepEqual(actions[0], expectedEmail) {
+		t.Errorf("expected action %+v, got %+v", expectedEmail, actions[0])
+	}
+	expectedMessage := ShowMessageAction{Title: "Backup", Body: "Done."}
+	if actions[1] != expectedMessage {
+		t.Errorf("expected action %+v, got %+v", expectedMessage, actions[1])
+	}
+}
+
+func TestParseTaskCacheTriggers(t *testing.T) {
+	start := time.Date(2020, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	var b taskCacheBuilder
+	b.u8(0x17)
+	b.pad(7)
+	b.tstime(start)
+	b.tstime(time.Time{})
+	// job bucket
+	b.u32(0)
+	b.pad(4)
+	b.u32(0xdeadbeef)
+	b.pad(4)
+	b.alignedWString("LocalSystem")
+	b.alignedWString("")
+	b.userInfo(testSystemSID, "")
+	b.u32(12)
+	b.pad(4)
+	b.pad(16)
+
+	b.triggerHeader(taskCacheTimeTrigger, start, 0, 3600, "weekly")
+	b.u32(taskCacheScheduleWeekly)
+	b.u32(300)
+	b.u16(uint16(Monday | Friday))
+	b.u16(uint16(EveryOtherWeek))
+	b.u16(0)
+	b.pad(2)
+	b.u32(0)
+	b.pad(4)
+
+	b.triggerHeader(taskCacheTimeTrigger, start, 0, 0, "")
+	b.u32(taskCacheScheduleMonthly)
+	b.u32(0)
+	b.u16(0)
+	b.u16(uint16(January | July))
+	b.u16(0)
+	b.pad(2)
+	b.u32(uint32(One | Fifteen | LastDayOfMonth))
+	b.pad(4)
+
+	b.triggerHeader(taskCacheLogonTrigger, time.Time{}, 30, 0, "")
+	b.userInfo(nil, `DOMAIN\user`)
+
+	b.triggerHeader(taskCacheSessionStateTrigger, time.Time{}, 0, 0, "")
+	b.u32(uint32(TASK_SESSION_LOCK))
+	b.pad(4)
+	b.userInfo(testSystemSID, "")
+
+	b.triggerHeader(taskCacheEventTrigger, time.Time{}, 0, 0, "")
+	b.alignedWString("<QueryList></QueryList>")
+	b.u32(1)
+	b.pad(4)
+	b.alignedWString("id")
+	b.alignedWString("Event/System/EventID")
+
+	b.triggerHeader(taskCacheBootTrigger, time.Time{}, 60, 0, "")
+	b.triggerHeader(taskCacheWNFTrigger, time.Time{}, 0, 0, "")
+	b.u64(0x0D83063EA3BE0075)
+	b.alignedBytes([]byte{1, 2, 3})
+
+	principal, triggers, err := ParseTaskCacheTriggers(b.buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPrincipal := Principal{ID: "LocalSystem", UserID: "S-1-5-18"}
+	if !reflect.DeepEqual(principal, expectedPrincipal) {
+		t.Errorf("expected principal %+v, got %+v", expectedPrincipal, principal)
+	}
+	if len(triggers) != 7 {
+		t.Fatalf("expected 7 triggers, got %d", len(triggers))
+	}
+
+	weekly, ok := triggers[0].(WeeklyTrigger)
+	if !ok {
+		t.Fatalf("expected WeeklyTrigger, got %T", triggers[0])
+	}
+	if weekly.ID != "weekly" || !weekly.Enabled || !weekly.StartBoundary.Equal(start) || weekly.RepetitionInterval != period.NewHMS(1, 0, 0) ||
+		weekly.RandomDelay != period.NewHMS(0, 5, 0) || weekly.DaysOfWeek != Monday|Friday || weekly.WeekInterval != EveryOtherWeek {
+		t.Errorf("unexpected weekly trigger %+v", weekly)
+	}
+
+	monthly, ok := triggers[1].(MonthlyTrigger)
+	if !ok {
+		t.Fatalf("expected MonthlyTrigger, got %T", triggers[1])
+	}
+	if monthly.DaysOfMonth != One|Fifteen || !monthly.RunOnLastWeekOfMonth || monthly.MonthsOfYear != January|July {
+		t.Errorf("unexpected monthly trigger %+v", monthly)
+	}
+
+	logon, ok := triggers[2].(LogonTrigger)
+	if !ok {
+		t.Fatalf("expected LogonTrigger, got %T", triggers[2])
+	}
+	if logon.UserID != `DOMAIN\user` || logon.Delay != period.NewHMS(0, 0, 30) {
+		t.Errorf("unexpected logon trigger %+v", logon)
+	}
+
+	session, ok := triggers[3].(SessionStateChangeTrigger)
+	if !ok {
+		t.Fatalf("expected SessionStateChangeTrigger, got %T", triggers[3])
+	}
+	if session.StateChange != TASK_SESSION_LOCK || session.UserId != "S-1-5-18" {
+		t.Errorf("unexpected session state change trigger %+v", session)
+	}
+
+	event, ok := triggers[4].(EventTrigger)
+	if !ok {
+		t.Fatalf("expected EventTrigger, got %T", triggers[4])
+	}
+	if event.Subscription != "<QueryList></QueryList>" || event.ValueQueries["id"] != "Event/System/EventID" {
+		t.Errorf("unexpected event trigger %+v", event)
+	}
+
+	boot, ok := triggers[5].(BootTrigger)
+	if !ok {
+		t.Fatalf("expected BootTrigger, got %T", triggers[5])
+	}
+	if boot.Delay != period.NewHMS(0, 1, 0) {
+		t.Errorf("unexpected boot trigger %+v", boot)
+	}
+
+	customTrigger, ok := triggers[6].(CustomTrigger)
+	if !ok {
+		t.Fatalf("expected CustomTrigger, got %T", triggers[6])
+	}
+	if customTrigger.Name != "WnfStateChangeTrigger" || customTrigger.StateName != 0x0D83063EA3BE0075 || !reflect.DeepEqual(customTrigger.Data, []byte{1, 2, 3}) {
+		t.Errorf("unexpected custom trigger %+v", customTrigger)
+	}
+
+	if _, _, err := ParseTaskCacheTriggers([]byte{0x01, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Error("expected error parsing unsupported Triggers version")
+	}
+}
+
+func TestParseTaskCacheTask(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	lastRun := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	var dynamicInfo taskCacheBuilder
+	dynamicInfo.u32(3)
+	dynamicInfo.u64(timeToFileTime(created))
+	dynamicInfo.u64(timeToFileTime(lastRun))
+	dynamicInfo.u32(0)
+	dynamicInfo.u32(uint32(SCHED_S_TASK_HAS_NOT_RUN))
+	dynamicInfo.u64(timeToFileTime(lastRun))
+
+	var actions taskCacheBuilder
+	actions.u16(3)
+	actions.wstring("Author")
+	actions.u16(taskCacheExecAction)
+	actions.wstring("")
+	actions.wstring("cmd.exe")
+	actions.wstring("")
+	actions.wstring("")
+	actions.u16(0)
+
+	values := map[string][]byte{
+		"Path":        append(utf16Bytes(`\Taskmaster\Task`), 0, 0),
+		"Author":      append(utf16Bytes(`DOMAIN\user`), 0, 0),
+		"Actions":     actions.buf.Bytes(),
+		"DynamicInfo": dynamicInfo.buf.Bytes(),
+	}
+	task, err := ParseTaskCacheTask("{GUID}", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.GUID != "{GUID}" || task.Path != `\Taskmaster\Task` || task.Author != `DOMAIN\user` || task.Context != "Author" || len(task.Actions) != 1 || task.Triggers != nil {
+		t.Errorf("unexpected task %+v", task)
+	}
+	expectedInfo := TaskCacheDynamicInfo{
+		CreationTime:          created,
+		LastRunTime:           lastRun,
+		LastResult:            SCHED_S_TASK_HAS_NOT_RUN,
+		LastSuccessfulRunTime: lastRun,
+	}
+	if task.DynamicInfo != expectedInfo {
+		t.Errorf("expected dynamic info %+v, got %+v", expectedInfo, task.DynamicInfo)
+	}
+
+	values["DynamicInfo"] = values["DynamicInfo"][:20]
+	if _, err := ParseTaskCacheTask("{GUID}", values); err == nil {
+		t.Error("expected error parsing invalid DynamicInfo value")
+	}
+}
+
+func TestParseTaskCacheTreeEntry(t *testing.T) {
+	entry, err := ParseTaskCacheTreeEntry(`\Taskmaster\Task`, map[string][]byte{
+		"Id":    append(utf16Bytes("{GUID}"), 0, 0),
+		"Index": {3, 0, 0, 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Path != `\Taskmaster\Task` || entry.ID != "{GUID}" || entry.Index != 3 || entry.SD != nil {
+		t.Errorf("unexpected tree entry %+v", entry)
+	}
+
+	if _, err := ParseTaskCacheTreeEntry(`\Task`, map[string][]byte{"Index": {1}}); err == nil {
+		t.Error("expected error parsing invalid Index value")
+	}
+}
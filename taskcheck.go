@@ -0,0 +1,236 @@
+package taskmaster
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rickb777/date/period"
+)
+
+// InconsistencyType is the kind of inconsistency between the task XML store and
+// the TaskCache registry key.
+type InconsistencyType uint
+
+const (
+	MissingFromTaskCache InconsistencyType = iota // a task is in the XML store but not in TaskCache
+	MissingFromTaskStore                          // a task is in TaskCache but not in the XML store
+	MissingTreeEntry                              // a TaskCache\Tasks key has no TaskCache\Tree key
+	MissingTaskEntry                              // a TaskCache\Tree key references a TaskCache\Tasks key that doesn't exist
+	MissingSD                                     // a TaskCache\Tree key has no SD value, which hides the task from the Task Scheduler service
+	IndexMismatch                                 // a TaskCache\Tree key and the TaskCache\Tasks key it references disagree on the path or GUID of a task
+	DefinitionMismatch                            // the actions or triggers of a task differ between the XML store and TaskCache
+)
+
+func (t InconsistencyType) String() string {
+	switch t {
+	case MissingFromTaskCache:
+		return "Missing From TaskCache"
+	case MissingFromTaskStore:
+		return "Missing From Task Store"
+	case MissingTreeEntry:
+		return "Missing Tree Entry"
+	case MissingTaskEntry:
+		return "Missing Task Entry"
+	case MissingSD:
+		return "Missing SD"
+	case IndexMismatch:
+		return "Index Mismatch"
+	case DefinitionMismatch:
+		return "Definition Mismatch"
+	default:
+		return ""
+	}
+}
+
+// Inconsistency is a difference between the task XML store and the TaskCache
+// registry key, which may be a sign of tampering.
+type Inconsistency struct {
+	Type    InconsistencyType
+	Path    string        // the path of the task or folder
+	GUID    string        // the GUID of the task in TaskCache, if known
+	Detail  string        // a description of the inconsistency
+	Changes []FieldChange // the fields that differ from the XML store to TaskCache. Only set if Type is DefinitionMismatch
+}
+
+func (i Inconsistency) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.Path, i.Type, i.Detail)
+}
+
+// CheckTaskCache compares the tasks of an XML store, such as one returned by
+// LoadTaskStore, to the tasks and tree entries of the TaskCache registry key of
+// the same system, and returns the inconsistencies between them sorted by path.
+// Paths are compared case insensitively. Tasks that failed to load from the XML
+// store are reported as missing from it.
+//
+// Only the actions and triggers of definitions are compared, as the rest of a
+// definition is not stored in TaskCache. Durations are compared by length, as
+// TaskCache stores them in seconds.
+func CheckTaskCache(store TaskFolder, tasks []TaskCacheTask, tree []TaskCacheTreeEntry) []Inconsistency {
+	var inconsistencies []Inconsistency
+	report := func(t InconsistencyType, path, guid, format string, args ...interface{}) {
+		inconsistencies = append(inconsistencies, Inconsistency{
+			Type:   t,
+			Path:   path,
+			GUID:   guid,
+			Detail: fmt.Sprintf(format, args...),
+		})
+	}
+
+	storeTasks := make(map[string]RegisteredTask)
+	var addFolder func(*TaskFolder)
+	addFolder = func(folder *TaskFolder) {
+		for _, task := range folder.RegisteredTasks {
+			storeTasks[strings.ToLower(task.Path)] = task
+		}
+		for _, subFolder := range folder.SubFolders {
+			addFolder(subFolder)
+		}
+	}
+	addFolder(&store)
+
+	cacheTasks := make(map[string]TaskCacheTask)
+	cacheTasksByPath := make(map[string]TaskCacheTask)
+	for _, task := range tasks {
+		cacheTasks[strings.ToLower(task.GUID)] = task
+		cacheTasksByPath[strings.ToLower(task.Path)] = task
+	}
+	treeEntries := make(map[string]TaskCacheTreeEntry)
+	for _, entry := range tree {
+		treeEntries[strings.ToLower(entry.Path)] = entry
+	}
+
+	for _, entry := range tree {
+		if entry.SD == nil {
+			report(MissingSD, entry.Path, entry.ID, "tree entry has no SD value")
+		}
+		// entries without an ID are folders
+		if entry.ID == "" {
+			continue
+		}
+
+		task, ok := cacheTasks[strings.ToLower(entry.ID)]
+		if !ok {
+			report(MissingTaskEntry, entry.Path, entry.ID, "tree entry references task %s which doesn't exist", entry.ID)
+		} else if !strings.EqualFold(task.Path, entry.Path) {
+			report(IndexMismatch, entry.Path, entry.ID, "tree entry references task %s which has path %s", entry.ID, task.Path)
+		}
+
+		if _, ok := storeTasks[strings.ToLower(entry.Path)]; !ok {
+			if _, ok := cacheTasksByPath[strings.ToLower(entry.Path)]; !ok {
+				report(MissingFromTaskStore, entry.Path, entry.ID, "tree entry has no task file")
+			}
+		}
+	}
+
+	for _, task := range tasks {
+		entry, ok := treeEntries[strings.ToLower(task.Path)]
+		if !ok {
+			report(MissingTreeEntry, task.Path, task.GUID, "task has no tree entry")
+		} else if !strings.EqualFold(entry.ID, task.GUID) {
+			report(IndexMismatch, task.Path, task.GUID, "tree entry references task %s instead", entry.ID)
+		}
+
+		storeTask, ok := storeTasks[strings.ToLower(task.Path)]
+		if !ok {
+			report(MissingFromTaskStore, task.Path, task.GUID, "task has no task file")
+			continue
+		}
+
+		changes := diffTaskCacheDefinition(storeTask.Definition, task)
+		if len(changes) != 0 {
+			inconsistencies = append(inconsistencies, Inconsistency{
+				Type:    DefinitionMismatch,
+				Path:    task.Path,
+				GUID:    task.GUID,
+				Detail:  fmt.Sprintf("%d fields differ, first %s", len(changes), changes[0]),
+				Changes: changes,
+			})
+		}
+	}
+
+	for path, task := range storeTasks {
+		_, inTasks := cacheTasksByPath[path]
+		_, inTree := treeEntries[path]
+		if !inTasks && !inTree {
+			report(MissingFromTaskCache, task.Path, "", "task file is not in TaskCache")
+		}
+	}
+
+	sort.SliceStable(inconsistencies, func(i, j int) bool {
+		pathI, pathJ := strings.ToLower(inconsistencies[i].Path), strings.ToLower(inconsistencies[j].Path)
+		if pathI != pathJ {
+			return pathI < pathJ
+		}
+
+		return inconsistencies[i].Type < inconsistencies[j].Type
+	})
+
+	return inconsistencies
+}
+
+// diffTaskCacheDefinition compares the actions and triggers of a definition
+// from the XML store to those of a TaskCache task.
+func diffTaskCacheDefinition(storeDef Definition, task TaskCacheTask) []FieldChange {
+	oldDef := Definition{
+		Actions:  storeDef.Actions,
+		Triggers: storeDef.Triggers,
+	}
+	newDef := Definition{
+		Actions:  task.Actions,
+		Triggers: task.Triggers,
+	}
+	// the context is only stored by Windows 8 and later
+	if task.Context != "" {
+		oldDef.Context = storeDef.Context
+		newDef.Context = task.Context
+	}
+
+	normalizePeriods(reflect.ValueOf(&oldDef).Elem())
+	normalizePeriods(reflect.ValueOf(&newDef).Elem())
+
+	return DiffDefinitions(oldDef, newDef)
+}
+
+var periodType = reflect.TypeOf(period.Period{})
+
+// normalizePeriods converts the periods of v to hours, minutes and seconds so
+// periods of the same length are equal. Slices and interfaces are copied so
+// the values they reference are not modified.
+func normalizePeriods(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == periodType {
+			p := v.Interface().(period.Period)
+			seconds := p.DurationApprox() / time.Second
+			v.Set(reflect.ValueOf(secondsToPeriod(uint32(seconds))))
+			return
+		}
+		if !hasOnlyExportedFields(v.Type()) {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			normalizePeriods(v.Field(i))
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		elems := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(elems, v)
+		for i := 0; i < elems.Len(); i++ {
+			normalizePeriods(elems.Index(i))
+		}
+		v.Set(elems)
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		normalizePeriods(elem)
+		v.Set(elem)
+	}
+}
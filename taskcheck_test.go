@@ -0,0 +1,104 @@
+package taskmaster
+
+import (
+	"testing"
+
+	"github.com/rickb777/date/period"
+)
+
+func TestCheckTaskCache(t *testing.T) {
+	sd := []byte{1, 0, 4, 0x80}
+	newDef := func(path string, interval period.Period) Definition {
+		return Definition{
+			Context: "Author",
+			Actions: []Action{ExecAction{Path: path}},
+			Triggers: []Trigger{BootTrigger{
+				TaskTrigger: TaskTrigger{
+					Enabled:           true,
+					RepetitionPattern: RepetitionPattern{RepetitionInterval: interval},
+				},
+			}},
+		}
+	}
+	newCacheTask := func(guid, path, exe string) TaskCacheTask {
+		def := newDef(exe, period.NewHMS(24, 0, 0))
+		return TaskCacheTask{
+			GUID:     guid,
+			Path:     path,
+			Context:  def.Context,
+			Actions:  def.Actions,
+			Triggers: def.Triggers,
+		}
+	}
+
+	store := TaskFolder{
+		Path: `\`,
+		RegisteredTasks: RegisteredTaskCollection{
+			{Path: `\Consistent`, Definition: newDef("a.exe", period.NewYMD(0, 0, 1))},
+			{Path: `\FileOnly`, Definition: newDef("a.exe", period.Period{})},
+		},
+		SubFolders: []*TaskFolder{{
+			Path: `\Folder`,
+			RegisteredTasks: RegisteredTaskCollection{
+				{Path: `\Folder\Hidden`, Definition: newDef("a.exe", period.NewYMD(0, 0, 1))},
+				{Path: `\Folder\Changed`, Definition: newDef("a.exe", period.NewYMD(0, 0, 1))},
+				{Path: `\Folder\Dangling`, Definition: newDef("a.exe", period.NewYMD(0, 0, 1))},
+			},
+		}},
+	}
+	tasks := []TaskCacheTask{
+		newCacheTask("{1}", `\consistent`, "a.exe"),
+		newCacheTask("{2}", `\Folder\Hidden`, "a.exe"),
+		newCacheTask("{3}", `\Folder\Changed`, "evil.exe"),
+		newCacheTask("{4}", `\Orphan`, "a.exe"),
+		newCacheTask("{5}", `\NoTree`, "a.exe"),
+	}
+	tree := []TaskCacheTreeEntry{
+		{Path: `\Folder`, SD: sd},
+		{Path: `\Consistent`, ID: "{1}", SD: sd},
+		{Path: `\Folder\Hidden`, ID: "{2}"},
+		// references the task of \Folder\Hidden
+		{Path: `\Folder\Changed`, ID: "{2}", SD: sd},
+		{Path: `\Folder\Dangling`, ID: "{6}", SD: sd},
+		{Path: `\Orphan`, ID: "{1}", SD: sd},
+	}
+
+	expected := []struct {
+		typ  InconsistencyType
+		path string
+	}{
+		{MissingFromTaskCache, `\FileOnly`},
+		{IndexMismatch, `\Folder\Changed`},
+		{IndexMismatch, `\Folder\Changed`},
+		{DefinitionMismatch, `\Folder\Changed`},
+		{MissingTaskEntry, `\Folder\Dangling`},
+		{MissingSD, `\Folder\Hidden`},
+		{MissingFromTaskStore, `\NoTree`},
+		{MissingTreeEntry, `\NoTree`},
+		{MissingFromTaskStore, `\Orphan`},
+		{IndexMismatch, `\Orphan`},
+		{IndexMismatch, `\Orphan`},
+	}
+
+	inconsistencies := CheckTaskCache(store, tasks, tree)
+	if len(inconsistencies) != len(expected) {
+		for _, i := range inconsistencies {
+			t.Log(i)
+		}
+		t.Fatalf("expected %d inconsistencies, got %d", len(expected), len(inconsistencies))
+	}
+	for i, e := range expected {
+		if inconsistencies[i].Type != e.typ || inconsistencies[i].Path != e.path {
+			t.Errorf("expected inconsistency %d to be %v of %s, got %v", i, e.typ, e.path, inconsistencies[i])
+		}
+	}
+
+	for _, i := range inconsistencies {
+		if i.Type != DefinitionMismatch {
+			continue
+		}
+		if len(i.Changes) != 1 || i.Changes[0].Field != "Actions[0].Path" || i.Changes[0].New != "evil.exe" {
+			t.Errorf("unexpected changes %v", i.Changes)
+		}
+	}
+}
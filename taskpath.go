@@ -0,0 +1,167 @@
+package taskmaster
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// MaxTaskPathLength is an approximation of the maximum length of a task path
+// in UTF-16 characters. Tasks are stored as files under
+// %SystemRoot%\System32\Tasks, whose path must fit in MAX_PATH (260)
+// characters, so the limit is computed for the default C:\Windows. It isn't
+// what the Task Scheduler service enforces: the actual limit is shorter on
+// hosts with a longer Windows directory, and longer on hosts with a shorter one.
+const MaxTaskPathLength = 260 - len(`C:\Windows\System32\Tasks`) - 1
+
+// the characters that can't be used in the names of tasks and folders, in
+// addition to control characters
+const illegalTaskNameChars = `<>:"/\|?*`
+
+// reserved device names that can't be used as the names of files
+var reservedTaskNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// TaskPath is the `\` separated path of a task or task folder, such as
+// `\Microsoft\Windows\Defrag\ScheduledDefrag`. The root folder is `\`.
+type TaskPath string
+
+// ParseTaskPath normalizes and validates a task path.
+func ParseTaskPath(path string) (TaskPath, error) {
+	p := NormalizeTaskPath(path)
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+
+	return p, nil
+}
+
+// NormalizeTaskPath converts '/' to `\`, adds the leading `\` if it's missing
+// and removes empty elements, such as those caused by repeated or trailing
+// separators.
+func NormalizeTaskPath(path string) TaskPath {
+	elems := strings.FieldsFunc(path, func(r rune) bool {
+		return r == '\\' || r == '/'
+	})
+
+	return TaskPath(`\` + strings.Join(elems, `\`))
+}
+
+// Validate returns an error if the path isn't a normalized absolute path, is
+// longer than MaxTaskPathLength, or has names the Task Scheduler service
+// rejects: names containing illegal or control characters, names ending with a
+// space or period, and reserved device names such as CON or NUL. Validation is
+// opt-in: the methods of TaskService that take paths of existing tasks don't
+// validate them, and leave rejecting invalid paths to the service.
+func (p TaskPath) Validate() error {
+	if p == "" || p[0] != '\\' {
+		return fmt.Errorf("invalid task path %q: %v", p, ErrInvalidPath)
+	}
+	if n := len(utf16.Encode([]rune(string(p)))); n > MaxTaskPathLength {
+		return fmt.Errorf("invalid task path %q: path is %d characters long, the maximum is %d", p, n, MaxTaskPathLength)
+	}
+	if p.IsRoot() {
+		return nil
+	}
+
+	for _, name := range strings.Split(string(p[1:]), `\`) {
+		if err := validateTaskName(name); err != nil {
+			return fmt.Errorf("invalid task path %q: %v", p, err)
+		}
+	}
+
+	return nil
+}
+
+func validateTaskName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty name")
+	}
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(illegalTaskNameChars, r) {
+			return fmt.Errorf("name %q contains illegal character %q", name, r)
+		}
+	}
+	if last := name[len(name)-1]; last == ' ' || last == '.' {
+		return fmt.Errorf("name %q ends with %q", name, last)
+	}
+	base := strings.ToLower(name)
+	if i := strings.IndexByte(base, '.'); i != -1 {
+		base = base[:i]
+	}
+	if reservedTaskNames[strings.TrimRight(base, " ")] {
+		return fmt.Errorf("name %q is reserved", name)
+	}
+
+	return nil
+}
+
+// IsRoot reports whether the path is the root folder.
+func (p TaskPath) IsRoot() bool {
+	return p == `\`
+}
+
+// Join returns the path with elems appended to it, normalized.
+func (p TaskPath) Join(elems ...string) TaskPath {
+	return NormalizeTaskPath(string(p) + `\` + strings.Join(elems, `\`))
+}
+
+// Split splits the path into the path of its folder and its name. The folder
+// of the root folder is the root folder, and its name is empty.
+func (p TaskPath) Split() (folder TaskPath, name string) {
+	p = NormalizeTaskPath(string(p))
+	i := strings.LastIndexByte(string(p), '\\')
+	if i == 0 {
+		return `\`, string(p[1:])
+	}
+
+	return p[:i], string(p[i+1:])
+}
+
+// Folder returns the path of the folder of the path.
+func (p TaskPath) Folder() TaskPath {
+	folder, _ := p.Split()
+	return folder
+}
+
+// Name returns the last element of the path.
+func (p TaskPath) Name() string {
+	_, name := p.Split()
+	return name
+}
+
+// Elements returns the names of the folders and task of the path. The root
+// folder has no elements.
+func (p TaskPath) Elements() []string {
+	p = NormalizeTaskPath(string(p))
+	if p.IsRoot() {
+		return nil
+	}
+
+	return strings.Split(string(p[1:]), `\`)
+}
+
+// HasPrefix reports whether the path is folder or is in folder or one of its
+// subfolders. Paths are compared case insensitively.
+func (p TaskPath) HasPrefix(folder TaskPath) bool {
+	path := strings.ToLower(string(NormalizeTaskPath(string(p))))
+	prefix := strings.ToLower(string(NormalizeTaskPath(string(folder))))
+	if prefix == `\` || path == prefix {
+		return true
+	}
+
+	return strings.HasPrefix(path, prefix+`\`)
+}
+
+// Equal reports whether two paths refer to the same task or folder. Paths
+// are compared case insensitively after normalizing them.
+func (p TaskPath) Equal(other TaskPath) bool {
+	return strings.EqualFold(string(NormalizeTaskPath(string(p))), string(NormalizeTaskPath(string(other))))
+}
+
+func (p TaskPath) String() string {
+	return string(p)
+}
@@ -0,0 +1,91 @@
+package taskmaster
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTaskPath(t *testing.T) {
+	valid := []struct {
+		path, expected string
+	}{
+		{`\`, `\`},
+		{``, `\`},
+		{`\Microsoft\Windows\Defrag`, `\Microsoft\Windows\Defrag`},
+		{`Microsoft/Windows//Defrag\`, `\Microsoft\Windows\Defrag`},
+		{`\Task.v2`, `\Task.v2`},
+		{`\CONSOLE`, `\CONSOLE`},
+	}
+	for _, test := range valid {
+		path, err := ParseTaskPath(test.path)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.path, err)
+		} else if path != TaskPath(test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.path, test.expected, path)
+		}
+	}
+
+	invalid := []string{
+		`\Task?`,
+		`\Folder\Ta:sk`,
+		"\\Tab\tTask",
+		`\Task.`,
+		`\Task `,
+		`\..\Task`,
+		`\NUL`,
+		`\Folder\com1.txt`,
+		`\` + strings.Repeat("a", MaxTaskPathLength),
+	}
+	for _, path := range invalid {
+		if _, err := ParseTaskPath(path); err == nil {
+			t.Errorf("%q: expected error", path)
+		}
+	}
+
+	if err := TaskPath(`Task`).Validate(); err == nil {
+		t.Error("expected error validating a relative path")
+	}
+}
+
+func TestTaskPath(t *testing.T) {
+	p := TaskPath(`\Microsoft\Windows`)
+
+	if joined := p.Join("Defrag", `ScheduledDefrag\`); joined != `\Microsoft\Windows\Defrag\ScheduledDefrag` {
+		t.Errorf("unexpected joined path %q", joined)
+	}
+	if joined := TaskPath(`\`).Join("Task"); joined != `\Task` {
+		t.Errorf("unexpected joined path %q", joined)
+	}
+
+	splits := []struct {
+		path, folder, name string
+	}{
+		{`\Microsoft\Windows`, `\Microsoft`, "Windows"},
+		{`\Task`, `\`, "Task"},
+		{`\`, `\`, ""},
+	}
+	for _, test := range splits {
+		folder, name := TaskPath(test.path).Split()
+		if folder != TaskPath(test.folder) || name != test.name {
+			t.Errorf("%q: expected %q and %q, got %q and %q", test.path, test.folder, test.name, folder, name)
+		}
+	}
+
+	if elems := p.Elements(); !reflect.DeepEqual(elems, []string{"Microsoft", "Windows"}) {
+		t.Errorf("unexpected elements %v", elems)
+	}
+	if elems := TaskPath(`\`).Elements(); elems != nil {
+		t.Errorf("unexpected elements %v", elems)
+	}
+
+	if !TaskPath(`\microsoft\windows\Defrag`).HasPrefix(p) || !p.HasPrefix(`\`) || !p.HasPrefix(p) {
+		t.Error("expected path to have prefix")
+	}
+	if TaskPath(`\Microsoft\WindowsDefender`).HasPrefix(p) {
+		t.Error("expected path not to have prefix")
+	}
+	if !p.Equal(`microsoft/WINDOWS/`) {
+		t.Error("expected paths to be equal")
+	}
+}
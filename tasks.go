@@ -3,8 +3,10 @@
 package taskmaster
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/go-ole/go-ole"
 	"github.com/go-ole/go-ole/oleutil"
@@ -21,20 +23,29 @@ func (d *Definition) AddTrigger(trigger Trigger) {
 // Refresh refreshes all of the local instance variables of the running task.
 // https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nf-taskschd-irunningtask-refresh
 func (r RunningTask) Refresh() error {
-	_, err := oleutil.CallMethod(r.taskObj, "Refresh")
-	if err != nil {
-		return fmt.Errorf("error refreshing running task %s: %v", r.Path, getTaskSchedulerError(err))
-	}
+	return r.dispatcher.run(context.Background(), func() error {
+		_, err := oleutil.CallMethod(r.taskObj, "Refresh")
+		if err != nil {
+			return fmt.Errorf("error refreshing running task %s: %w", r.Path, getTaskSchedulerError(err))
+		}
 
-	return nil
+		return nil
+	})
 }
 
 // Stop kills and releases a running task.
 // https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nf-taskschd-irunningtask-stop
 func (r *RunningTask) Stop() error {
-	_, err := oleutil.CallMethod(r.taskObj, "Stop")
+	err := r.dispatcher.run(context.Background(), func() error {
+		_, err := oleutil.CallMethod(r.taskObj, "Stop")
+		if err != nil {
+			return fmt.Errorf("error stopping running task %s: %w", r.Path, getTaskSchedulerError(err))
+		}
+
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("error stopping running task %s: %v", r.Path, getTaskSchedulerError(err))
+		return err
 	}
 
 	r.Release()
@@ -42,15 +53,6 @@ func (r *RunningTask) Stop() error {
 	return nil
 }
 
-// Release frees the running task COM object. Must be called before
-// program termination to avoid memory leaks.
-func (r *RunningTask) Release() {
-	if !r.isReleased && r.taskObj != nil {
-		r.taskObj.Release()
-		r.isReleased = true
-	}
-}
-
 // Run starts an instance of a registered task. If the task was started successfully,
 // a pointer to a running task will be returned.
 // https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nf-taskschd-iregisteredtask-run
@@ -62,24 +64,149 @@ func (r *RegisteredTask) Run(args ...string) (RunningTask, error) {
 // a pointer to a running task will be returned.
 // https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nf-taskschd-iregisteredtask-runex
 func (r *RegisteredTask) RunEx(args []string, flags TaskRunFlags, sessionID int, user string) (RunningTask, error) {
+	return r.runEx(context.Background(), args, flags, sessionID, user)
+}
+
+func (r *RegisteredTask) runEx(ctx context.Context, args []string, flags TaskRunFlags, sessionID int, user string) (RunningTask, error) {
 	if !r.Enabled {
 		return RunningTask{}, fmt.Errorf("error running registered task %s: cannot run a disabled task", r.Path)
 	}
 
-	runningTaskObj, err := oleutil.CallMethod(r.taskObj, "RunEx", args, int(flags), sessionID, user)
+	var runningTask RunningTask
+	err := r.dispatcher.run(ctx, func() error {
+		res, err := oleutil.CallMethod(r.taskObj, "RunEx", args, int(flags), sessionID, user)
+		if err != nil {
+			return fmt.Errorf("error running registered task %s: %w", r.Path, getTaskSchedulerError(err))
+		}
+
+		runningTaskObj := res.ToIDispatch()
+		runningTask, err = parseRunningTask(runningTaskObj, r.dispatcher)
+		if err != nil {
+			runningTaskObj.Release()
+		}
+
+		return err
+	})
+	if err != nil {
+		return RunningTask{}, err
+	}
+
+	return runningTask, nil
+}
+
+// RunAndWait starts an instance of a registered task and waits for it to complete.
+// The instance is polled every DefaultRunPollInterval until it is no longer running
+// and the last run time of the registered task has changed, then the last run
+// result of the registered task is read. If the deadline of ctx passes before the
+// instance completes, the instance is left running and a result with TimedOut set
+// is returned. If the instance completes before it can be parsed, InstanceGUID
+// isn't set. If the task ignores new instances while one is running and an
+// instance is already running, an error is returned instead of starting the task.
+func (r *RegisteredTask) RunAndWait(ctx context.Context, args ...string) (RunResult, error) {
+	// the wait ends once the last run time changes, so it's read before
+	// the instance is started
+	var alreadyRunning bool
+	err := r.dispatcher.run(ctx, func() error {
+		if err := r.refreshLastRun(); err != nil {
+			return err
+		}
+		instances, err := r.getInstances()
+		for _, instance := range instances {
+			instance.taskObj.Release()
+		}
+		alreadyRunning = len(instances) != 0
+
+		return err
+	})
+	if err != nil {
+		return RunResult{}, err
+	}
+	if alreadyRunning && r.Definition.Settings.MultipleInstances == TASK_INSTANCES_IGNORE_NEW {
+		return RunResult{}, fmt.Errorf("error running registered task %s: an instance is already running and new instances are ignored", r.Path)
+	}
+	prevLastRun := r.LastRunTime
+
+	started := time.Now()
+	runningTask, err := r.runEx(ctx, args, TASK_RUN_NO_FLAGS, 0, "")
+	if err != nil && !errors.Is(err, ErrRunningTaskCompleted) {
+		return RunResult{}, err
+	}
+	// an instance that already completed has no GUID, so it's never found
+	// running
+	runningTask.Release()
+
+	waiter := runWaiter{
+		clk:      realClock{},
+		interval: DefaultRunPollInterval,
+		isRunning: func(ctx context.Context) (bool, error) {
+			var running bool
+			err := r.dispatcher.run(ctx, func() error {
+				instances, err := r.getInstances()
+				for _, instance := range instances {
+					if instance.InstanceGUID == runningTask.InstanceGUID {
+						running = true
+					}
+					instance.taskObj.Release()
+				}
+
+				return err
+			})
+
+			return running, err
+		},
+		lastRun: func(ctx context.Context) (time.Time, TaskResult, error) {
+			err := r.dispatcher.run(ctx, r.refreshLastRun)
+			return r.LastRunTime, r.LastTaskResult, err
+		},
+	}
+
+	return waiter.wait(ctx, runningTask.InstanceGUID, started, prevLastRun)
+}
+
+// refreshLastRun updates the state and last run information of the registered task.
+func (r *RegisteredTask) refreshLastRun() error {
+	state, err := oleutil.GetProperty(r.taskObj, "State")
+	if err != nil {
+		return fmt.Errorf("error getting state of registered task %s: %w", r.Path, getTaskSchedulerError(err))
+	}
+	lastRunTime, err := oleutil.GetProperty(r.taskObj, "LastRunTime")
 	if err != nil {
-		return RunningTask{}, fmt.Errorf("error running registered task %s: %v", r.Path, getTaskSchedulerError(err))
+		return fmt.Errorf("error getting last run time of registered task %s: %w", r.Path, getTaskSchedulerError(err))
 	}
+	lastTaskResult, err := oleutil.GetProperty(r.taskObj, "LastTaskResult")
+	if err != nil {
+		return fmt.Errorf("error getting last result of registered task %s: %w", r.Path, getTaskSchedulerError(err))
+	}
+
+	r.State = TaskState(state.Val)
+	r.LastRunTime = lastRunTime.Value().(time.Time)
+	r.LastTaskResult = TaskResult(lastTaskResult.Val)
 
-	return parseRunningTask(runningTaskObj.ToIDispatch())
+	return nil
 }
 
 // GetInstances returns all of the currently running instances of a registered task.
 // https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nf-taskschd-iregisteredtask-getinstances
 func (r *RegisteredTask) GetInstances() (RunningTaskCollection, error) {
+	var parsedRunningTasks RunningTaskCollection
+
+	err := r.dispatcher.run(context.Background(), func() error {
+		var err error
+		parsedRunningTasks, err = r.getInstances()
+		return err
+	})
+	if err != nil {
+		parsedRunningTasks.Release()
+		return nil, err
+	}
+
+	return parsedRunningTasks, nil
+}
+
+func (r *RegisteredTask) getInstances() (RunningTaskCollection, error) {
 	runningTasks, err := oleutil.CallMethod(r.taskObj, "GetInstances", 0)
 	if err != nil {
-		return nil, fmt.Errorf("error getting instances of registered task %s: %v", r.Path, getTaskSchedulerError(err))
+		return nil, fmt.Errorf("error getting instances of registered task %s: %w", r.Path, getTaskSchedulerError(err))
 	}
 
 	runningTasksObj := runningTasks.ToIDispatch()
@@ -89,7 +216,7 @@ func (r *RegisteredTask) GetInstances() (RunningTaskCollection, error) {
 	err = oleutil.ForEach(runningTasksObj, func(v *ole.VARIANT) error {
 		runningTaskObj := v.ToIDispatch()
 
-		parsedRunningTask, err := parseRunningTask(runningTaskObj)
+		parsedRunningTask, err := parseRunningTask(runningTaskObj, r.dispatcher)
 		if err != nil {
 			if errors.Is(err, ErrRunningTaskCompleted) {
 				return nil
@@ -102,7 +229,7 @@ func (r *RegisteredTask) GetInstances() (RunningTaskCollection, error) {
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return parsedRunningTasks, err
 	}
 
 	return parsedRunningTasks, nil
@@ -113,26 +240,16 @@ func (r *RegisteredTask) GetInstances() (RunningTaskCollection, error) {
 // otherwise Stop returns false.
 // https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nf-taskschd-iregisteredtask-stop
 func (r *RegisteredTask) Stop() error {
-	_, err := oleutil.CallMethod(r.taskObj, "Stop", 0)
-	if err != nil {
-		return fmt.Errorf("error stopping registered task %s: %v", r.Path, getTaskSchedulerError(err))
-	}
-
-	return nil
-}
+	return r.dispatcher.run(context.Background(), func() error {
+		_, err := oleutil.CallMethod(r.taskObj, "Stop", 0)
+		if err != nil {
+			return fmt.Errorf("error stopping registered task %s: %w", r.Path, getTaskSchedulerError(err))
+		}
 
-// Release frees the registered task COM object. Must be called before
-// program termination to avoid memory leaks.
-func (r *RegisteredTask) Release() {
-	if !r.isReleased && r.taskObj != nil {
-		r.taskObj.Release()
-		r.isReleased = true
-	}
+		return nil
+	})
 }
 
-// RunningTaskCollection is a collection of running tasks.
-type RunningTaskCollection []RunningTask
-
 // Stop kills and frees all the running tasks COM objects in the
 // collection. If an error is encountered while stopping a running
 // task, Stop returns the error without attempting to stop any
@@ -146,36 +263,3 @@ func (r RunningTaskCollection) Stop() error {
 
 	return nil
 }
-
-// Release frees all the running task COM objects in the collection.
-// Must be called before program termination to avoid memory leaks.
-func (r RunningTaskCollection) Release() {
-	for _, runningTask := range r {
-		runningTask.Release()
-	}
-}
-
-// RegisteredTaskCollection is a collection of registered tasks.
-type RegisteredTaskCollection []RegisteredTask
-
-// Release frees all the registered task COM objects in the collection.
-// Must be called before program termination to avoid memory leaks.
-func (r RegisteredTaskCollection) Release() {
-	for _, registeredTask := range r {
-		registeredTask.Release()
-	}
-}
-
-// Release frees all the registered task COM objects in the folder and
-// all subfolders. Must be called before program termination to avoid
-// memory leaks.
-func (f *TaskFolder) Release() {
-	if !f.isReleased {
-		f.RegisteredTasks.Release()
-		for _, subFolder := range f.SubFolders {
-			subFolder.RegisteredTasks.Release()
-		}
-
-		f.isReleased = true
-	}
-}
@@ -3,6 +3,7 @@
 package taskmaster
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -119,3 +120,34 @@ func TestStopRegisteredTask(t *testing.T) {
 		t.Fatalf("error stopping tasks: %v", err)
 	}
 }
+
+func TestRunAndWaitRegisteredTask(t *testing.T) {
+	taskService, err := Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTask := createTestTask(taskService)
+	defer taskService.Disconnect()
+
+	result, err := testTask.RunAndWait(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TimedOut {
+		t.Fatal("task shouldn't have timed out")
+	}
+	if result.Duration < time.Second {
+		t.Errorf("task should have run for at least a second, ran for %v", result.Duration)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	result, err = testTask.RunAndWait(ctx, "9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.TimedOut {
+		t.Error("task should have timed out")
+	}
+	testTask.Stop()
+}
@@ -0,0 +1,98 @@
+package taskmaster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// TaskStoreErrors holds the errors of the tasks and folders of a task store
+// that could not be loaded, keyed by their task path.
+type TaskStoreErrors map[string]error
+
+func (e TaskStoreErrors) Error() string {
+	paths := make([]string, 0, len(e))
+	for path := range e {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return fmt.Sprintf("error loading %d tasks or folders: %s: %v", len(e), paths[0], e[paths[0]])
+}
+
+// LoadTaskStore reads the task definitions the Task Scheduler service stores in
+// the System32\Tasks folder of a Windows installation, such as one mounted from
+// a disk image, without using the Task Scheduler service. dir is the path to
+// the Tasks folder, which is returned as the root folder.
+//
+// Only the names, paths and definitions of the returned tasks are set, and
+// Enabled is taken from the settings of the definition. If some tasks or folders
+// could not be read or parsed, the rest are still returned along with a
+// TaskStoreErrors.
+func LoadTaskStore(dir string) (TaskFolder, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return TaskFolder{}, fmt.Errorf("error reading task store: %v", err)
+	} else if !info.IsDir() {
+		return TaskFolder{}, fmt.Errorf("error reading task store: %s is not a directory", dir)
+	}
+
+	root := TaskFolder{Path: `\`}
+	errs := make(TaskStoreErrors)
+	loadTaskStoreFolder(dir, &root, errs)
+	if len(errs) != 0 {
+		return root, errs
+	}
+
+	return root, nil
+}
+
+func loadTaskStoreFolder(dir string, folder *TaskFolder, errs TaskStoreErrors) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		errs[folder.Path] = err
+		return
+	}
+
+	for _, entry := range entries {
+		path := TaskPath(folder.Path).Join(entry.Name()).String()
+		fullPath := filepath.Join(dir, entry.Name())
+
+		switch {
+		case entry.IsDir():
+			subFolder := &TaskFolder{
+				Name: entry.Name(),
+				Path: path,
+			}
+			folder.SubFolders = append(folder.SubFolders, subFolder)
+			loadTaskStoreFolder(fullPath, subFolder, errs)
+		case entry.Mode().IsRegular():
+			task, err := loadStoredTask(fullPath, entry.Name(), path)
+			if err != nil {
+				errs[path] = err
+				continue
+			}
+			folder.RegisteredTasks = append(folder.RegisteredTasks, task)
+		}
+	}
+}
+
+func loadStoredTask(fullPath, name, path string) (RegisteredTask, error) {
+	data, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		return RegisteredTask{}, err
+	}
+	def, err := ParseTaskXML(data)
+	if err != nil {
+		return RegisteredTask{}, err
+	}
+
+	return RegisteredTask{
+		Name:       name,
+		Path:       path,
+		Definition: def,
+		Enabled:    def.Settings.Enabled,
+	}, nil
+}
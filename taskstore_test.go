@@ -0,0 +1,500 @@
+package taskmaster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/rickb777/date/period"
+)
+
+const testTaskXML = `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Date>2020-01-01T00:00:00.0000000</Date>
+    <Author>DOMAIN\user</Author>
+    <Description>a test task</Description>
+    <URI>\Taskmaster\Task</URI>
+    <SecurityDescriptor>D:(A;;FA;;;BA)</SecurityDescriptor>
+  </RegistrationInfo>
+  <Triggers>
+    <TimeTrigger id="time">
+      <Repetition>
+        <Interval>PT1H</Interval>
+        <Duration>P1D</Duration>
+      </Repetition>
+      <StartBoundary>2020-01-01T12:00:00</StartBoundary>
+      <RandomDelay>PT5M</RandomDelay>
+    </TimeTrigger>
+    <CalendarTrigger>
+      <StartBoundary>2020-01-01T08:00:00</StartBoundary>
+      <Enabled>false</Enabled>
+      <ScheduleByWeek>
+        <DaysOfWeek>
+          <Monday />
+          <Friday />
+        </DaysOfWeek>
+        <WeeksInterval>2</WeeksInterval>
+      </ScheduleByWeek>
+    </CalendarTrigger>
+    <CalendarTrigger>
+      <StartBoundary>2020-01-01T08:00:00</StartBoundary>
+      <ScheduleByMonth>
+        <DaysOfMonth>
+          <Day>1</Day>
+          <Day>15</Day>
+          <Day>Last</Day>
+        </DaysOfMonth>
+        <Months>
+          <January />
+          <July />
+        </Months>
+      </ScheduleByMonth>
+    </CalendarTrigger>
+    <CalendarTrigger>
+      <StartBoundary>2020-01-01T08:00:00</StartBoundary>
+      <ScheduleByMonthDayOfWeek>
+        <Weeks>
+          <Week>2</Week>
+          <Week>Last</Week>
+        </Weeks>
+        <DaysOfWeek>
+          <Sunday />
+        </DaysOfWeek>
+        <Months>
+          <December />
+        </Months>
+      </ScheduleByMonthDayOfWeek>
+    </CalendarTrigger>
+    <EventTrigger>
+      <Subscription>&lt;QueryList&gt;&lt;/QueryList&gt;</Subscription>
+      <ValueQueries>
+        <Value name="id">Event/System/EventID</Value>
+      </ValueQueries>
+    </EventTrigger>
+    <SessionStateChangeTrigger>
+      <StateChange>SessionUnlock</StateChange>
+      <UserId>DOMAIN\user</UserId>
+    </SessionStateChangeTrigger>
+    <WnfStateChangeTrigger>
+      <StateName>7508BCA3380C960C</StateName>
+    </WnfStateChangeTrigger>
+  </Triggers>
+  <Principals>
+    <Principal id="Other">
+      <UserId>DOMAIN\other</UserId>
+      <LogonType>Password</LogonType>
+    </Principal>
+    <Principal id="System">
+      <UserId>S-1-5-18</UserId>
+      <RunLevel>HighestAvailable</RunLevel>
+    </Principal>
+  </Principals>
+  <Settings>
+    <MultipleInstancesPolicy>Queue</MultipleInstancesPolicy>
+    <DisallowStartIfOnBatteries>false</DisallowStartIfOnBatteries>
+    <IdleSettings>
+      <StopOnIdleEnd>false</StopOnIdleEnd>
+    </IdleSettings>
+    <RestartOnFailure>
+      <Interval>PT1M</Interval>
+      <Count>3</Count>
+    </RestartOnFailure>
+    <Enabled>false</Enabled>
+    <Priority>4</Priority>
+  </Settings>
+  <Data>data</Data>
+  <Actions Context="System">
+    <Exec id="exec">
+      <Command>cmd.exe</Command>
+      <Arguments>/c exit</Arguments>
+    </Exec>
+    <ComHandler>
+      <ClassId>{00000000-0000-0000-0000-000000000000}</ClassId>
+    </ComHandler>
+  </Actions>
+</Task>`
+
+const testMinimalTaskXML = `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.4" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <Actions Context="Author">
+    <Exec>
+      <Command>notepad.exe</Command>
+    </Exec>
+  </Actions>
+</Task>`
+
+func encodeUTF16LE(s string) []byte {
+	chars := utf16.Encode([]rune(s))
+	data := []byte{0xFF, 0xFE}
+	for _, c := range chars {
+		data = append(data, byte(c), byte(c>>8))
+	}
+
+	return data
+}
+
+func TestParseTaskXML(t *testing.T) {
+	def, err := ParseTaskXML(encodeUTF16LE(testTaskXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if def.XMLText != testTaskXML {
+		t.Error("expected XMLText to be the decoded XML")
+	}
+	if def.Context != "System" || def.Data != "data" {
+		t.Errorf("unexpected context %q or data %q", def.Context, def.Data)
+	}
+
+	expectedDate := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	regInfo := def.RegistrationInfo
+	if regInfo.Author != `DOMAIN\user` || !regInfo.Date.Equal(expectedDate) || regInfo.URI != `\Taskmaster\Task` || regInfo.SecurityDescriptor != "D:(A;;FA;;;BA)" {
+		t.Errorf("unexpected registration info %+v", regInfo)
+	}
+
+	expectedPrincipal := Principal{
+		ID:        "System",
+		UserID:    "S-1-5-18",
+		LogonType: TASK_LOGON_SERVICE_ACCOUNT,
+		RunLevel:  TASK_RUNLEVEL_HIGHEST,
+
+		ProcessTokenSidType: processTokenSidTypePtr(TASK_PROCESSTOKENSID_DEFAULT),
+	}
+	if !reflect.DeepEqual(def.Principal, expectedPrincipal) {
+		t.Errorf("expected principal %+v, got %+v", expectedPrincipal, def.Principal)
+	}
+
+	settings := def.Settings
+	if settings.Compatibility != TASK_COMPATIBILITY_V2 {
+		t.Errorf("expected compatibility %v, got %v", TASK_COMPATIBILITY_V2, settings.Compatibility)
+	}
+	if settings.Enabled || settings.DontStartOnBatteries || settings.StopOnIdleEnd {
+		t.Error("expected settings set to false to be parsed")
+	}
+	if !settings.AllowDemandStart || !settings.StopIfGoingOnBatteries || settings.TimeLimit != period.NewHMS(72, 0, 0) || settings.IdleDuration != period.NewHMS(0, 10, 0) {
+		t.Error("expected missing settings to be set to their defaults")
+	}
+	if settings.MultipleInstances != TASK_INSTANCES_QUEUE || settings.Priority != 4 || settings.RestartCount != 3 || settings.RestartInterval != period.NewHMS(0, 1, 0) {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+
+	if len(def.Actions) != 2 {
+		t.Fatalf("expected 2 actions, got %d", len(def.Actions))
+	}
+	expectedExec := ExecAction{ID: "exec", Path: "cmd.exe", Args: "/c exit"}
+	if def.Actions[0] != expectedExec {
+		t.Errorf("expected action %+v, got %+v", expectedExec, def.Actions[0])
+	}
+	if def.Actions[1].GetType() != TASK_ACTION_COM_HANDLER {
+		t.Errorf("expected com handler action, got %v", def.Actions[1].GetType())
+	}
+
+	if len(def.Triggers) != 7 {
+		t.Fatalf("expected 7 triggers, got %d", len(def.Triggers))
+	}
+
+	timeTrigger, ok := def.Triggers[0].(TimeTrigger)
+	if !ok {
+		t.Fatalf("expected TimeTrigger, got %T", def.Triggers[0])
+	}
+	if timeTrigger.ID != "time" || !timeTrigger.Enabled || timeTrigger.RepetitionInterval != period.NewHMS(1, 0, 0) || timeTrigger.RepetitionDuration != period.NewYMD(0, 0, 1) || timeTrigger.RandomDelay != period.NewHMS(0, 5, 0) {
+		t.Errorf("unexpected time trigger %+v", timeTrigger)
+	}
+
+	weeklyTrigger, ok := def.Triggers[1].(WeeklyTrigger)
+	if !ok {
+		t.Fatalf("expected WeeklyTrigger, got %T", def.Triggers[1])
+	}
+	if weeklyTrigger.Enabled || weeklyTrigger.DaysOfWeek != Monday|Friday || weeklyTrigger.WeekInterval != EveryOtherWeek {
+		t.Errorf("unexpected weekly trigger %+v", weeklyTrigger)
+	}
+
+	monthlyTrigger, ok := def.Triggers[2].(MonthlyTrigger)
+	if !ok {
+		t.Fatalf("expected MonthlyTrigger, got %T", def.Triggers[2])
+	}
+	if monthlyTrigger.DaysOfMonth != One|Fifteen || !monthlyTrigger.RunOnLastWeekOfMonth || monthlyTrigger.MonthsOfYear != January|July {
+		t.Errorf("unexpected monthly trigger %+v", monthlyTrigger)
+	}
+
+	monthlyDOWTrigger, ok := def.Triggers[3].(MonthlyDOWTrigger)
+	if !ok {
+		t.Fatalf("expected MonthlyDOWTrigger, got %T", def.Triggers[3])
+	}
+	if monthlyDOWTrigger.WeeksOfMonth != Second || !monthlyDOWTrigger.RunOnLastWeekOfMonth || monthlyDOWTrigger.DaysOfWeek != Sunday || monthlyDOWTrigger.MonthsOfYear != December {
+		t.Errorf("unexpected monthly day of week trigger %+v", monthlyDOWTrigger)
+	}
+
+	eventTrigger, ok := def.Triggers[4].(EventTrigger)
+	if !ok {
+		t.Fatalf("expected EventTrigger, got %T", def.Triggers[4])
+	}
+	if eventTrigger.Subscription != "<QueryList></QueryList>" || eventTrigger.ValueQueries["id"] != "Event/System/EventID" {
+		t.Errorf("unexpected event trigger %+v", eventTrigger)
+	}
+
+	sessionTrigger, ok := def.Triggers[5].(SessionStateChangeTrigger)
+	if !ok {
+		t.Fatalf("expected SessionStateChangeTrigger, got %T", def.Triggers[5])
+	}
+	if sessionTrigger.StateChange != TASK_SESSION_UNLOCK || sessionTrigger.UserId != `DOMAIN\user` {
+		t.Errorf("unexpected session state change trigger %+v", sessionTrigger)
+	}
+
+	customTrigger, ok := def.Triggers[6].(CustomTrigger)
+	if !ok {
+		t.Fatalf("expected CustomTrigger, got %T", def.Triggers[6])
+	}
+	if customTrigger.Name != "WnfStateChangeTrigger" || customTrigger.StateName != 0x0C960C38A3BC0875 || customTrigger.Data != nil {
+		t.Errorf("unexpected custom trigger %+v", customTrigger)
+	}
+}
+
+func TestParseTaskXMLDefaults(t *testing.T) {
+	// UTF-8 text is accepted as well
+	def, err := ParseTaskXML([]byte(testMinimalTaskXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if def.Settings.Compatibility != TASK_COMPATIBILITY_V2_2 {
+		t.Errorf("expected compatibility %v, got %v", TASK_COMPATIBILITY_V2_2, def.Settings.Compatibility)
+	}
+	if !def.Settings.Enabled || !def.Settings.AllowHardTerminate || def.Settings.MultipleInstances != TASK_INSTANCES_IGNORE_NEW || def.Settings.Priority != 7 {
+		t.Errorf("expected default settings, got %+v", def.Settings)
+	}
+	if !reflect.DeepEqual(def.Principal, Principal{}) {
+		t.Errorf("expected no principal, got %+v", def.Principal)
+	}
+
+	if _, err := ParseTaskXML([]byte(`<Task version="9.9"></Task>`)); err == nil {
+		t.Error("expected error parsing unsupported task version")
+	}
+	if _, err := ParseTaskXML([]byte(`<Task version="1.2"><Actions><SendSMS/></Actions></Task>`)); err == nil {
+		t.Error("expected error parsing unsupported action")
+	}
+}
+
+func TestParseTaskXMLDeprecatedActions(t *testing.T) {
+	def, err := ParseTaskXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <Actions Context="Author">
+    <SendEmail id="mail">
+      <Server>smtp.example.com</Server>
+      <Subject>Backup finished</Subject>
+      <To>ops@example.com</To>
+      <Cc>dev@example.com</Cc>
+      <Bcc>audit@example.com</Bcc>
+      <ReplyTo>noreply@example.com</ReplyTo>
+      <From>backup@example.com</From>
+      <HeaderFields>
+        <HeaderField>
+          <Name>X-Priority</Name>
+          <Value>1</Value>
+        </HeaderField>
+      </HeaderFields>
+      <Body>The backup finished.</Body>
+      <Attachments>
+        <File>C:\Logs\backup.log</File>
+        <File>C:\Logs\errors.log</File>
+      </Attachments>
+    </SendEmail>
+    <ShowMessage>
+      <Title>Backup</Title>
+      <Body>The backup finished.</Body>
+    </ShowMessage>
+  </Actions>
+</Task>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(def.Actions) != 2 {
+		t.Fatalf("expected 2 actions, got %d", len(def.Actions))
+	}
+	expectedEmail := EmailAction{
+		ID:           "mail",
+		Server:       "smtp.example.com",
+		From:         "backup@example.com",
+		To:           "ops@example.com",
+		Cc:           "dev@example.com",
+		Bcc:          "audit@example.com",
+		ReplyTo:      "noreply@example.com",
+		Subject:      "Backup finished",
+		Body:         "The backup finished.",
+		HeaderFields: map[string]string{"X-Priority": "1"},
+		Attachments:  []string{`C:\Logs\backup.log`, `C:\Logs\errors.log`},
+	}
+	if !reflect.DeepEqual(def.Actions[0], expectedEmail) {
+		t.Errorf("expected action %+v, got %+v", expectedEmail, def.Actions[0])
+	}
+	expectedMessage := ShowMessageAction{Title: "Backup", Body: "The backup finished."}
+	if def.Actions[1] != expectedMessage {
+		t.Errorf("expected action %+v, got %+v", expectedMessage, def.Actions[1])
+	}
+}
+
+func TestParseTaskXMLPrincipal2(t *testing.T) {
+	def, err := ParseTaskXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Task version="1.3" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <Principals>
+    <Principal id="LocalService">
+      <UserId>S-1-5-19</UserId>
+      <LogonType>ServiceAccount</LogonType>
+      <ProcessTokenSidType>Unrestricted</ProcessTokenSidType>
+      <RequiredPrivileges>
+        <Privilege>SeBackupPrivilege</Privilege>
+        <Privilege>SeChangeNotifyPrivilege</Privilege>
+      </RequiredPrivileges>
+    </Principal>
+  </Principals>
+  <Actions Context="LocalService">
+    <Exec>
+      <Command>backup.exe</Command>
+    </Exec>
+  </Actions>
+</Task>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedPrincipal := Principal{
+		ID:                  "LocalService",
+		UserID:              "S-1-5-19",
+		LogonType:           TASK_LOGON_SERVICE_ACCOUNT,
+		ProcessTokenSidType: processTokenSidTypePtr(TASK_PROCESSTOKENSID_UNRESTRICTED),
+		RequiredPrivileges:  []string{"SeBackupPrivilege", "SeChangeNotifyPrivilege"},
+	}
+	if !reflect.DeepEqual(def.Principal, expectedPrincipal) {
+		t.Errorf("expected principal %+v, got %+v", expectedPrincipal, def.Principal)
+	}
+
+	def, err = ParseTaskXML([]byte(`<Task version="1.3"><Principals><Principal><LogonType>ServiceAccount</LogonType><ProcessTokenSidType>None</ProcessTokenSidType></Principal></Principals></Task>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sidType := def.Principal.ProcessTokenSidType; sidType == nil || *sidType != TASK_PROCESSTOKENSID_NONE {
+		t.Errorf("expected process token SID type %v, got %v", TASK_PROCESSTOKENSID_NONE, sidType)
+	}
+
+	if _, err := ParseTaskXML([]byte(`<Task version="1.3"><Principals><Principal><ProcessTokenSidType>Restricted</ProcessTokenSidType></Principal></Principals></Task>`)); err == nil {
+		t.Error("expected error parsing unknown process token SID type")
+	}
+}
+
+func TestParseTaskXMLSettings3(t *testing.T) {
+	def, err := ParseTaskXML([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Task version="1.4" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <Settings>
+    <DisallowStartOnRemoteAppSession>true</DisallowStartOnRemoteAppSession>
+    <UseUnifiedSchedulingEngine>true</UseUnifiedSchedulingEngine>
+    <Volatile>true</Volatile>
+    <MaintenanceSettings>
+      <Period>P1D</Period>
+      <Deadline>P2D</Deadline>
+      <Exclusive>true</Exclusive>
+    </MaintenanceSettings>
+  </Settings>
+  <Actions>
+    <Exec>
+      <Command>patch.exe</Command>
+    </Exec>
+  </Actions>
+</Task>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := def.Settings
+	if !settings.DisallowStartOnRemoteAppSession || !settings.UseUnifiedSchedulingEngine || !settings.Volatile {
+		t.Errorf("expected settings 2 and 3 to be parsed, got %+v", settings)
+	}
+	expectedMaintenance := MaintenanceSettings{
+		Period:    period.NewYMD(0, 0, 1),
+		Deadline:  period.NewYMD(0, 0, 2),
+		Exclusive: true,
+	}
+	if settings.MaintenanceSettings != expectedMaintenance {
+		t.Errorf("expected maintenance settings %+v, got %+v", expectedMaintenance, settings.MaintenanceSettings)
+	}
+	if err := validateSettings(settings); err != nil {
+		t.Errorf("unexpected error validating settings: %v", err)
+	}
+
+	if _, err := ParseTaskXML([]byte(`<Task version="1.4"><Settings><MaintenanceSettings><Period>daily</Period></MaintenanceSettings></Settings></Task>`)); err == nil {
+		t.Error("expected error parsing invalid maintenance period")
+	}
+}
+
+func TestLoadTaskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "taskstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{
+		"Root":                      encodeUTF16LE(testMinimalTaskXML),
+		"Taskmaster/Task":           encodeUTF16LE(testTaskXML),
+		"Taskmaster/Broken":         encodeUTF16LE(`<Task version="1.2"><Actions>`),
+		"Taskmaster/Nested/Another": encodeUTF16LE(testMinimalTaskXML),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "Empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := LoadTaskStore(dir)
+	storeErrs, ok := err.(TaskStoreErrors)
+	if !ok {
+		t.Fatalf("expected TaskStoreErrors, got %v", err)
+	}
+	if len(storeErrs) != 1 || storeErrs[`\Taskmaster\Broken`] == nil {
+		t.Errorf("expected an error for the broken task only, got %v", storeErrs)
+	}
+
+	if root.Path != `\` || len(root.RegisteredTasks) != 1 || root.RegisteredTasks[0].Path != `\Root` {
+		t.Fatalf("unexpected root folder %+v", root)
+	}
+	if len(root.SubFolders) != 2 {
+		t.Fatalf("expected 2 subfolders, got %d", len(root.SubFolders))
+	}
+
+	empty, taskmaster := root.SubFolders[0], root.SubFolders[1]
+	if empty.Name != "Empty" || empty.Path != `\Empty` || len(empty.RegisteredTasks) != 0 {
+		t.Errorf("unexpected folder %+v", empty)
+	}
+	if taskmaster.Path != `\Taskmaster` || len(taskmaster.RegisteredTasks) != 1 {
+		t.Fatalf("unexpected folder %+v", taskmaster)
+	}
+
+	task := taskmaster.RegisteredTasks[0]
+	if task.Name != "Task" || task.Path != `\Taskmaster\Task` || task.Enabled || task.Definition.RegistrationInfo.Author != `DOMAIN\user` {
+		t.Errorf("unexpected task %+v", task)
+	}
+
+	if len(taskmaster.SubFolders) != 1 || taskmaster.SubFolders[0].Path != `\Taskmaster\Nested` {
+		t.Fatalf("unexpected subfolders of %s", taskmaster.Path)
+	}
+	nested := taskmaster.SubFolders[0]
+	if len(nested.RegisteredTasks) != 1 || nested.RegisteredTasks[0].Path != `\Taskmaster\Nested\Another` || !nested.RegisteredTasks[0].Enabled {
+		t.Errorf("unexpected folder %+v", nested)
+	}
+
+	if _, err := LoadTaskStore(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error loading missing task store")
+	}
+}
@@ -0,0 +1,884 @@
+package taskmaster
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/rickb777/date/period"
+)
+
+// xmlTask mirrors the Task Scheduler schema closely enough to decode the
+// task definitions the service stores on disk.
+// https://docs.microsoft.com/en-us/windows/desktop/taskschd/task-scheduler-schema
+type xmlTask struct {
+	XMLName          xml.Name            `xml:"Task"`
+	Version          string              `xml:"version,attr"`
+	RegistrationInfo xmlRegistrationInfo `xml:"RegistrationInfo"`
+	Triggers         struct {
+		Triggers []xmlTrigger `xml:",any"`
+	} `xml:"Triggers"`
+	Principals struct {
+		Principals []xmlPrincipal `xml:"Principal"`
+	} `xml:"Principals"`
+	Settings xmlSettings `xml:"Settings"`
+	Data     string      `xml:"Data"`
+	Actions  struct {
+		Context string      `xml:"Context,attr"`
+		Actions []xmlAction `xml:",any"`
+	} `xml:"Actions"`
+}
+
+type xmlRegistrationInfo struct {
+	Author             string `xml:"Author"`
+	Date               string `xml:"Date"`
+	Description        string `xml:"Description"`
+	Documentation      string `xml:"Documentation"`
+	SecurityDescriptor string `xml:"SecurityDescriptor"`
+	Source             string `xml:"Source"`
+	URI                string `xml:"URI"`
+	Version            string `xml:"Version"`
+}
+
+type xmlPrincipal struct {
+	ID          string `xml:"id,attr"`
+	UserID      string `xml:"UserId"`
+	GroupID     string `xml:"GroupId"`
+	DisplayName string `xml:"DisplayName"`
+	LogonType   string `xml:"LogonType"`
+	RunLevel    string `xml:"RunLevel"`
+	// ProcessTokenSidType and RequiredPrivileges were added in version 1.3
+	ProcessTokenSidType string `xml:"ProcessTokenSidType"`
+	RequiredPrivileges  struct {
+		Privileges []string `xml:"Privilege"`
+	} `xml:"RequiredPrivileges"`
+}
+
+type xmlSettings struct {
+	AllowStartOnDemand         bool   `xml:"AllowStartOnDemand"`
+	AllowHardTerminate         bool   `xml:"AllowHardTerminate"`
+	DeleteExpiredTaskAfter     string `xml:"DeleteExpiredTaskAfter"`
+	DisallowStartIfOnBatteries bool   `xml:"DisallowStartIfOnBatteries"`
+	Enabled                    bool   `xml:"Enabled"`
+	ExecutionTimeLimit         string `xml:"ExecutionTimeLimit"`
+	Hidden                     bool   `xml:"Hidden"`
+	IdleSettings               struct {
+		Duration      string `xml:"Duration"`
+		WaitTimeout   string `xml:"WaitTimeout"`
+		StopOnIdleEnd bool   `xml:"StopOnIdleEnd"`
+		RestartOnIdle bool   `xml:"RestartOnIdle"`
+	} `xml:"IdleSettings"`
+	MultipleInstancesPolicy string `xml:"MultipleInstancesPolicy"`
+	NetworkSettings         struct {
+		Name string `xml:"Name"`
+		ID   string `xml:"Id"`
+	} `xml:"NetworkSettings"`
+	Priority         uint `xml:"Priority"`
+	RestartOnFailure struct {
+		Interval string `xml:"Interval"`
+		Count    uint   `xml:"Count"`
+	} `xml:"RestartOnFailure"`
+	RunOnlyIfIdle             bool `xml:"RunOnlyIfIdle"`
+	RunOnlyIfNetworkAvailable bool `xml:"RunOnlyIfNetworkAvailable"`
+	StartWhenAvailable        bool `xml:"StartWhenAvailable"`
+	StopIfGoingOnBatteries    bool `xml:"StopIfGoingOnBatteries"`
+	WakeToRun                 bool `xml:"WakeToRun"`
+	// DisallowStartOnRemoteAppSession and UseUnifiedSchedulingEngine were
+	// added in version 1.3, Volatile and MaintenanceSettings in version 1.4
+	DisallowStartOnRemoteAppSession bool `xml:"DisallowStartOnRemoteAppSession"`
+	UseUnifiedSchedulingEngine      bool `xml:"UseUnifiedSchedulingEngine"`
+	Volatile                        bool `xml:"Volatile"`
+	MaintenanceSettings             struct {
+		Period    string `xml:"Period"`
+		Deadline  string `xml:"Deadline"`
+		Exclusive bool   `xml:"Exclusive"`
+	} `xml:"MaintenanceSettings"`
+}
+
+// xmlElementList is a list of empty elements whose names are the values,
+// such as the days of a week.
+type xmlElementList struct {
+	Elements []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+type xmlTrigger struct {
+	XMLName            xml.Name
+	InnerXML           string `xml:",innerxml"`
+	ID                 string `xml:"id,attr"`
+	Enabled            *bool  `xml:"Enabled"`
+	StartBoundary      string `xml:"StartBoundary"`
+	EndBoundary        string `xml:"EndBoundary"`
+	ExecutionTimeLimit string `xml:"ExecutionTimeLimit"`
+	Repetition         struct {
+		Interval          string `xml:"Interval"`
+		Duration          string `xml:"Duration"`
+		StopAtDurationEnd bool   `xml:"StopAtDurationEnd"`
+	} `xml:"Repetition"`
+	Delay        string `xml:"Delay"`
+	RandomDelay  string `xml:"RandomDelay"`
+	UserID       string `xml:"UserId"`
+	Subscription string `xml:"Subscription"`
+	ValueQueries struct {
+		Values []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Value"`
+	} `xml:"ValueQueries"`
+	StateChange   string `xml:"StateChange"`
+	StateName     string `xml:"StateName"`
+	Data          string `xml:"Data"`
+	DataOffset    uint   `xml:"DataOffset"`
+	ScheduleByDay *struct {
+		DaysInterval uint `xml:"DaysInterval"`
+	} `xml:"ScheduleByDay"`
+	ScheduleByWeek *struct {
+		DaysOfWeek    xmlElementList `xml:"DaysOfWeek"`
+		WeeksInterval uint           `xml:"WeeksInterval"`
+	} `xml:"ScheduleByWeek"`
+	ScheduleByMonth *struct {
+		DaysOfMonth struct {
+			Days []string `xml:"Day"`
+		} `xml:"DaysOfMonth"`
+		Months xmlElementList `xml:"Months"`
+	} `xml:"ScheduleByMonth"`
+	ScheduleByMonthDayOfWeek *struct {
+		Weeks struct {
+			Weeks []string `xml:"Week"`
+		} `xml:"Weeks"`
+		DaysOfWeek xmlElementList `xml:"DaysOfWeek"`
+		Months     xmlElementList `xml:"Months"`
+	} `xml:"ScheduleByMonthDayOfWeek"`
+}
+
+type xmlAction struct {
+	XMLName          xml.Name
+	ID               string `xml:"id,attr"`
+	Command          string `xml:"Command"`
+	Arguments        string `xml:"Arguments"`
+	WorkingDirectory string `xml:"WorkingDirectory"`
+	ClassID          string `xml:"ClassId"`
+	Data             string `xml:"Data"`
+	Server           string `xml:"Server"`
+	Subject          string `xml:"Subject"`
+	To               string `xml:"To"`
+	Cc               string `xml:"Cc"`
+	Bcc              string `xml:"Bcc"`
+	ReplyTo          string `xml:"ReplyTo"`
+	From             string `xml:"From"`
+	HeaderFields     struct {
+		HeaderFields []struct {
+			Name  string `xml:"Name"`
+			Value string `xml:"Value"`
+		} `xml:"HeaderField"`
+	} `xml:"HeaderFields"`
+	Body        string `xml:"Body"`
+	Attachments struct {
+		Files []string `xml:"File"`
+	} `xml:"Attachments"`
+	Title string `xml:"Title"`
+}
+
+// newXMLTask returns an xmlTask with the settings the Task Scheduler service
+// uses when they are not present in a definition.
+func newXMLTask() xmlTask {
+	var task xmlTask
+	task.Settings.AllowStartOnDemand = true
+	task.Settings.AllowHardTerminate = true
+	task.Settings.DisallowStartIfOnBatteries = true
+	task.Settings.Enabled = true
+	task.Settings.ExecutionTimeLimit = "PT72H"
+	task.Settings.IdleSettings.Duration = "PT10M"
+	task.Settings.IdleSettings.WaitTimeout = "PT1H"
+	task.Settings.IdleSettings.StopOnIdleEnd = true
+	task.Settings.MultipleInstancesPolicy = "IgnoreNew"
+	task.Settings.Priority = 7
+	task.Settings.StopIfGoingOnBatteries = true
+
+	return task
+}
+
+// ParseTaskXML parses a task definition in the XML format of the Task Scheduler
+// schema, such as the files the Task Scheduler service stores in the
+// System32\Tasks folder. The XML may be encoded in UTF-16 or UTF-8.
+func ParseTaskXML(data []byte) (Definition, error) {
+	xmlText, err := decodeTaskXMLText(data)
+	if err != nil {
+		return Definition{}, err
+	}
+
+	task := newXMLTask()
+	decoder := xml.NewDecoder(strings.NewReader(xmlText))
+	// the text has already been decoded, so ignore the declared encoding
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := decoder.Decode(&task); err != nil {
+		return Definition{}, fmt.Errorf("error parsing task XML: %v", err)
+	}
+
+	def, err := task.definition()
+	if err != nil {
+		return Definition{}, err
+	}
+	def.XMLText = xmlText
+
+	return def, nil
+}
+
+// decodeTaskXMLText converts UTF-16 text to a string. Text without a UTF-16
+// byte order mark is assumed to be UTF-8, unless it starts with a UTF-16
+// encoded '<'.
+func decodeTaskXMLText(data []byte) (string, error) {
+	var bigEndian bool
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		data = data[2:]
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		data = data[2:]
+		bigEndian = true
+	case bytes.HasPrefix(data, []byte{'<', 0}):
+	case bytes.HasPrefix(data, []byte{0, '<'}):
+		bigEndian = true
+	default:
+		return string(bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})), nil
+	}
+
+	if len(data)%2 != 0 {
+		return "", errors.New("error decoding task XML: invalid UTF-16 text length")
+	}
+
+	chars := make([]uint16, len(data)/2)
+	for i := range chars {
+		if bigEndian {
+			chars[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			chars[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+		}
+	}
+
+	return string(utf16.Decode(chars)), nil
+}
+
+func (t xmlTask) definition() (Definition, error) {
+	var def Definition
+	var err error
+
+	def.Context = t.Actions.Context
+	def.Data = t.Data
+
+	for _, a := range t.Actions.Actions {
+		action, err := a.action()
+		if err != nil {
+			return Definition{}, fmt.Errorf("error parsing action: %v", err)
+		}
+		def.Actions = append(def.Actions, action)
+	}
+
+	// the principal used is the one the actions run under, which is
+	// the first if no context is set
+	for i, p := range t.Principals.Principals {
+		if i == 0 || p.ID == def.Context {
+			def.Principal, err = p.principal()
+			if err != nil {
+				return Definition{}, fmt.Errorf("error parsing principal: %v", err)
+			}
+		}
+		if p.ID == def.Context {
+			break
+		}
+	}
+
+	def.RegistrationInfo, err = t.RegistrationInfo.registrationInfo()
+	if err != nil {
+		return Definition{}, fmt.Errorf("error parsing registration info: %v", err)
+	}
+
+	compatibility, err := xmlVersionToCompatibility(t.Version)
+	if err != nil {
+		return Definition{}, err
+	}
+	def.Settings, err = t.Settings.settings()
+	if err != nil {
+		return Definition{}, fmt.Errorf("error parsing settings: %v", err)
+	}
+	def.Settings.Compatibility = compatibility
+
+	for _, tr := range t.Triggers.Triggers {
+		trigger, err := tr.trigger()
+		if err != nil {
+			return Definition{}, fmt.Errorf("error parsing %s: %v", tr.XMLName.Local, err)
+		}
+		def.Triggers = append(def.Triggers, trigger)
+	}
+
+	return def, nil
+}
+
+func xmlVersionToCompatibility(version string) (TaskCompatibility, error) {
+	switch version {
+	case "1.0":
+		return TASK_COMPATIBILITY_AT, nil
+	case "1.1":
+		return TASK_COMPATIBILITY_V1, nil
+	case "1.2":
+		return TASK_COMPATIBILITY_V2, nil
+	case "1.3":
+		return TASK_COMPATIBILITY_V2_1, nil
+	case "1.4":
+		return TASK_COMPATIBILITY_V2_2, nil
+	case "1.5":
+		return TASK_COMPATIBILITY_V2_3, nil
+	case "1.6":
+		return TASK_COMPATIBILITY_V2_4, nil
+	default:
+		return 0, fmt.Errorf("unsupported task version %q", version)
+	}
+}
+
+func (a xmlAction) action() (Action, error) {
+	switch a.XMLName.Local {
+	case "Exec":
+		return ExecAction{
+			ID:         a.ID,
+			Path:       a.Command,
+			Args:       a.Arguments,
+			WorkingDir: a.WorkingDirectory,
+		}, nil
+	case "ComHandler":
+		return ComHandlerAction{
+			ID:      a.ID,
+			ClassID: a.ClassID,
+			Data:    a.Data,
+		}, nil
+	case "SendEmail":
+		headerFields := make(map[string]string)
+		for _, field := range a.HeaderFields.HeaderFields {
+			headerFields[field.Name] = field.Value
+		}
+
+		return EmailAction{
+			ID:           a.ID,
+			Server:       a.Server,
+			From:         a.From,
+			To:           a.To,
+			Cc:           a.Cc,
+			Bcc:          a.Bcc,
+			ReplyTo:      a.ReplyTo,
+			Subject:      a.Subject,
+			Body:         a.Body,
+			HeaderFields: headerFields,
+			Attachments:  a.Attachments.Files,
+		}, nil
+	case "ShowMessage":
+		return ShowMessageAction{
+			ID:    a.ID,
+			Title: a.Title,
+			Body:  a.Body,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported action type %q", a.XMLName.Local)
+	}
+}
+
+// well-known SIDs and names of the service accounts tasks can run as
+var serviceAccounts = map[string]bool{
+	"s-1-5-18":                     true,
+	"s-1-5-19":                     true,
+	"s-1-5-20":                     true,
+	"system":                       true,
+	"local service":                true,
+	"network service":              true,
+	`nt authority\system`:          true,
+	`nt authority\local service`:   true,
+	`nt authority\network service`: true,
+	`nt authority\localservice`:    true,
+	`nt authority\networkservice`:  true,
+}
+
+func (p xmlPrincipal) principal() (Principal, error) {
+	principal := Principal{
+		Name:    p.DisplayName,
+		GroupID: p.GroupID,
+		ID:      p.ID,
+		UserID:  p.UserID,
+	}
+
+	switch p.LogonType {
+	case "None":
+		principal.LogonType = TASK_LOGON_NONE
+	case "Password":
+		principal.LogonType = TASK_LOGON_PASSWORD
+	case "S4U":
+		principal.LogonType = TASK_LOGON_S4U
+	case "InteractiveToken":
+		principal.LogonType = TASK_LOGON_INTERACTIVE_TOKEN
+	case "Group":
+		principal.LogonType = TASK_LOGON_GROUP
+	case "ServiceAccount":
+		principal.LogonType = TASK_LOGON_SERVICE_ACCOUNT
+	case "InteractiveTokenOrPassword":
+		principal.LogonType = TASK_LOGON_INTERACTIVE_TOKEN_OR_PASSWORD
+	case "":
+		// the service infers the logon type from the account
+		if p.GroupID != "" {
+			principal.LogonType = TASK_LOGON_GROUP
+		} else if serviceAccounts[strings.ToLower(p.UserID)] {
+			principal.LogonType = TASK_LOGON_SERVICE_ACCOUNT
+		} else {
+			principal.LogonType = TASK_LOGON_INTERACTIVE_TOKEN
+		}
+	default:
+		return Principal{}, fmt.Errorf("unknown logon type %q", p.LogonType)
+	}
+
+	switch p.RunLevel {
+	case "", "LeastPrivilege":
+		principal.RunLevel = TASK_RUNLEVEL_LUA
+	case "HighestAvailable":
+		principal.RunLevel = TASK_RUNLEVEL_HIGHEST
+	default:
+		return Principal{}, fmt.Errorf("unknown run level %q", p.RunLevel)
+	}
+
+	// like the Task Scheduler API, only set the process token SID type of
+	// service accounts
+	var sidType TaskProcessTokenSidType
+	switch p.ProcessTokenSidType {
+	case "", "Default":
+		sidType = TASK_PROCESSTOKENSID_DEFAULT
+	case "None":
+		sidType = TASK_PROCESSTOKENSID_NONE
+	case "Unrestricted":
+		sidType = TASK_PROCESSTOKENSID_UNRESTRICTED
+	default:
+		return Principal{}, fmt.Errorf("unknown process token SID type %q", p.ProcessTokenSidType)
+	}
+	if principal.LogonType == TASK_LOGON_SERVICE_ACCOUNT {
+		principal.ProcessTokenSidType = processTokenSidTypePtr(sidType)
+	}
+	principal.RequiredPrivileges = p.RequiredPrivileges.Privileges
+
+	return principal, nil
+}
+
+func (r xmlRegistrationInfo) registrationInfo() (RegistrationInfo, error) {
+	date, err := TaskDateToTime(r.Date)
+	if err != nil {
+		return RegistrationInfo{}, fmt.Errorf("error parsing Date field: %v", err)
+	}
+
+	return RegistrationInfo{
+		Author:             r.Author,
+		Date:               date,
+		Description:        r.Description,
+		Documentation:      r.Documentation,
+		SecurityDescriptor: r.SecurityDescriptor,
+		Source:             r.Source,
+		URI:                r.URI,
+		Version:            r.Version,
+	}, nil
+}
+
+func (s xmlSettings) settings() (TaskSettings, error) {
+	timeLimit, err := StringToPeriod(s.ExecutionTimeLimit)
+	if err != nil {
+		return TaskSettings{}, fmt.Errorf("error parsing ExecutionTimeLimit field: %v", err)
+	}
+	idleDuration, err := StringToPeriod(s.IdleSettings.Duration)
+	if err != nil {
+		return TaskSettings{}, fmt.Errorf("error parsing IdleDuration field: %v", err)
+	}
+	waitTimeout, err := StringToPeriod(s.IdleSettings.WaitTimeout)
+	if err != nil {
+		return TaskSettings{}, fmt.Errorf("error parsing WaitTimeout field: %v", err)
+	}
+	restartInterval, err := StringToPeriod(s.RestartOnFailure.Interval)
+	if err != nil {
+		return TaskSettings{}, fmt.Errorf("error parsing RestartInterval field: %v", err)
+	}
+
+	maintenancePeriod, err := StringToPeriod(s.MaintenanceSettings.Period)
+	if err != nil {
+		return TaskSettings{}, fmt.Errorf("error parsing maintenance Period field: %v", err)
+	}
+	maintenanceDeadline, err := StringToPeriod(s.MaintenanceSettings.Deadline)
+	if err != nil {
+		return TaskSettings{}, fmt.Errorf("error parsing maintenance Deadline field: %v", err)
+	}
+
+	var multipleInstances TaskInstancesPolicy
+
+	switch s.MultipleInstancesPolicy {
+	case "Parallel":
+		multipleInstances = TASK_INSTANCES_PARALLEL
+	case "Queue":
+		multipleInstances = TASK_INSTANCES_QUEUE
+	case "IgnoreNew":
+		multipleInstances = TASK_INSTANCES_IGNORE_NEW
+	case "StopExisting":
+		multipleInstances = TASK_INSTANCES_STOP_EXISTING
+	default:
+		return TaskSettings{}, fmt.Errorf("unknown multiple instances policy %q", s.MultipleInstancesPolicy)
+	}
+
+	return TaskSettings{
+		AllowDemandStart:       s.AllowStartOnDemand,
+		AllowHardTerminate:     s.AllowHardTerminate,
+		DeleteExpiredTaskAfter: s.DeleteExpiredTaskAfter,
+		DontStartOnBatteries:   s.DisallowStartIfOnBatteries,
+		Enabled:                s.Enabled,
+		TimeLimit:              timeLimit,
+		Hidden:                 s.Hidden,
+		IdleSettings: IdleSettings{
+			IdleDuration:  idleDuration,
+			RestartOnIdle: s.IdleSettings.RestartOnIdle,
+			StopOnIdleEnd: s.IdleSettings.StopOnIdleEnd,
+			WaitTimeout:   waitTimeout,
+		},
+		MultipleInstances: multipleInstances,
+		NetworkSettings: NetworkSettings{
+			ID:   s.NetworkSettings.ID,
+			Name: s.NetworkSettings.Name,
+		},
+		Priority:                  s.Priority,
+		RestartCount:              s.RestartOnFailure.Count,
+		RestartInterval:           restartInterval,
+		RunOnlyIfIdle:             s.RunOnlyIfIdle,
+		RunOnlyIfNetworkAvailable: s.RunOnlyIfNetworkAvailable,
+		StartWhenAvailable:        s.StartWhenAvailable,
+		StopIfGoingOnBatteries:    s.StopIfGoingOnBatteries,
+		WakeToRun:                 s.WakeToRun,
+
+		DisallowStartOnRemoteAppSession: s.DisallowStartOnRemoteAppSession,
+		UseUnifiedSchedulingEngine:      s.UseUnifiedSchedulingEngine,
+		Volatile:                        s.Volatile,
+		MaintenanceSettings: MaintenanceSettings{
+			Period:    maintenancePeriod,
+			Deadline:  maintenanceDeadline,
+			Exclusive: s.MaintenanceSettings.Exclusive,
+		},
+	}, nil
+}
+
+func (t xmlTrigger) taskTrigger() (TaskTrigger, error) {
+	startBoundary, err := TaskDateToTime(t.StartBoundary)
+	if err != nil {
+		return TaskTrigger{}, fmt.Errorf("error parsing StartBoundary field: %v", err)
+	}
+	endBoundary, err := TaskDateToTime(t.EndBoundary)
+	if err != nil {
+		return TaskTrigger{}, fmt.Errorf("error parsing EndBoundary field: %v", err)
+	}
+	executionTimeLimit, err := StringToPeriod(t.ExecutionTimeLimit)
+	if err != nil {
+		return TaskTrigger{}, fmt.Errorf("error parsing ExecutionTimeLimit field: %v", err)
+	}
+	repetitionDuration, err := StringToPeriod(t.Repetition.Duration)
+	if err != nil {
+		return TaskTrigger{}, fmt.Errorf("error parsing RepetitionDuration field: %v", err)
+	}
+	repetitionInterval, err := StringToPeriod(t.Repetition.Interval)
+	if err != nil {
+		return TaskTrigger{}, fmt.Errorf("error parsing RepetitionInterval field: %v", err)
+	}
+
+	enabled := true
+	if t.Enabled != nil {
+		enabled = *t.Enabled
+	}
+
+	return TaskTrigger{
+		Enabled:            enabled,
+		EndBoundary:        endBoundary,
+		ExecutionTimeLimit: executionTimeLimit,
+		ID:                 t.ID,
+		RepetitionPattern: RepetitionPattern{
+			RepetitionDuration: repetitionDuration,
+			RepetitionInterval: repetitionInterval,
+			StopAtDurationEnd:  t.Repetition.StopAtDurationEnd,
+		},
+		StartBoundary: startBoundary,
+	}, nil
+}
+
+func (t xmlTrigger) trigger() (Trigger, error) {
+	taskTrigger, err := t.taskTrigger()
+	if err != nil {
+		return nil, err
+	}
+	delay, err := StringToPeriod(t.Delay)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Delay field: %v", err)
+	}
+	randomDelay, err := StringToPeriod(t.RandomDelay)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing RandomDelay field: %v", err)
+	}
+
+	switch t.XMLName.Local {
+	case "BootTrigger":
+		return BootTrigger{
+			TaskTrigger: taskTrigger,
+			Delay:       delay,
+		}, nil
+	case "CalendarTrigger":
+		return t.calendarTrigger(taskTrigger, randomDelay)
+	case "EventTrigger":
+		valueQueries := make(map[string]string)
+		for _, v := range t.ValueQueries.Values {
+			valueQueries[v.Name] = v.Value
+		}
+
+		return EventTrigger{
+			TaskTrigger:  taskTrigger,
+			Delay:        delay,
+			Subscription: t.Subscription,
+			ValueQueries: valueQueries,
+		}, nil
+	case "IdleTrigger":
+		return IdleTrigger{
+			TaskTrigger: taskTrigger,
+		}, nil
+	case "LogonTrigger":
+		return LogonTrigger{
+			TaskTrigger: taskTrigger,
+			Delay:       delay,
+			UserID:      t.UserID,
+		}, nil
+	case "RegistrationTrigger":
+		return RegistrationTrigger{
+			TaskTrigger: taskTrigger,
+			Delay:       delay,
+		}, nil
+	case "SessionStateChangeTrigger":
+		stateChange, err := xmlToStateChange(t.StateChange)
+		if err != nil {
+			return nil, err
+		}
+
+		return SessionStateChangeTrigger{
+			TaskTrigger: taskTrigger,
+			Delay:       delay,
+			StateChange: stateChange,
+			UserId:      t.UserID,
+		}, nil
+	case "TimeTrigger":
+		return TimeTrigger{
+			TaskTrigger: taskTrigger,
+			RandomDelay: randomDelay,
+		}, nil
+	case wnfStateChangeTrigger:
+		stateName, err := parseWNFStateName(t.StateName)
+		if err != nil {
+			return nil, err
+		}
+		var data []byte
+		if t.Data != "" {
+			data, err = hex.DecodeString(t.Data)
+			if err != nil {
+				return nil, fmt.Errorf("invalid WNF state data %q", t.Data)
+			}
+		}
+
+		return CustomTrigger{
+			TaskTrigger: taskTrigger,
+			Name:        t.XMLName.Local,
+			Delay:       delay,
+			StateName:   stateName,
+			Data:        data,
+			DataOffset:  t.DataOffset,
+		}, nil
+	default:
+		// other triggers that are not part of the ITrigger API are
+		// reported as custom triggers as well
+		return CustomTrigger{
+			TaskTrigger: taskTrigger,
+			Name:        t.XMLName.Local,
+			XML:         t.elementXML(),
+			Delay:       delay,
+		}, nil
+	}
+}
+
+// elementXML returns the XML element of the trigger, so that triggers that
+// can't be created from their fields can be registered again as they were.
+func (t xmlTrigger) elementXML() string {
+	var buf bytes.Buffer
+	buf.WriteString("<" + t.XMLName.Local)
+	if t.ID != "" {
+		buf.WriteString(` id="`)
+		xml.EscapeText(&buf, []byte(t.ID))
+		buf.WriteString(`"`)
+	}
+	buf.WriteString(">" + t.InnerXML + "</" + t.XMLName.Local + ">")
+
+	return buf.String()
+}
+
+func (t xmlTrigger) calendarTrigger(taskTrigger TaskTrigger, randomDelay period.Period) (Trigger, error) {
+	switch {
+	case t.ScheduleByDay != nil:
+		return DailyTrigger{
+			TaskTrigger: taskTrigger,
+			DayInterval: DayInterval(t.ScheduleByDay.DaysInterval),
+			RandomDelay: randomDelay,
+		}, nil
+	case t.ScheduleByWeek != nil:
+		daysOfWeek, err := t.ScheduleByWeek.DaysOfWeek.daysOfWeek()
+		if err != nil {
+			return nil, err
+		}
+
+		return WeeklyTrigger{
+			TaskTrigger:  taskTrigger,
+			DaysOfWeek:   daysOfWeek,
+			RandomDelay:  randomDelay,
+			WeekInterval: WeekInterval(t.ScheduleByWeek.WeeksInterval),
+		}, nil
+	case t.ScheduleByMonth != nil:
+		var daysOfMonth DayOfMonth
+		var runOnLastDay bool
+		for _, day := range t.ScheduleByMonth.DaysOfMonth.Days {
+			day = strings.TrimSpace(day)
+			if day == "Last" {
+				runOnLastDay = true
+				continue
+			}
+
+			n, err := strconv.Atoi(day)
+			if err != nil {
+				return nil, fmt.Errorf("invalid day of month %q", day)
+			}
+			d, err := IntToDayOfMonth(n)
+			if err != nil {
+				return nil, err
+			}
+			daysOfMonth |= d
+		}
+		months, err := t.ScheduleByMonth.Months.months()
+		if err != nil {
+			return nil, err
+		}
+
+		return MonthlyTrigger{
+			TaskTrigger:          taskTrigger,
+			DaysOfMonth:          daysOfMonth,
+			MonthsOfYear:         months,
+			RandomDelay:          randomDelay,
+			RunOnLastWeekOfMonth: runOnLastDay,
+		}, nil
+	case t.ScheduleByMonthDayOfWeek != nil:
+		var weeks Week
+		var runOnLastWeek bool
+		for _, week := range t.ScheduleByMonthDayOfWeek.Weeks.Weeks {
+			week = strings.TrimSpace(week)
+			if week == "Last" {
+				runOnLastWeek = true
+				continue
+			}
+
+			n, err := strconv.Atoi(week)
+			if err != nil || n < 1 || n > 4 {
+				return nil, fmt.Errorf("invalid week of month %q", week)
+			}
+			weeks |= 1 << uint(n-1)
+		}
+		daysOfWeek, err := t.ScheduleByMonthDayOfWeek.DaysOfWeek.daysOfWeek()
+		if err != nil {
+			return nil, err
+		}
+		months, err := t.ScheduleByMonthDayOfWeek.Months.months()
+		if err != nil {
+			return nil, err
+		}
+
+		return MonthlyDOWTrigger{
+			TaskTrigger:          taskTrigger,
+			DaysOfWeek:           daysOfWeek,
+			MonthsOfYear:         months,
+			RandomDelay:          randomDelay,
+			RunOnLastWeekOfMonth: runOnLastWeek,
+			WeeksOfMonth:         weeks,
+		}, nil
+	default:
+		return nil, errors.New("no schedule is defined")
+	}
+}
+
+var xmlDaysOfWeek = map[string]DayOfWeek{
+	"Sunday":    Sunday,
+	"Monday":    Monday,
+	"Tuesday":   Tuesday,
+	"Wednesday": Wednesday,
+	"Thursday":  Thursday,
+	"Friday":    Friday,
+	"Saturday":  Saturday,
+}
+
+var xmlMonths = map[string]Month{
+	"January":   January,
+	"February":  February,
+	"March":     March,
+	"April":     April,
+	"May":       May,
+	"June":      June,
+	"July":      July,
+	"August":    August,
+	"September": September,
+	"October":   October,
+	"November":  November,
+	"December":  December,
+}
+
+func (l xmlElementList) daysOfWeek() (DayOfWeek, error) {
+	var days DayOfWeek
+	for _, e := range l.Elements {
+		day, ok := xmlDaysOfWeek[e.XMLName.Local]
+		if !ok {
+			return 0, fmt.Errorf("invalid day of week %q", e.XMLName.Local)
+		}
+		days |= day
+	}
+
+	return days, nil
+}
+
+func (l xmlElementList) months() (Month, error) {
+	var months Month
+	for _, e := range l.Elements {
+		month, ok := xmlMonths[e.XMLName.Local]
+		if !ok {
+			return 0, fmt.Errorf("invalid month %q", e.XMLName.Local)
+		}
+		months |= month
+	}
+
+	return months, nil
+}
+
+func xmlToStateChange(s string) (TaskSessionStateChangeType, error) {
+	switch s {
+	case "ConsoleConnect":
+		return TASK_CONSOLE_CONNECT, nil
+	case "ConsoleDisconnect":
+		return TASK_CONSOLE_DISCONNECT, nil
+	case "RemoteConnect":
+		return TASK_REMOTE_CONNECT, nil
+	case "RemoteDisconnect":
+		return TASK_REMOTE_DISCONNECT, nil
+	case "SessionLock":
+		return TASK_SESSION_LOCK, nil
+	case "SessionUnlock":
+		return TASK_SESSION_UNLOCK, nil
+	default:
+		return 0, fmt.Errorf("unknown session state change %q", s)
+	}
+}
@@ -1,8 +1,8 @@
-// +build windows
-
 package taskmaster
 
 import (
+	"context"
+	"fmt"
 	"strconv"
 	"strings"
 	"syscall"
@@ -412,6 +412,29 @@ func (t TaskLogonType) String() string {
 	}
 }
 
+// TaskProcessTokenSidType specifies the security identifier (SID) type of the process token of a task.
+// https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/ne-taskschd-task_processtokensid_type
+type TaskProcessTokenSidType uint
+
+const (
+	TASK_PROCESSTOKENSID_NONE         TaskProcessTokenSidType = iota // no changes will be made to the process token groups list
+	TASK_PROCESSTOKENSID_UNRESTRICTED                                // a task SID that is derived from the task name will be added to the process token groups list, and the token default discretionary access control list (DACL) will be modified to allow only the task SID and local system full control and the account SID read control
+	TASK_PROCESSTOKENSID_DEFAULT                                     // a Task Scheduler will apply default settings to the task process
+)
+
+func (t TaskProcessTokenSidType) String() string {
+	switch t {
+	case TASK_PROCESSTOKENSID_NONE:
+		return "None"
+	case TASK_PROCESSTOKENSID_UNRESTRICTED:
+		return "Unrestricted"
+	case TASK_PROCESSTOKENSID_DEFAULT:
+		return "Default"
+	default:
+		return ""
+	}
+}
+
 // TaskRunFlags specifies how a task will be executed.
 // https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/ne-taskschd-task_run_flags
 type TaskRunFlags uint
@@ -607,10 +630,26 @@ func (r TaskResult) String() string {
 	}
 }
 
+// Scheduler is the set of operations that can be performed on a connected Task
+// Scheduler service. TaskService implements Scheduler; other implementations
+// allow code that manages tasks to be used and tested without Windows.
+type Scheduler interface {
+	GetRegisteredTasksContext(ctx context.Context) (RegisteredTaskCollection, error)
+	GetRegisteredTaskContext(ctx context.Context, path string) (RegisteredTask, error)
+	GetTaskFolderContext(ctx context.Context, path string) (TaskFolder, error)
+	CreateTaskExContext(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType, overwrite bool) (RegisteredTask, bool, error)
+	UpdateTaskExContext(ctx context.Context, path string, newTaskDef Definition, username, password string, logonType TaskLogonType) (RegisteredTask, error)
+	DeleteTaskContext(ctx context.Context, path string) error
+	RunTaskContext(ctx context.Context, path string, args ...string) (RunningTask, error)
+	Disconnect()
+}
+
+// TaskService is a connection to a local or remote Task Scheduler service.
+// It is safe for concurrent use by multiple goroutines.
 type TaskService struct {
+	dispatcher            *dispatcher
 	taskServiceObj        *ole.IDispatch
 	rootFolderObj         *ole.IDispatch
-	isInitialized         bool
 	isConnected           bool
 	connectedDomain       string
 	connectedComputerName string
@@ -628,6 +667,7 @@ type TaskFolder struct {
 // RunningTask is a task that is currently running.
 // https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nn-taskschd-irunningtask
 type RunningTask struct {
+	dispatcher    *dispatcher
 	taskObj       *ole.IDispatch
 	isReleased    bool
 	CurrentAction string    // the name of the current action that the running task is performing
@@ -638,9 +678,13 @@ type RunningTask struct {
 	State         TaskState // an identifier for the state of the running task
 }
 
+// RunningTaskCollection is a collection of running tasks.
+type RunningTaskCollection []RunningTask
+
 // RegisteredTask is a task that is registered in the Task Scheduler database.
 // https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nn-taskschd-iregisteredtask
 type RegisteredTask struct {
+	dispatcher     *dispatcher
 	taskObj        *ole.IDispatch
 	isReleased     bool
 	Name           string // the name of the registered task
@@ -654,6 +698,38 @@ type RegisteredTask struct {
 	LastTaskResult TaskResult // the results that were returned the last time the registered task was run
 }
 
+// RegisteredTaskCollection is a collection of registered tasks.
+type RegisteredTaskCollection []RegisteredTask
+
+// ValidationReport is the result of validating a task definition without
+// registering it, both locally and by the Task Scheduler service.
+type ValidationReport struct {
+	Path       string
+	Exists     bool     // whether a task is registered at Path
+	Skipped    bool     // for dry runs of creates, whether the task registered at Path would be left as it is
+	PathErr    error    // the error registering the task at Path would fail with, such as an invalid path or, when updating, a missing task
+	LocalErr   error    // the error found validating the definition locally
+	Warnings   []string // problems that don't prevent the definition from being registered, as returned by DefinitionWarnings
+	ServiceErr error    // the error the Task Scheduler service returned validating the definition
+}
+
+// Valid reports whether the definition can be registered at Path.
+func (r ValidationReport) Valid() bool {
+	return r.Err() == nil
+}
+
+// Err returns the first error of the report, or nil if the definition can be
+// registered at Path.
+func (r ValidationReport) Err() error {
+	for _, err := range []error{r.PathErr, r.LocalErr, r.ServiceErr} {
+		if err != nil {
+			return fmt.Errorf("invalid task %s: %v", r.Path, err)
+		}
+	}
+
+	return nil
+}
+
 // Definition defines all the components of a task, such as the task settings, triggers, actions, and registration information
 // https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nn-taskschd-itaskdefinition
 type Definition struct {
@@ -693,8 +769,38 @@ type ComHandlerAction struct {
 	Data    string
 }
 
+// EmailAction is an action that sends an email message. Addresses are
+// separated by semicolons. Email actions are deprecated since Windows 8 and
+// Windows Server 2012, and tasks with them fail to run on newer versions of
+// Windows.
+// https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nn-taskschd-iemailaction
+type EmailAction struct {
+	ID           string
+	Server       string            // the name of the SMTP server used to send the message
+	From         string            // the address of the sender
+	To           string            // the addresses of the recipients
+	Cc           string            // the addresses the message is copied to
+	Bcc          string            // the addresses the message is blind copied to
+	ReplyTo      string            // the address replies are sent to
+	Subject      string            // the subject of the message
+	Body         string            // the body of the message
+	HeaderFields map[string]string // additional header fields of the message
+	Attachments  []string          // the paths of the files attached to the message
+}
+
+// ShowMessageAction is an action that shows a message box. Show message
+// actions are deprecated since Windows 8 and Windows Server 2012, and tasks
+// with them fail to run on newer versions of Windows.
+// https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nn-taskschd-ishowmessageaction
+type ShowMessageAction struct {
+	ID    string
+	Title string // the title of the message box
+	Body  string // the message shown in the message box
+}
+
 // Principal provides security credentials that define the security context for the tasks that are associated with it.
 // https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nn-taskschd-iprincipal
+// https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nn-taskschd-iprincipal2
 type Principal struct {
 	Name      string        // the name of the principal
 	GroupID   string        // the identifier of the user group that is required to run the tasks
@@ -702,6 +808,15 @@ type Principal struct {
 	LogonType TaskLogonType // the security logon method that is required to run the tasks
 	RunLevel  TaskRunLevel  // the identifier that is used to specify the privilege level that is required to run the tasks
 	UserID    string        // the user identifier that is required to run the tasks
+	// ProcessTokenSidType is the SID type of the process token of the tasks,
+	// or nil to use TASK_PROCESSTOKENSID_DEFAULT. It's only set with
+	// TASK_LOGON_SERVICE_ACCOUNT, as other logon types can only use
+	// TASK_PROCESSTOKENSID_DEFAULT.
+	ProcessTokenSidType *TaskProcessTokenSidType
+	// RequiredPrivileges are the privileges, such as SeBackupPrivilege, the
+	// tasks run with. If empty, the tasks run with all the privileges of
+	// the account. They can only be set with TASK_LOGON_SERVICE_ACCOUNT.
+	RequiredPrivileges []string
 }
 
 // RegistrationInfo provides the administrative information that can be used to describe the task
@@ -739,6 +854,25 @@ type TaskSettings struct {
 	StartWhenAvailable        bool          // indicates that the Task Scheduler can start the task at any time after its scheduled time has passed
 	StopIfGoingOnBatteries    bool          // indicates that the task will be stopped if the computer is going onto batteries
 	WakeToRun                 bool          // indicates that the Task Scheduler will wake the computer when it is time to run the task, and keep the computer awake until the task is completed
+	// DisallowStartOnRemoteAppSession and UseUnifiedSchedulingEngine
+	// require TASK_COMPATIBILITY_V2_1 or later.
+	// https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nn-taskschd-itasksettings2
+	DisallowStartOnRemoteAppSession bool // indicates that the task will not be started if triggered to run in a Remote Applications Integrated Locally (RAIL) session
+	UseUnifiedSchedulingEngine      bool // indicates that the Unified Scheduling Engine will be used to run the task
+	// Volatile and MaintenanceSettings require TASK_COMPATIBILITY_V2_2 or
+	// later.
+	// https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nn-taskschd-itasksettings3
+	Volatile bool // indicates that the task is automatically disabled every time Windows starts
+	MaintenanceSettings
+}
+
+// MaintenanceSettings specifies how the Task Scheduler performs the task during Automatic maintenance.
+// The task isn't run during Automatic maintenance if Period is zero.
+// https://docs.microsoft.com/en-us/windows/desktop/api/taskschd/nn-taskschd-imaintenancesettings
+type MaintenanceSettings struct {
+	Period    period.Period // the amount of time the task needs to be started during Automatic maintenance. The minimum value is one day
+	Deadline  period.Period // the amount of time after which the Task Scheduler attempts to run the task during emergency Automatic maintenance, if the task failed to complete during regular Automatic maintenance. The minimum value is one day, and it must be greater than Period
+	Exclusive bool          // indicates that the Task Scheduler must start the task during Automatic maintenance in exclusive mode
 }
 
 // IdleSettings specifies how the Task Scheduler performs tasks when the computer is in an idle condition.
@@ -880,12 +1014,24 @@ type WeeklyTrigger struct {
 	WeekInterval WeekInterval  // the interval between the weeks in the schedule
 }
 
+// CustomTrigger is a trigger that isn't part of the Task Scheduler API and is only
+// defined in the XML of the task, such as a WNF state change trigger, which triggers
+// the task when a Windows Notification Facility (WNF) state changes. Only WNF state
+// change triggers can be created from their fields. Other custom triggers are
+// registered with the XML element they were parsed from, and changes to their other
+// fields are ignored.
 type CustomTrigger struct {
 	TaskTrigger
+	Name       string        // the name of the XML element of the trigger, such as WnfStateChangeTrigger
+	XML        string        // the XML element of a trigger that isn't a WNF state change trigger
+	Delay      period.Period // indicates the amount of time between when the state changes and when the task is started
+	StateName  WNFStateName  // the WNF state whose changes trigger the task
+	Data       []byte        // the data the state must have to trigger the task. If empty, any change triggers the task
+	DataOffset uint          // the offset in the data of the state that Data is compared at
 }
 
 func (t TaskService) IsConnected() bool {
-	return t.isConnected
+	return t.isConnected && !t.dispatcher.stopped()
 }
 
 func (t TaskService) GetConnectedDomain() string {
@@ -916,6 +1062,22 @@ func (ComHandlerAction) GetType() TaskActionType {
 	return TASK_ACTION_COM_HANDLER
 }
 
+func (e EmailAction) GetID() string {
+	return e.ID
+}
+
+func (EmailAction) GetType() TaskActionType {
+	return TASK_ACTION_SEND_EMAIL
+}
+
+func (s ShowMessageAction) GetID() string {
+	return s.ID
+}
+
+func (ShowMessageAction) GetType() TaskActionType {
+	return TASK_ACTION_SHOW_MESSAGE
+}
+
 func (t TaskTrigger) GetRepetitionDuration() period.Period {
 	return t.RepetitionDuration
 }
@@ -0,0 +1,9 @@
+// +build !windows
+
+package taskmaster
+
+import "context"
+
+func dialTaskService(ctx context.Context, host Host) (Scheduler, error) {
+	return nil, ErrUnsupportedPlatform
+}
@@ -22,8 +22,11 @@ func validateDefinition(def Definition) error {
 		return err
 	}
 
-	if def.Principal.UserID != "" && def.Principal.GroupID != "" {
-		return ErrInvalidPrinciple
+	if err = validatePrincipal(def.Principal); err != nil {
+		return err
+	}
+	if err = validateSettings(def.Settings); err != nil {
+		return err
 	}
 
 	return nil
@@ -36,6 +39,14 @@ func validateActions(actions []Action) error {
 			return nil
 		case TASK_ACTION_COM_HANDLER:
 			return nil
+		case TASK_ACTION_SEND_EMAIL:
+			if action.(EmailAction).Server == "" {
+				return errors.New("invalid EmailAction: Server is required")
+			}
+
+			return nil
+		case TASK_ACTION_SHOW_MESSAGE:
+			return nil
 		default:
 			return errors.New("invalid task action type")
 		}
@@ -49,6 +60,8 @@ func validateTriggers(triggers []Trigger) error {
 		switch t := trigger.(type) {
 		case BootTrigger:
 			return nil
+		case CustomTrigger:
+			return validateCustomTrigger(t)
 		case DailyTrigger:
 			if t.GetStartBoundary() == defaultTime {
 				return errors.New("invalid DailyTrigger: StartBoundary is required")
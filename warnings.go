@@ -0,0 +1,18 @@
+package taskmaster
+
+import "fmt"
+
+// DefinitionWarnings returns the problems of def that don't prevent it from
+// being registered, but may keep the task from working as expected, such as
+// deprecated actions.
+func DefinitionWarnings(def Definition) []string {
+	var warnings []string
+	for i, action := range def.Actions {
+		switch action.GetType() {
+		case TASK_ACTION_SEND_EMAIL, TASK_ACTION_SHOW_MESSAGE:
+			warnings = append(warnings, fmt.Sprintf("action %d: %s actions are deprecated and fail to run on Windows 8, Windows Server 2012 and later", i, action.GetType()))
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,56 @@
+package taskmaster
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefinitionWarnings(t *testing.T) {
+	def := Definition{
+		Actions: []Action{
+			ExecAction{Path: "cmd.exe"},
+			EmailAction{Server: "smtp.example.com"},
+			ShowMessageAction{Title: "Done"},
+		},
+	}
+
+	warnings := DefinitionWarnings(def)
+	if len(warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %v", warnings)
+	}
+	if !strings.HasPrefix(warnings[0], "action 1: Send Email") || !strings.HasPrefix(warnings[1], "action 2: Show Message") {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+
+	if warnings := DefinitionWarnings(Definition{Actions: []Action{ExecAction{Path: "cmd.exe"}}}); len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+}
+
+func TestDeprecatedActionsJSON(t *testing.T) {
+	email := EmailAction{
+		ID:           "mail",
+		Server:       "smtp.example.com",
+		To:           "ops@example.com",
+		Subject:      "Backup finished",
+		HeaderFields: map[string]string{"X-Priority": "1"},
+		Attachments:  []string{`C:\Logs\backup.log`},
+	}
+	message := ShowMessageAction{Title: "Backup", Body: "Done."}
+
+	for _, action := range []Action{email, message} {
+		data, err := json.Marshal(action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := reflect.New(reflect.TypeOf(action))
+		if err := json.Unmarshal(data, decoded.Interface()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded.Elem().Interface(), action) {
+			t.Errorf("expected %+v after round trip, got %+v", action, decoded.Elem().Interface())
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package taskmaster
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// TaskEventType specifies the kind of change a TaskEvent reports.
+type TaskEventType uint
+
+const (
+	TaskAdded               TaskEventType = iota // a task was registered
+	TaskRemoved                                  // a task was deleted
+	TaskDefinitionChanged                        // the definition of a task was changed
+	TaskEnabled                                  // a task was enabled
+	TaskDisabled                                 // a task was disabled
+	TaskStateChanged                             // the operational state of a task changed
+	TaskLastRunCompleted                         // a run of a task completed
+	TaskMissedRunsIncreased                      // a task missed one or more scheduled runs
+	WatchError                                   // the task folder couldn't be enumerated
+)
+
+func (t TaskEventType) String() string {
+	switch t {
+	case TaskAdded:
+		return "Task Added"
+	case TaskRemoved:
+		return "Task Removed"
+	case TaskDefinitionChanged:
+		return "Definition Changed"
+	case TaskEnabled:
+		return "Enabled"
+	case TaskDisabled:
+		return "Disabled"
+	case TaskStateChanged:
+		return "State Changed"
+	case TaskLastRunCompleted:
+		return "Last Run Completed"
+	case TaskMissedRunsIncreased:
+		return "Missed Runs Increased"
+	case WatchError:
+		return "Watch Error"
+	default:
+		return ""
+	}
+}
+
+// TaskEvent is a change to a registered task that was detected by Watch. The
+// tasks of an event are snapshots, their COM objects have already been released.
+type TaskEvent struct {
+	Type     TaskEventType
+	Path     string         // the path of the task that changed
+	Time     time.Time      // when the change was detected
+	Task     RegisteredTask // the task after the change. For TaskRemoved events, the task before it was removed
+	Previous RegisteredTask // the task before the change. Empty for TaskAdded events
+	Changes  []FieldChange  // the fields of the definition that changed, set for TaskDefinitionChanged events
+	Err      error          // the error enumerating tasks, set for WatchError events
+}
+
+// TaskFolderSnapshot is the state of every registered task in a task folder
+// tree at a point in time, keyed by task path.
+type TaskFolderSnapshot map[string]RegisteredTask
+
+// NewTaskFolderSnapshot takes a snapshot of every registered task in folder and
+// its subfolders. The tasks in the snapshot don't reference COM objects, so
+// folder can be released once the snapshot is taken.
+func NewTaskFolderSnapshot(folder TaskFolder) TaskFolderSnapshot {
+	snapshot := make(TaskFolderSnapshot)
+
+	var addFolder func(*TaskFolder)
+	addFolder = func(f *TaskFolder) {
+		for _, task := range f.RegisteredTasks {
+			task.dispatcher = nil
+			task.taskObj = nil
+			snapshot[task.Path] = task
+		}
+		for _, subFolder := range f.SubFolders {
+			addFolder(subFolder)
+		}
+	}
+	addFolder(&folder)
+
+	return snapshot
+}
+
+// Diff returns the events that turn the snapshot s into the newer snapshot
+// next. Events are ordered by task path, and the events of a task are ordered
+// by TaskEventType.
+func (s TaskFolderSnapshot) Diff(next TaskFolderSnapshot, now time.Time) []TaskEvent {
+	paths := make(map[string]struct{}, len(next))
+	for path := range s {
+		paths[path] = struct{}{}
+	}
+	for path := range next {
+		paths[path] = struct{}{}
+	}
+	sortedPaths := make([]string, 0, len(paths))
+	for path := range paths {
+		sortedPaths = append(sortedPaths, path)
+	}
+	sort.Strings(sortedPaths)
+
+	var events []TaskEvent
+	for _, path := range sortedPaths {
+		oldTask, existed := s[path]
+		newTask, exists := next[path]
+
+		event := TaskEvent{
+			Path:     path,
+			Time:     now,
+			Task:     newTask,
+			Previous: oldTask,
+		}
+		add := func(eventType TaskEventType) {
+			e := event
+			e.Type = eventType
+			events = append(events, e)
+		}
+
+		switch {
+		case !existed:
+			event.Previous = RegisteredTask{}
+			add(TaskAdded)
+			continue
+		case !exists:
+			event.Task = oldTask
+			event.Previous = RegisteredTask{}
+			add(TaskRemoved)
+			continue
+		}
+
+		if !reflect.DeepEqual(oldTask.Definition, newTask.Definition) {
+			if changes := DiffDefinitions(oldTask.Definition, newTask.Definition); len(changes) > 0 {
+				event.Changes = changes
+				add(TaskDefinitionChanged)
+				event.Changes = nil
+			}
+		}
+		if !oldTask.Enabled && newTask.Enabled {
+			add(TaskEnabled)
+		} else if oldTask.Enabled && !newTask.Enabled {
+			add(TaskDisabled)
+		}
+		if oldTask.State != newTask.State {
+			add(TaskStateChanged)
+		}
+		if runCompleted(oldTask, newTask) {
+			add(TaskLastRunCompleted)
+		}
+		if newTask.MissedRuns > oldTask.MissedRuns {
+			add(TaskMissedRunsIncreased)
+		}
+	}
+
+	return events
+}
+
+// runCompleted returns true if a run of the task finished between the two
+// snapshots of it.
+func runCompleted(oldTask, newTask RegisteredTask) bool {
+	if newTask.State == TASK_STATE_RUNNING || newTask.LastTaskResult == SCHED_S_TASK_RUNNING {
+		return false
+	}
+
+	return newTask.LastRunTime.After(oldTask.LastRunTime) ||
+		oldTask.State == TASK_STATE_RUNNING ||
+		oldTask.LastTaskResult == SCHED_S_TASK_RUNNING
+}
+
+// clock abstracts time so that polling can be tested deterministically.
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) ticker
+}
+
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// Watch takes a snapshot of the task folder at folderPath and its subfolders
+// every interval, and sends an event for every change between two successive
+// snapshots. The first snapshot is used as a baseline and produces no events.
+// If a snapshot can't be taken, a WatchError event is sent and the next
+// snapshot is compared to the last successful one. The returned channel is
+// closed once ctx is done.
+func Watch(ctx context.Context, svc Scheduler, folderPath string, interval time.Duration) <-chan TaskEvent {
+	return watch(ctx, svc, folderPath, interval, realClock{})
+}
+
+func watch(ctx context.Context, svc Scheduler, folderPath string, interval time.Duration, clk clock) <-chan TaskEvent {
+	events := make(chan TaskEvent)
+
+	go func() {
+		defer close(events)
+
+		send := func(event TaskEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		take := func() (TaskFolderSnapshot, error) {
+			folder, err := svc.GetTaskFolderContext(ctx, folderPath)
+			if err != nil {
+				return nil, err
+			}
+			defer folder.Release()
+
+			return NewTaskFolderSnapshot(folder), nil
+		}
+
+		ticker := clk.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last TaskFolderSnapshot
+		for {
+			snapshot, err := take()
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if !send(TaskEvent{Type: WatchError, Path: folderPath, Time: clk.Now(), Err: err}) {
+					return
+				}
+			} else {
+				if last != nil {
+					for _, event := range last.Diff(snapshot, clk.Now()) {
+						if !send(event) {
+							return
+						}
+					}
+				}
+				last = snapshot
+			}
+
+			select {
+			case <-ticker.C():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events
+}
@@ -0,0 +1,231 @@
+package taskmaster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rickb777/date/period"
+)
+
+type fakeClock struct {
+	now     time.Time
+	ticks   chan time.Time
+	waiting chan struct{}
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ticks:   make(chan time.Time),
+		waiting: make(chan struct{}, 16),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	return fakeTicker{c}
+}
+
+// wait blocks until the watcher is waiting for the next tick.
+func (c *fakeClock) wait() {
+	<-c.waiting
+}
+
+func (c *fakeClock) tick() {
+	c.ticks <- c.now
+}
+
+type fakeTicker struct {
+	clk *fakeClock
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	t.clk.waiting <- struct{}{}
+	return t.clk.ticks
+}
+
+func (fakeTicker) Stop() {}
+
+func receiveEvents(t *testing.T, events <-chan TaskEvent, n int) []TaskEvent {
+	t.Helper()
+
+	var received []TaskEvent
+	for i := 0; i < n; i++ {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("channel closed after %d events, expected %d", i, n)
+			}
+			received = append(received, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+
+	return received
+}
+
+func TestWatch(t *testing.T) {
+	changed := newFakeTask(`\Taskmaster\Sub\Changed`)
+	removed := newFakeTask(`\Taskmaster\Removed`)
+	toggled := newFakeTask(`\Taskmaster\Toggled`)
+	running := newFakeTask(`\Taskmaster\Running`)
+	running.State = TASK_STATE_RUNNING
+	running.LastTaskResult = SCHED_S_TASK_RUNNING
+	other := newFakeTask(`\Other\Task`)
+	svc := newFakeScheduler(changed, removed, toggled, running, other)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clk := newFakeClock()
+	events := watch(ctx, svc, `\Taskmaster`, time.Minute, clk)
+
+	// the baseline snapshot is taken before the first tick
+	clk.wait()
+
+	changed.Definition.Settings.TimeLimit = period.NewHMS(1, 0, 0)
+	changed.MissedRuns = 2
+	svc.setTask(changed)
+	svc.DeleteTaskContext(ctx, removed.Path)
+	toggled.Enabled = false
+	toggled.State = TASK_STATE_DISABLED
+	svc.setTask(toggled)
+	running.State = TASK_STATE_READY
+	running.LastTaskResult = 0
+	running.LastRunTime = clk.now
+	svc.setTask(running)
+	svc.setTask(newFakeTask(`\Taskmaster\Added`))
+	svc.setTask(newFakeTask(`\Other\Added`))
+
+	clk.now = clk.now.Add(time.Minute)
+	clk.tick()
+
+	expected := []struct {
+		eventType TaskEventType
+		path      string
+	}{
+		{TaskAdded, `\Taskmaster\Added`},
+		{TaskRemoved, `\Taskmaster\Removed`},
+		{TaskStateChanged, `\Taskmaster\Running`},
+		{TaskLastRunCompleted, `\Taskmaster\Running`},
+		{TaskDefinitionChanged, `\Taskmaster\Sub\Changed`},
+		{TaskMissedRunsIncreased, `\Taskmaster\Sub\Changed`},
+		{TaskDisabled, `\Taskmaster\Toggled`},
+		{TaskStateChanged, `\Taskmaster\Toggled`},
+	}
+	received := receiveEvents(t, events, len(expected))
+	clk.wait()
+	for i, event := range received {
+		if event.Type != expected[i].eventType || event.Path != expected[i].path {
+			t.Errorf("event %d: expected %s %s, got %s %s", i, expected[i].eventType, expected[i].path, event.Type, event.Path)
+		}
+		if !event.Time.Equal(clk.now) {
+			t.Errorf("event %d has time %v, expected %v", i, event.Time, clk.now)
+		}
+	}
+
+	if received[1].Task.Path != removed.Path {
+		t.Error("removed event should contain the removed task")
+	}
+	defChanged := received[4]
+	if len(defChanged.Changes) != 1 || defChanged.Changes[0].Field != "Settings.TimeLimit" {
+		t.Errorf("unexpected definition changes: %v", defChanged.Changes)
+	}
+	if defChanged.Previous.MissedRuns != 0 || defChanged.Task.MissedRuns != 2 {
+		t.Error("event should contain the task before and after the change")
+	}
+
+	toggled.Enabled = true
+	toggled.State = TASK_STATE_READY
+	svc.setTask(toggled)
+	clk.tick()
+
+	received = receiveEvents(t, events, 2)
+	clk.wait()
+	if received[0].Type != TaskEnabled || received[1].Type != TaskStateChanged {
+		t.Errorf("expected enabled and state changed events, got %s and %s", received[0].Type, received[1].Type)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("no events should have been sent")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel should have been closed after cancellation")
+	}
+}
+
+func TestWatchError(t *testing.T) {
+	task := newFakeTask(`\Taskmaster\Task`)
+	svc := newFakeScheduler(task)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clk := newFakeClock()
+	events := watch(ctx, svc, `\Taskmaster`, time.Minute, clk)
+	clk.wait()
+
+	errFailed := errors.New("enumeration failed")
+	svc.mu.Lock()
+	svc.err = errFailed
+	svc.mu.Unlock()
+	clk.tick()
+
+	received := receiveEvents(t, events, 1)
+	clk.wait()
+	if received[0].Type != WatchError || received[0].Err != errFailed {
+		t.Fatalf("expected a watch error, got %s %v", received[0].Type, received[0].Err)
+	}
+
+	// the next snapshot should be compared with the last successful one
+	svc.mu.Lock()
+	svc.err = nil
+	svc.mu.Unlock()
+	task.Enabled = false
+	svc.setTask(task)
+	clk.tick()
+
+	received = receiveEvents(t, events, 1)
+	if received[0].Type != TaskDisabled {
+		t.Errorf("expected a disabled event, got %s", received[0].Type)
+	}
+}
+
+func TestDiffDefinitions(t *testing.T) {
+	oldDef := newFakeTask(`\Taskmaster\Task`).Definition
+	oldDef.Triggers = []Trigger{DailyTrigger{DayInterval: EveryDay}}
+	newDef := oldDef
+	newDef.Actions = []Action{ExecAction{Path: "powershell.exe"}, ExecAction{Path: "cmd.exe"}}
+	newDef.Triggers = []Trigger{BootTrigger{}}
+	newDef.Settings.Enabled = false
+	newDef.XMLText = "<Task />"
+	newDef.Principal.ProcessTokenSidType = processTokenSidTypePtr(TASK_PROCESSTOKENSID_NONE)
+
+	if changes := DiffDefinitions(oldDef, oldDef); len(changes) != 0 {
+		t.Errorf("identical definitions should have no changes, got %v", changes)
+	}
+
+	changes := DiffDefinitions(oldDef, newDef)
+	expected := []string{"Actions[0].Path", "Actions[1]", "Principal.ProcessTokenSidType", "Settings.Enabled", "Triggers[0]"}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %v", len(expected), changes)
+	}
+	for i, change := range changes {
+		if change.Field != expected[i] {
+			t.Errorf("change %d: expected field %s, got %s", i, expected[i], change.Field)
+		}
+	}
+	if changes[1].Old != nil {
+		t.Errorf("added action should have no old value, got %v", changes[1].Old)
+	}
+	if changes[2].Old != nil || changes[2].New != TASK_PROCESSTOKENSID_NONE {
+		t.Errorf("expected set process token SID type to be reported by value, got %v", changes[2])
+	}
+}
@@ -0,0 +1,305 @@
+package taskmaster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// wnfStateChangeTrigger is the name of the XML element of WNF state change
+// triggers.
+const wnfStateChangeTrigger = "WnfStateChangeTrigger"
+
+// WNFStateName identifies a Windows Notification Facility (WNF) state, whose
+// changes WNF state change triggers start tasks on.
+type WNFStateName uint64
+
+// WNFStateNames is a catalog of well-known WNF state names. It isn't
+// exhaustive, and names can be added to it, which String and
+// LookupWNFStateName use.
+var WNFStateNames = map[WNFStateName]string{
+	0x02821B2CA3BC4075: "WNF_AUDC_CAPTURE",
+	0x0D83063EA3BE0075: "WNF_SHEL_APPLICATION_STARTED",
+	0x0D83063EA3BE0875: "WNF_SHEL_APPLICATION_TERMINATED",
+	0x0D83063EA3BE5075: "WNF_SHEL_DESKTOP_APPLICATION_STARTED",
+	0x0D83063EA3BE5875: "WNF_SHEL_DESKTOP_APPLICATION_TERMINATED",
+}
+
+// LookupWNFStateName returns the WNF state name with a well-known name in
+// WNFStateNames, such as WNF_SHEL_APPLICATION_STARTED. The name is matched case
+// insensitively.
+func LookupWNFStateName(name string) (WNFStateName, bool) {
+	for stateName, n := range WNFStateNames {
+		if strings.EqualFold(n, name) {
+			return stateName, true
+		}
+	}
+
+	return 0, false
+}
+
+// String returns the well-known name of the state, or its value in
+// hexadecimal if it isn't in WNFStateNames.
+func (n WNFStateName) String() string {
+	if name, ok := WNFStateNames[n]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("0x%016X", uint64(n))
+}
+
+// parseWNFStateName parses a state name as it's written in task XML, which
+// is the 8 bytes of its value in little endian order in hexadecimal.
+func parseWNFStateName(s string) (WNFStateName, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != 8 {
+		return 0, fmt.Errorf("invalid WNF state name %q", s)
+	}
+
+	return WNFStateName(binary.LittleEndian.Uint64(data)), nil
+}
+
+// xmlString returns the state name as it's written in task XML.
+func (n WNFStateName) xmlString() string {
+	var data [8]byte
+	binary.LittleEndian.PutUint64(data[:], uint64(n))
+
+	return strings.ToUpper(hex.EncodeToString(data[:]))
+}
+
+// resolveCustomTriggers replaces the custom triggers of triggers parsed with
+// the Task Scheduler API, which have only their common settings, with the
+// triggers parsed from the XML of the task. The API returns triggers in the
+// order of the XML, which insertCustomTriggersXML keeps.
+func resolveCustomTriggers(triggers []Trigger, xmlText string) ([]Trigger, error) {
+	var hasCustomTriggers bool
+	for _, trigger := range triggers {
+		if _, ok := trigger.(CustomTrigger); ok {
+			hasCustomTriggers = true
+			break
+		}
+	}
+	if !hasCustomTriggers {
+		return triggers, nil
+	}
+
+	def, err := ParseTaskXML([]byte(xmlText))
+	if err != nil {
+		return nil, err
+	}
+	if len(def.Triggers) != len(triggers) {
+		return nil, fmt.Errorf("task XML has %d triggers, expected %d", len(def.Triggers), len(triggers))
+	}
+
+	resolved := make([]Trigger, len(triggers))
+	copy(resolved, triggers)
+	for i, trigger := range triggers {
+		if _, ok := trigger.(CustomTrigger); !ok {
+			continue
+		}
+		customTrigger, ok := def.Triggers[i].(CustomTrigger)
+		if !ok {
+			return nil, fmt.Errorf("trigger %d of task XML is a %s trigger, expected a custom trigger", i, def.Triggers[i].GetType())
+		}
+		resolved[i] = customTrigger
+	}
+
+	return resolved, nil
+}
+
+func validateCustomTrigger(trigger CustomTrigger) error {
+	if trigger.Name != wnfStateChangeTrigger {
+		// other custom triggers can only be registered as they were parsed
+		if trigger.XML == "" {
+			return fmt.Errorf("invalid CustomTrigger: unsupported custom trigger %q", trigger.Name)
+		}
+		return nil
+	}
+	if trigger.StateName == 0 {
+		return errors.New("invalid CustomTrigger: StateName is required")
+	}
+
+	return nil
+}
+
+// customTriggerXML returns the XML element of a custom trigger.
+func customTriggerXML(trigger CustomTrigger) (string, error) {
+	if err := validateCustomTrigger(trigger); err != nil {
+		return "", err
+	}
+	if trigger.Name != wnfStateChangeTrigger {
+		return trigger.XML, nil
+	}
+
+	var buf bytes.Buffer
+	writeElement := func(name, value string) {
+		buf.WriteString("<" + name + ">")
+		xml.EscapeText(&buf, []byte(value))
+		buf.WriteString("</" + name + ">")
+	}
+
+	buf.WriteString("<" + trigger.Name)
+	if trigger.ID != "" {
+		buf.WriteString(` id="`)
+		xml.EscapeText(&buf, []byte(trigger.ID))
+		buf.WriteString(`"`)
+	}
+	buf.WriteString(">")
+	writeElement("Enabled", strconv.FormatBool(trigger.Enabled))
+	if !trigger.StartBoundary.IsZero() {
+		writeElement("StartBoundary", TimeToTaskDate(trigger.StartBoundary))
+	}
+	if !trigger.EndBoundary.IsZero() {
+		writeElement("EndBoundary", TimeToTaskDate(trigger.EndBoundary))
+	}
+	if !trigger.ExecutionTimeLimit.IsZero() {
+		writeElement("ExecutionTimeLimit", trigger.ExecutionTimeLimit.String())
+	}
+	if !trigger.RepetitionInterval.IsZero() {
+		buf.WriteString("<Repetition>")
+		writeElement("Interval", trigger.RepetitionInterval.String())
+		if !trigger.RepetitionDuration.IsZero() {
+			writeElement("Duration", trigger.RepetitionDuration.String())
+		}
+		writeElement("StopAtDurationEnd", strconv.FormatBool(trigger.StopAtDurationEnd))
+		buf.WriteString("</Repetition>")
+	}
+	if !trigger.Delay.IsZero() {
+		writeElement("Delay", trigger.Delay.String())
+	}
+	writeElement("StateName", trigger.StateName.xmlString())
+	if len(trigger.Data) != 0 {
+		writeElement("Data", strings.ToUpper(hex.EncodeToString(trigger.Data)))
+		writeElement("DataOffset", strconv.FormatUint(uint64(trigger.DataOffset), 10))
+	}
+	buf.WriteString("</" + trigger.Name + ">")
+
+	return buf.String(), nil
+}
+
+// triggersXML is the location of the Triggers element in the XML of a task.
+type triggersXML struct {
+	start    int   // the offset of the start tag, or -1 if there is no Triggers element
+	end      int   // the offset of the end tag, or of the end tag of the Task element if there is no Triggers element
+	empty    bool  // whether the element is written as an empty element tag, such as <Triggers />
+	triggers []int // the offsets of the trigger elements
+}
+
+// findTriggersXML finds the Triggers element in the XML of a task.
+func findTriggersXML(xmlText string) (triggersXML, error) {
+	decoder := xml.NewDecoder(strings.NewReader(xmlText))
+	// the text is already decoded, so ignore the declared encoding
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	loc := triggersXML{start: -1}
+	var (
+		depth      int
+		foundTask  bool
+		inTriggers bool
+		startEnd   int
+	)
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return triggersXML{}, fmt.Errorf("error parsing task XML: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 1 && t.Name.Local == "Task":
+				foundTask = true
+			case depth == 2 && foundTask && t.Name.Local == "Triggers":
+				inTriggers = true
+				loc.start = offset
+				startEnd = int(decoder.InputOffset())
+			case depth == 3 && inTriggers:
+				loc.triggers = append(loc.triggers, offset)
+			}
+		case xml.EndElement:
+			switch {
+			case depth == 1 && foundTask:
+				if loc.start == -1 {
+					loc.end = offset
+				}
+				return loc, nil
+			case depth == 2 && inTriggers:
+				inTriggers = false
+				loc.end = offset
+				loc.empty = offset == startEnd && strings.HasSuffix(xmlText[:offset], "/>")
+			}
+			depth--
+		}
+	}
+
+	return triggersXML{}, errors.New("task XML has no Task element")
+}
+
+// insertCustomTriggersXML adds the custom triggers of triggers to the XML of
+// a task, as they can't be created with the Task Scheduler API. The XML has
+// the other triggers in the same order, and the custom triggers are inserted
+// between them so that the order of triggers is kept.
+func insertCustomTriggersXML(xmlText string, triggers []Trigger) (string, error) {
+	elements := make(map[int]string)
+	for i, trigger := range triggers {
+		customTrigger, ok := trigger.(CustomTrigger)
+		if !ok {
+			continue
+		}
+		element, err := customTriggerXML(customTrigger)
+		if err != nil {
+			return "", err
+		}
+		elements[i] = element
+	}
+	if len(elements) == 0 {
+		return xmlText, nil
+	}
+
+	loc, err := findTriggersXML(xmlText)
+	if err != nil {
+		return "", fmt.Errorf("error adding custom triggers: %v", err)
+	}
+	switch {
+	case loc.start == -1:
+		xmlText = xmlText[:loc.end] + "<Triggers></Triggers>" + xmlText[loc.end:]
+		loc.end += len("<Triggers>")
+	case loc.empty:
+		xmlText = xmlText[:loc.start] + "<Triggers></Triggers>" + xmlText[loc.end:]
+		loc.end = loc.start + len("<Triggers>")
+	}
+
+	var buf strings.Builder
+	var written, next int
+	for i := range triggers {
+		element, ok := elements[i]
+		if !ok {
+			next++
+			continue
+		}
+
+		// the custom trigger goes before the next of the other triggers
+		offset := loc.end
+		if next < len(loc.triggers) {
+			offset = loc.triggers[next]
+		}
+		buf.WriteString(xmlText[written:offset])
+		buf.WriteString(element)
+		written = offset
+	}
+	buf.WriteString(xmlText[written:])
+
+	return buf.String(), nil
+}
@@ -0,0 +1,229 @@
+package taskmaster
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickb777/date/period"
+)
+
+func TestWNFStateName(t *testing.T) {
+	stateName, err := parseWNFStateName("7500BEA33E06830D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stateName != 0x0D83063EA3BE0075 || stateName.String() != "WNF_SHEL_APPLICATION_STARTED" {
+		t.Errorf("unexpected state name %#x (%s)", uint64(stateName), stateName)
+	}
+	if xmlString := stateName.xmlString(); xmlString != "7500BEA33E06830D" {
+		t.Errorf("expected XML state name 7500BEA33E06830D, got %s", xmlString)
+	}
+
+	if s := WNFStateName(0x0C960C38A3BC0875).String(); s != "0x0C960C38A3BC0875" {
+		t.Errorf("expected unknown state name to be formatted as hexadecimal, got %s", s)
+	}
+	if stateName, ok := LookupWNFStateName("wnf_shel_application_terminated"); !ok || stateName != 0x0D83063EA3BE0875 {
+		t.Errorf("unexpected state name %#x", uint64(stateName))
+	}
+	if _, ok := LookupWNFStateName("WNF_UNKNOWN"); ok {
+		t.Error("expected unknown name not to be found")
+	}
+
+	for _, s := range []string{"", "7500BEA33E0683", "7500BEA33E06830Z"} {
+		if _, err := parseWNFStateName(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+const testCustomTriggersXML = `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <Triggers>
+    <BootTrigger>
+      <Enabled>true</Enabled>
+    </BootTrigger>
+    <WnfStateChangeTrigger id="wnf">
+      <Enabled>true</Enabled>
+      <Delay>PT5M</Delay>
+      <StateName>7508BCA3380C960C</StateName>
+      <Data>0102</Data>
+      <DataOffset>4</DataOffset>
+    </WnfStateChangeTrigger>
+  </Triggers>
+  <Actions>
+    <Exec>
+      <Command>cmd.exe</Command>
+    </Exec>
+  </Actions>
+</Task>`
+
+func TestResolveCustomTriggers(t *testing.T) {
+	// the Task Scheduler API only returns the common settings of custom
+	// triggers
+	triggers := []Trigger{
+		BootTrigger{TaskTrigger: TaskTrigger{Enabled: true}},
+		CustomTrigger{TaskTrigger: TaskTrigger{Enabled: true, ID: "wnf"}},
+	}
+
+	resolved, err := resolveCustomTriggers(triggers, testCustomTriggersXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := CustomTrigger{
+		TaskTrigger: TaskTrigger{Enabled: true, ID: "wnf"},
+		Name:        "WnfStateChangeTrigger",
+		Delay:       period.NewHMS(0, 5, 0),
+		StateName:   0x0C960C38A3BC0875,
+		Data:        []byte{1, 2},
+		DataOffset:  4,
+	}
+	if resolved[0] != triggers[0] || !reflect.DeepEqual(resolved[1], expected) {
+		t.Errorf("expected %+v, got %+v", expected, resolved[1])
+	}
+	if _, ok := triggers[1].(CustomTrigger); !ok || !reflect.DeepEqual(triggers[1], CustomTrigger{TaskTrigger: TaskTrigger{Enabled: true, ID: "wnf"}}) {
+		t.Error("the original triggers were changed")
+	}
+
+	if _, err := resolveCustomTriggers(triggers[1:], testCustomTriggersXML); err == nil {
+		t.Error("expected error resolving triggers that don't match the XML")
+	}
+	noCustomTriggers := triggers[:1]
+	if resolved, err := resolveCustomTriggers(noCustomTriggers, "not XML"); err != nil || len(resolved) != 1 {
+		t.Errorf("expected triggers without custom triggers to be returned as is, got %v, %v", resolved, err)
+	}
+}
+
+func TestInsertCustomTriggersXML(t *testing.T) {
+	customTrigger := CustomTrigger{
+		TaskTrigger: TaskTrigger{
+			Enabled:           true,
+			ID:                `wnf "1" & <2>`,
+			StartBoundary:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			RepetitionPattern: RepetitionPattern{RepetitionInterval: period.NewHMS(1, 0, 0), StopAtDurationEnd: true},
+		},
+		Name:       "WnfStateChangeTrigger",
+		Delay:      period.NewHMS(0, 5, 0),
+		StateName:  0x0D83063EA3BE0075,
+		Data:       []byte{0xAB},
+		DataOffset: 2,
+	}
+	triggers := []Trigger{BootTrigger{TaskTrigger: TaskTrigger{Enabled: true}}, customTrigger}
+
+	xmlTexts := []string{
+		`<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task"><Triggers><BootTrigger><Enabled>true</Enabled></BootTrigger></Triggers></Task>`,
+		`<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task"><Triggers /></Task>`,
+		`<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task"></Task>`,
+	}
+	for _, xmlText := range xmlTexts {
+		newXMLText, err := insertCustomTriggersXML(xmlText, triggers)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", xmlText, err)
+			continue
+		}
+		def, err := ParseTaskXML([]byte(newXMLText))
+		if err != nil {
+			t.Errorf("%s: unexpected error parsing %s: %v", xmlText, newXMLText, err)
+			continue
+		}
+		parsed, ok := def.Triggers[len(def.Triggers)-1].(CustomTrigger)
+		if !ok {
+			t.Errorf("%s: expected custom trigger to be added last, got %+v", xmlText, def.Triggers)
+			continue
+		}
+		// start boundaries are compared separately, as their locations differ
+		if !parsed.StartBoundary.Equal(customTrigger.StartBoundary) {
+			t.Errorf("%s: expected start boundary %v, got %v", xmlText, customTrigger.StartBoundary, parsed.StartBoundary)
+		}
+		parsed.StartBoundary = customTrigger.StartBoundary
+		if !reflect.DeepEqual(parsed, customTrigger) {
+			t.Errorf("%s: expected %+v after round trip, got %+v", xmlText, customTrigger, parsed)
+		}
+	}
+
+	if xmlText, err := insertCustomTriggersXML(xmlTexts[0], triggers[:1]); err != nil || xmlText != xmlTexts[0] {
+		t.Errorf("expected XML without custom triggers to be unchanged, got %s, %v", xmlText, err)
+	}
+	if _, err := insertCustomTriggersXML("", triggers); err == nil || !strings.Contains(err.Error(), "no Task element") {
+		t.Errorf("expected error adding triggers to empty XML, got %v", err)
+	}
+	unsupported := []Trigger{CustomTrigger{Name: "MysteryTrigger"}}
+	if _, err := insertCustomTriggersXML(xmlTexts[0], unsupported); err == nil {
+		t.Error("expected error adding unsupported custom trigger")
+	}
+}
+
+func TestInsertParsedCustomTriggersXML(t *testing.T) {
+	xmlText := `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <Triggers>
+    <MysteryTrigger id="mystery"><Enabled>true</Enabled><Mystery a="1">x &amp; y</Mystery></MysteryTrigger>
+    <BootTrigger id="boot"><Enabled>true</Enabled></BootTrigger>
+  </Triggers>
+</Task>`
+	def, err := ParseTaskXML([]byte(xmlText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mystery, ok := def.Triggers[0].(CustomTrigger)
+	if !ok || mystery.Name != "MysteryTrigger" || mystery.XML == "" {
+		t.Fatalf("expected parsed custom trigger with its XML, got %+v", def.Triggers[0])
+	}
+
+	// the trigger is registered with the XML it was parsed from
+	apiXMLText := `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <Triggers>
+    <BootTrigger id="boot"><Enabled>true</Enabled></BootTrigger>
+  </Triggers>
+</Task>`
+	newXMLText, err := insertCustomTriggersXML(apiXMLText, def.Triggers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newDef, err := ParseTaskXML([]byte(newXMLText))
+	if err != nil {
+		t.Fatalf("unexpected error parsing %s: %v", newXMLText, err)
+	}
+	if !reflect.DeepEqual(newDef.Triggers, def.Triggers) {
+		t.Errorf("expected triggers %+v, got %+v", def.Triggers, newDef.Triggers)
+	}
+}
+
+func TestInsertCustomTriggersXMLOrder(t *testing.T) {
+	first := CustomTrigger{TaskTrigger: TaskTrigger{Enabled: true, ID: "first"}, Name: "WnfStateChangeTrigger", StateName: 0x0D83063EA3BE0075}
+	second := CustomTrigger{TaskTrigger: TaskTrigger{Enabled: true, ID: "second"}, Name: "WnfStateChangeTrigger", StateName: 0x0D83063EA3BE0875}
+	boot := BootTrigger{TaskTrigger: TaskTrigger{Enabled: true, ID: "boot"}}
+	logon := LogonTrigger{TaskTrigger: TaskTrigger{Enabled: true, ID: "logon"}}
+	triggers := []Trigger{first, boot, second, logon}
+
+	xmlText := `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <Triggers>
+    <BootTrigger id="boot"><Enabled>true</Enabled></BootTrigger>
+    <LogonTrigger id="logon"><Enabled>true</Enabled></LogonTrigger>
+  </Triggers>
+</Task>`
+	newXMLText, err := insertCustomTriggersXML(xmlText, triggers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := ParseTaskXML([]byte(newXMLText))
+	if err != nil {
+		t.Fatalf("unexpected error parsing %s: %v", newXMLText, err)
+	}
+	if !reflect.DeepEqual(def.Triggers, triggers) {
+		t.Errorf("expected triggers %+v, got %+v", triggers, def.Triggers)
+	}
+
+	// the Task Scheduler API only returns the common settings of custom triggers
+	apiTriggers := []Trigger{CustomTrigger{TaskTrigger: first.TaskTrigger}, boot, CustomTrigger{TaskTrigger: second.TaskTrigger}, logon}
+	resolved, err := resolveCustomTriggers(apiTriggers, newXMLText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resolved, triggers) {
+		t.Errorf("expected triggers %+v after round trip, got %+v", triggers, resolved)
+	}
+}